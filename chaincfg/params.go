@@ -0,0 +1,262 @@
+package chaincfg
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"math/big"
+)
+
+// Params describes everything that differs between the networks bitcoin runs on
+type Params struct {
+	// Name is the chain name Core uses, "main", "test", "testnet4", "signet" or "regtest"
+	Name string
+
+	// Magic starts every p2p message and keeps the networks from talking to each other
+	Magic       [4]byte
+	DefaultPort uint16
+	DNSSeeds    []string
+
+	// GenesisBlock is the serialized 80 byte genesis header, GenesisHash its hash in display order
+	GenesisBlock []byte
+	GenesisHash  []byte
+
+	// PowLimit is the easiest target allowed, PowLimitBits the same target in compact form
+	PowLimit     *big.Int
+	PowLimitBits uint32
+
+	// heights from which the soft forks are enforced
+	BIP34Height  int32
+	BIP65Height  int32
+	BIP66Height  int32
+	CSVHeight    int32
+	SegwitHeight int32
+
+	// address and key prefixes
+	PubKeyHashAddrID byte
+	ScriptHashAddrID byte
+	PrivateKeyID     byte
+	Bech32HRP        string
+	HDPrivateKeyID   uint32
+	HDPublicKeyID    uint32
+
+	// SignetChallenge is the script blocks have to satisfy, only set on signets
+	SignetChallenge []byte
+}
+
+// IsTestnet tells whether the network uses the test address and key prefixes
+func (p *Params) IsTestnet() bool {
+	return p.PubKeyHashAddrID != MainNetParams.PubKeyHashAddrID
+}
+
+// DEFAULT_SIGNET_CHALLENGE is the 1-of-2 multisig that signs the global signet
+const DEFAULT_SIGNET_CHALLENGE = "512103ad5e0edad18cb1f0fc0d28a3d4f1f3e445640337489abb10404f2d1e086be430210359ef5021964fe22d6f8e05b2463c9540ce96883fe3b278760f048f5189f2e6c452ae"
+
+// merkle root of the coinbase every network but testnet4 starts with
+const genesisMerkleRoot = "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b"
+
+var (
+	mainPowLimit    = compactToBig(0x1d00ffff)
+	signetPowLimit  = compactToBig(0x1e0377ae)
+	regtestPowLimit = compactToBig(0x207fffff)
+)
+
+var MainNetParams = Params{
+	Name:        "main",
+	Magic:       [4]byte{0xf9, 0xbe, 0xb4, 0xd9},
+	DefaultPort: 8333,
+	DNSSeeds: []string{
+		"seed.bitcoin.sipa.be",
+		"dnsseed.bluematt.me",
+		"seed.btc.petertodd.net",
+		"seed.bitcoin.sprovoost.nl",
+		"dnsseed.emzy.de",
+		"seed.bitcoin.wiz.biz",
+		"seed.mainnet.achownodes.xyz",
+	},
+	GenesisBlock: genesisHeader(genesisMerkleRoot, 1231006505, 0x1d00ffff, 2083236893),
+	GenesisHash:  mustDecodeHex("000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f"),
+	PowLimit:     mainPowLimit,
+	PowLimitBits: 0x1d00ffff,
+
+	BIP34Height:  227931,
+	BIP65Height:  388381,
+	BIP66Height:  363725,
+	CSVHeight:    419328,
+	SegwitHeight: 481824,
+
+	PubKeyHashAddrID: 0x00,
+	ScriptHashAddrID: 0x05,
+	PrivateKeyID:     0x80,
+	Bech32HRP:        "bc",
+	HDPrivateKeyID:   0x0488ade4,
+	HDPublicKeyID:    0x0488b21e,
+}
+
+var TestNet3Params = Params{
+	Name:        "test",
+	Magic:       [4]byte{0x0b, 0x11, 0x09, 0x07},
+	DefaultPort: 18333,
+	DNSSeeds: []string{
+		"testnet-seed.bitcoin.jonasschnelli.ch",
+		"seed.tbtc.petertodd.net",
+		"seed.testnet.bitcoin.sprovoost.nl",
+		"testnet-seed.bluematt.me",
+		"seed.testnet.achownodes.xyz",
+	},
+	GenesisBlock: genesisHeader(genesisMerkleRoot, 1296688602, 0x1d00ffff, 414098458),
+	GenesisHash:  mustDecodeHex("000000000933ea01ad0ee984209779baaec3ced90fa3f408719526f8d77f4943"),
+	PowLimit:     mainPowLimit,
+	PowLimitBits: 0x1d00ffff,
+
+	BIP34Height:  21111,
+	BIP65Height:  581885,
+	BIP66Height:  330776,
+	CSVHeight:    770112,
+	SegwitHeight: 834624,
+
+	PubKeyHashAddrID: 0x6f,
+	ScriptHashAddrID: 0xc4,
+	PrivateKeyID:     0xef,
+	Bech32HRP:        "tb",
+	HDPrivateKeyID:   0x04358394,
+	HDPublicKeyID:    0x043587cf,
+}
+
+var TestNet4Params = Params{
+	Name:        "testnet4",
+	Magic:       [4]byte{0x1c, 0x16, 0x3f, 0x28},
+	DefaultPort: 48333,
+	DNSSeeds: []string{
+		"seed.testnet4.bitcoin.sprovoost.nl",
+		"seed.testnet4.wiz.biz",
+	},
+	GenesisBlock: genesisHeader("7aa0a7ae1e223414cb807e40cd57e667b718e42aaf9306db9102fe28912b7b4e", 1714777860, 0x1d00ffff, 393743547),
+	GenesisHash:  mustDecodeHex("00000000da84f2bafbbc53dee25a72ae507ff4914b867c565be350b0da8bf043"),
+	PowLimit:     mainPowLimit,
+	PowLimitBits: 0x1d00ffff,
+
+	// every soft fork is active from the start
+	BIP34Height:  1,
+	BIP65Height:  1,
+	BIP66Height:  1,
+	CSVHeight:    1,
+	SegwitHeight: 1,
+
+	PubKeyHashAddrID: 0x6f,
+	ScriptHashAddrID: 0xc4,
+	PrivateKeyID:     0xef,
+	Bech32HRP:        "tb",
+	HDPrivateKeyID:   0x04358394,
+	HDPublicKeyID:    0x043587cf,
+}
+
+var SigNetParams = CustomSignetParams(mustDecodeHex(DEFAULT_SIGNET_CHALLENGE), []string{
+	"seed.signet.bitcoin.sprovoost.nl",
+	"seed.signet.achownodes.xyz",
+})
+
+var RegTestParams = Params{
+	Name:         "regtest",
+	Magic:        [4]byte{0xfa, 0xbf, 0xb5, 0xda},
+	DefaultPort:  18444,
+	GenesisBlock: genesisHeader(genesisMerkleRoot, 1296688602, 0x207fffff, 2),
+	GenesisHash:  mustDecodeHex("0f9188f13cb7b2c71f2a335e3a4fc328bf5beb436012afca590b1a11466e2206"),
+	PowLimit:     regtestPowLimit,
+	PowLimitBits: 0x207fffff,
+
+	BIP34Height:  1,
+	BIP65Height:  1,
+	BIP66Height:  1,
+	CSVHeight:    1,
+	SegwitHeight: 0,
+
+	PubKeyHashAddrID: 0x6f,
+	ScriptHashAddrID: 0xc4,
+	PrivateKeyID:     0xef,
+	Bech32HRP:        "bcrt",
+	HDPrivateKeyID:   0x04358394,
+	HDPublicKeyID:    0x043587cf,
+}
+
+// CustomSignetParams returns the parameters of a signet run with its own challenge, the message
+// magic is taken from the hash of the challenge so every signet gets its own
+func CustomSignetParams(challenge []byte, seeds []string) Params {
+	data := append(compactSize(len(challenge)), challenge...)
+	first := sha256.Sum256(data)
+	hash := sha256.Sum256(first[:])
+
+	var magic [4]byte
+	copy(magic[:], hash[:4])
+
+	return Params{
+		Name:         "signet",
+		Magic:        magic,
+		DefaultPort:  38333,
+		DNSSeeds:     seeds,
+		GenesisBlock: genesisHeader(genesisMerkleRoot, 1598918400, 0x1e0377ae, 52613770),
+		GenesisHash:  mustDecodeHex("00000008819873e925422c1ff0f99f7cc9bbb232af63a077a480a3633bee1ef6"),
+		PowLimit:     signetPowLimit,
+		PowLimitBits: 0x1e0377ae,
+
+		BIP34Height:  1,
+		BIP65Height:  1,
+		BIP66Height:  1,
+		CSVHeight:    1,
+		SegwitHeight: 1,
+
+		PubKeyHashAddrID: 0x6f,
+		ScriptHashAddrID: 0xc4,
+		PrivateKeyID:     0xef,
+		Bech32HRP:        "tb",
+		HDPrivateKeyID:   0x04358394,
+		HDPublicKeyID:    0x043587cf,
+
+		SignetChallenge: challenge,
+	}
+}
+
+// genesisHeader serializes a version 1 header without a parent, the merkle root is given in display order
+func genesisHeader(merkleRoot string, timestamp uint32, bits uint32, nonce uint32) []byte {
+	root := mustDecodeHex(merkleRoot)
+	for i, j := 0, len(root)-1; i < j; i, j = i+1, j-1 {
+		root[i], root[j] = root[j], root[i]
+	}
+
+	header := binary.LittleEndian.AppendUint32(nil, 1)
+	header = append(header, make([]byte, 32)...)
+	header = append(header, root...)
+	header = binary.LittleEndian.AppendUint32(header, timestamp)
+	header = binary.LittleEndian.AppendUint32(header, bits)
+	return binary.LittleEndian.AppendUint32(header, nonce)
+}
+
+// compactToBig expands compact bits into the target they stand for
+func compactToBig(bits uint32) *big.Int {
+	exponent := uint(bits >> 24)
+	mantissa := big.NewInt(int64(bits & 0x007fffff))
+	if exponent <= 3 {
+		return mantissa.Rsh(mantissa, 8*(3-exponent))
+	}
+	return mantissa.Lsh(mantissa, 8*(exponent-3))
+}
+
+// compactSize encodes n the way lengths are prefixed on the wire
+func compactSize(n int) []byte {
+	switch {
+	case n < 0xfd:
+		return []byte{byte(n)}
+	case n <= 0xffff:
+		return binary.LittleEndian.AppendUint16([]byte{0xfd}, uint16(n))
+	default:
+		return binary.LittleEndian.AppendUint32([]byte{0xfe}, uint32(n))
+	}
+}
+
+func mustDecodeHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
@@ -0,0 +1,198 @@
+package elliptic_curve
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	BECH32_ALPHABET    = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+	BECH32_CONSTANT    = 1
+	BECH32M_CONSTANT   = 0x2bc830a3
+	BECH32_MAX_LENGTH  = 90
+	BECH32_CHECKSUM_SZ = 6
+)
+
+var (
+	ErrBech32Checksum = errors.New("invalid bech32 checksum")
+	ErrBech32Format   = errors.New("invalid bech32 string")
+	ErrSegwitProgram  = errors.New("invalid segwit program")
+)
+
+// bech32Polymod computes the BCH checksum over 5-bit values as defined by BIP-173
+func bech32Polymod(values []byte) uint32 {
+	generator := []uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>i)&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+// bech32HrpExpand expands the human readable part for checksum computation
+func bech32HrpExpand(hrp string) []byte {
+	result := make([]byte, 0, len(hrp)*2+1)
+	for i := 0; i < len(hrp); i++ {
+		result = append(result, hrp[i]>>5)
+	}
+	result = append(result, 0)
+	for i := 0; i < len(hrp); i++ {
+		result = append(result, hrp[i]&31)
+	}
+	return result
+}
+
+// EncodeBech32 encodes 5-bit data with the given checksum constant, BECH32_CONSTANT or BECH32M_CONSTANT
+func EncodeBech32(hrp string, data []byte, constant uint32) string {
+	values := append(bech32HrpExpand(hrp), data...)
+	values = append(values, make([]byte, BECH32_CHECKSUM_SZ)...)
+	polymod := bech32Polymod(values) ^ constant
+
+	var builder strings.Builder
+	builder.WriteString(hrp)
+	builder.WriteByte('1')
+	for _, v := range data {
+		builder.WriteByte(BECH32_ALPHABET[v])
+	}
+	for i := 0; i < BECH32_CHECKSUM_SZ; i++ {
+		builder.WriteByte(BECH32_ALPHABET[(polymod>>(5*(5-i)))&31])
+	}
+	return builder.String()
+}
+
+// DecodeBech32 decodes a bech32 or bech32m string into its human readable part, 5-bit data and checksum constant
+func DecodeBech32(s string) (string, []byte, uint32, error) {
+	if len(s) > BECH32_MAX_LENGTH {
+		return "", nil, 0, fmt.Errorf("%w: too long", ErrBech32Format)
+	}
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, 0, fmt.Errorf("%w: mixed case", ErrBech32Format)
+	}
+	s = strings.ToLower(s)
+
+	sep := strings.LastIndexByte(s, '1')
+	if sep < 1 || sep+BECH32_CHECKSUM_SZ+1 > len(s) {
+		return "", nil, 0, fmt.Errorf("%w: separator position", ErrBech32Format)
+	}
+
+	hrp := s[:sep]
+	for i := 0; i < len(hrp); i++ {
+		if hrp[i] < 33 || hrp[i] > 126 {
+			return "", nil, 0, fmt.Errorf("%w: human readable part", ErrBech32Format)
+		}
+	}
+
+	data := make([]byte, 0, len(s)-sep-1)
+	for i := sep + 1; i < len(s); i++ {
+		idx := strings.IndexByte(BECH32_ALPHABET, s[i])
+		if idx == -1 {
+			return "", nil, 0, fmt.Errorf("%w: character %q", ErrBech32Format, s[i])
+		}
+		data = append(data, byte(idx))
+	}
+
+	constant := bech32Polymod(append(bech32HrpExpand(hrp), data...))
+	if constant != BECH32_CONSTANT && constant != BECH32M_CONSTANT {
+		return "", nil, 0, ErrBech32Checksum
+	}
+
+	return hrp, data[:len(data)-BECH32_CHECKSUM_SZ], constant, nil
+}
+
+// ConvertBits regroups a byte slice from fromBits to toBits sized groups
+func ConvertBits(data []byte, fromBits uint, toBits uint, pad bool) ([]byte, error) {
+	acc := uint32(0)
+	bits := uint(0)
+	maxv := uint32(1)<<toBits - 1
+	result := make([]byte, 0, len(data)*int(fromBits)/int(toBits)+1)
+
+	for _, value := range data {
+		if uint32(value)>>fromBits != 0 {
+			return nil, fmt.Errorf("%w: value out of range", ErrBech32Format)
+		}
+		acc = acc<<fromBits | uint32(value)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			result = append(result, byte(acc>>bits&maxv))
+		}
+	}
+
+	if pad {
+		if bits > 0 {
+			result = append(result, byte(acc<<(toBits-bits)&maxv))
+		}
+	} else if bits >= fromBits || acc<<(toBits-bits)&maxv != 0 {
+		return nil, fmt.Errorf("%w: invalid padding", ErrBech32Format)
+	}
+
+	return result, nil
+}
+
+// EncodeSegwitAddress encodes a witness program as a BIP-173 (v0) or BIP-350 (v1+) address
+func EncodeSegwitAddress(hrp string, version int, program []byte) (string, error) {
+	if err := checkWitnessProgram(version, program); err != nil {
+		return "", err
+	}
+
+	data, err := ConvertBits(program, 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+
+	constant := uint32(BECH32_CONSTANT)
+	if version > 0 {
+		constant = BECH32M_CONSTANT
+	}
+	return EncodeBech32(hrp, append([]byte{byte(version)}, data...), constant), nil
+}
+
+// DecodeSegwitAddress decodes a segwit address with the expected human readable part into its version and program
+func DecodeSegwitAddress(hrp string, address string) (int, []byte, error) {
+	decodedHrp, data, constant, err := DecodeBech32(address)
+	if err != nil {
+		return 0, nil, err
+	}
+	if decodedHrp != hrp {
+		return 0, nil, fmt.Errorf("%w: unexpected human readable part %q", ErrBech32Format, decodedHrp)
+	}
+	if len(data) < 1 {
+		return 0, nil, ErrSegwitProgram
+	}
+
+	version := int(data[0])
+	if (version == 0 && constant != BECH32_CONSTANT) || (version != 0 && constant != BECH32M_CONSTANT) {
+		return 0, nil, ErrBech32Checksum
+	}
+
+	program, err := ConvertBits(data[1:], 5, 8, false)
+	if err != nil {
+		return 0, nil, err
+	}
+	if err := checkWitnessProgram(version, program); err != nil {
+		return 0, nil, err
+	}
+
+	return version, program, nil
+}
+
+// checkWitnessProgram validates the witness version and program length rules of BIP-141
+func checkWitnessProgram(version int, program []byte) error {
+	if version < 0 || version > 16 {
+		return fmt.Errorf("%w: version %d", ErrSegwitProgram, version)
+	}
+	if len(program) < 2 || len(program) > 40 {
+		return fmt.Errorf("%w: length %d", ErrSegwitProgram, len(program))
+	}
+	if version == 0 && len(program) != 20 && len(program) != 32 {
+		return fmt.Errorf("%w: version 0 length %d", ErrSegwitProgram, len(program))
+	}
+	return nil
+}
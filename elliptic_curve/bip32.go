@@ -0,0 +1,260 @@
+package elliptic_curve
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"math/big"
+
+	"github.com/sudonite/bitcoin/chaincfg"
+)
+
+// BIP-32 serialization version bytes and sizes
+const (
+	MAINNET_XPUB_VERSION = 0x0488b21e
+	MAINNET_XPRV_VERSION = 0x0488ade4
+	TESTNET_XPUB_VERSION = 0x043587cf
+	TESTNET_XPRV_VERSION = 0x04358394
+
+	HARDENED_KEY_START    = 0x80000000
+	EXTENDED_KEY_LENGTH   = 78
+	KEY_FINGERPRINT_SIZE  = 4
+	BIP32_CHAIN_CODE_SIZE = 32
+)
+
+var (
+	ErrExtendedKey     = errors.New("invalid extended key")
+	ErrHardenedPublic  = errors.New("cannot derive a hardened child from a public key")
+	ErrInvalidChildKey = errors.New("derived child key is invalid, use the next index")
+)
+
+// ExtendedKey is a BIP-32 key together with its chain code and position in the tree
+type ExtendedKey struct {
+	version           uint32
+	depth             byte
+	parentFingerprint []byte
+	childNumber       uint32
+	chainCode         []byte
+	// privateKey is nil for extended public keys
+	privateKey *PrivateKey
+	publicKey  *Point
+}
+
+// NewMasterKey derives the master extended private key from a seed
+func NewMasterKey(seed []byte, params *chaincfg.Params) (*ExtendedKey, error) {
+	mac := hmac.New(sha512.New, []byte("Bitcoin seed"))
+	mac.Write(seed)
+	sum := mac.Sum(nil)
+
+	secret := new(big.Int).SetBytes(sum[:32])
+	if secret.Sign() == 0 || secret.Cmp(GetBitcoinValueN()) >= 0 {
+		return nil, ErrInvalidChildKey
+	}
+
+	key := NewPrivateKey(secret)
+	return &ExtendedKey{
+		version:           params.HDPrivateKeyID,
+		parentFingerprint: make([]byte, KEY_FINGERPRINT_SIZE),
+		chainCode:         sum[32:],
+		privateKey:        key,
+		publicKey:         key.GetPublicKey(),
+	}, nil
+}
+
+// ParseExtendedKey decodes a base58 xpub, xprv, tpub or tprv string
+func ParseExtendedKey(s string) (*ExtendedKey, error) {
+	versionByte, payload, err := DecodeBase58Check(s)
+	if err != nil {
+		return nil, err
+	}
+	raw := append([]byte{versionByte}, payload...)
+	if len(raw) != EXTENDED_KEY_LENGTH {
+		return nil, ErrExtendedKey
+	}
+
+	key := &ExtendedKey{
+		version:           binary.BigEndian.Uint32(raw[0:4]),
+		depth:             raw[4],
+		parentFingerprint: raw[5:9],
+		childNumber:       binary.BigEndian.Uint32(raw[9:13]),
+		chainCode:         raw[13:45],
+	}
+	if key.depth == 0 && (binary.BigEndian.Uint32(key.parentFingerprint) != 0 || key.childNumber != 0) {
+		return nil, ErrExtendedKey
+	}
+
+	keyData := raw[45:]
+	switch key.version {
+	case MAINNET_XPRV_VERSION, TESTNET_XPRV_VERSION:
+		secret := new(big.Int).SetBytes(keyData[1:])
+		if keyData[0] != 0x00 || secret.Sign() == 0 || secret.Cmp(GetBitcoinValueN()) >= 0 {
+			return nil, ErrExtendedKey
+		}
+		key.privateKey = NewPrivateKey(secret)
+		key.publicKey = key.privateKey.GetPublicKey()
+	case MAINNET_XPUB_VERSION, TESTNET_XPUB_VERSION:
+		if keyData[0] != 0x02 && keyData[0] != 0x03 {
+			return nil, ErrExtendedKey
+		}
+		point, err := parseCompressedPoint(keyData)
+		if err != nil {
+			return nil, err
+		}
+		key.publicKey = point
+	default:
+		return nil, ErrExtendedKey
+	}
+
+	return key, nil
+}
+
+// parseCompressedPoint parses a compressed SEC key, rejecting x coordinates that are not on the curve
+func parseCompressedPoint(sec []byte) (*Point, error) {
+	point, err := ParseSEC(sec)
+	if err != nil {
+		return nil, ErrExtendedKey
+	}
+	return point, nil
+}
+
+// String serializes the key in base58 with its checksum
+func (k *ExtendedKey) String() string {
+	raw := binary.BigEndian.AppendUint32(nil, k.version)
+	raw = append(raw, k.depth)
+	raw = append(raw, k.parentFingerprint...)
+	raw = binary.BigEndian.AppendUint32(raw, k.childNumber)
+	raw = append(raw, k.chainCode...)
+	if k.privateKey != nil {
+		raw = append(raw, 0x00)
+		raw = append(raw, k.privateKey.secret.FillBytes(make([]byte, 32))...)
+	} else {
+		raw = append(raw, k.PublicKey()...)
+	}
+	return Base58Checksum(raw)
+}
+
+// IsPrivate checks whether the extended key holds a private key
+func (k *ExtendedKey) IsPrivate() bool {
+	return k.privateKey != nil
+}
+
+// IsTestnet checks whether the key uses the testnet version bytes
+func (k *ExtendedKey) IsTestnet() bool {
+	return k.version == TESTNET_XPUB_VERSION || k.version == TESTNET_XPRV_VERSION
+}
+
+// IsForNet checks whether the key uses the version bytes of the network
+func (k *ExtendedKey) IsForNet(params *chaincfg.Params) bool {
+	return k.version == params.HDPublicKeyID || k.version == params.HDPrivateKeyID
+}
+
+// Depth returns how many derivations separate the key from the master key
+func (k *ExtendedKey) Depth() byte {
+	return k.depth
+}
+
+// ChildNumber returns the index the key was derived at
+func (k *ExtendedKey) ChildNumber() uint32 {
+	return k.childNumber
+}
+
+// PrivateKey returns the private key, nil for extended public keys
+func (k *ExtendedKey) PrivateKey() *PrivateKey {
+	return k.privateKey
+}
+
+// PublicKey returns the compressed SEC public key
+func (k *ExtendedKey) PublicKey() []byte {
+	_, sec := k.publicKey.Sec(true)
+	return sec
+}
+
+// Fingerprint returns the first 4 bytes of the hash160 of the public key
+func (k *ExtendedKey) Fingerprint() []byte {
+	return Hash160(k.PublicKey())[:KEY_FINGERPRINT_SIZE]
+}
+
+// Neuter returns the extended public key of the key
+func (k *ExtendedKey) Neuter() *ExtendedKey {
+	if k.privateKey == nil {
+		return k
+	}
+
+	version := uint32(MAINNET_XPUB_VERSION)
+	if k.IsTestnet() {
+		version = TESTNET_XPUB_VERSION
+	}
+	return &ExtendedKey{
+		version:           version,
+		depth:             k.depth,
+		parentFingerprint: k.parentFingerprint,
+		childNumber:       k.childNumber,
+		chainCode:         k.chainCode,
+		publicKey:         k.publicKey,
+	}
+}
+
+// Child derives the child key at index, indexes from HARDENED_KEY_START up are hardened
+func (k *ExtendedKey) Child(index uint32) (*ExtendedKey, error) {
+	data := []byte{}
+	if index >= HARDENED_KEY_START {
+		if k.privateKey == nil {
+			return nil, ErrHardenedPublic
+		}
+		data = append(data, 0x00)
+		data = append(data, k.privateKey.secret.FillBytes(make([]byte, 32))...)
+	} else {
+		data = append(data, k.PublicKey()...)
+	}
+	data = binary.BigEndian.AppendUint32(data, index)
+
+	mac := hmac.New(sha512.New, k.chainCode)
+	mac.Write(data)
+	sum := mac.Sum(nil)
+
+	n := GetBitcoinValueN()
+	tweak := new(big.Int).SetBytes(sum[:32])
+	if tweak.Cmp(n) >= 0 {
+		return nil, ErrInvalidChildKey
+	}
+
+	child := &ExtendedKey{
+		version:           k.version,
+		depth:             k.depth + 1,
+		parentFingerprint: k.Fingerprint(),
+		childNumber:       index,
+		chainCode:         sum[32:],
+	}
+
+	if k.privateKey != nil {
+		secret := new(big.Int).Add(tweak, k.privateKey.secret)
+		secret.Mod(secret, n)
+		if secret.Sign() == 0 {
+			return nil, ErrInvalidChildKey
+		}
+		child.privateKey = NewPrivateKey(secret)
+		child.publicKey = child.privateKey.GetPublicKey()
+		return child, nil
+	}
+
+	point := GetGenerator().ScalarMul(tweak).Add(k.publicKey)
+	if point.IsInfinity() {
+		return nil, ErrInvalidChildKey
+	}
+	child.publicKey = point
+	return child, nil
+}
+
+// Derive follows a path of child indexes from the key
+func (k *ExtendedKey) Derive(path []uint32) (*ExtendedKey, error) {
+	key := k
+	for _, index := range path {
+		child, err := key.Child(index)
+		if err != nil {
+			return nil, err
+		}
+		key = child
+	}
+	return key, nil
+}
@@ -0,0 +1,99 @@
+package elliptic_curve
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/sudonite/bitcoin/chaincfg"
+)
+
+// BIP-32 test vector 1
+func TestExtendedKeyDerivation(t *testing.T) {
+	seed, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	master, err := NewMasterKey(seed, &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		path []uint32
+		xpub string
+		xprv string
+	}{
+		{"m", nil,
+			"xpub661MyMwAqRbcFtXgS5sYJABqqG9YLmC4Q1Rdap9gSE8NqtwybGhePY2gZ29ESFjqJoCu1Rupje8YtGqsefD265TMg7usUDFdp6W1EGMcet8",
+			"xprv9s21ZrQH143K3QTDL4LXw2F7HEK3wJUD2nW2nRk4stbPy6cq3jPPqjiChkVvvNKmPGJxWUtg6LnF5kejMRNNU3TGtRBeJgk33yuGBxrMPHi"},
+		{"m/0H", []uint32{HARDENED_KEY_START},
+			"xpub68Gmy5EdvgibQVfPdqkBBCHxA5htiqg55crXYuXoQRKfDBFA1WEjWgP6LHhwBZeNK1VTsfTFUHCdrfp1bgwQ9xv5ski8PX9rL2dZXvgGDnw",
+			"xprv9uHRZZhk6KAJC1avXpDAp4MDc3sQKNxDiPvvkX8Br5ngLNv1TxvUxt4cV1rGL5hj6KCesnDYUhd7oWgT11eZG7XnxHrnYeSvkzY7d2bhkJ7"},
+		{"m/0H/1", []uint32{HARDENED_KEY_START, 1},
+			"xpub6ASuArnXKPbfEwhqN6e3mwBcDTgzisQN1wXN9BJcM47sSikHjJf3UFHKkNAWbWMiGj7Wf5uMash7SyYq527Hqck2AxYysAA7xmALppuCkwQ",
+			"xprv9wTYmMFdV23N2TdNG573QoEsfRrWKQgWeibmLntzniatZvR9BmLnvSxqu53Kw1UmYPxLgboyZQaXwTCg8MSY3H2EU4pWcQDnRnrVA1xe8fs"},
+		{"m/0H/1/2H", []uint32{HARDENED_KEY_START, 1, HARDENED_KEY_START + 2},
+			"xpub6D4BDPcP2GT577Vvch3R8wDkScZWzQzMMUm3PWbmWvVJrZwQY4VUNgqFJPMM3No2dFDFGTsxxpG5uJh7n7epu4trkrX7x7DogT5Uv6fcLW5",
+			"xprv9z4pot5VBttmtdRTWfWQmoH1taj2axGVzFqSb8C9xaxKymcFzXBDptWmT7FwuEzG3ryjH4ktypQSAewRiNMjANTtpgP4mLTj34bhnZX7UiM"},
+		{"m/0H/1/2H/2/1000000000", []uint32{HARDENED_KEY_START, 1, HARDENED_KEY_START + 2, 2, 1000000000},
+			"xpub6H1LXWLaKsWFhvm6RVpEL9P4KfRZSW7abD2ttkWP3SSQvnyA8FSVqNTEcYFgJS2UaFcxupHiYkro49S8yGasTvXEYBVPamhGW6cFJodrTHy",
+			"xprvA41z7zogVVwxVSgdKUHDy1SKmdb533PjDz7J6N6mV6uS3ze1ai8FHa8kmHScGpWmj4WggLyQjgPie1rFSruoUihUZREPSL39UNdE3BBDu76"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := master.Derive(tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := key.String(); got != tt.xprv {
+				t.Errorf("xprv %s, want %s", got, tt.xprv)
+			}
+			if got := key.Neuter().String(); got != tt.xpub {
+				t.Errorf("xpub %s, want %s", got, tt.xpub)
+			}
+
+			// both serializations parse back to the same key
+			for _, s := range []string{tt.xprv, tt.xpub} {
+				parsed, err := ParseExtendedKey(s)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if parsed.String() != s {
+					t.Errorf("parsed %s, want %s", parsed, s)
+				}
+			}
+		})
+	}
+
+	// public derivation of a non-hardened child matches the private one, hardened children need the private key
+	parent, err := ParseExtendedKey(tests[1].xpub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if child, err := parent.Child(1); err != nil || child.String() != tests[2].xpub {
+		t.Errorf("public derivation %v, %v, want %s", child, err, tests[2].xpub)
+	}
+	if _, err := parent.Derive([]uint32{1, HARDENED_KEY_START + 2}); !errors.Is(err, ErrHardenedPublic) {
+		t.Errorf("hardened derivation from an xpub: error %v, want %v", err, ErrHardenedPublic)
+	}
+}
+
+func TestParseExtendedKeyInvalid(t *testing.T) {
+	xpub := "xpub661MyMwAqRbcFtXgS5sYJABqqG9YLmC4Q1Rdap9gSE8NqtwybGhePY2gZ29ESFjqJoCu1Rupje8YtGqsefD265TMg7usUDFdp6W1EGMcet8"
+	tests := []struct {
+		name string
+		key  string
+	}{
+		{"bad checksum", xpub[:len(xpub)-1] + "9"},
+		{"truncated", xpub[:len(xpub)-4]},
+		{"not base58", "xpub0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseExtendedKey(tt.key); err == nil {
+				t.Error("key parsed")
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package elliptic_curve
+
+import "math/big"
+
+// ContractTweak returns the tweak committing the key to data, the tagged hash of the compressed key and the data
+func ContractTweak(p *Point, data []byte) []byte {
+	_, sec := p.Sec(true)
+	return TaggedHash("PayToContract", sec, data)
+}
+
+// PayToContract returns P + H(P || data)G, a key that looks like any other but commits to data.
+// Only who knows the original key and the data can show the commitment
+func (p *Point) PayToContract(data []byte) (*Point, error) {
+	t := new(big.Int).SetBytes(ContractTweak(p, data))
+	if t.Cmp(GetBitcoinValueN()) >= 0 {
+		return nil, ErrInvalidTweak
+	}
+
+	result := p.Add(GetGenerator().ScalarMul(t))
+	if result.IsInfinity() {
+		return nil, ErrInvalidTweak
+	}
+	return result, nil
+}
+
+// PayToContract returns the private key of the point produced by Point.PayToContract with the same data
+func (p *PrivateKey) PayToContract(data []byte) (*PrivateKey, error) {
+	n := GetBitcoinValueN()
+	t := new(big.Int).SetBytes(ContractTweak(p.point, data))
+	if t.Cmp(n) >= 0 {
+		return nil, ErrInvalidTweak
+	}
+
+	d := new(big.Int).Add(p.secret, t)
+	d.Mod(d, n)
+	if d.Sign() == 0 {
+		return nil, ErrInvalidTweak
+	}
+
+	return NewPrivateKey(d), nil
+}
+
+// VerifyContractCommitment checks that tweaked is the original key committed to data
+func VerifyContractCommitment(original *Point, tweaked *Point, data []byte) bool {
+	expected, err := original.PayToContract(data)
+	if err != nil || tweaked.IsInfinity() {
+		return false
+	}
+	return expected.Equal(tweaked)
+}
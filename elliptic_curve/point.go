@@ -3,6 +3,8 @@ package elliptic_curve
 import (
 	"fmt"
 	"math/big"
+
+	"github.com/sudonite/bitcoin/chaincfg"
 )
 
 // Operation type for field arithmetic
@@ -218,40 +220,41 @@ func (p *Point) Verify(z *FieldElement, sig *Signature) bool {
 	v := sig.r.Multiply(sInverse)
 	G := GetGenerator()
 	total := (G.ScalarMul(u.num)).Add(p.ScalarMul(v.num))
+	if total.x == nil {
+		// the point at infinity never matches
+		return false
+	}
 	return total.x.num.Cmp(sig.r.num) == 0
 }
 
 // Returns the SEC (Standards for Efficient Cryptography) uncompressed serialization of the point
 func (p *Point) Sec(compressed bool) (string, []byte) {
 	secBytes := []byte{}
+	// coordinates are always encoded on 32 bytes, even with leading zeros
+	xBytes := p.x.num.FillBytes(make([]byte, 32))
 
 	if !compressed {
 		secBytes = append(secBytes, 0x04)
-		secBytes = append(secBytes, p.x.num.Bytes()...)
-		secBytes = append(secBytes, p.y.num.Bytes()...)
+		secBytes = append(secBytes, xBytes...)
+		secBytes = append(secBytes, p.y.num.FillBytes(make([]byte, 32))...)
 		return fmt.Sprintf("04%064x%064x", p.x.num, p.y.num), secBytes
 	}
 
 	if new(big.Int).Mod(p.y.num, big.NewInt(2)).Cmp(big.NewInt(0)) == 0 {
 		secBytes = append(secBytes, 0x02)
-		secBytes = append(secBytes, p.x.num.Bytes()...)
+		secBytes = append(secBytes, xBytes...)
 		return fmt.Sprintf("02%064x", p.x.num), secBytes
 	} else {
 		secBytes = append(secBytes, 0x03)
-		secBytes = append(secBytes, p.x.num.Bytes()...)
+		secBytes = append(secBytes, xBytes...)
 		return fmt.Sprintf("03%064x", p.x.num), secBytes
 	}
 }
 
 // Generates a Bitcoin address from an EC point
-func (p *Point) Address(compressed bool, testnet bool) string {
+func (p *Point) Address(compressed bool, params *chaincfg.Params) string {
 	hash160 := p.hash160(compressed)
-	prefix := []byte{}
-	if testnet {
-		prefix = append(prefix, 0x6f)
-	} else {
-		prefix = append(prefix, 0x00)
-	}
+	prefix := []byte{params.PubKeyHashAddrID}
 
 	return Base58Checksum(append(prefix, hash160...))
 }
@@ -0,0 +1,186 @@
+package elliptic_curve
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+var (
+	ErrInvalidXOnlyKey = errors.New("invalid x-only public key")
+	ErrInvalidTweak    = errors.New("tweak is not smaller than the curve order")
+)
+
+// TaggedHash computes the BIP-340 tagged hash SHA256(SHA256(tag) || SHA256(tag) || data...)
+func TaggedHash(tag string, data ...[]byte) []byte {
+	tagHash := sha256.Sum256([]byte(tag))
+	hasher := sha256.New()
+	hasher.Write(tagHash[:])
+	hasher.Write(tagHash[:])
+	for _, item := range data {
+		hasher.Write(item)
+	}
+	return hasher.Sum(nil)
+}
+
+// LiftX returns the point with the given x coordinate and an even y coordinate
+func LiftX(xBin []byte) (*Point, error) {
+	if len(xBin) != 32 {
+		return nil, ErrInvalidXOnlyKey
+	}
+
+	x := new(big.Int).SetBytes(xBin)
+	p := S256Field(big.NewInt(0)).order
+	if x.Cmp(p) >= 0 {
+		return nil, ErrInvalidXOnlyKey
+	}
+
+	ySquare := S256Field(x).Power(big.NewInt(3)).Add(S256Field(big.NewInt(7)))
+	y := ySquare.Sqrt()
+	if y.Power(big.NewInt(2)).EqualTo(ySquare) != true {
+		// x is not on the curve
+		return nil, ErrInvalidXOnlyKey
+	}
+
+	if y.num.Bit(0) == 1 {
+		y = y.Negate()
+	}
+	return S256Point(x, y.num), nil
+}
+
+// XOnly returns the 32 bytes x coordinate of the point as used by BIP-340
+func (p *Point) XOnly() []byte {
+	return p.x.num.FillBytes(make([]byte, 32))
+}
+
+// HasEvenY checks whether the y coordinate of the point is even
+func (p *Point) HasEvenY() bool {
+	return p.y.num.Bit(0) == 0
+}
+
+// IsInfinity checks whether the point is the point at infinity
+func (p *Point) IsInfinity() bool {
+	return p.x == nil
+}
+
+// XOnlyTweakAdd lifts the point to its even y version and adds tweak*G, as done by BIP-341 output keys
+func (p *Point) XOnlyTweakAdd(tweak []byte) (*Point, error) {
+	t := new(big.Int).SetBytes(tweak)
+	if t.Cmp(GetBitcoinValueN()) >= 0 {
+		return nil, ErrInvalidTweak
+	}
+
+	internal, err := LiftX(p.XOnly())
+	if err != nil {
+		return nil, err
+	}
+
+	result := internal.Add(GetGenerator().ScalarMul(t))
+	if result.IsInfinity() {
+		return nil, ErrInvalidTweak
+	}
+	return result, nil
+}
+
+// SchnorrVerify verifies a 64 bytes BIP-340 signature of msg against a 32 bytes x-only public key
+func SchnorrVerify(pubKey []byte, msg []byte, sig []byte) bool {
+	if len(sig) != 64 {
+		return false
+	}
+
+	point, err := LiftX(pubKey)
+	if err != nil {
+		return false
+	}
+
+	n := GetBitcoinValueN()
+	r := new(big.Int).SetBytes(sig[0:32])
+	if r.Cmp(S256Field(big.NewInt(0)).order) >= 0 {
+		return false
+	}
+	s := new(big.Int).SetBytes(sig[32:64])
+	if s.Cmp(n) >= 0 {
+		return false
+	}
+
+	e := new(big.Int).SetBytes(TaggedHash("BIP0340/challenge", sig[0:32], pubKey, msg))
+	e.Mod(e, n)
+
+	// R = s*G - e*P, computed as s*G + (n-e)*P
+	negE := new(big.Int).Sub(n, e)
+	R := GetGenerator().ScalarMul(s).Add(point.ScalarMul(negE))
+	if R.IsInfinity() || R.HasEvenY() != true {
+		return false
+	}
+
+	return R.x.num.Cmp(r) == 0
+}
+
+// SignSchnorr creates a BIP-340 signature of the 32 bytes msg, auxRand is drawn from crypto/rand when nil
+func (p *PrivateKey) SignSchnorr(msg []byte, auxRand []byte) []byte {
+	n := GetBitcoinValueN()
+	G := GetGenerator()
+
+	if auxRand == nil {
+		auxRand = make([]byte, 32)
+		if _, err := rand.Read(auxRand); err != nil {
+			panic(fmt.Sprintf("SignSchnorr err with rand: %s", err))
+		}
+	}
+
+	d := new(big.Int).Set(p.secret)
+	if p.point.HasEvenY() != true {
+		d.Sub(n, d)
+	}
+	pubKey := p.point.XOnly()
+
+	auxHash := TaggedHash("BIP0340/aux", auxRand)
+	masked := d.FillBytes(make([]byte, 32))
+	for i := range masked {
+		masked[i] ^= auxHash[i]
+	}
+
+	k := new(big.Int).SetBytes(TaggedHash("BIP0340/nonce", masked, pubKey, msg))
+	k.Mod(k, n)
+	if k.Sign() == 0 {
+		panic("SignSchnorr derived a zero nonce")
+	}
+
+	R := G.ScalarMul(k)
+	if R.HasEvenY() != true {
+		k.Sub(n, k)
+	}
+	rBin := R.XOnly()
+
+	e := new(big.Int).SetBytes(TaggedHash("BIP0340/challenge", rBin, pubKey, msg))
+	e.Mod(e, n)
+
+	s := new(big.Int).Mul(e, d)
+	s.Add(s, k)
+	s.Mod(s, n)
+
+	return append(rBin, s.FillBytes(make([]byte, 32))...)
+}
+
+// XOnlyTweakAdd returns the private key of the point produced by Point.XOnlyTweakAdd with the same tweak
+func (p *PrivateKey) XOnlyTweakAdd(tweak []byte) (*PrivateKey, error) {
+	n := GetBitcoinValueN()
+	t := new(big.Int).SetBytes(tweak)
+	if t.Cmp(n) >= 0 {
+		return nil, ErrInvalidTweak
+	}
+
+	d := new(big.Int).Set(p.secret)
+	if p.point.HasEvenY() != true {
+		d.Sub(n, d)
+	}
+	d.Add(d, t)
+	d.Mod(d, n)
+	if d.Sign() == 0 {
+		return nil, ErrInvalidTweak
+	}
+
+	return NewPrivateKey(d), nil
+}
@@ -1,9 +1,10 @@
 package elliptic_curve
 
 import (
-	"bufio"
 	"bytes"
 	"crypto/sha256"
+	"errors"
+	"fmt"
 	"math/big"
 	"strings"
 
@@ -11,6 +12,13 @@ import (
 	"golang.org/x/crypto/ripemd160"
 )
 
+var (
+	ErrBase58Alphabet   = errors.New("can't find char in base58 alphabet")
+	ErrBase58Checksum   = errors.New("decode base58 checksum error")
+	ErrInvalidSEC       = errors.New("invalid SEC public key")
+	ErrInvalidSignature = errors.New("invalid DER signature")
+)
+
 // Performs SHA256(SHA256(text)) hashing
 func Hash256(text string) []byte {
 	hashOnce := sha256.Sum256([]byte(text))
@@ -36,33 +44,35 @@ func GetBitcoinValueN() *big.Int {
 	return n
 }
 
-// Parses a SEC serialized point (compressed or uncompressed)
-func ParseSEC(secBin []byte) *Point {
-	if secBin[0] == 4 {
+// Parses a SEC serialized point (compressed or uncompressed), rejecting points that are not on the curve
+func ParseSEC(secBin []byte) (*Point, error) {
+	if len(secBin) == 65 && secBin[0] == 4 {
 		x := new(big.Int).SetBytes(secBin[1:33])
 		y := new(big.Int).SetBytes(secBin[33:65])
-		return S256Point(x, y)
+		p := S256Field(big.NewInt(0)).order
+		if x.Cmp(p) >= 0 || y.Cmp(p) >= 0 {
+			return nil, ErrInvalidSEC
+		}
+		// y^2 = x^3 + 7
+		right := S256Field(x).Power(big.NewInt(3)).Add(S256Field(big.NewInt(7)))
+		if S256Field(y).Power(big.NewInt(2)).EqualTo(right) != true {
+			return nil, ErrInvalidSEC
+		}
+		return S256Point(x, y), nil
 	}
 
-	x := new(big.Int).SetBytes(secBin[1:])
-	y := S256Field(x).Power(big.NewInt(3)).Add(S256Field(big.NewInt(7))).Sqrt()
-
-	var yEven *FieldElement
-	var yOdd *FieldElement
-
-	if new(big.Int).Mod(y.num, big.NewInt(2)).Cmp(big.NewInt(0)) == 0 {
-		yEven = y
-		yOdd = y.Negate()
-	} else {
-		yEven = y.Negate()
-		yOdd = y
+	if len(secBin) != 33 || (secBin[0] != 2 && secBin[0] != 3) {
+		return nil, ErrInvalidSEC
 	}
-
-	if secBin[0] == 2 {
-		return S256Point(x, yEven.num)
+	// LiftX gives the even y, the odd one is its negation
+	point, err := LiftX(secBin[1:])
+	if err != nil {
+		return nil, ErrInvalidSEC
 	}
-
-	return S256Point(x, yOdd.num)
+	if secBin[0] == 3 {
+		return S256Point(point.x.num, point.y.Negate().num), nil
+	}
+	return point, nil
 }
 
 // Encodes a byte slice into a Bitcoin-style Base58 string
@@ -97,6 +107,16 @@ func EncodeBase58(s []byte) string {
 
 // Decodes a Base58Check-encoded string into raw bytes
 func DecodeBase58(s string) []byte {
+	_, payload, err := DecodeBase58Check(s)
+	if err != nil {
+		panic(err)
+	}
+
+	return payload
+}
+
+// DecodeBase58Check decodes a Base58Check string into its version byte and payload
+func DecodeBase58Check(s string) (byte, []byte, error) {
 	BASE58_ALPHABET := "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
 	num := big.NewInt(int64(0))
 	for _, char := range s {
@@ -105,20 +125,29 @@ func DecodeBase58(s string) []byte {
 
 		idx := strings.Index(BASE58_ALPHABET, string(char))
 		if idx == -1 {
-			panic("can't find char in base58 alphabet")
+			return 0, nil, ErrBase58Alphabet
 		}
 		addOp := new(big.Int)
 		num = addOp.Add(num, big.NewInt(int64(idx)))
 	}
 
-	combined := num.Bytes()
+	// every leading '1' stands for a leading zero byte that the number drops
+	combined := []byte{}
+	for i := 0; i < len(s) && s[i] == '1'; i++ {
+		combined = append(combined, 0x00)
+	}
+	combined = append(combined, num.Bytes()...)
+	if len(combined) < 5 {
+		return 0, nil, ErrBase58Checksum
+	}
+
 	checksum := combined[len(combined)-4:]
 	h256 := Hash256(string(combined[0 : len(combined)-4]))
 	if bytes.Equal(h256[0:4], checksum) != true {
-		panic("decode base58 checksum error")
+		return 0, nil, ErrBase58Checksum
 	}
 
-	return combined[1 : len(combined)-4]
+	return combined[0], combined[1 : len(combined)-4], nil
 }
 
 // Computes Base58Check encoding: payload + first 4 bytes of double SHA256 checksum
@@ -190,56 +219,37 @@ func LittleEndianToBigInt(bytes []byte, length LITTLE_ENDIAN_LENGTH) *big.Int {
 }
 
 // Parses a DER-encoded ECDSA signature and returns a Signature object
-func ParseSigBin(sigBin []byte) *Signature {
-	reader := bytes.NewReader(sigBin)
-	bufReader := bufio.NewReader(reader)
-
-	firstByte := make([]byte, 1)
-	bufReader.Read(firstByte)
-	if firstByte[0] != 0x30 {
-		panic("Bad signature, the first byte is not 0x30")
+func ParseSigBin(sigBin []byte) (*Signature, error) {
+	if len(sigBin) < 8 || sigBin[0] != 0x30 {
+		return nil, fmt.Errorf("%w: the first byte is not 0x30", ErrInvalidSignature)
 	}
-
-	lenBuf := make([]byte, 1)
-	bufReader.Read(lenBuf)
-
-	if lenBuf[0]+2 != byte(len(sigBin)) {
-		panic("Bad signature length")
+	if int(sigBin[1])+2 != len(sigBin) {
+		return nil, fmt.Errorf("%w: bad signature length", ErrInvalidSignature)
 	}
 
-	marker := make([]byte, 1)
-	bufReader.Read(marker)
-	if marker[0] != 0x02 {
-		panic("signature marker for r is not 0x02")
+	if sigBin[2] != 0x02 {
+		return nil, fmt.Errorf("%w: marker for r is not 0x02", ErrInvalidSignature)
 	}
-
-	lenBuf = make([]byte, 1)
-	bufReader.Read(lenBuf)
-
-	rLength := lenBuf[0]
-	rBin := make([]byte, rLength)
-	bufReader.Read(rBin)
-
-	r := new(big.Int)
-	r.SetBytes(rBin)
-
-	marker = make([]byte, 1)
-	bufReader.Read(marker)
-	if marker[0] != 0x02 {
-		panic("Signature marker for s is not 0x02")
+	rLength := int(sigBin[3])
+	// r is followed by at least the marker and length of s
+	if 4+rLength+2 > len(sigBin) {
+		return nil, fmt.Errorf("%w: r runs past the end", ErrInvalidSignature)
 	}
+	r := new(big.Int).SetBytes(sigBin[4 : 4+rLength])
 
-	lenBuf = make([]byte, 1)
-	bufReader.Read(lenBuf)
-	sLength := lenBuf[0]
-	sBin := make([]byte, sLength)
-	bufReader.Read(sBin)
-	s := new(big.Int)
-	s.SetBytes(sBin)
-	if len(sigBin) != int(6+rLength+sLength) {
-		panic("Signature wrong length")
+	sigBin = sigBin[4+rLength:]
+	if sigBin[0] != 0x02 {
+		return nil, fmt.Errorf("%w: marker for s is not 0x02", ErrInvalidSignature)
+	}
+	sLength := int(sigBin[1])
+	if len(sigBin) != 2+sLength {
+		return nil, fmt.Errorf("%w: wrong length", ErrInvalidSignature)
 	}
+	s := new(big.Int).SetBytes(sigBin[2:])
 
 	n := GetBitcoinValueN()
-	return NewSignature(NewFieldElement(n, r), NewFieldElement(n, s))
+	if r.Sign() == 0 || s.Sign() == 0 || r.Cmp(n) >= 0 || s.Cmp(n) >= 0 {
+		return nil, fmt.Errorf("%w: r and s must be between 1 and n-1", ErrInvalidSignature)
+	}
+	return NewSignature(NewFieldElement(n, r), NewFieldElement(n, s)), nil
 }
@@ -4,6 +4,7 @@ import (
 	"encoding/hex"
 	"fmt"
 
+	"github.com/sudonite/bitcoin/chaincfg"
 	"github.com/sudonite/bitcoin/transaction"
 )
 
@@ -12,16 +13,25 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
-	tx := transaction.ParseTransaction(txBinary)
-	tx.SetTestnet()
+	tx, err := transaction.ParseTransaction(txBinary)
+	if err != nil {
+		panic(err)
+	}
+	tx.SetParams(&chaincfg.TestNet3Params)
 	fmt.Printf("hash:%x\n", tx.Hash())
 	// check p2wpkh transaction
-	script := tx.GetScript(0, true)
+	script, err := tx.GetScript(0, tx.Params())
+	if err != nil {
+		panic(err)
+	}
 	isP2wpkh := tx.IsP2wpkh(script)
 	fmt.Printf("is segwit: %v\n", isP2wpkh)
 
 	// BIP0134 verify message
-	z := tx.BIP143SigHash(0)
+	z, err := tx.BIP143SigHash(0)
+	if err != nil {
+		panic(err)
+	}
 	fmt.Printf("verify msg: %x\n", z)
 
 	// verify the transaction
@@ -0,0 +1,74 @@
+package merkletree
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/sudonite/bitcoin/transaction"
+)
+
+var (
+	ErrNoTransactions        = errors.New("block has no transactions")
+	ErrWitnessCommitment     = errors.New("witness commitment mismatch")
+	ErrWitnessReservedValue  = errors.New("coinbase witness reserved value is malformed")
+	ErrUnexpectedWitnessData = errors.New("witness data without a commitment")
+)
+
+// WitnessMerkleRoot computes the merkle root of the wtxids of a block, the coinbase counts as zero.
+// Hashes are in internal byte order, the reverse of the hex shown for txids.
+func WitnessMerkleRoot(txs []*transaction.Transaction) []byte {
+	hashes := make([][]byte, 0, len(txs))
+	for i, tx := range txs {
+		if i == 0 {
+			// the coinbase cannot commit to its own wtxid
+			hashes = append(hashes, make([]byte, 32))
+			continue
+		}
+		hashes = append(hashes, transaction.ReverseByteSlice(tx.WitnessHash()))
+	}
+	return MerkleRoot(hashes)
+}
+
+// VerifyWitnessCommitment checks the BIP-141 commitment in the coinbase against the transactions of a block
+func VerifyWitnessCommitment(txs []*transaction.Transaction) error {
+	if len(txs) == 0 {
+		return ErrNoTransactions
+	}
+
+	coinbase := txs[0]
+	commitment, ok := coinbase.WitnessCommitment()
+	if !ok {
+		// blocks without a commitment must not carry witness data
+		for _, tx := range txs {
+			if tx.HasWitness() {
+				return ErrUnexpectedWitnessData
+			}
+		}
+		return nil
+	}
+
+	reservedValue, ok := coinbase.WitnessReservedValue()
+	if !ok {
+		return ErrWitnessReservedValue
+	}
+
+	expected := transaction.ComputeWitnessCommitment(WitnessMerkleRoot(txs), reservedValue)
+	if !bytes.Equal(commitment, expected) {
+		return ErrWitnessCommitment
+	}
+	return nil
+}
+
+// AddWitnessCommitment puts the commitment to the witness data of the block in its coinbase,
+// the coinbase must be the first transaction
+func AddWitnessCommitment(txs []*transaction.Transaction, reservedValue []byte) error {
+	if len(txs) == 0 {
+		return ErrNoTransactions
+	}
+	if len(reservedValue) != transaction.WITNESS_RESERVED_VALUE_SIZE {
+		return ErrWitnessReservedValue
+	}
+
+	txs[0].SetWitnessCommitment(WitnessMerkleRoot(txs), reservedValue)
+	return nil
+}
@@ -3,10 +3,10 @@ package networking
 import (
 	"bufio"
 	"bytes"
-	"encoding/hex"
 	"fmt"
 	"math/big"
 
+	"github.com/sudonite/bitcoin/chaincfg"
 	tx "github.com/sudonite/bitcoin/transaction"
 )
 
@@ -19,13 +19,9 @@ type GetHeaderMessage struct {
 	endBlock   []byte
 }
 
-// GetGenesisBlockHash returns the hash of the Bitcoin genesis block.
-func GetGenesisBlockHash() []byte {
-	genesisBlockRawData, err := hex.DecodeString("0100000000000000000000000000000000000000000000000000000000000000000000003ba3edfd7a7b12b27ac72c3e67768f617fc81bc3888a51323a9fb8aa4b1e5e4a29ab5f49ffff001d1dac2b7c")
-	if err != nil {
-		panic(err)
-	}
-	genesisBlock := tx.ParseBlock(genesisBlockRawData)
+// GetGenesisBlockHash returns the hash of the genesis block of the network.
+func GetGenesisBlockHash(params *chaincfg.Params) []byte {
+	genesisBlock := tx.ParseBlock(params.GenesisBlock)
 	return genesisBlock.Hash()
 }
 
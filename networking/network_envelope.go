@@ -7,6 +7,7 @@ import (
 	"io"
 	"math/big"
 
+	"github.com/sudonite/bitcoin/chaincfg"
 	ecc "github.com/sudonite/bitcoin/elliptic_curve"
 	tx "github.com/sudonite/bitcoin/transaction"
 )
@@ -15,29 +16,22 @@ import (
 type NetworkEnvelope struct {
 	command []byte
 	payload []byte
-	testnet bool
+	params  *chaincfg.Params
 	magic   []byte
 }
 
 // NewNetworkEnvelope creates a new network envelope for the given command and payload.
-func NewNetworkEnvelope(command []byte, payload []byte, testnet bool) *NetworkEnvelope {
-	network := &NetworkEnvelope{
+func NewNetworkEnvelope(command []byte, payload []byte, params *chaincfg.Params) *NetworkEnvelope {
+	return &NetworkEnvelope{
 		command: command,
 		payload: payload,
-		testnet: testnet,
+		params:  params,
+		magic:   params.Magic[:],
 	}
-
-	if testnet {
-		network.magic = []byte{0x0b, 0x11, 0x09, 0x07}
-	} else {
-		network.magic = []byte{0xf9, 0xbe, 0xb4, 0xd9}
-	}
-
-	return network
 }
 
 // ParseNetwork parses raw network message bytes and returns a NetworkEnvelope.
-func ParseNetwork(rawData []byte, testnet bool) *NetworkEnvelope {
+func ParseNetwork(rawData []byte, params *chaincfg.Params) *NetworkEnvelope {
 	reader := bytes.NewReader(rawData)
 	bufReader := bufio.NewReader(reader)
 
@@ -50,14 +44,7 @@ func ParseNetwork(rawData []byte, testnet bool) *NetworkEnvelope {
 		panic("connection reset!")
 	}
 
-	var expectedMagic []byte
-
-	if testnet {
-		expectedMagic = []byte{0x0b, 0x11, 0x09, 0x07}
-	} else {
-		expectedMagic = []byte{0xf9, 0xbe, 0xb4, 0xd9}
-	}
-	if bytes.Equal(magic, expectedMagic) != true {
+	if bytes.Equal(magic, params.Magic[:]) != true {
 		panic("magic is not right")
 	}
 
@@ -91,7 +78,7 @@ func ParseNetwork(rawData []byte, testnet bool) *NetworkEnvelope {
 		panic("checksum dose not match")
 	}
 
-	return NewNetworkEnvelope(command, payload, testnet)
+	return NewNetworkEnvelope(command, payload, params)
 }
 
 // String returns a human-readable representation of the network envelope.
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	bloomfilter "github.com/sudonite/bitcoin/bloom_filter"
+	"github.com/sudonite/bitcoin/chaincfg"
 	merkletree "github.com/sudonite/bitcoin/merkle_tree"
 )
 
@@ -21,16 +22,19 @@ type Message interface {
 type SimpleNode struct {
 	host        string
 	port        uint16
-	testnet     bool
+	params      *chaincfg.Params
 	receiveMsgs []Message
 }
 
-// NewSimpleNode creates a new SimpleNode instance for a given host, port, and testnet flag.
-func NewSimpleNode(host string, port uint16, testnet bool) *SimpleNode {
+// NewSimpleNode creates a new SimpleNode instance for a given host, port, and network, a zero port means the network's default port.
+func NewSimpleNode(host string, port uint16, params *chaincfg.Params) *SimpleNode {
+	if port == 0 {
+		port = params.DefaultPort
+	}
 	return &SimpleNode{
-		host:    host,
-		port:    port,
-		testnet: testnet,
+		host:   host,
+		port:   port,
+		params: params,
 	}
 }
 
@@ -88,7 +92,7 @@ func (s *SimpleNode) GetData(conn net.Conn) {
 // GetHeaders sends a "getheaders" message and waits for "headers" response from the peer.
 func (s *SimpleNode) GetHeaders(conn net.Conn) {
 	// after handshaking we send get header request
-	getHeadersMsg := NewGetHeaderMessage(GetGenesisBlockHash())
+	getHeadersMsg := NewGetHeaderMessage(GetGenesisBlockHash(s.params))
 	fmt.Printf("get header raw:%x\n", getHeadersMsg.Serialize())
 
 	s.Send(conn, getHeadersMsg)
@@ -114,7 +118,7 @@ func (s *SimpleNode) GetHeaders(conn net.Conn) {
 
 // Send serializes a Message into a NetworkEnvelope and writes it to the TCP connection.
 func (s *SimpleNode) Send(conn net.Conn, msg Message) {
-	envelop := NewNetworkEnvelope([]byte(msg.Command()), msg.Serialize(), s.testnet)
+	envelop := NewNetworkEnvelope([]byte(msg.Command()), msg.Serialize(), s.params)
 	n, err := conn.Write(envelop.Serialize())
 	if err != nil {
 		panic(err)
@@ -146,7 +150,7 @@ func (s *SimpleNode) Read(conn net.Conn) []*NetworkEnvelope {
 		if parsedLen >= totalLen {
 			break
 		}
-		msg := ParseNetwork(receivedBuf, s.testnet)
+		msg := ParseNetwork(receivedBuf, s.params)
 		msgs = append(msgs, msg)
 
 		if parsedLen < totalLen {
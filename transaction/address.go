@@ -0,0 +1,61 @@
+package transaction
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/sudonite/bitcoin/chaincfg"
+	ecc "github.com/sudonite/bitcoin/elliptic_curve"
+)
+
+var ErrUnknownAddress = errors.New("unknown address format")
+
+// AddressToScript decodes a base58 or bech32 address into the scriptPubKey it pays to
+func AddressToScript(address string, params *chaincfg.Params) (*ScriptSig, error) {
+	if version, program, err := ecc.DecodeSegwitAddress(params.Bech32HRP, address); err == nil {
+		return witnessScript(version, program), nil
+	}
+
+	version, payload, err := ecc.DecodeBase58Check(address)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownAddress, address)
+	}
+	if len(payload) != 20 {
+		return nil, fmt.Errorf("%w: payload length %d", ErrUnknownAddress, len(payload))
+	}
+
+	switch {
+	case version == params.PubKeyHashAddrID:
+		return P2pkhScript(payload), nil
+	case version == params.ScriptHashAddrID:
+		return P2shScript(payload), nil
+	}
+
+	return nil, fmt.Errorf("%w: version byte %x", ErrUnknownAddress, version)
+}
+
+// witnessScript builds the scriptPubKey of a witness program, the version becomes OP_0 or OP_1..OP_16
+func witnessScript(version int, program []byte) *ScriptSig {
+	versionOp := byte(OP_0)
+	if version > 0 {
+		versionOp = byte(OP_1 + version - 1)
+	}
+	return InitScriptSig([]ScriptCmd{OpCmd(versionOp), DataPush(program)})
+}
+
+// ScriptToAddress encodes the address a scriptPubKey pays to, scripts without an address
+// such as bare multisig or OP_RETURN outputs give ErrUnknownAddress
+func ScriptToAddress(script *ScriptSig, params *chaincfg.Params) (string, error) {
+	template := script.Classify()
+	switch template.Class() {
+	case SCRIPT_P2PKH:
+		return ecc.Base58Checksum(append([]byte{params.PubKeyHashAddrID}, template.Hash()...)), nil
+	case SCRIPT_P2SH:
+		return ecc.Base58Checksum(append([]byte{params.ScriptHashAddrID}, template.Hash()...)), nil
+	case SCRIPT_P2WPKH, SCRIPT_P2WSH, SCRIPT_P2TR, SCRIPT_WITNESS_UNKNOWN:
+		version, program, _ := template.WitnessProgram()
+		return ecc.EncodeSegwitAddress(params.Bech32HRP, version, program)
+	}
+
+	return "", fmt.Errorf("%w: %s script", ErrUnknownAddress, template.Class())
+}
@@ -0,0 +1,329 @@
+package transaction
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Amount is a quantity of bitcoin in satoshis
+type Amount int64
+
+const (
+	SATOSHI   Amount = 1
+	MICRO_BTC Amount = 100
+	MILLI_BTC Amount = 100000
+	BTC       Amount = STASHI_PER_BITCOIN
+
+	// no amount can ever exceed the 21 million coins that will exist
+	MAX_MONEY Amount = 21000000 * BTC
+)
+
+var (
+	ErrAmountFormat   = errors.New("invalid amount")
+	ErrAmountOverflow = errors.New("amount overflows")
+	ErrAmountRange    = errors.New("amount out of range")
+)
+
+// AmountUnit is a unit amounts are shown in
+type AmountUnit int
+
+const (
+	AMOUNT_UNIT_BTC AmountUnit = iota
+	AMOUNT_UNIT_MBTC
+	AMOUNT_UNIT_UBTC
+	AMOUNT_UNIT_SAT
+)
+
+// String returns the symbol of the unit
+func (u AmountUnit) String() string {
+	switch u {
+	case AMOUNT_UNIT_MBTC:
+		return "mBTC"
+	case AMOUNT_UNIT_UBTC:
+		return "µBTC"
+	case AMOUNT_UNIT_SAT:
+		return "sat"
+	}
+	return "BTC"
+}
+
+// satoshis returns how many satoshis one unit is worth
+func (u AmountUnit) satoshis() Amount {
+	switch u {
+	case AMOUNT_UNIT_MBTC:
+		return MILLI_BTC
+	case AMOUNT_UNIT_UBTC:
+		return MICRO_BTC
+	case AMOUNT_UNIT_SAT:
+		return SATOSHI
+	}
+	return BTC
+}
+
+// decimals returns the number of decimals needed to show a satoshi in the unit
+func (u AmountUnit) decimals() int {
+	return len(strconv.FormatInt(int64(u.satoshis()), 10)) - 1
+}
+
+// amountUnits maps the unit names ParseAmount accepts
+var amountUnits = map[string]AmountUnit{
+	"btc":      AMOUNT_UNIT_BTC,
+	"mbtc":     AMOUNT_UNIT_MBTC,
+	"µbtc":     AMOUNT_UNIT_UBTC,
+	"ubtc":     AMOUNT_UNIT_UBTC,
+	"bits":     AMOUNT_UNIT_UBTC,
+	"sat":      AMOUNT_UNIT_SAT,
+	"sats":     AMOUNT_UNIT_SAT,
+	"satoshi":  AMOUNT_UNIT_SAT,
+	"satoshis": AMOUNT_UNIT_SAT,
+}
+
+// ParseAmount parses an amount like "0.00012345 BTC", "1.5 mBTC" or "1000 sat", a bare number is in BTC.
+// Digits below a satoshi and amounts outside 0..MAX_MONEY are rejected
+func ParseAmount(s string) (Amount, error) {
+	fields := strings.Fields(s)
+	unit := AMOUNT_UNIT_BTC
+	switch len(fields) {
+	case 1:
+	case 2:
+		var ok bool
+		if unit, ok = amountUnits[strings.ToLower(fields[1])]; !ok {
+			return 0, fmt.Errorf("%w: unknown unit %q", ErrAmountFormat, fields[1])
+		}
+	default:
+		return 0, fmt.Errorf("%w: %q", ErrAmountFormat, s)
+	}
+
+	// with one decimal per power of ten the parsed number is already in satoshis
+	amount, err := parseFixedPoint(fields[0], unit.decimals())
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q", err, s)
+	}
+	if !amount.IsValid() {
+		return 0, fmt.Errorf("%w: %q", ErrAmountRange, s)
+	}
+	return amount, nil
+}
+
+// parseFixedPoint parses an unsigned decimal number with at most decimals digits after the point,
+// the result is scaled so the last allowed decimal is one
+func parseFixedPoint(s string, decimals int) (Amount, error) {
+	whole, fraction, _ := strings.Cut(s, ".")
+	if whole == "" && fraction == "" || len(fraction) > decimals ||
+		strings.Trim(whole, "0123456789") != "" || strings.Trim(fraction, "0123456789") != "" {
+		return 0, ErrAmountFormat
+	}
+
+	digits := strings.TrimLeft(whole+fraction+strings.Repeat("0", decimals-len(fraction)), "0")
+	if digits == "" {
+		return 0, nil
+	}
+	value, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return 0, ErrAmountOverflow
+	}
+	return Amount(value), nil
+}
+
+// IsValid checks the amount is within 0..MAX_MONEY, the range consensus allows for values
+func (a Amount) IsValid() bool {
+	return a >= 0 && a <= MAX_MONEY
+}
+
+// Add returns a+b, failing on int64 overflow or a total beyond MAX_MONEY
+func (a Amount) Add(b Amount) (Amount, error) {
+	if (b > 0 && a > math.MaxInt64-b) || (b < 0 && a < math.MinInt64-b) {
+		return 0, fmt.Errorf("%w: %d + %d", ErrAmountOverflow, a, b)
+	}
+	return checkMoney(a + b)
+}
+
+// Sub returns a-b, failing on int64 overflow or a difference beyond MAX_MONEY either way
+func (a Amount) Sub(b Amount) (Amount, error) {
+	if (b < 0 && a > math.MaxInt64+b) || (b > 0 && a < math.MinInt64+b) {
+		return 0, fmt.Errorf("%w: %d - %d", ErrAmountOverflow, a, b)
+	}
+	return checkMoney(a - b)
+}
+
+// MulInt returns a*n, failing on int64 overflow or a product beyond MAX_MONEY
+func (a Amount) MulInt(n int64) (Amount, error) {
+	product := new(big.Int).Mul(big.NewInt(int64(a)), big.NewInt(n))
+	if !product.IsInt64() {
+		return 0, fmt.Errorf("%w: %d * %d", ErrAmountOverflow, a, n)
+	}
+	return checkMoney(Amount(product.Int64()))
+}
+
+// checkMoney rejects amounts larger than MAX_MONEY in either direction, negative results such as
+// a fee shortfall stay allowed
+func checkMoney(a Amount) (Amount, error) {
+	if a > MAX_MONEY || a < -MAX_MONEY {
+		return 0, fmt.Errorf("%w: %d", ErrAmountRange, a)
+	}
+	return a, nil
+}
+
+// SumAmounts adds up the amounts with the checks of Add
+func SumAmounts(amounts ...Amount) (Amount, error) {
+	total := Amount(0)
+	for _, amount := range amounts {
+		var err error
+		if total, err = total.Add(amount); err != nil {
+			return 0, err
+		}
+	}
+	return total, nil
+}
+
+// Format shows the amount in the unit with every decimal down to a satoshi, like "0.00012345 BTC"
+func (a Amount) Format(unit AmountUnit) string {
+	return formatFixedPoint(int64(a), unit.decimals()) + " " + unit.String()
+}
+
+// String shows the amount in BTC
+func (a Amount) String() string {
+	return a.Format(AMOUNT_UNIT_BTC)
+}
+
+// formatFixedPoint writes value with the last decimals digits after the point
+func formatFixedPoint(value int64, decimals int) string {
+	sign := ""
+	abs := new(big.Int).Abs(big.NewInt(value))
+	if value < 0 {
+		sign = "-"
+	}
+	if decimals == 0 {
+		return sign + abs.String()
+	}
+
+	digits := abs.String()
+	if len(digits) <= decimals {
+		digits = strings.Repeat("0", decimals-len(digits)+1) + digits
+	}
+	return sign + digits[:len(digits)-decimals] + "." + digits[len(digits)-decimals:]
+}
+
+// MarshalJSON writes the amount as a BTC number with eight decimals, the way bitcoind does
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(formatFixedPoint(int64(a), AMOUNT_UNIT_BTC.decimals())), nil
+}
+
+// UnmarshalJSON reads a BTC number exactly, digits below a satoshi are an error
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	value, ok := new(big.Rat).SetString(string(data))
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrAmountFormat, data)
+	}
+	value.Mul(value, new(big.Rat).SetInt64(int64(BTC)))
+	if !value.IsInt() || !value.Num().IsInt64() {
+		return fmt.Errorf("%w: %s", ErrAmountFormat, data)
+	}
+	amount, err := checkMoney(Amount(value.Num().Int64()))
+	if err != nil {
+		return err
+	}
+	*a = amount
+	return nil
+}
+
+// FeeRate is a fee rate in satoshis per 1000 virtual bytes, the unit Bitcoin Core works in
+type FeeRate int64
+
+// FeeRateUnit is a unit fee rates are shown in
+type FeeRateUnit int
+
+const (
+	FEE_RATE_UNIT_SAT_VB FeeRateUnit = iota
+	FEE_RATE_UNIT_BTC_KVB
+)
+
+// String returns the symbol of the unit
+func (u FeeRateUnit) String() string {
+	if u == FEE_RATE_UNIT_BTC_KVB {
+		return "BTC/kvB"
+	}
+	return "sat/vB"
+}
+
+// NewFeeRate returns the rate paying fee for vsize virtual bytes
+func NewFeeRate(fee Amount, vsize int) FeeRate {
+	if vsize <= 0 {
+		return 0
+	}
+	return FeeRate(int64(fee) * 1000 / int64(vsize))
+}
+
+// ParseFeeRate parses a rate like "12.5 sat/vB" or "0.0001 BTC/kvB", a bare number is in sat/vB
+func ParseFeeRate(s string) (FeeRate, error) {
+	fields := strings.Fields(s)
+	unit := FEE_RATE_UNIT_SAT_VB
+	switch {
+	case len(fields) == 2 && strings.EqualFold(fields[1], "btc/kvb"):
+		unit = FEE_RATE_UNIT_BTC_KVB
+	case len(fields) == 2 && !strings.EqualFold(fields[1], "sat/vb"):
+		return 0, fmt.Errorf("%w: unknown fee rate unit %q", ErrAmountFormat, fields[1])
+	case len(fields) != 1 && len(fields) != 2:
+		return 0, fmt.Errorf("%w: %q", ErrAmountFormat, s)
+	}
+
+	// a sat/vB rate is exact to a thousandth, a BTC/kvB rate to a satoshi
+	decimals := 3
+	if unit == FEE_RATE_UNIT_BTC_KVB {
+		decimals = AMOUNT_UNIT_BTC.decimals()
+	}
+	rate, err := parseFixedPoint(fields[0], decimals)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q", err, s)
+	}
+	if rate > MAX_MONEY {
+		return 0, fmt.Errorf("%w: %q", ErrAmountRange, s)
+	}
+	return FeeRate(rate), nil
+}
+
+// Fee returns the fee for vsize virtual bytes, rounded up so the rate is always met. Fees above
+// MAX_MONEY are clamped to it, no transaction could pay them anyway
+func (r FeeRate) Fee(vsize int) Amount {
+	return r.feeFor(vsize, 1000)
+}
+
+// FeeForWeight returns the fee for the given weight, rounded up and clamped like Fee
+func (r FeeRate) FeeForWeight(weight int) Amount {
+	return r.feeFor(weight, 1000*WITNESS_SCALE_FACTOR)
+}
+
+// feeFor returns rate * size / scale rounded up, checking the product against MAX_MONEY before it can overflow
+func (r FeeRate) feeFor(size int, scale int64) Amount {
+	if r > 0 && size > 0 && int64(r) > int64(MAX_MONEY)*scale/int64(size) {
+		return MAX_MONEY
+	}
+	return Amount((int64(r)*int64(size) + scale - 1) / scale)
+}
+
+// SatPerVByte returns the rate in satoshis per virtual byte
+func (r FeeRate) SatPerVByte() float64 {
+	return float64(r) / 1000
+}
+
+// BTCPerKvB returns the amount paid per 1000 virtual bytes
+func (r FeeRate) BTCPerKvB() Amount {
+	return Amount(r)
+}
+
+// Format shows the rate in the unit, "12.500 sat/vB" or "0.00012500 BTC/kvB"
+func (r FeeRate) Format(unit FeeRateUnit) string {
+	if unit == FEE_RATE_UNIT_BTC_KVB {
+		return formatFixedPoint(int64(r), AMOUNT_UNIT_BTC.decimals()) + " " + unit.String()
+	}
+	return formatFixedPoint(int64(r), 3) + " " + unit.String()
+}
+
+// String shows the rate in sat/vB
+func (r FeeRate) String() string {
+	return r.Format(FEE_RATE_UNIT_SAT_VB)
+}
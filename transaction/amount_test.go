@@ -0,0 +1,171 @@
+package transaction
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestAmountArithmetic(t *testing.T) {
+	tests := []struct {
+		name    string
+		op      func() (Amount, error)
+		want    Amount
+		wantErr error
+	}{
+		{"add up to MAX_MONEY", func() (Amount, error) { return (MAX_MONEY - 1).Add(1) }, MAX_MONEY, nil},
+		{"add past MAX_MONEY", func() (Amount, error) { return MAX_MONEY.Add(1) }, 0, ErrAmountRange},
+		{"add overflowing int64", func() (Amount, error) { return Amount(math.MaxInt64).Add(1) }, 0, ErrAmountOverflow},
+		{"add underflowing int64", func() (Amount, error) { return Amount(math.MinInt64).Add(-1) }, 0, ErrAmountOverflow},
+		{"add a negative amount", func() (Amount, error) { return Amount(5).Add(-7) }, -2, nil},
+		{"sub down to -MAX_MONEY", func() (Amount, error) { return Amount(0).Sub(MAX_MONEY) }, -MAX_MONEY, nil},
+		{"sub past -MAX_MONEY", func() (Amount, error) { return (-MAX_MONEY).Sub(1) }, 0, ErrAmountRange},
+		{"sub overflowing int64", func() (Amount, error) { return Amount(math.MaxInt64).Sub(-1) }, 0, ErrAmountOverflow},
+		{"sub underflowing int64", func() (Amount, error) { return Amount(math.MinInt64).Sub(1) }, 0, ErrAmountOverflow},
+		{"mul up to MAX_MONEY", func() (Amount, error) { return BTC.MulInt(21000000) }, MAX_MONEY, nil},
+		{"mul past MAX_MONEY", func() (Amount, error) { return BTC.MulInt(21000001) }, 0, ErrAmountRange},
+		{"mul overflowing int64", func() (Amount, error) { return Amount(math.MaxInt64).MulInt(2) }, 0, ErrAmountOverflow},
+		{"mul overflowing to 2^63", func() (Amount, error) { return Amount(-1).MulInt(math.MinInt64) }, 0, ErrAmountOverflow},
+		{"mul by a negative number", func() (Amount, error) { return BTC.MulInt(-3) }, -3 * BTC, nil},
+		{"sum", func() (Amount, error) { return SumAmounts(BTC, 2*BTC, 3*BTC) }, 6 * BTC, nil},
+		{"sum past MAX_MONEY", func() (Amount, error) { return SumAmounts(MAX_MONEY, 1, -1) }, 0, ErrAmountRange},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.op()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Amount
+		wantErr error
+	}{
+		{"0.00012345 BTC", 12345, nil},
+		{"1.5 mBTC", 150000, nil},
+		{"2.5 uBTC", 250, nil},
+		{"1000 sat", 1000, nil},
+		{"21000000", MAX_MONEY, nil},
+		{".5", BTC / 2, nil},
+		{"0", 0, nil},
+		{"21000000.00000001", 0, ErrAmountRange},
+		{"0.000000001", 0, ErrAmountFormat},
+		{"1.5 sat", 0, ErrAmountFormat},
+		{"-1", 0, ErrAmountFormat},
+		{"1e5", 0, ErrAmountFormat},
+		{".", 0, ErrAmountFormat},
+		{"", 0, ErrAmountFormat},
+		{"1 XYZ", 0, ErrAmountFormat},
+		{"1 BTC extra", 0, ErrAmountFormat},
+		{"99999999999999999999 sat", 0, ErrAmountOverflow},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseAmount(tt.in)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAmountFormat(t *testing.T) {
+	tests := []struct {
+		amount Amount
+		unit   AmountUnit
+		want   string
+	}{
+		{12345, AMOUNT_UNIT_BTC, "0.00012345 BTC"},
+		{-1, AMOUNT_UNIT_BTC, "-0.00000001 BTC"},
+		{MAX_MONEY, AMOUNT_UNIT_BTC, "21000000.00000000 BTC"},
+		{150000, AMOUNT_UNIT_MBTC, "1.50000 mBTC"},
+		{1000, AMOUNT_UNIT_SAT, "1000 sat"},
+	}
+	for _, tt := range tests {
+		if got := tt.amount.Format(tt.unit); got != tt.want {
+			t.Errorf("format %d: %s, want %s", tt.amount, got, tt.want)
+		}
+	}
+
+	var amount Amount
+	if err := json.Unmarshal([]byte("0.00012345"), &amount); err != nil || amount != 12345 {
+		t.Errorf("unmarshal %d, %v", amount, err)
+	}
+	if err := json.Unmarshal([]byte("0.000000001"), &amount); !errors.Is(err, ErrAmountFormat) {
+		t.Errorf("unmarshal below a satoshi: error %v, want %v", err, ErrAmountFormat)
+	}
+	if data, err := json.Marshal(Amount(12345)); err != nil || string(data) != "0.00012345" {
+		t.Errorf("marshal %s, %v", data, err)
+	}
+}
+
+func TestFeeRate(t *testing.T) {
+	fees := []struct {
+		name string
+		fee  Amount
+		want Amount
+	}{
+		{"whole sat/vB", FeeRate(1000).Fee(250), 250},
+		{"fraction rounded up", FeeRate(1001).Fee(1), 2},
+		{"weight rounded up", FeeRate(1000).FeeForWeight(5), 2},
+		{"weight exact", FeeRate(1000).FeeForWeight(400), 100},
+		{"zero size", FeeRate(1000).Fee(0), 0},
+		{"up to MAX_MONEY", FeeRate(MAX_MONEY).Fee(1000), MAX_MONEY},
+		{"clamped to MAX_MONEY", FeeRate(MAX_MONEY).Fee(1001), MAX_MONEY},
+		{"clamped instead of overflowing", FeeRate(math.MaxInt64).Fee(2), MAX_MONEY},
+		{"weight clamped instead of overflowing", FeeRate(math.MaxInt64).FeeForWeight(math.MaxInt32), MAX_MONEY},
+	}
+	for _, tt := range fees {
+		if tt.fee != tt.want {
+			t.Errorf("%s: fee %d, want %d", tt.name, tt.fee, tt.want)
+		}
+	}
+
+	if got := NewFeeRate(1000, 3); got != 333333 {
+		t.Errorf("rate of 1000 sat in 3 vB %d, want 333333", got)
+	}
+
+	parses := []struct {
+		in      string
+		want    FeeRate
+		wantErr error
+	}{
+		{"12.5 sat/vB", 12500, nil},
+		{"12.5", 12500, nil},
+		{"0.001 sat/vb", 1, nil},
+		{"0.0001 BTC/kvB", 10000, nil},
+		{"1.2345 sat/vB", 0, ErrAmountFormat},
+		{"1 sat/B", 0, ErrAmountFormat},
+		{"-1 sat/vB", 0, ErrAmountFormat},
+		{"21000001 BTC/kvB", 0, ErrAmountRange},
+	}
+	for _, tt := range parses {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseFeeRate(tt.in)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+
+	if got := FeeRate(12500).String(); got != "12.500 sat/vB" {
+		t.Errorf("format %s, want 12.500 sat/vB", got)
+	}
+}
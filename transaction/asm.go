@@ -0,0 +1,225 @@
+package transaction
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Script numbers rendered as decimals in ASM are at most 4 bytes wide
+const MAX_ASM_NUMBER = 1<<31 - 1
+
+var ErrAsmToken = errors.New("invalid asm token")
+
+// sighashNames maps the sighash types Bitcoin Core decodes on signatures to their ASM suffix
+var sighashNames = map[byte]string{
+	SIGHASH_ALL:                           "ALL",
+	SIGHASH_NONE:                          "NONE",
+	SIGHASH_SINGLE:                        "SINGLE",
+	SIGHASH_ALL | SIGHASH_ANYONECANPAY:    "ALL|ANYONECANPAY",
+	SIGHASH_NONE | SIGHASH_ANYONECANPAY:   "NONE|ANYONECANPAY",
+	SIGHASH_SINGLE | SIGHASH_ANYONECANPAY: "SINGLE|ANYONECANPAY",
+}
+
+// opCodesByName is the reverse of the opcode names table, OP_TRUE and OP_FALSE are accepted as aliases
+var opCodesByName = func() map[string]byte {
+	byName := map[string]byte{"OP_TRUE": OP_1, "OP_FALSE": OP_0}
+	for op, name := range NewBitcoinOpCode().opCodeNames {
+		byName[name] = byte(op)
+	}
+	return byName
+}()
+
+// Asm renders the script the way Bitcoin Core prints a scriptPubKey
+func (s *ScriptSig) Asm() string {
+	return s.asm(false)
+}
+
+// AsmWithSighash renders the script like Asm but decodes the sighash type of signatures,
+// the way Bitcoin Core prints a scriptSig
+func (s *ScriptSig) AsmWithSighash() string {
+	return s.asm(true)
+}
+
+// String returns the ASM form of the script
+func (s *ScriptSig) String() string {
+	return s.Asm()
+}
+
+func (s *ScriptSig) asm(decodeSighash bool) string {
+	if s.unparsed != nil {
+		return "[error]"
+	}
+
+	// signatures are never decoded on an unspendable script
+	if len(s.bitcoinOpCode.cmds) > 0 && isOp(s.bitcoinOpCode.cmds[0], OP_RETURN) {
+		decodeSighash = false
+	}
+
+	tokens := make([]string, 0, len(s.bitcoinOpCode.cmds))
+	for _, cmd := range s.bitcoinOpCode.cmds {
+		tokens = append(tokens, s.cmdAsm(cmd, decodeSighash))
+	}
+	return strings.Join(tokens, " ")
+}
+
+// cmdAsm renders a single command, small pushes are shown as numbers and the rest as hex
+func (s *ScriptSig) cmdAsm(cmd ScriptCmd, decodeSighash bool) string {
+	if isOpCode(cmd) {
+		return s.opCodeName(cmd.opCode)
+	}
+
+	data := cmd.data
+	if len(data) <= 4 {
+		return strconv.FormatInt(s.bitcoinOpCode.DecodeNum(data), 10)
+	}
+
+	if decodeSighash && isValidSignatureEncoding(data) {
+		if name, ok := sighashNames[data[len(data)-1]]; ok {
+			return hex.EncodeToString(data[:len(data)-1]) + "[" + name + "]"
+		}
+	}
+
+	return hex.EncodeToString(data)
+}
+
+// opCodeName returns the ASM name of the opcode, number opcodes are written as their value
+func (s *ScriptSig) opCodeName(op byte) string {
+	switch {
+	case op == OP_0:
+		return "0"
+	case op == OP_1NEGATE:
+		return "-1"
+	case op >= OP_1 && op <= OP_16:
+		return strconv.Itoa(int(op) - OP_1 + 1)
+	}
+
+	if name, ok := s.bitcoinOpCode.opCodeNames[int(op)]; ok {
+		return name
+	}
+	return "OP_UNKNOWN"
+}
+
+// ParseAsm builds a script from its ASM form. Tokens are decimal numbers, opcode names with or
+// without the OP_ prefix, hex data and signatures carrying a [SIGHASH] suffix. Numbers and data
+// are pushed with minimal encoding, a token that reads as a 4 byte script number is a number
+func ParseAsm(asm string) (*ScriptSig, error) {
+	cmds := []ScriptCmd{}
+
+	for _, token := range strings.Fields(asm) {
+		if num, ok := parseAsmNumber(token); ok {
+			cmds = append(cmds, scriptNumCmd(num))
+			continue
+		}
+
+		name := strings.ToUpper(token)
+		if !strings.HasPrefix(name, "OP_") {
+			name = "OP_" + name
+		}
+		if op, ok := opCodesByName[name]; ok {
+			if op >= OP_PUSHDATA1 && op <= OP_PUSHDATA4 {
+				return nil, fmt.Errorf("%w: %s needs its data as a hex token", ErrAsmToken, token)
+			}
+			cmds = append(cmds, OpCmd(op))
+			continue
+		}
+
+		data, err := parseAsmData(token)
+		if err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, pushCmd(data))
+	}
+
+	return InitScriptSig(cmds), nil
+}
+
+// parseAsmNumber reads a token written the way Asm renders script numbers
+func parseAsmNumber(token string) (int64, bool) {
+	num, err := strconv.ParseInt(token, 10, 64)
+	if err != nil || strconv.FormatInt(num, 10) != token {
+		return 0, false
+	}
+	if num > MAX_ASM_NUMBER || num < -MAX_ASM_NUMBER {
+		return 0, false
+	}
+	return num, true
+}
+
+// parseAsmData decodes a hex token, appending the sighash byte when it ends in a [SIGHASH] suffix
+func parseAsmData(token string) ([]byte, error) {
+	hexPart, suffix := token, ""
+	if open := strings.IndexByte(token, '['); open >= 0 && strings.HasSuffix(token, "]") {
+		hexPart, suffix = token[:open], token[open+1:len(token)-1]
+	}
+
+	data, err := hex.DecodeString(hexPart)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrAsmToken, token)
+	}
+	if suffix == "" {
+		return data, nil
+	}
+
+	for hashType, name := range sighashNames {
+		if name == suffix {
+			return append(data, hashType), nil
+		}
+	}
+	return nil, fmt.Errorf("%w: unknown sighash type %s", ErrAsmToken, suffix)
+}
+
+// scriptNumCmd returns the minimal command pushing a script number
+func scriptNumCmd(num int64) ScriptCmd {
+	return pushCmd((&BitcoinOpCode{}).EncodeNum(num))
+}
+
+// pushCmd returns the minimal command pushing data, small numbers become OP_0, OP_1NEGATE and OP_1..OP_16
+func pushCmd(data []byte) ScriptCmd {
+	switch {
+	case len(data) == 0:
+		return OpCmd(OP_0)
+	case len(data) == 1 && data[0] >= 1 && data[0] <= 16:
+		return OpCmd(byte(OP_1 + int(data[0]) - 1))
+	case len(data) == 1 && data[0] == 0x81:
+		return OpCmd(OP_1NEGATE)
+	}
+	return DataPush(data)
+}
+
+// isValidSignatureEncoding checks the strict DER encoding with a trailing sighash byte from BIP 66
+func isValidSignatureEncoding(sig []byte) bool {
+	if len(sig) < 9 || len(sig) > 73 {
+		return false
+	}
+	if sig[0] != 0x30 || int(sig[1]) != len(sig)-3 {
+		return false
+	}
+
+	lenR := int(sig[3])
+	if 5+lenR >= len(sig) {
+		return false
+	}
+	lenS := int(sig[5+lenR])
+	if lenR+lenS+7 != len(sig) {
+		return false
+	}
+
+	if sig[2] != 0x02 || lenR == 0 || sig[4]&0x80 != 0 {
+		return false
+	}
+	if lenR > 1 && sig[4] == 0x00 && sig[5]&0x80 == 0 {
+		return false
+	}
+
+	if sig[lenR+4] != 0x02 || lenS == 0 || sig[lenR+6]&0x80 != 0 {
+		return false
+	}
+	if lenS > 1 && sig[lenR+6] == 0x00 && sig[lenR+7]&0x80 == 0 {
+		return false
+	}
+
+	return true
+}
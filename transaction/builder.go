@@ -0,0 +1,359 @@
+package transaction
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/sudonite/bitcoin/chaincfg"
+	ecc "github.com/sudonite/bitcoin/elliptic_curve"
+)
+
+const (
+	DEFAULT_TX_VERSION = 2
+
+	// a non-final sequence lets nLockTime take effect
+	SEQUENCE_LOCKTIME_ENABLED = 0xfffffffe
+	// any sequence below this value signals BIP-125 replaceability
+	SEQUENCE_RBF = 0xfffffffd
+)
+
+var (
+	ErrMissingKey    = errors.New("missing private key")
+	ErrUnsupported   = errors.New("unsupported script type")
+	ErrMissingScript = errors.New("missing redeem or witness script")
+)
+
+// builderInput is an input together with the scripts needed to sign it
+type builderInput struct {
+	input         *TransactionInput
+	redeemScript  *ScriptSig
+	witnessScript *ScriptSig
+}
+
+// TransactionBuilder assembles and signs a transaction spending known outputs
+type TransactionBuilder struct {
+	version  int32
+	lockTime uint32
+	rbf      bool
+	inputs   []*builderInput
+	outputs  []*TransactionOutput
+	params   *chaincfg.Params
+}
+
+// NewTransactionBuilder creates an empty version 2 transaction builder
+func NewTransactionBuilder(params *chaincfg.Params) *TransactionBuilder {
+	return &TransactionBuilder{
+		version: DEFAULT_TX_VERSION,
+		inputs:  make([]*builderInput, 0),
+		outputs: make([]*TransactionOutput, 0),
+		params:  params,
+	}
+}
+
+// SetVersion overrides the transaction version
+func (b *TransactionBuilder) SetVersion(version int32) {
+	b.version = version
+}
+
+// AddInput spends the output prevIndex of prevTxID, which holds value satoshis locked by scriptPubKey.
+// prevTxID is in the usual big endian hex order. Returns the index of the new input.
+func (b *TransactionBuilder) AddInput(prevTxID []byte, prevIndex uint32, value Amount, scriptPubKey *ScriptSig) int {
+	input := InitTransactionInput(prevTxID, prevIndex)
+	input.SetScriptSig(InitScriptSig([]ScriptCmd{}))
+	input.SetPreviousOutput(InitTransactionOutput(value, scriptPubKey))
+	if b.lockTime != 0 {
+		input.SetSequence(SEQUENCE_LOCKTIME_ENABLED)
+	}
+	if b.rbf {
+		input.SetSequence(SEQUENCE_RBF)
+	}
+
+	b.inputs = append(b.inputs, &builderInput{input: input})
+	return len(b.inputs) - 1
+}
+
+// SetRedeemScript sets the redeem script of a P2SH input
+func (b *TransactionBuilder) SetRedeemScript(inputIdx int, redeemScript *ScriptSig) error {
+	if inputIdx < 0 || inputIdx >= len(b.inputs) {
+		return fmt.Errorf("invalid index %d for transaction input", inputIdx)
+	}
+	b.inputs[inputIdx].redeemScript = redeemScript
+	return nil
+}
+
+// SetWitnessScript sets the witness script of a P2WSH or P2SH-P2WSH input
+func (b *TransactionBuilder) SetWitnessScript(inputIdx int, witnessScript *ScriptSig) error {
+	if inputIdx < 0 || inputIdx >= len(b.inputs) {
+		return fmt.Errorf("invalid index %d for transaction input", inputIdx)
+	}
+	b.inputs[inputIdx].witnessScript = witnessScript
+	return nil
+}
+
+// SetSequence sets the nSequence of an input
+func (b *TransactionBuilder) SetSequence(inputIdx int, sequence uint32) error {
+	if inputIdx < 0 || inputIdx >= len(b.inputs) {
+		return fmt.Errorf("invalid index %d for transaction input", inputIdx)
+	}
+	b.inputs[inputIdx].input.SetSequence(sequence)
+	return nil
+}
+
+// EnableRBF signals BIP-125 replaceability on every input, including the ones added later
+func (b *TransactionBuilder) EnableRBF() {
+	b.rbf = true
+	for _, in := range b.inputs {
+		if in.input.Sequence() > SEQUENCE_RBF {
+			in.input.SetSequence(SEQUENCE_RBF)
+		}
+	}
+}
+
+// SetLockTime sets nLockTime, final input sequences are lowered so the lock time is enforced
+func (b *TransactionBuilder) SetLockTime(lockTime uint32) {
+	b.lockTime = lockTime
+	if lockTime == 0 {
+		return
+	}
+	for _, in := range b.inputs {
+		if in.input.Sequence() == SEQUENCE_FINAL {
+			in.input.SetSequence(SEQUENCE_LOCKTIME_ENABLED)
+		}
+	}
+}
+
+// AddOutput pays amount satoshis to the given scriptPubKey
+func (b *TransactionBuilder) AddOutput(amount Amount, scriptPubKey *ScriptSig) {
+	b.outputs = append(b.outputs, InitTransactionOutput(amount, scriptPubKey))
+}
+
+// AddOutputAddress pays amount satoshis to a base58 or bech32 address
+func (b *TransactionBuilder) AddOutputAddress(amount Amount, address string) error {
+	script, err := AddressToScript(address, b.params)
+	if err != nil {
+		return err
+	}
+	b.AddOutput(amount, script)
+	return nil
+}
+
+// Build returns the unsigned transaction
+func (b *TransactionBuilder) Build() *Transaction {
+	txInputs := make([]*TransactionInput, 0, len(b.inputs))
+	for _, in := range b.inputs {
+		txInputs = append(txInputs, in.input)
+	}
+
+	return InitTransaction(b.version, txInputs, b.outputs, b.lockTime, b.params)
+}
+
+// EstimateWeight returns the weight the transaction will have once signed, using the redeem and
+// witness scripts of P2SH and P2WSH inputs
+func (b *TransactionBuilder) EstimateWeight() (int, error) {
+	return b.Build().estimateWeight(func(inputIdx int) (int, bool, error) {
+		in := b.inputs[inputIdx]
+		return EstimateSpendWeight(in.input.prevOutput.scriptPubKey, in.redeemScript, in.witnessScript)
+	})
+}
+
+// EstimateVSize returns the virtual size the transaction will have once signed
+func (b *TransactionBuilder) EstimateVSize() (int, error) {
+	weight, err := b.EstimateWeight()
+	if err != nil {
+		return 0, err
+	}
+	return weightToVSize(weight), nil
+}
+
+// Sign builds the transaction and signs every input with SIGHASH_ALL using the given keys.
+// P2PKH, P2SH multisig, P2WPKH, P2SH-P2WPKH, P2WSH and P2SH-P2WSH inputs are supported.
+func (b *TransactionBuilder) Sign(keys []*ecc.PrivateKey) (*Transaction, error) {
+	tx := b.Build()
+	keyring := newKeyring(keys)
+	// signing leaves the outpoints, sequences and outputs alone, so every input shares the hashes
+	sigHashes := NewSigHashCache(tx)
+
+	for i, in := range b.inputs {
+		if err := b.signInput(tx, sigHashes, i, in, keyring); err != nil {
+			return nil, fmt.Errorf("sign input %d: %w", i, err)
+		}
+		if len(in.input.witness) > 0 {
+			tx.segwit = true
+		}
+	}
+
+	return tx, nil
+}
+
+// signInput fills in the scriptSig and witness of one input
+func (b *TransactionBuilder) signInput(tx *Transaction, sigHashes *SigHashCache, inputIdx int, in *builderInput, keyring *keyring) error {
+	scriptPubKey := in.input.prevOutput.scriptPubKey
+
+	if template := scriptPubKey.Classify(); template.Class() == SCRIPT_P2PKH {
+		key, sec, err := keyring.byHash160(template.Hash())
+		if err != nil {
+			return err
+		}
+		z := ecc.Hash256(string(tx.serializeForLegacySig(inputIdx, scriptPubKey)))
+		in.input.SetScriptSig(InitScriptSig([]ScriptCmd{DataPush(signEcdsa(key, z)), DataPush(sec)}))
+		return nil
+	}
+
+	script := scriptPubKey
+	scriptSigCmds := []ScriptCmd{}
+	if scriptPubKey.bitcoinOpCode.isP2sh() {
+		if in.redeemScript == nil {
+			return ErrMissingScript
+		}
+		rawRedeem := in.redeemScript.rawSerialize()
+		if !bytes.Equal(ecc.Hash160(rawRedeem), scriptPubKey.bitcoinOpCode.cmds[1].data) {
+			return fmt.Errorf("redeem script does not match P2SH hash")
+		}
+		scriptSigCmds = append(scriptSigCmds, DataPush(rawRedeem))
+		script = in.redeemScript
+	}
+
+	version, program, isWitness := script.witnessProgram()
+	if !isWitness {
+		if len(scriptSigCmds) == 0 {
+			return ErrUnsupported
+		}
+		// bare P2SH, the redeem script must be a multisig
+		z := ecc.Hash256(string(tx.serializeForLegacySig(inputIdx, in.redeemScript)))
+		sigs, err := signScript(in.redeemScript, z, keyring)
+		if err != nil {
+			return err
+		}
+		in.input.SetScriptSig(InitScriptSig(append(pushCmds(sigs), scriptSigCmds...)))
+		return nil
+	}
+
+	in.input.SetScriptSig(InitScriptSig(scriptSigCmds))
+	if version != 0 {
+		return ErrUnsupported
+	}
+
+	if len(program) == 20 {
+		key, sec, err := keyring.byHash160(program)
+		if err != nil {
+			return err
+		}
+		if len(sec) != 33 {
+			return fmt.Errorf("P2WPKH requires a compressed public key")
+		}
+		z, err := tx.bip143SigHash(sigHashes, inputIdx, P2pkhScript(program).Serialize(), SIGHASH_ALL)
+		if err != nil {
+			return err
+		}
+		in.input.SetWitness([][]byte{signEcdsa(key, z), sec})
+		return nil
+	}
+
+	if in.witnessScript == nil {
+		return ErrMissingScript
+	}
+	rawWitnessScript := in.witnessScript.rawSerialize()
+	if !bytes.Equal(sha256Bytes(rawWitnessScript), program) {
+		return fmt.Errorf("witness script does not match P2WSH hash")
+	}
+	z, err := tx.bip143SigHash(sigHashes, inputIdx, in.witnessScript.Serialize(), SIGHASH_ALL)
+	if err != nil {
+		return err
+	}
+	witness, err := signScript(in.witnessScript, z, keyring)
+	if err != nil {
+		return err
+	}
+	in.input.SetWitness(append(witness, rawWitnessScript))
+	return nil
+}
+
+// signScript produces the arguments satisfying a multisig or <pubkey> OP_CHECKSIG script
+func signScript(script *ScriptSig, z []byte, keyring *keyring) ([][]byte, error) {
+	template := script.Classify()
+
+	if template.Class() == SCRIPT_P2PK {
+		key, err := keyring.bySec(template.PubKeys()[0])
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{signEcdsa(key, z)}, nil
+	}
+
+	if template.Class() != SCRIPT_P2MS {
+		return nil, ErrUnsupported
+	}
+	m, pubKeys := template.Required(), template.PubKeys()
+
+	// the dummy element consumed by OP_CHECKMULTISIG, signatures must follow the public key order
+	sigs := [][]byte{{}}
+	for _, pubKey := range pubKeys {
+		if len(sigs)-1 == m {
+			break
+		}
+		key, err := keyring.bySec(pubKey)
+		if err != nil {
+			continue
+		}
+		sigs = append(sigs, signEcdsa(key, z))
+	}
+	if len(sigs)-1 < m {
+		return nil, fmt.Errorf("%w: have %d of %d signatures", ErrMissingKey, len(sigs)-1, m)
+	}
+
+	return sigs, nil
+}
+
+// isOp checks whether the command is the given single byte opcode
+func isOp(cmd ScriptCmd, op int) bool {
+	return isOpCode(cmd) && int(cmd.opCode) == op
+}
+
+// signEcdsa signs the message hash and appends the SIGHASH_ALL byte
+func signEcdsa(key *ecc.PrivateKey, z []byte) []byte {
+	sig := key.Sign(new(big.Int).SetBytes(z))
+	return append(sig.Der(), SIGHASH_ALL)
+}
+
+// keyring looks up private keys by their SEC encoding or its hash160
+type keyring struct {
+	bySecHex map[string]*ecc.PrivateKey
+	secs     map[string][]byte
+}
+
+// newKeyring indexes both the compressed and uncompressed public keys of every key
+func newKeyring(keys []*ecc.PrivateKey) *keyring {
+	k := &keyring{
+		bySecHex: make(map[string]*ecc.PrivateKey),
+		secs:     make(map[string][]byte),
+	}
+	for _, key := range keys {
+		for _, compressed := range []bool{true, false} {
+			secHex, sec := key.GetPublicKey().Sec(compressed)
+			k.bySecHex[secHex] = key
+			k.secs[string(ecc.Hash160(sec))] = sec
+		}
+	}
+	return k
+}
+
+// bySec finds the key of a SEC encoded public key
+func (k *keyring) bySec(sec []byte) (*ecc.PrivateKey, error) {
+	key, ok := k.bySecHex[fmt.Sprintf("%x", sec)]
+	if !ok {
+		return nil, fmt.Errorf("%w for public key %x", ErrMissingKey, sec)
+	}
+	return key, nil
+}
+
+// byHash160 finds the key and SEC public key whose hash160 is h160
+func (k *keyring) byHash160(h160 []byte) (*ecc.PrivateKey, []byte, error) {
+	sec, ok := k.secs[string(h160)]
+	if !ok {
+		return nil, nil, fmt.Errorf("%w for public key hash %x", ErrMissingKey, h160)
+	}
+	key, err := k.bySec(sec)
+	return key, sec, err
+}
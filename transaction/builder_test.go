@@ -0,0 +1,43 @@
+package transaction
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/sudonite/bitcoin/chaincfg"
+)
+
+func TestBuilderSequence(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func(b *TransactionBuilder)
+		want  uint32
+	}{
+		{"final by default", func(b *TransactionBuilder) {}, SEQUENCE_FINAL},
+		{"lock time before the input", func(b *TransactionBuilder) { b.SetLockTime(500) }, SEQUENCE_LOCKTIME_ENABLED},
+		{"RBF before the input", func(b *TransactionBuilder) { b.EnableRBF() }, SEQUENCE_RBF},
+		{"RBF and lock time before the input", func(b *TransactionBuilder) { b.EnableRBF(); b.SetLockTime(500) }, SEQUENCE_RBF},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewTransactionBuilder(&chaincfg.RegTestParams)
+			tt.build(b)
+			b.AddInput(bytes.Repeat([]byte{1}, 32), 0, 100000, P2wpkhScript(make([]byte, 20)))
+			if got := b.Build().txInputs[0].Sequence(); got != tt.want {
+				t.Errorf("sequence %#x, want %#x", got, tt.want)
+			}
+		})
+	}
+
+	// inputs added before EnableRBF are lowered too
+	b := NewTransactionBuilder(&chaincfg.RegTestParams)
+	b.AddInput(bytes.Repeat([]byte{1}, 32), 0, 100000, P2wpkhScript(make([]byte, 20)))
+	b.EnableRBF()
+	b.AddInput(bytes.Repeat([]byte{2}, 32), 0, 100000, P2wpkhScript(make([]byte, 20)))
+	for i, in := range b.Build().txInputs {
+		if !in.SignalsRBF() {
+			t.Errorf("input %d does not signal RBF", i)
+		}
+	}
+}
@@ -0,0 +1,502 @@
+package transaction
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"sort"
+)
+
+const (
+	DEFAULT_LONG_TERM_FEE_RATE FeeRate = 10000
+	DUST_RELAY_FEE_RATE        FeeRate = 3000
+
+	BNB_TOTAL_TRIES     = 100000
+	KNAPSACK_ITERATIONS = 1000
+	// knapsack tries to leave at least this much change so it is not wasted on tiny outputs
+	MIN_CHANGE Amount = 1000000
+)
+
+var (
+	ErrInsufficientFunds  = errors.New("insufficient funds")
+	ErrUnknownInputWeight = errors.New("unknown input weight")
+)
+
+// SpendableOutput is an unspent output that coin selection may use as an input
+type SpendableOutput struct {
+	txID         []byte
+	index        uint32
+	value        Amount
+	scriptPubKey *ScriptSig
+	// inputWeight is the weight of the input spending this output, signatures included
+	inputWeight int
+	witness     bool
+}
+
+// NewSpendableOutput creates a coin from its outpoint, value and locking script, the input weight is
+// estimated from the script type and must be set with SetInputWeight for P2SH and P2WSH scripts
+func NewSpendableOutput(txID []byte, index uint32, value Amount, scriptPubKey *ScriptSig) *SpendableOutput {
+	weight, _ := EstimateInputWeight(scriptPubKey)
+	_, _, witness := scriptPubKey.witnessProgram()
+	return &SpendableOutput{
+		txID:         txID,
+		index:        index,
+		value:        value,
+		scriptPubKey: scriptPubKey,
+		inputWeight:  weight,
+		witness:      witness,
+	}
+}
+
+// SetInputWeight sets the weight of the signed input spending this coin and whether it has a witness
+func (s *SpendableOutput) SetInputWeight(weight int, witness bool) {
+	s.inputWeight = weight
+	s.witness = witness
+}
+
+// Value returns the amount of the coin
+func (s *SpendableOutput) Value() Amount {
+	return s.value
+}
+
+// TxInput creates the unsigned input spending this coin
+func (s *SpendableOutput) TxInput() *TransactionInput {
+	input := InitTransactionInput(s.txID, s.index)
+	input.SetScriptSig(InitScriptSig([]ScriptCmd{}))
+	input.SetPreviousOutput(InitTransactionOutput(s.value, s.scriptPubKey))
+	return input
+}
+
+// dustThreshold returns the smallest output value that is worth more than the fee to spend it
+func dustThreshold(script *ScriptSig, dustRelayFeeRate FeeRate) Amount {
+	size := int64(len(InitTransactionOutput(0, script).Serialize()))
+	if _, _, ok := script.witnessProgram(); ok {
+		// outpoint, sequence, empty scriptSig and a discounted signature and public key
+		size += 32 + 4 + 1 + 107/4 + 4
+	} else {
+		size += 148
+	}
+	return Amount(size * int64(dustRelayFeeRate) / 1000)
+}
+
+// CoinSelectionParams are the fee settings coin selection works with
+type CoinSelectionParams struct {
+	feeRate          FeeRate
+	longTermFeeRate  FeeRate
+	dustRelayFeeRate FeeRate
+	changeScript     *ScriptSig
+	// changeSpendWeight is the weight of the input that will later spend the change
+	changeSpendWeight int
+}
+
+// NewCoinSelectionParams creates the parameters for a fee rate, change is sent to changeScript,
+// without one any excess goes to the fee
+func NewCoinSelectionParams(feeRate FeeRate, changeScript *ScriptSig) *CoinSelectionParams {
+	params := &CoinSelectionParams{
+		feeRate:          feeRate,
+		longTermFeeRate:  DEFAULT_LONG_TERM_FEE_RATE,
+		dustRelayFeeRate: DUST_RELAY_FEE_RATE,
+		changeScript:     changeScript,
+	}
+	if changeScript != nil {
+		params.changeSpendWeight, _ = EstimateInputWeight(changeScript)
+	}
+	return params
+}
+
+// SetLongTermFeeRate sets the fee rate expected when the change will be spent, used by the waste metric
+func (p *CoinSelectionParams) SetLongTermFeeRate(feeRate FeeRate) {
+	p.longTermFeeRate = feeRate
+}
+
+// SetDustRelayFeeRate sets the fee rate defining dust change
+func (p *CoinSelectionParams) SetDustRelayFeeRate(feeRate FeeRate) {
+	p.dustRelayFeeRate = feeRate
+}
+
+// SetChangeSpendWeight sets the weight of the input spending the change when it cannot be estimated from its script
+func (p *CoinSelectionParams) SetChangeSpendWeight(weight int) {
+	p.changeSpendWeight = weight
+}
+
+// changeOutputFee is the fee of adding the change output to the transaction
+func (p *CoinSelectionParams) changeOutputFee() Amount {
+	if p.changeScript == nil {
+		return 0
+	}
+	return p.feeRate.FeeForWeight(OutputWeight(InitTransactionOutput(0, p.changeScript)))
+}
+
+// costOfChange is the fee of creating the change now plus spending it later
+func (p *CoinSelectionParams) costOfChange() Amount {
+	if p.changeScript == nil {
+		return 0
+	}
+	return p.changeOutputFee() + p.longTermFeeRate.FeeForWeight(p.changeSpendWeight)
+}
+
+// selectionCoin is a coin with the fees of spending it precomputed
+type selectionCoin struct {
+	coin           *SpendableOutput
+	effectiveValue Amount
+	fee            Amount
+	longTermFee    Amount
+}
+
+// CoinSelection is the result of coin selection
+type CoinSelection struct {
+	inputs    []*SpendableOutput
+	outputs   []*TransactionOutput
+	change    Amount
+	fee       Amount
+	waste     Amount
+	algorithm string
+}
+
+// Inputs returns the selected coins
+func (c *CoinSelection) Inputs() []*SpendableOutput {
+	return c.inputs
+}
+
+// Change returns the change amount, zero when the selection is changeless
+func (c *CoinSelection) Change() Amount {
+	return c.change
+}
+
+// Fee returns the fee the transaction pays
+func (c *CoinSelection) Fee() Amount {
+	return c.fee
+}
+
+// Waste returns the waste metric of the selection, lower is better
+func (c *CoinSelection) Waste() Amount {
+	return c.waste
+}
+
+// Algorithm returns the name of the algorithm that found the selection
+func (c *CoinSelection) Algorithm() string {
+	return c.algorithm
+}
+
+// TxInputs returns the unsigned inputs spending the selected coins
+func (c *CoinSelection) TxInputs() []*TransactionInput {
+	inputs := make([]*TransactionInput, 0, len(c.inputs))
+	for _, coin := range c.inputs {
+		inputs = append(inputs, coin.TxInput())
+	}
+	return inputs
+}
+
+// TxOutputs returns the payment outputs followed by the change output if there is one
+func (c *CoinSelection) TxOutputs() []*TransactionOutput {
+	return c.outputs
+}
+
+// SelectCoins picks coins paying for the outputs at the fee rate of params. Branch and bound, knapsack and
+// largest first are all tried and the selection with the lowest waste wins.
+func SelectCoins(coins []*SpendableOutput, outputs []*TransactionOutput, params *CoinSelectionParams) (*CoinSelection, error) {
+	target := Amount(0)
+	notInputWeight := TX_BASE_WEIGHT
+	for _, output := range outputs {
+		var err error
+		if target, err = target.Add(output.amount); err != nil {
+			return nil, err
+		}
+		notInputWeight += OutputWeight(output)
+	}
+
+	pool := make([]*selectionCoin, 0, len(coins))
+	hasWitness := false
+	for _, coin := range coins {
+		if coin.inputWeight == 0 {
+			return nil, fmt.Errorf("%w for coin %x:%d", ErrUnknownInputWeight, coin.txID, coin.index)
+		}
+		fee := params.feeRate.FeeForWeight(coin.inputWeight)
+		if coin.value <= fee {
+			// spending this coin costs more than it is worth
+			continue
+		}
+		pool = append(pool, &selectionCoin{
+			coin:           coin,
+			effectiveValue: coin.value - fee,
+			fee:            fee,
+			longTermFee:    params.longTermFeeRate.FeeForWeight(coin.inputWeight),
+		})
+		hasWitness = hasWitness || coin.witness
+	}
+	if hasWitness {
+		notInputWeight += SEGWIT_MARKER_WEIGHT
+	}
+	// the selected coins must pay for the outputs and for the transaction parts that are not inputs
+	selectionTarget, err := target.Add(params.feeRate.FeeForWeight(notInputWeight))
+	if err != nil {
+		return nil, err
+	}
+	withChange, err := selectionTarget.Add(params.changeOutputFee())
+	if err != nil {
+		return nil, err
+	}
+
+	// branch and bound sums the whole pool first, so the sums of the other algorithms cannot overflow
+	bnb, err := selectBnB(pool, selectionTarget, params)
+	if err != nil {
+		return nil, err
+	}
+	candidates := []struct {
+		name      string
+		selection []*selectionCoin
+	}{
+		{"bnb", bnb},
+		{"knapsack", selectKnapsack(pool, withChange)},
+		{"largest_first", selectLargestFirst(pool, withChange)},
+	}
+
+	var best *CoinSelection
+	for _, candidate := range candidates {
+		if candidate.selection == nil {
+			continue
+		}
+		result, err := newCoinSelection(candidate.name, candidate.selection, outputs, selectionTarget, params)
+		if err != nil {
+			return nil, err
+		}
+		if best == nil || result.waste < best.waste {
+			best = result
+		}
+	}
+	if best == nil {
+		return nil, ErrInsufficientFunds
+	}
+	return best, nil
+}
+
+// newCoinSelection decides on change and scores the selected coins
+func newCoinSelection(algorithm string, selection []*selectionCoin, outputs []*TransactionOutput,
+	selectionTarget Amount, params *CoinSelectionParams) (*CoinSelection, error) {
+	result := &CoinSelection{
+		outputs:   append([]*TransactionOutput{}, outputs...),
+		algorithm: algorithm,
+	}
+
+	selected, total, waste := Amount(0), Amount(0), Amount(0)
+	for _, c := range selection {
+		result.inputs = append(result.inputs, c.coin)
+		var err error
+		if selected, err = selected.Add(c.effectiveValue); err != nil {
+			return nil, err
+		}
+		if total, err = total.Add(c.coin.value); err != nil {
+			return nil, err
+		}
+		// spending now instead of at the long term rate wastes the difference
+		waste += c.fee - c.longTermFee
+	}
+
+	excess := selected - selectionTarget
+	changeFee := params.changeOutputFee()
+	if params.changeScript != nil && excess > changeFee &&
+		excess-changeFee >= dustThreshold(params.changeScript, params.dustRelayFeeRate) {
+		result.change = excess - changeFee
+		result.outputs = append(result.outputs,
+			InitTransactionOutput(result.change, params.changeScript))
+		waste += params.costOfChange()
+	} else {
+		// without change the excess goes to the miners
+		waste += excess
+	}
+
+	outputSum := Amount(0)
+	for _, output := range result.outputs {
+		var err error
+		if outputSum, err = outputSum.Add(output.amount); err != nil {
+			return nil, err
+		}
+	}
+	result.fee = total - outputSum
+	result.waste = waste
+	return result, nil
+}
+
+// sortByEffectiveValue orders the coins from the largest effective value down
+func sortByEffectiveValue(pool []*selectionCoin) []*selectionCoin {
+	sorted := append([]*selectionCoin{}, pool...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].effectiveValue > sorted[j].effectiveValue
+	})
+	return sorted
+}
+
+// selectBnB searches depth first for a changeless selection within costOfChange above the target,
+// keeping the one with the least waste. A nil selection without an error means none was found
+func selectBnB(pool []*selectionCoin, target Amount, params *CoinSelectionParams) ([]*selectionCoin, error) {
+	sorted := sortByEffectiveValue(pool)
+	costOfChange := params.costOfChange()
+	feeRateHigh := params.feeRate > params.longTermFeeRate
+
+	available := Amount(0)
+	for _, c := range sorted {
+		var err error
+		if available, err = available.Add(c.effectiveValue); err != nil {
+			return nil, err
+		}
+	}
+	if available < target {
+		return nil, nil
+	}
+
+	current := make([]int, 0)
+	var best []int
+	currentValue, currentWaste, bestWaste := Amount(0), Amount(0), Amount(math.MaxInt64)
+
+	for try, index := 0, 0; try < BNB_TOTAL_TRIES; try, index = try+1, index+1 {
+		backtrack := false
+		if currentValue+available < target || currentValue > target+costOfChange ||
+			(currentWaste > bestWaste && feeRateHigh) {
+			backtrack = true
+		} else if currentValue >= target {
+			waste := currentWaste + currentValue - target
+			if waste <= bestWaste {
+				best = append([]int{}, current...)
+				bestWaste = waste
+			}
+			backtrack = true
+		}
+
+		if backtrack {
+			if len(current) == 0 {
+				break
+			}
+			// coins skipped after the last included one become available again
+			for index--; index > current[len(current)-1]; index-- {
+				available += sorted[index].effectiveValue
+			}
+			// then try the branch excluding the last included coin
+			last := sorted[index]
+			currentValue -= last.effectiveValue
+			currentWaste -= last.fee - last.longTermFee
+			current = current[:len(current)-1]
+			continue
+		}
+
+		c := sorted[index]
+		available -= c.effectiveValue
+		// including a coin equivalent to a skipped one would explore the same selections again
+		if len(current) == 0 || index-1 == current[len(current)-1] ||
+			c.effectiveValue != sorted[index-1].effectiveValue || c.fee != sorted[index-1].fee {
+			current = append(current, index)
+			currentValue += c.effectiveValue
+			currentWaste += c.fee - c.longTermFee
+		}
+	}
+
+	if best == nil {
+		return nil, nil
+	}
+	selection := make([]*selectionCoin, 0, len(best))
+	for _, index := range best {
+		selection = append(selection, sorted[index])
+	}
+	return selection, nil
+}
+
+// selectKnapsack picks an exact match, the smallest single coin above the target, or the random subset
+// closest to the target, aiming to leave at least MIN_CHANGE
+func selectKnapsack(pool []*selectionCoin, target Amount) []*selectionCoin {
+	shuffled := append([]*selectionCoin{}, pool...)
+	rand.Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+
+	applicable := make([]*selectionCoin, 0)
+	var lowestLarger *selectionCoin
+	totalLower := Amount(0)
+	for _, c := range shuffled {
+		switch {
+		case c.effectiveValue == target:
+			return []*selectionCoin{c}
+		case c.effectiveValue < target+MIN_CHANGE:
+			applicable = append(applicable, c)
+			totalLower += c.effectiveValue
+		case lowestLarger == nil || c.effectiveValue < lowestLarger.effectiveValue:
+			lowestLarger = c
+		}
+	}
+
+	if totalLower == target {
+		return applicable
+	}
+	if totalLower < target {
+		if lowestLarger == nil {
+			return nil
+		}
+		return []*selectionCoin{lowestLarger}
+	}
+
+	applicable = sortByEffectiveValue(applicable)
+	best, bestValue := approximateBestSubset(applicable, totalLower, target)
+	if bestValue != target && totalLower >= target+MIN_CHANGE {
+		best, bestValue = approximateBestSubset(applicable, totalLower, target+MIN_CHANGE)
+	}
+
+	if lowestLarger != nil &&
+		((bestValue != target && bestValue < target+MIN_CHANGE) || lowestLarger.effectiveValue <= bestValue) {
+		return []*selectionCoin{lowestLarger}
+	}
+	return best
+}
+
+// approximateBestSubset runs randomized passes looking for the subset just above the target
+func approximateBestSubset(coins []*selectionCoin, totalLower Amount, target Amount) ([]*selectionCoin, Amount) {
+	bestIncluded := make([]bool, len(coins))
+	for i := range bestIncluded {
+		bestIncluded[i] = true
+	}
+	bestValue := totalLower
+
+	for rep := 0; rep < KNAPSACK_ITERATIONS && bestValue != target; rep++ {
+		included := make([]bool, len(coins))
+		total := Amount(0)
+		reachedTarget := false
+		for pass := 0; pass < 2 && !reachedTarget; pass++ {
+			for i, c := range coins {
+				// the first pass includes coins at random, the second adds every coin left out
+				if (pass == 0 && rand.IntN(2) == 0) || (pass == 1 && !included[i]) {
+					total += c.effectiveValue
+					included[i] = true
+					if total >= target {
+						reachedTarget = true
+						if total < bestValue {
+							bestValue = total
+							copy(bestIncluded, included)
+						}
+						total -= c.effectiveValue
+						included[i] = false
+					}
+				}
+			}
+		}
+	}
+
+	best := make([]*selectionCoin, 0)
+	for i, c := range coins {
+		if bestIncluded[i] {
+			best = append(best, c)
+		}
+	}
+	return best, bestValue
+}
+
+// selectLargestFirst adds coins from the largest down until the target is met
+func selectLargestFirst(pool []*selectionCoin, target Amount) []*selectionCoin {
+	selected := Amount(0)
+	selection := make([]*selectionCoin, 0)
+	for _, c := range sortByEffectiveValue(pool) {
+		selection = append(selection, c)
+		selected += c.effectiveValue
+		if selected >= target {
+			return selection
+		}
+	}
+	return nil
+}
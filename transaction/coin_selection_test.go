@@ -0,0 +1,166 @@
+package transaction
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"testing"
+)
+
+// testPool makes selection coins whose effective value is their value, with no fees to spend them
+func testPool(values ...Amount) []*selectionCoin {
+	pool := make([]*selectionCoin, 0, len(values))
+	for i, value := range values {
+		coin := NewSpendableOutput(bytes.Repeat([]byte{byte(i + 1)}, 32), 0, value, P2wpkhScript(make([]byte, 20)))
+		pool = append(pool, &selectionCoin{coin: coin, effectiveValue: value})
+	}
+	return pool
+}
+
+// selectedValues returns the effective values of a selection from the largest down
+func selectedValues(selection []*selectionCoin) []Amount {
+	values := make([]Amount, 0, len(selection))
+	for _, c := range selection {
+		values = append(values, c.effectiveValue)
+	}
+	slices.Sort(values)
+	slices.Reverse(values)
+	return values
+}
+
+func TestSelectBnB(t *testing.T) {
+	// at a zero fee rate the cost of change is only the long term fee of spending it
+	params := NewCoinSelectionParams(0, P2wpkhScript(make([]byte, 20)))
+	costOfChange := params.costOfChange()
+
+	tests := []struct {
+		name   string
+		pool   []Amount
+		target Amount
+		want   []Amount
+	}{
+		{"exact single coin", []Amount{1000000, 2000000, 3000000}, 2000000, []Amount{2000000}},
+		{"exact combination", []Amount{1000000, 2000000, 5000000}, 3000000, []Amount{2000000, 1000000}},
+		{"within the cost of change", []Amount{1000000 + costOfChange, 4000000}, 1000000, []Amount{1000000 + costOfChange}},
+		{"above the cost of change", []Amount{1000000 + costOfChange + 1, 4000000}, 1000000, nil},
+		{"no combination", []Amount{1000000, 4000000}, 2000000, nil},
+		{"insufficient funds", []Amount{1000000, 1000000}, 3000000, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selection, err := selectBnB(testPool(tt.pool...), tt.target, params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := selectedValues(selection); !slices.Equal(got, tt.want) {
+				t.Errorf("selected %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := selectBnB(testPool(MAX_MONEY, 1), 1000, params); !errors.Is(err, ErrAmountRange) {
+		t.Errorf("pool above MAX_MONEY: error %v, want %v", err, ErrAmountRange)
+	}
+}
+
+func TestSelectKnapsack(t *testing.T) {
+	tests := []struct {
+		name   string
+		pool   []Amount
+		target Amount
+		want   []Amount
+	}{
+		{"exact single coin", []Amount{500000, 2000000, 9000000}, 2000000, []Amount{2000000}},
+		{"every smaller coin", []Amount{500000, 1500000, 9000000}, 2000000, []Amount{1500000, 500000}},
+		{"lowest larger coin", []Amount{9000000, 5000000, 100000}, 2000000, []Amount{5000000}},
+		{"insufficient funds", []Amount{500000, 500000}, 2000000, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := selectedValues(selectKnapsack(testPool(tt.pool...), tt.target))
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("selected %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectCoins(t *testing.T) {
+	change := P2wpkhScript(bytes.Repeat([]byte{9}, 20))
+	coins := func(values ...Amount) []*SpendableOutput {
+		result := make([]*SpendableOutput, 0, len(values))
+		for _, c := range testPool(values...) {
+			result = append(result, c.coin)
+		}
+		return result
+	}
+	pay := func(amounts ...Amount) []*TransactionOutput {
+		outputs := make([]*TransactionOutput, 0, len(amounts))
+		for _, amount := range amounts {
+			outputs = append(outputs, InitTransactionOutput(amount, P2pkhScript(make([]byte, 20))))
+		}
+		return outputs
+	}
+	inputWeight, _ := EstimateInputWeight(P2wpkhScript(make([]byte, 20)))
+
+	t.Run("exact match", func(t *testing.T) {
+		// without fees the coin pays the output exactly and no change is made
+		selection, err := SelectCoins(coins(50000, 3000000), pay(50000), NewCoinSelectionParams(0, change))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if selection.Algorithm() != "bnb" || selection.Change() != 0 || selection.Fee() != 0 || len(selection.TxOutputs()) != 1 {
+			t.Errorf("%s selection with change %s and fee %s", selection.Algorithm(), selection.Change(), selection.Fee())
+		}
+		// spending now at a lower rate than the long term one saves the difference
+		if want := -DEFAULT_LONG_TERM_FEE_RATE.FeeForWeight(inputWeight); selection.Waste() != want {
+			t.Errorf("waste %d, want %d", selection.Waste(), want)
+		}
+	})
+
+	t.Run("change", func(t *testing.T) {
+		feeRate := FeeRate(5000)
+		selection, err := SelectCoins(coins(1000000), pay(200000), NewCoinSelectionParams(feeRate, change))
+		if err != nil {
+			t.Fatal(err)
+		}
+		outputs := selection.TxOutputs()
+		if len(outputs) != 2 || outputs[1].amount != selection.Change() || selection.Change() <= 0 {
+			t.Fatalf("change %s in %d outputs", selection.Change(), len(outputs))
+		}
+		if got := 200000 + selection.Change() + selection.Fee(); got != 1000000 {
+			t.Errorf("outputs and fee add up to %d, want 1000000", got)
+		}
+		if selection.Fee() < feeRate.FeeForWeight(inputWeight) {
+			t.Errorf("fee %s does not pay for the input", selection.Fee())
+		}
+		wantWaste := feeRate.FeeForWeight(inputWeight) - DEFAULT_LONG_TERM_FEE_RATE.FeeForWeight(inputWeight) +
+			NewCoinSelectionParams(feeRate, change).costOfChange()
+		if selection.Waste() != wantWaste {
+			t.Errorf("waste %d, want %d", selection.Waste(), wantWaste)
+		}
+	})
+
+	invalid := []struct {
+		name    string
+		coins   []*SpendableOutput
+		outputs []*TransactionOutput
+		want    error
+	}{
+		{"insufficient funds", coins(1000, 2000), pay(5000), ErrInsufficientFunds},
+		{"coins worth less than their fee", coins(100), pay(50), ErrInsufficientFunds},
+		{"outputs above MAX_MONEY", coins(1000), pay(MAX_MONEY, MAX_MONEY), ErrAmountRange},
+		{"coins above MAX_MONEY", coins(MAX_MONEY, MAX_MONEY), pay(1000), ErrAmountRange},
+		{"unknown input weight", []*SpendableOutput{NewSpendableOutput(bytes.Repeat([]byte{1}, 32), 0, 1000, P2wshScript(make([]byte, 32)))},
+			pay(500), ErrUnknownInputWeight},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := SelectCoins(tt.coins, tt.outputs, NewCoinSelectionParams(1000, change)); !errors.Is(err, tt.want) {
+				t.Errorf("error %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,330 @@
+package transaction
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// a transaction can never be bigger than a block, whose weight is capped at 4M
+	MAX_TX_SIZE = 4000000
+
+	// smallest possible encodings, used to bound counts before allocating anything
+	MIN_TX_INPUT_SIZE  = INPUT_BASE_SIZE + 1
+	MIN_TX_OUTPUT_SIZE = 8 + 1
+
+	MAX_TX_INPUTS        = MAX_TX_SIZE / MIN_TX_INPUT_SIZE
+	MAX_TX_OUTPUTS       = MAX_TX_SIZE / MIN_TX_OUTPUT_SIZE
+	MAX_TX_WITNESS_ITEMS = MAX_TX_SIZE
+)
+
+var (
+	ErrTxTruncated = errors.New("transaction data truncated")
+	ErrTxOversized = errors.New("transaction data exceeds limits")
+	ErrTxMalformed = errors.New("malformed transaction data")
+)
+
+// txDecoder reads transaction fields from a stream, never reading more than remaining bytes
+type txDecoder struct {
+	reader    io.Reader
+	remaining int
+}
+
+// newTxDecoder wraps a reader with the maximum transaction size as its budget
+func newTxDecoder(reader io.Reader) *txDecoder {
+	return &txDecoder{reader: reader, remaining: MAX_TX_SIZE}
+}
+
+// DecodeTransaction reads a single legacy or segwit transaction from the reader
+func DecodeTransaction(reader io.Reader) (*Transaction, error) {
+	return newTxDecoder(reader).transaction()
+}
+
+// readFull reads exactly n bytes, checking them against the budget first
+func (d *txDecoder) readFull(n int) ([]byte, error) {
+	if n > d.remaining {
+		return nil, fmt.Errorf("%w: need %d more bytes, at most %d allowed", ErrTxOversized, n, d.remaining)
+	}
+
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(d.reader, buf); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, fmt.Errorf("%w: wanted %d bytes", ErrTxTruncated, n)
+		}
+		return nil, err
+	}
+	d.remaining -= n
+	return buf, nil
+}
+
+// uint32 reads a little-endian 4 byte field
+func (d *txDecoder) uint32() (uint32, error) {
+	buf, err := d.readFull(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(buf), nil
+}
+
+// varint reads a compact size integer, rejecting non-canonical encodings like Core does
+func (d *txDecoder) varint() (uint64, error) {
+	prefix, err := d.readFull(1)
+	if err != nil {
+		return 0, err
+	}
+
+	var v, min uint64
+	switch prefix[0] {
+	case 0xfd:
+		buf, err := d.readFull(2)
+		if err != nil {
+			return 0, err
+		}
+		v, min = uint64(binary.LittleEndian.Uint16(buf)), 0xfd
+	case 0xfe:
+		buf, err := d.readFull(4)
+		if err != nil {
+			return 0, err
+		}
+		v, min = uint64(binary.LittleEndian.Uint32(buf)), 0x10000
+	case 0xff:
+		buf, err := d.readFull(8)
+		if err != nil {
+			return 0, err
+		}
+		v, min = binary.LittleEndian.Uint64(buf), 0x100000000
+	default:
+		return uint64(prefix[0]), nil
+	}
+
+	if v < min {
+		return 0, fmt.Errorf("%w: non-canonical varint", ErrTxMalformed)
+	}
+	return v, nil
+}
+
+// count reads a varint element count, each element taking at least minSize bytes of what is left
+func (d *txDecoder) count(what string, max int, minSize int) (int, error) {
+	n, err := d.varint()
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(max) || n*uint64(minSize) > uint64(d.remaining) {
+		return 0, fmt.Errorf("%w: %d %s", ErrTxOversized, n, what)
+	}
+	return int(n), nil
+}
+
+// bytes reads a varint length prefixed byte string
+func (d *txDecoder) bytes() ([]byte, error) {
+	n, err := d.varint()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(d.remaining) {
+		return nil, fmt.Errorf("%w: %d byte field", ErrTxOversized, n)
+	}
+	return d.readFull(int(n))
+}
+
+// script reads a length prefixed script and splits it into commands
+func (d *txDecoder) script() (*ScriptSig, error) {
+	raw, err := d.bytes()
+	if err != nil {
+		return nil, err
+	}
+	cmds, err := parseScriptCmds(raw)
+	if err != nil {
+		// scripts that don't parse are still valid transaction data, they just can't be spent
+		return &ScriptSig{bitcoinOpCode: NewBitcoinOpCode(), unparsed: raw}, nil
+	}
+	return InitScriptSig(cmds), nil
+}
+
+// input reads the outpoint, script sig and sequence of an input
+func (d *txDecoder) input() (*TransactionInput, error) {
+	prevTx, err := d.readFull(32)
+	if err != nil {
+		return nil, err
+	}
+	prevIndex, err := d.uint32()
+	if err != nil {
+		return nil, err
+	}
+	scriptSig, err := d.script()
+	if err != nil {
+		return nil, err
+	}
+	sequence, err := d.uint32()
+	if err != nil {
+		return nil, err
+	}
+
+	return &TransactionInput{
+		previousTransactionID:    ReverseByteSlice(prevTx),
+		previousTransactionIndex: prevIndex,
+		scriptSig:                scriptSig,
+		sequence:                 sequence,
+	}, nil
+}
+
+// output reads the amount and locking script of an output
+func (d *txDecoder) output() (*TransactionOutput, error) {
+	amountBuf, err := d.readFull(8)
+	if err != nil {
+		return nil, err
+	}
+	script, err := d.script()
+	if err != nil {
+		return nil, err
+	}
+
+	return &TransactionOutput{
+		amount:       Amount(binary.LittleEndian.Uint64(amountBuf)),
+		scriptPubKey: script,
+	}, nil
+}
+
+// witness reads the witness stack of a single input
+func (d *txDecoder) witness() ([][]byte, error) {
+	n, err := d.count("witness items", MAX_TX_WITNESS_ITEMS, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		item, err := d.bytes()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// transaction reads a whole transaction, the 0x00 marker right after the version means segwit
+func (d *txDecoder) transaction() (*Transaction, error) {
+	version, err := d.uint32()
+	if err != nil {
+		return nil, err
+	}
+	tx := &Transaction{version: int32(version)}
+
+	numInputs, err := d.count("inputs", MAX_TX_INPUTS, MIN_TX_INPUT_SIZE)
+	if err != nil {
+		return nil, err
+	}
+	if numInputs == 0 {
+		// an empty input list can only be the segwit marker, the flag has to follow
+		flag, err := d.readFull(1)
+		if err != nil {
+			return nil, err
+		}
+		if flag[0] != 0x01 {
+			return nil, fmt.Errorf("%w: unknown segwit flag %#x", ErrTxMalformed, flag[0])
+		}
+		tx.segwit = true
+
+		if numInputs, err = d.count("inputs", MAX_TX_INPUTS, MIN_TX_INPUT_SIZE); err != nil {
+			return nil, err
+		}
+	}
+
+	tx.txInputs = make([]*TransactionInput, 0, numInputs)
+	for i := 0; i < numInputs; i++ {
+		input, err := d.input()
+		if err != nil {
+			return nil, fmt.Errorf("input %d: %w", i, err)
+		}
+		tx.txInputs = append(tx.txInputs, input)
+	}
+
+	numOutputs, err := d.count("outputs", MAX_TX_OUTPUTS, MIN_TX_OUTPUT_SIZE)
+	if err != nil {
+		return nil, err
+	}
+	tx.txOutputs = make([]*TransactionOutput, 0, numOutputs)
+	for i := 0; i < numOutputs; i++ {
+		output, err := d.output()
+		if err != nil {
+			return nil, fmt.Errorf("output %d: %w", i, err)
+		}
+		tx.txOutputs = append(tx.txOutputs, output)
+	}
+
+	if tx.segwit {
+		for i, input := range tx.txInputs {
+			if input.witness, err = d.witness(); err != nil {
+				return nil, fmt.Errorf("witness %d: %w", i, err)
+			}
+		}
+		// the marker is only there to announce witnesses, Bitcoin Core refuses the extended format without any
+		if !tx.HasWitness() {
+			return nil, fmt.Errorf("%w: superfluous witness record", ErrTxMalformed)
+		}
+	}
+
+	lockTime, err := d.uint32()
+	if err != nil {
+		return nil, err
+	}
+	tx.lockTime = lockTime
+
+	return tx, nil
+}
+
+// parseScriptCmds splits raw script bytes into opcodes and data pushes
+func parseScriptCmds(raw []byte) ([]ScriptCmd, error) {
+	cmds := []ScriptCmd{}
+	reader := bytes.NewReader(raw)
+
+	for reader.Len() > 0 {
+		op, _ := reader.ReadByte()
+
+		var length int
+		switch {
+		case op >= SCRIPT_DATA_LENGTH_BEGIN && op <= SCRIPT_DATA_LENGTH_END:
+			length = int(op)
+		case op == OP_PUSHDATA1:
+			if reader.Len() < 1 {
+				return nil, fmt.Errorf("%w: truncated OP_PUSHDATA1", ErrTxMalformed)
+			}
+			b, _ := reader.ReadByte()
+			length = int(b)
+		case op == OP_PUSHDATA2:
+			if reader.Len() < 2 {
+				return nil, fmt.Errorf("%w: truncated OP_PUSHDATA2", ErrTxMalformed)
+			}
+			lenBuf := make([]byte, 2)
+			reader.Read(lenBuf)
+			length = int(binary.LittleEndian.Uint16(lenBuf))
+		case op == OP_PUSHDATA4:
+			if reader.Len() < 4 {
+				return nil, fmt.Errorf("%w: truncated OP_PUSHDATA4", ErrTxMalformed)
+			}
+			lenBuf := make([]byte, 4)
+			reader.Read(lenBuf)
+			if uint64(binary.LittleEndian.Uint32(lenBuf)) > uint64(reader.Len()) {
+				return nil, fmt.Errorf("%w: push past end of script", ErrTxMalformed)
+			}
+			length = int(binary.LittleEndian.Uint32(lenBuf))
+		default:
+			// current byte is an instruction
+			cmds = append(cmds, OpCmd(op))
+			continue
+		}
+
+		if length > reader.Len() {
+			return nil, fmt.Errorf("%w: push past end of script", ErrTxMalformed)
+		}
+		data := make([]byte, length)
+		reader.Read(data)
+		cmds = append(cmds, ScriptCmd{opCode: op, data: data, push: true})
+	}
+
+	return cmds, nil
+}
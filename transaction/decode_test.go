@@ -0,0 +1,176 @@
+package transaction
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// legacyTestTx is an unsigned legacy transaction spending one input with a scriptSig given as raw bytes
+func legacyTestTx(scriptSig []byte) []byte {
+	raw := []byte{0x01, 0x00, 0x00, 0x00, 0x01}
+	raw = append(raw, bytes.Repeat([]byte{0xab}, 32)...)
+	raw = append(raw, 0x00, 0x00, 0x00, 0x00, byte(len(scriptSig)))
+	raw = append(raw, scriptSig...)
+	raw = append(raw, 0xff, 0xff, 0xff, 0xff, 0x01)
+	raw = append(raw, 0xe8, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, OP_1)
+	return append(raw, 0x00, 0x00, 0x00, 0x00)
+}
+
+func TestParseTransactionRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     []byte
+		segwit  bool
+		inputs  int
+		outputs int
+	}{
+		{"BIP-143 native P2WPKH", decodeHex(t, bip143NativeP2wpkhTx), true, 2, 2},
+		{"BIP-143 P2SH-P2WPKH", decodeHex(t, bip143NestedP2wpkhTx), true, 1, 2},
+		{"BIP-341 output with a push past the end", decodeHex(t, bip341KeyPathTx), false, 9, 2},
+		{"legacy with an empty scriptSig", legacyTestTx(nil), false, 1, 1},
+		{"non-minimal OP_PUSHDATA1", legacyTestTx([]byte{OP_PUSHDATA1, 0x01, 0xaa}), false, 1, 1},
+		{"non-minimal OP_PUSHDATA2", legacyTestTx([]byte{OP_PUSHDATA2, 0x02, 0x00, 0xaa, 0xbb}), false, 1, 1},
+		{"non-minimal OP_PUSHDATA4", legacyTestTx([]byte{OP_PUSHDATA4, 0x01, 0x00, 0x00, 0x00, 0xaa}), false, 1, 1},
+		{"truncated push in the scriptSig", legacyTestTx([]byte{OP_PUSHDATA2, 0xff}), false, 1, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx, err := ParseTransaction(tt.raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tx.segwit != tt.segwit || len(tx.txInputs) != tt.inputs || len(tx.txOutputs) != tt.outputs {
+				t.Errorf("segwit %v with %d inputs and %d outputs", tx.segwit, len(tx.txInputs), len(tx.txOutputs))
+			}
+			if got := tx.Serialize(); !bytes.Equal(got, tt.raw) {
+				t.Errorf("serialized %x, want %x", got, tt.raw)
+			}
+		})
+	}
+}
+
+func TestDecodeTransactionStream(t *testing.T) {
+	txs := [][]byte{decodeHex(t, bip143NestedP2wpkhTx), legacyTestTx(nil), decodeHex(t, bip143NativeP2wpkhTx)}
+	reader := bytes.NewReader(bytes.Join(txs, nil))
+
+	for i, raw := range txs {
+		tx, err := DecodeTransaction(reader)
+		if err != nil {
+			t.Fatalf("transaction %d: %v", i, err)
+		}
+		if !bytes.Equal(tx.Serialize(), raw) {
+			t.Errorf("transaction %d decoded differently", i)
+		}
+	}
+	if _, err := DecodeTransaction(reader); !errors.Is(err, ErrTxTruncated) {
+		t.Errorf("got %v at the end of the stream, want %v", err, ErrTxTruncated)
+	}
+}
+
+func TestParseTransactionTruncated(t *testing.T) {
+	for _, raw := range [][]byte{decodeHex(t, bip143NativeP2wpkhTx), legacyTestTx([]byte{OP_1})} {
+		for i := 0; i < len(raw); i++ {
+			if _, err := ParseTransaction(raw[:i]); !errors.Is(err, ErrTxTruncated) {
+				t.Fatalf("%d of %d bytes: got %v, want %v", i, len(raw), err, ErrTxTruncated)
+			}
+		}
+	}
+}
+
+func TestParseTransactionInvalid(t *testing.T) {
+	version := []byte{0x01, 0x00, 0x00, 0x00}
+	// one input whose scriptSig length is the given varint, the script itself is never reached
+	scriptLength := func(varint ...byte) []byte {
+		raw := append(append([]byte{}, version...), 0x01)
+		raw = append(raw, make([]byte, 36)...)
+		return append(raw, varint...)
+	}
+
+	// the legacy test transaction with the marker and flag and an empty witness for its only input
+	legacy := legacyTestTx(nil)
+	superfluousWitness := append(append(append([]byte{}, version...), 0x00, 0x01), legacy[4:len(legacy)-4]...)
+	superfluousWitness = append(superfluousWitness, 0x00, 0x00, 0x00, 0x00, 0x00)
+
+	tests := []struct {
+		name string
+		raw  []byte
+		err  error
+	}{
+		{"trailing bytes", append(legacyTestTx(nil), 0x00), ErrTxMalformed},
+		{"non-canonical input count", append(append([]byte{}, version...), 0xfd, 0x01, 0x00), ErrTxMalformed},
+		{"non-canonical script length", scriptLength(0xfe, 0x01, 0x00, 0x00, 0x00), ErrTxMalformed},
+		{"unknown segwit flag", append(append([]byte{}, version...), 0x00, 0x02), ErrTxMalformed},
+		{"segwit marker with only empty witnesses", superfluousWitness, ErrTxMalformed},
+		{"input count of 2^64-1", append(append([]byte{}, version...), 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff), ErrTxOversized},
+		{"input count over what fits in a transaction", append(append([]byte{}, version...), 0xfe, 0x00, 0x00, 0x02, 0x00), ErrTxOversized},
+		{"script longer than a transaction", scriptLength(0xfe, 0x01, 0x09, 0x3d, 0x00), ErrTxOversized},
+		{"script past the end", scriptLength(0x10, 0x00), ErrTxTruncated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseTransaction(tt.raw); !errors.Is(err, tt.err) {
+				t.Errorf("got %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestDecodedScript(t *testing.T) {
+	hash := bytes.Repeat([]byte{0x11}, 20)
+	tests := []struct {
+		name  string
+		raw   []byte
+		class ScriptClass
+		asm   string
+	}{
+		{"P2SH", append(append([]byte{OP_HASH160, 20}, hash...), OP_EQUAL), SCRIPT_P2SH,
+			"OP_HASH160 1111111111111111111111111111111111111111 OP_EQUAL"},
+		{"P2SH hash pushed with OP_PUSHDATA1", append(append([]byte{OP_HASH160, OP_PUSHDATA1, 20}, hash...), OP_EQUAL), SCRIPT_NONSTANDARD,
+			"OP_HASH160 1111111111111111111111111111111111111111 OP_EQUAL"},
+		{"P2WPKH", append([]byte{OP_0, 20}, hash...), SCRIPT_P2WPKH, "0 1111111111111111111111111111111111111111"},
+		{"witness program pushed with OP_PUSHDATA1", append([]byte{OP_0, OP_PUSHDATA1, 20}, hash...), SCRIPT_NONSTANDARD,
+			"0 1111111111111111111111111111111111111111"},
+		{"push past the end", []byte{OP_1, 0x05, 0x01}, SCRIPT_NONSTANDARD, "[error]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script, err := NewScriptSig(bytes.NewReader(append([]byte{byte(len(tt.raw))}, tt.raw...)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := script.rawSerialize(); !bytes.Equal(got, tt.raw) {
+				t.Errorf("serialized %x, want %x", got, tt.raw)
+			}
+			if got := script.Classify().Class(); got != tt.class {
+				t.Errorf("class %v, want %v", got, tt.class)
+			}
+			if got := script.Asm(); got != tt.asm {
+				t.Errorf("asm %q, want %q", got, tt.asm)
+			}
+		})
+	}
+}
+
+func TestEvaluateUnparsedScript(t *testing.T) {
+	// a scriptPubKey that doesn't parse can't be spent, whatever the scriptSig pushes
+	script, err := NewScriptSig(bytes.NewReader([]byte{0x02, OP_1, OP_PUSHDATA1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	combined := InitScriptSig([]ScriptCmd{OpCmd(OP_1)}).Add(script)
+	if combined.Evaluate(nil) {
+		t.Error("unparsed script evaluated")
+	}
+	if got := combined.rawSerialize(); !bytes.Equal(got, []byte{OP_1, OP_1, OP_PUSHDATA1}) {
+		t.Errorf("combined script %x", got)
+	}
+
+	debugger := NewScriptDebugger(combined, nil, nil)
+	if ok, err := debugger.Result(); ok || !errors.Is(err, ErrScriptMalformed) {
+		t.Errorf("debugger result %v, want %v", err, ErrScriptMalformed)
+	}
+}
@@ -0,0 +1,727 @@
+package transaction
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/sudonite/bitcoin/chaincfg"
+	ecc "github.com/sudonite/bitcoin/elliptic_curve"
+)
+
+// Output script descriptor constants (BIP 380-386)
+const (
+	DESCRIPTOR_INPUT_CHARSET    = "0123456789()[],'/*abcdefgh@:$%{}IJKLMNOPQRSTUVWXYZ&+-.;<=>?!^_|~ijklmnopqrstuvwxyzABCDEFGH`#\"\\ "
+	DESCRIPTOR_CHECKSUM_CHARSET = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+	DESCRIPTOR_CHECKSUM_LENGTH  = 8
+
+	MAX_P2SH_MULTISIG_KEYS = 15
+	MAX_TAPROOT_TREE_DEPTH = 128
+)
+
+// descriptorContext is where an expression sits, it decides which expressions and keys are allowed
+type descriptorContext int
+
+const (
+	DESCRIPTOR_TOP descriptorContext = iota
+	DESCRIPTOR_SH
+	DESCRIPTOR_WSH
+	DESCRIPTOR_TR
+)
+
+var (
+	ErrDescriptor         = errors.New("invalid descriptor")
+	ErrDescriptorChecksum = errors.New("invalid descriptor checksum")
+)
+
+var descriptorGenerator = [5]uint64{0xf5dee51989, 0xa9fdca3312, 0x1bab10e32d, 0x3706b1677a, 0x644d626ffd}
+
+// DescriptorChecksum computes the BIP-380 checksum of a descriptor written without its #checksum suffix
+func DescriptorChecksum(desc string) (string, error) {
+	chk := uint64(1)
+	polymod := func(value uint64) {
+		top := chk >> 35
+		chk = (chk&0x7ffffffff)<<5 ^ value
+		for i, generator := range descriptorGenerator {
+			if (top>>i)&1 == 1 {
+				chk ^= generator
+			}
+		}
+	}
+
+	groups := []uint64{}
+	for _, char := range desc {
+		position := strings.IndexRune(DESCRIPTOR_INPUT_CHARSET, char)
+		if position < 0 {
+			return "", fmt.Errorf("%w: invalid character %q", ErrDescriptor, char)
+		}
+		// the low 5 bits are a symbol on their own, the high bits are packed three characters at a time
+		polymod(uint64(position) & 31)
+		groups = append(groups, uint64(position)>>5)
+		if len(groups) == 3 {
+			polymod(groups[0]*9 + groups[1]*3 + groups[2])
+			groups = groups[:0]
+		}
+	}
+	switch len(groups) {
+	case 1:
+		polymod(groups[0])
+	case 2:
+		polymod(groups[0]*3 + groups[1])
+	}
+	for i := 0; i < DESCRIPTOR_CHECKSUM_LENGTH; i++ {
+		polymod(0)
+	}
+	chk ^= 1
+
+	checksum := make([]byte, DESCRIPTOR_CHECKSUM_LENGTH)
+	for i := range checksum {
+		checksum[i] = DESCRIPTOR_CHECKSUM_CHARSET[(chk>>(5*(7-i)))&31]
+	}
+	return string(checksum), nil
+}
+
+// Descriptor is a parsed output script descriptor
+type Descriptor struct {
+	root   *descriptorNode
+	params *chaincfg.Params
+}
+
+// descriptorNode is one script expression such as pkh(KEY) or wsh(SCRIPT)
+type descriptorNode struct {
+	name      string
+	keys      []*descriptorKey
+	threshold int
+	// sub is the script wrapped by sh() and wsh()
+	sub *descriptorNode
+	// tree holds the script leaves of tr(), nil for key path only outputs
+	tree *tapTree
+	// arg and script are the address or hex of addr() and raw()
+	arg    string
+	script *ScriptSig
+	// miniscript is set for the other expressions of wsh() and tr() leaves, keys then holds its keys
+	miniscript *miniscriptNode
+}
+
+// tapTree is either a leaf script or a branch of two subtrees
+type tapTree struct {
+	leaf  *descriptorNode
+	left  *tapTree
+	right *tapTree
+}
+
+// descriptorKey is a key expression: an optional origin followed by a hex, WIF or extended key
+type descriptorKey struct {
+	text   string
+	origin *Bip32Derivation
+	pubKey []byte
+	extKey *ecc.ExtendedKey
+	path   []uint32
+	// ranged keys end in /* and are derived at the index the descriptor is expanded for
+	ranged        bool
+	hardenedRange bool
+	xOnly         bool
+}
+
+// descriptorScripts collects the scripts an expanded descriptor produces
+type descriptorScripts struct {
+	redeemScript  *ScriptSig
+	witnessScript *ScriptSig
+}
+
+// ParseDescriptor parses a descriptor, checking its checksum when one is given
+func ParseDescriptor(desc string, params *chaincfg.Params) (*Descriptor, error) {
+	body, checksum, hasChecksum := strings.Cut(desc, "#")
+	expected, err := DescriptorChecksum(body)
+	if err != nil {
+		return nil, err
+	}
+	if hasChecksum && checksum != expected {
+		return nil, fmt.Errorf("%w: expected %s, got %s", ErrDescriptorChecksum, expected, checksum)
+	}
+
+	root, err := parseDescriptorNode(body, DESCRIPTOR_TOP, params)
+	if err != nil {
+		return nil, err
+	}
+	return &Descriptor{root: root, params: params}, nil
+}
+
+// String returns the descriptor with its checksum
+func (d *Descriptor) String() string {
+	body := d.root.String()
+	checksum, _ := DescriptorChecksum(body)
+	return body + "#" + checksum
+}
+
+// IsRange checks whether the descriptor has keys ending in /*, those need an index to expand
+func (d *Descriptor) IsRange() bool {
+	return d.root.isRange()
+}
+
+// ScriptPubKey returns the locking script at the given index, the index is ignored by descriptors without ranges
+func (d *Descriptor) ScriptPubKey(index uint32) (*ScriptSig, error) {
+	scriptPubKey, _, err := d.expand(index)
+	return scriptPubKey, err
+}
+
+// RedeemScript returns the script wrapped by sh() at the given index, nil for other descriptors
+func (d *Descriptor) RedeemScript(index uint32) (*ScriptSig, error) {
+	_, scripts, err := d.expand(index)
+	if err != nil {
+		return nil, err
+	}
+	return scripts.redeemScript, nil
+}
+
+// WitnessScript returns the script wrapped by wsh() at the given index, nil for other descriptors
+func (d *Descriptor) WitnessScript(index uint32) (*ScriptSig, error) {
+	_, scripts, err := d.expand(index)
+	if err != nil {
+		return nil, err
+	}
+	return scripts.witnessScript, nil
+}
+
+// Derivations returns the origin of every key at the given index, keyed by the public key as it appears in the script
+func (d *Descriptor) Derivations(index uint32) (map[string]*Bip32Derivation, error) {
+	derivations := map[string]*Bip32Derivation{}
+	for _, key := range d.root.allKeys() {
+		pubKey, err := key.pubKeyAt(index)
+		if err != nil {
+			return nil, err
+		}
+		derivations[string(pubKey)] = key.derivation(index, pubKey)
+	}
+	return derivations, nil
+}
+
+func (d *Descriptor) expand(index uint32) (*ScriptSig, *descriptorScripts, error) {
+	if index >= ecc.HARDENED_KEY_START {
+		return nil, nil, fmt.Errorf("%w: index %d is hardened", ErrDescriptor, index)
+	}
+	scripts := &descriptorScripts{}
+	scriptPubKey, err := d.root.expand(index, scripts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return scriptPubKey, scripts, nil
+}
+
+// parseDescriptorNode parses a script expression allowed in the given context
+func parseDescriptorNode(expr string, ctx descriptorContext, params *chaincfg.Params) (*descriptorNode, error) {
+	open := strings.IndexByte(expr, '(')
+	if open <= 0 || !strings.HasSuffix(expr, ")") {
+		return nil, fmt.Errorf("%w: expected a script expression, got %q", ErrDescriptor, expr)
+	}
+	node := &descriptorNode{name: expr[:open]}
+	args := expr[open+1 : len(expr)-1]
+
+	switch node.name {
+	case "pk", "pkh", "wpkh":
+		if node.name == "pkh" && ctx == DESCRIPTOR_TR {
+			// only miniscript knows the x-only key hash of pkh() in tapscript
+			return parseMiniscriptDescriptor(expr, ctx, params)
+		}
+		if node.name == "wpkh" && ctx != DESCRIPTOR_TOP && ctx != DESCRIPTOR_SH {
+			return nil, fmt.Errorf("%w: wpkh() is only allowed at the top or inside sh()", ErrDescriptor)
+		}
+		key, err := parseDescriptorKey(args, ctx, node.name == "wpkh", params)
+		if err != nil {
+			return nil, err
+		}
+		node.keys = []*descriptorKey{key}
+	case "sh", "wsh":
+		if ctx != DESCRIPTOR_TOP && !(node.name == "wsh" && ctx == DESCRIPTOR_SH) {
+			return nil, fmt.Errorf("%w: %s() is not allowed here", ErrDescriptor, node.name)
+		}
+		subCtx := DESCRIPTOR_SH
+		if node.name == "wsh" {
+			subCtx = DESCRIPTOR_WSH
+		}
+		sub, err := parseDescriptorNode(args, subCtx, params)
+		if err != nil {
+			return nil, err
+		}
+		node.sub = sub
+	case "multi", "sortedmulti":
+		if err := node.parseMulti(args, ctx, params); err != nil {
+			return nil, err
+		}
+	case "tr":
+		if ctx != DESCRIPTOR_TOP {
+			return nil, fmt.Errorf("%w: tr() is only allowed at the top", ErrDescriptor)
+		}
+		parts := splitDescriptorArgs(args)
+		if len(parts) > 2 {
+			return nil, fmt.Errorf("%w: tr() takes a key and an optional script tree", ErrDescriptor)
+		}
+		key, err := parseDescriptorKey(parts[0], DESCRIPTOR_TR, true, params)
+		if err != nil {
+			return nil, err
+		}
+		node.keys = []*descriptorKey{key}
+		if len(parts) == 2 {
+			if node.tree, err = parseTapTree(parts[1], 0, params); err != nil {
+				return nil, err
+			}
+		}
+	case "addr", "raw":
+		if ctx != DESCRIPTOR_TOP {
+			return nil, fmt.Errorf("%w: %s() is only allowed at the top", ErrDescriptor, node.name)
+		}
+		node.arg = args
+		if node.name == "addr" {
+			script, err := AddressToScript(args, params)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrDescriptor, err)
+			}
+			node.script = script
+			break
+		}
+		raw, err := hex.DecodeString(args)
+		if err != nil {
+			return nil, fmt.Errorf("%w: raw() takes hex", ErrDescriptor)
+		}
+		if node.script, err = parseScriptBytes(raw); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrDescriptor, err)
+		}
+	default:
+		if ctx == DESCRIPTOR_WSH || ctx == DESCRIPTOR_TR {
+			return parseMiniscriptDescriptor(expr, ctx, params)
+		}
+		return nil, fmt.Errorf("%w: unknown script expression %s()", ErrDescriptor, node.name)
+	}
+
+	return node, nil
+}
+
+// parseMulti parses the threshold and keys of multi() and sortedmulti()
+func (n *descriptorNode) parseMulti(args string, ctx descriptorContext, params *chaincfg.Params) error {
+	if ctx == DESCRIPTOR_TR {
+		return fmt.Errorf("%w: %s() is not allowed in tr()", ErrDescriptor, n.name)
+	}
+
+	parts := splitDescriptorArgs(args)
+	threshold, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return fmt.Errorf("%w: invalid threshold %q", ErrDescriptor, parts[0])
+	}
+	for _, part := range parts[1:] {
+		key, err := parseDescriptorKey(part, ctx, false, params)
+		if err != nil {
+			return err
+		}
+		n.keys = append(n.keys, key)
+	}
+
+	maxKeys := MAX_PUBKEYS_PER_MULTISIG
+	switch ctx {
+	case DESCRIPTOR_TOP:
+		maxKeys = MAX_BARE_MULTISIG_KEYS
+	case DESCRIPTOR_SH:
+		maxKeys = MAX_P2SH_MULTISIG_KEYS
+	}
+	if threshold < 1 || threshold > len(n.keys) || len(n.keys) > maxKeys {
+		return fmt.Errorf("%w: invalid %d-of-%d %s()", ErrDescriptor, threshold, len(n.keys), n.name)
+	}
+	n.threshold = threshold
+	return nil
+}
+
+// parseMiniscriptDescriptor parses a miniscript inside wsh() or a tr() leaf, it must be sane like Bitcoin Core requires
+func parseMiniscriptDescriptor(expr string, ctx descriptorContext, params *chaincfg.Params) (*descriptorNode, error) {
+	msCtx := MINISCRIPT_P2WSH
+	if ctx == DESCRIPTOR_TR {
+		msCtx = MINISCRIPT_TAPSCRIPT
+	}
+
+	root, err := parseMiniscriptNode(expr, msCtx, params)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDescriptor, err)
+	}
+	if err := root.checkSane(msCtx, 0); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDescriptor, err)
+	}
+	return &descriptorNode{keys: root.allKeys(), miniscript: root}, nil
+}
+
+// parseTapTree parses a leaf script or a {TREE,TREE} branch of tr()
+func parseTapTree(expr string, depth int, params *chaincfg.Params) (*tapTree, error) {
+	if depth > MAX_TAPROOT_TREE_DEPTH {
+		return nil, fmt.Errorf("%w: script tree is too deep", ErrDescriptor)
+	}
+
+	if !strings.HasPrefix(expr, "{") {
+		leaf, err := parseDescriptorNode(expr, DESCRIPTOR_TR, params)
+		if err != nil {
+			return nil, err
+		}
+		return &tapTree{leaf: leaf}, nil
+	}
+
+	if !strings.HasSuffix(expr, "}") {
+		return nil, fmt.Errorf("%w: unbalanced script tree", ErrDescriptor)
+	}
+	parts := splitDescriptorArgs(expr[1 : len(expr)-1])
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("%w: a script tree branch has two children", ErrDescriptor)
+	}
+	left, err := parseTapTree(parts[0], depth+1, params)
+	if err != nil {
+		return nil, err
+	}
+	right, err := parseTapTree(parts[1], depth+1, params)
+	if err != nil {
+		return nil, err
+	}
+	return &tapTree{left: left, right: right}, nil
+}
+
+// splitDescriptorArgs splits arguments on the commas that are not nested in (), [] or {}
+func splitDescriptorArgs(args string) []string {
+	parts := []string{}
+	depth, start := 0, 0
+	for i, char := range args {
+		switch char {
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, args[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, args[start:])
+}
+
+// parseDescriptorKey parses a key expression, segwit and tr() keys must be compressed
+func parseDescriptorKey(text string, ctx descriptorContext, compressed bool, params *chaincfg.Params) (*descriptorKey, error) {
+	key := &descriptorKey{text: text, xOnly: ctx == DESCRIPTOR_TR}
+	compressed = compressed || ctx == DESCRIPTOR_WSH || ctx == DESCRIPTOR_TR
+
+	if strings.HasPrefix(text, "[") {
+		end := strings.IndexByte(text, ']')
+		if end < 0 {
+			return nil, fmt.Errorf("%w: unterminated key origin in %q", ErrDescriptor, key.text)
+		}
+		elements := strings.Split(text[1:end], "/")
+		fingerprint, err := hex.DecodeString(elements[0])
+		if err != nil || len(fingerprint) != ecc.KEY_FINGERPRINT_SIZE {
+			return nil, fmt.Errorf("%w: invalid fingerprint in %q", ErrDescriptor, key.text)
+		}
+		path, _, err := parseDerivationPath(elements[1:])
+		if err != nil {
+			return nil, err
+		}
+		key.origin = &Bip32Derivation{fingerprint: fingerprint, path: path}
+		text = text[end+1:]
+	}
+
+	if data, err := hex.DecodeString(text); err == nil {
+		if err := key.setPubKey(data, compressed); err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+
+	if version, payload, err := ecc.DecodeBase58Check(text); err == nil && len(payload) != ecc.EXTENDED_KEY_LENGTH-1 {
+		if version != params.PrivateKeyID {
+			return nil, fmt.Errorf("%w: %q is not a key for this network", ErrDescriptor, key.text)
+		}
+		return key, key.setWif(payload, compressed)
+	}
+
+	elements := strings.Split(text, "/")
+	extKey, err := ecc.ParseExtendedKey(elements[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid key %q", ErrDescriptor, key.text)
+	}
+	if !extKey.IsForNet(params) {
+		return nil, fmt.Errorf("%w: %q is not a key for this network", ErrDescriptor, key.text)
+	}
+	key.extKey = extKey
+
+	elements = elements[1:]
+	if len(elements) > 0 {
+		switch last := elements[len(elements)-1]; last {
+		case "*", "*'", "*h":
+			key.ranged, key.hardenedRange = true, last != "*"
+			elements = elements[:len(elements)-1]
+		}
+	}
+	path, hardened, err := parseDerivationPath(elements)
+	if err != nil {
+		return nil, err
+	}
+	if (hardened || key.hardenedRange) && !extKey.IsPrivate() {
+		return nil, fmt.Errorf("%w: hardened derivation of %q needs a private key", ErrDescriptor, key.text)
+	}
+	key.path = path
+	return key, nil
+}
+
+// setPubKey validates a hex encoded key, tr() also takes 32 byte x-only keys
+func (k *descriptorKey) setPubKey(data []byte, compressed bool) error {
+	switch {
+	case len(data) == 32 && k.xOnly:
+		if _, err := ecc.LiftX(data); err != nil {
+			return fmt.Errorf("%w: invalid x-only key %q", ErrDescriptor, k.text)
+		}
+	case len(data) == 33 && (data[0] == 0x02 || data[0] == 0x03):
+		if _, err := ecc.LiftX(data[1:]); err != nil {
+			return fmt.Errorf("%w: invalid public key %q", ErrDescriptor, k.text)
+		}
+	case len(data) == 65 && data[0] == 0x04 && !compressed:
+	default:
+		return fmt.Errorf("%w: invalid public key %q", ErrDescriptor, k.text)
+	}
+	k.pubKey = data
+	return nil
+}
+
+// setWif derives the public key of a WIF private key
+func (k *descriptorKey) setWif(payload []byte, compressed bool) error {
+	isCompressed := len(payload) == 33 && payload[32] == 0x01
+	if len(payload) != 32 && !isCompressed {
+		return fmt.Errorf("%w: invalid WIF key", ErrDescriptor)
+	}
+	if compressed && !isCompressed {
+		return fmt.Errorf("%w: uncompressed keys are not allowed here", ErrDescriptor)
+	}
+
+	secret := new(big.Int).SetBytes(payload[:32])
+	if secret.Sign() == 0 || secret.Cmp(ecc.GetBitcoinValueN()) >= 0 {
+		return fmt.Errorf("%w: invalid WIF key", ErrDescriptor)
+	}
+	_, k.pubKey = ecc.NewPrivateKey(secret).GetPublicKey().Sec(isCompressed)
+	return nil
+}
+
+// parseDerivationPath parses path elements, a trailing ' or h marks a hardened index
+func parseDerivationPath(elements []string) ([]uint32, bool, error) {
+	path := make([]uint32, 0, len(elements))
+	anyHardened := false
+	for _, element := range elements {
+		hardened := strings.HasSuffix(element, "'") || strings.HasSuffix(element, "h")
+		if hardened {
+			element = element[:len(element)-1]
+		}
+		index, err := strconv.ParseUint(element, 10, 32)
+		if err != nil || index >= ecc.HARDENED_KEY_START {
+			return nil, false, fmt.Errorf("%w: invalid derivation step %q", ErrDescriptor, element)
+		}
+		if hardened {
+			index += ecc.HARDENED_KEY_START
+			anyHardened = true
+		}
+		path = append(path, uint32(index))
+	}
+	return path, anyHardened, nil
+}
+
+// keyPath returns the derivation from the extended key to the key used at index
+func (k *descriptorKey) keyPath(index uint32) []uint32 {
+	path := slices.Clone(k.path)
+	if k.ranged {
+		if k.hardenedRange {
+			index += ecc.HARDENED_KEY_START
+		}
+		path = append(path, index)
+	}
+	return path
+}
+
+// pubKeyAt returns the public key at index as it appears in the script
+func (k *descriptorKey) pubKeyAt(index uint32) ([]byte, error) {
+	pubKey := k.pubKey
+	if k.extKey != nil {
+		child, err := k.extKey.Derive(k.keyPath(index))
+		if err != nil {
+			return nil, fmt.Errorf("%w: derive %q: %w", ErrDescriptor, k.text, err)
+		}
+		pubKey = child.PublicKey()
+	}
+
+	if k.xOnly && len(pubKey) == 33 {
+		return pubKey[1:], nil
+	}
+	return pubKey, nil
+}
+
+// derivation returns the fingerprint and full path of the key at index. Keys without an origin
+// are their own root, as Bitcoin Core does
+func (k *descriptorKey) derivation(index uint32, pubKey []byte) *Bip32Derivation {
+	fingerprint := ecc.Hash160(pubKey)[:ecc.KEY_FINGERPRINT_SIZE]
+	path := []uint32{}
+	if k.extKey != nil {
+		fingerprint = k.extKey.Fingerprint()
+		path = k.keyPath(index)
+	}
+	if k.origin != nil {
+		fingerprint = k.origin.fingerprint
+		path = append(slices.Clone(k.origin.path), path...)
+	}
+	return &Bip32Derivation{fingerprint: fingerprint, path: path}
+}
+
+// String returns the expression as it was written
+func (n *descriptorNode) String() string {
+	if n.miniscript != nil {
+		return n.miniscript.String()
+	}
+	args := []string{}
+	switch n.name {
+	case "sh", "wsh":
+		args = append(args, n.sub.String())
+	case "multi", "sortedmulti":
+		args = append(args, strconv.Itoa(n.threshold))
+	case "addr", "raw":
+		args = append(args, n.arg)
+	}
+	for _, key := range n.keys {
+		args = append(args, key.text)
+	}
+	if n.tree != nil {
+		args = append(args, n.tree.String())
+	}
+	return n.name + "(" + strings.Join(args, ",") + ")"
+}
+
+func (t *tapTree) String() string {
+	if t.leaf != nil {
+		return t.leaf.String()
+	}
+	return "{" + t.left.String() + "," + t.right.String() + "}"
+}
+
+// allKeys returns the keys of the expression and every expression below it
+func (n *descriptorNode) allKeys() []*descriptorKey {
+	keys := slices.Clone(n.keys)
+	if n.sub != nil {
+		keys = append(keys, n.sub.allKeys()...)
+	}
+	if n.tree != nil {
+		keys = append(keys, n.tree.allKeys()...)
+	}
+	return keys
+}
+
+func (t *tapTree) allKeys() []*descriptorKey {
+	if t.leaf != nil {
+		return t.leaf.allKeys()
+	}
+	return append(t.left.allKeys(), t.right.allKeys()...)
+}
+
+func (n *descriptorNode) isRange() bool {
+	for _, key := range n.allKeys() {
+		if key.ranged {
+			return true
+		}
+	}
+	return false
+}
+
+// expand builds the script of the expression at index, recording the scripts wrapped by sh() and wsh()
+func (n *descriptorNode) expand(index uint32, scripts *descriptorScripts) (*ScriptSig, error) {
+	switch n.name {
+	case "sh":
+		redeemScript, err := n.sub.expand(index, scripts)
+		if err != nil {
+			return nil, err
+		}
+		rawRedeem := redeemScript.rawSerialize()
+		if len(rawRedeem) > MAX_SCRIPT_ELEMENT_SIZE {
+			return nil, fmt.Errorf("%w: redeem script is %d bytes", ErrDescriptor, len(rawRedeem))
+		}
+		scripts.redeemScript = redeemScript
+		return P2shScript(ecc.Hash160(rawRedeem)), nil
+	case "wsh":
+		witnessScript, err := n.sub.expand(index, scripts)
+		if err != nil {
+			return nil, err
+		}
+		scripts.witnessScript = witnessScript
+		return P2wshScript(sha256Bytes(witnessScript.rawSerialize())), nil
+	case "addr", "raw":
+		return n.script, nil
+	}
+	if n.miniscript != nil {
+		cmds, err := n.miniscript.compile(index)
+		if err != nil {
+			return nil, err
+		}
+		return InitScriptSig(cmds), nil
+	}
+
+	pubKeys := make([][]byte, 0, len(n.keys))
+	for _, key := range n.keys {
+		pubKey, err := key.pubKeyAt(index)
+		if err != nil {
+			return nil, err
+		}
+		pubKeys = append(pubKeys, pubKey)
+	}
+
+	switch n.name {
+	case "pk":
+		return InitScriptSig([]ScriptCmd{DataPush(pubKeys[0]), OpCmd(OP_CHECKSIG)}), nil
+	case "pkh":
+		return P2pkhScript(ecc.Hash160(pubKeys[0])), nil
+	case "wpkh":
+		return P2wpkhScript(ecc.Hash160(pubKeys[0])), nil
+	case "sortedmulti":
+		slices.SortFunc(pubKeys, bytes.Compare)
+		fallthrough
+	case "multi":
+		return MultisigScript(n.threshold, pubKeys)
+	case "tr":
+		internalKey, err := ecc.LiftX(pubKeys[0])
+		if err != nil {
+			return nil, err
+		}
+		var merkleRoot []byte
+		if n.tree != nil {
+			if merkleRoot, err = n.tree.merkleRoot(index); err != nil {
+				return nil, err
+			}
+		}
+		outputKey, err := TaprootOutputKey(internalKey, merkleRoot)
+		if err != nil {
+			return nil, err
+		}
+		return P2trScript(outputKey.XOnly()), nil
+	}
+
+	return nil, fmt.Errorf("%w: cannot expand %s()", ErrDescriptor, n.name)
+}
+
+// merkleRoot hashes the leaf scripts of the tree at index
+func (t *tapTree) merkleRoot(index uint32) ([]byte, error) {
+	if t.leaf != nil {
+		script, err := t.leaf.expand(index, &descriptorScripts{})
+		if err != nil {
+			return nil, err
+		}
+		return TapLeafHash(TAPROOT_LEAF_TAPSCRIPT, script.rawSerialize()), nil
+	}
+
+	left, err := t.left.merkleRoot(index)
+	if err != nil {
+		return nil, err
+	}
+	right, err := t.right.merkleRoot(index)
+	if err != nil {
+		return nil, err
+	}
+	return TapBranchHash(left, right), nil
+}
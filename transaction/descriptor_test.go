@@ -0,0 +1,91 @@
+package transaction
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/sudonite/bitcoin/chaincfg"
+)
+
+func TestParseDescriptor(t *testing.T) {
+	tests := []struct {
+		name         string
+		desc         string
+		index        uint32
+		scriptPubKey string
+	}{
+		// BIP-380
+		{"raw with checksum", "raw(deadbeef)#89f8spxm", 0, "deadbeef"},
+		// BIP-381
+		{"pk", "pk(0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798)", 0, "210279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798ac"},
+		{"pkh", "pkh(02c6047f9441ed7d6d3045406e95c07cd85c778e4b8cef3ca7abac09b95c709ee5)", 0, "76a91406afd46bcdfd22ef94ac122aa11f241244a37ecc88ac"},
+		{"pkh of a derived xpub", "pkh(xpub68Gmy5EdvgibQVfPdqkBBCHxA5htiqg55crXYuXoQRKfDBFA1WEjWgP6LHhwBZeNK1VTsfTFUHCdrfp1bgwQ9xv5ski8PX9rL2dZXvgGDnw/1/2)", 0,
+			"76a914f833c08f02389c451ae35ec797fccf7f396616bf88ac"},
+		{"ranged pkh", "pkh(xpub68Gmy5EdvgibQVfPdqkBBCHxA5htiqg55crXYuXoQRKfDBFA1WEjWgP6LHhwBZeNK1VTsfTFUHCdrfp1bgwQ9xv5ski8PX9rL2dZXvgGDnw/1/*)", 2,
+			"76a914f833c08f02389c451ae35ec797fccf7f396616bf88ac"},
+		// BIP-382
+		{"wpkh", "wpkh(02f9308a019258c31049344f85f89d5229b531c845836f99b08601f113bce036f9)", 0, "00147dd65592d0ab2fe0d0257d571abf032cd9db93dc"},
+		{"sh wpkh", "sh(wpkh(03fff97bd5755eeea420453a14355235d382f6472f8568a18b2f057a1460297556))", 0, "a914cc6ffbc0bf31af759451068f90ba7a0272b6b33287"},
+		// BIP-383
+		{"bare multi", "multi(1,022f8bde4d1a07209355b4a7250a5c5128e88b84bddc619ab7cba8d569b240efe4,025cbdf0646e5db4eaa398f365f2ea7a0e3d419b7e0330e39ce92bddedcac4f9bc)", 0,
+			"5121022f8bde4d1a07209355b4a7250a5c5128e88b84bddc619ab7cba8d569b240efe421025cbdf0646e5db4eaa398f365f2ea7a0e3d419b7e0330e39ce92bddedcac4f9bc52ae"},
+		{"sh multi", "sh(multi(2,022f01e5e15cca351daff3843fb70f3c2f0a1bdd05e5af888a67784ef3e10a2a01,03acd484e2f0c7f65309ad178a9f559abde09796974c57e714c35f110dfc27ccbe))", 0,
+			"a914a6a8b030a38762f4c1f5cbe387b61a3c5da5cd2687"},
+		{"wsh multi", "wsh(multi(2,03a0434d9e47f3c86235477c7b1ae6ae5d3442d49b1943c2b752a68e2a47e247c7,03774ae7f858a9411e5ef4246b70c65aac5649980be5c17891bbec17895da008cb,03d01115d548e7561b15c38f004d734633687cf4419620095bc5b0f47070afe85a))", 0,
+			"0020773d709598b76c4e3b575c08aad40658963f9322affc0f8c28d1d9a68d0c944a"},
+		{"sh wsh multi", "sh(wsh(multi(1,03f28773c2d975288bc7d1d205c3748651b075fbc6610e58cddeeddf8f19405aa8,03499fdf9e895e719cfd64e67f07d38e3226aa7b63678949e6e49b241a60e823e4,02d7924d4f7d43ea965a465ae3095ff41131e5946f3c85f79e44adbcf8e27e080e)))", 0,
+			"a914aec509e284f909f769bb7dda299a717c87cc97ac87"},
+		// BIP-386
+		{"tr key", "tr(a34b99f22c790c4e36b2b3c2c35a36db06226e41c692fc82b8b56ac1c540c5bd)", 0, "512077aab6e066f8a7419c5ab714c12c67d25007ed55a43cadcacb4d7a970a093f11"},
+		{"tr WIF", "tr(L4rK1yDtCWekvXuE6oXD9jCYfFNV2cWRpVuPLBcCU2z8TrisoyY1)", 0, "512077aab6e066f8a7419c5ab714c12c67d25007ed55a43cadcacb4d7a970a093f11"},
+		{"tr with a leaf", "tr(a34b99f22c790c4e36b2b3c2c35a36db06226e41c692fc82b8b56ac1c540c5bd,pk(669b8afcec803a0d323e9a17f3ea8e68e8abe5a278020a929adbec52421adbd0))", 0,
+			"512017cf18db381d836d8923b1bdb246cfcd818da1a9f0e6e7907f187f0b2f937754"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			desc, err := ParseDescriptor(tt.desc, &chaincfg.MainNetParams)
+			if err != nil {
+				t.Fatal(err)
+			}
+			script, err := desc.ScriptPubKey(tt.index)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := hex.EncodeToString(script.rawSerialize()); got != tt.scriptPubKey {
+				t.Errorf("scriptPubKey %s, want %s", got, tt.scriptPubKey)
+			}
+			// the checksummed form parses back to the same descriptor
+			if again, err := ParseDescriptor(desc.String(), &chaincfg.MainNetParams); err != nil || again.String() != desc.String() {
+				t.Errorf("%s does not round trip: %v", desc, err)
+			}
+		})
+	}
+}
+
+func TestParseDescriptorInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		desc string
+		want error
+	}{
+		{"checksum mismatch", "raw(deadbeef)#89f8spxn", ErrDescriptorChecksum},
+		{"checksum of another descriptor", "raw(deedbeef)#89f8spxm", ErrDescriptorChecksum},
+		{"checksum too short", "raw(deadbeef)#89f8spx", ErrDescriptorChecksum},
+		{"sh inside sh", "sh(sh(pk(0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798)))", ErrDescriptor},
+		{"wpkh inside wsh", "wsh(wpkh(02f9308a019258c31049344f85f89d5229b531c845836f99b08601f113bce036f9))", ErrDescriptor},
+		{"uncompressed key in wpkh", "wpkh(0479be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8)", ErrDescriptor},
+		{"hardened derivation from an xpub", "pkh(xpub68Gmy5EdvgibQVfPdqkBBCHxA5htiqg55crXYuXoQRKfDBFA1WEjWgP6LHhwBZeNK1VTsfTFUHCdrfp1bgwQ9xv5ski8PX9rL2dZXvgGDnw/1h/2)", ErrDescriptor},
+		{"hardened range from an xpub", "pkh(xpub68Gmy5EdvgibQVfPdqkBBCHxA5htiqg55crXYuXoQRKfDBFA1WEjWgP6LHhwBZeNK1VTsfTFUHCdrfp1bgwQ9xv5ski8PX9rL2dZXvgGDnw/1/*h)", ErrDescriptor},
+		{"tr inside sh", "sh(tr(a34b99f22c790c4e36b2b3c2c35a36db06226e41c692fc82b8b56ac1c540c5bd))", ErrDescriptor},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseDescriptor(tt.desc, &chaincfg.MainNetParams); !errors.Is(err, tt.want) {
+				t.Errorf("error %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package transaction
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+const (
+	// nodes ask a replacement to pay for its own relay at this rate on top of what it replaces
+	DEFAULT_INCREMENTAL_RELAY_FEE FeeRate = 1000
+	// a replacement may evict at most this many transactions, descendants of the originals included
+	MAX_REPLACEMENT_EVICTIONS = 100
+)
+
+var (
+	ErrNotReplaceable      = errors.New("transaction does not signal replaceability")
+	ErrReplacementRejected = errors.New("replacement breaks the BIP-125 rules")
+)
+
+// SignalsRBF checks the sequence opts the input into BIP-125 replacement
+func (t *TransactionInput) SignalsRBF() bool {
+	return t.sequence <= SEQUENCE_RBF
+}
+
+// SignalsRBF checks whether any input opts the transaction into BIP-125 replacement
+func (t *Transaction) SignalsRBF() bool {
+	for _, txInput := range t.txInputs {
+		if txInput.SignalsRBF() {
+			return true
+		}
+	}
+	return false
+}
+
+// RequiredReplacementFee returns the smallest fee a replacement of vsize virtual bytes has to pay
+// to replace transactions paying originalFee at originalRate: at least the original fee plus its
+// own relay at the incremental rate, and a strictly higher fee rate
+func RequiredReplacementFee(originalFee Amount, originalRate FeeRate, vsize int, incrementalRelayFee FeeRate) Amount {
+	fee := originalFee + incrementalRelayFee.Fee(vsize)
+	if higherRate := Amount(int64(originalRate)*int64(vsize)/1000) + 1; higherRate > fee {
+		fee = higherRate
+	}
+	return fee
+}
+
+// CheckReplacement checks replacement may replace original under BIP-125: the original signals,
+// both spend a common output and the replacement pays a higher fee rate and enough extra fee for
+// its own relay. Both transactions need their spent outputs to work out the fees
+func CheckReplacement(original *Transaction, replacement *Transaction, incrementalRelayFee FeeRate) error {
+	return CheckReplacements([]*Transaction{original}, replacement, incrementalRelayFee)
+}
+
+// CheckReplacements checks replacement may evict every transaction in replaced, the ones it conflicts
+// with and all of their descendants. Each conflict has to signal and pay a lower fee rate, the
+// replacement has to pay the fees of everything it evicts plus its own relay, and no more than
+// MAX_REPLACEMENT_EVICTIONS transactions can go
+func CheckReplacements(replaced []*Transaction, replacement *Transaction, incrementalRelayFee FeeRate) error {
+	direct := 0
+	for _, tx := range replaced {
+		if !conflicts(tx, replacement) {
+			continue
+		}
+		if !tx.SignalsRBF() {
+			return ErrNotReplaceable
+		}
+		direct++
+	}
+	if direct == 0 {
+		return fmt.Errorf("%w: no input in common with the original", ErrReplacementRejected)
+	}
+	if len(replaced) > MAX_REPLACEMENT_EVICTIONS {
+		return fmt.Errorf("%w: evicts %d transactions, at most %d allowed", ErrReplacementRejected, len(replaced),
+			MAX_REPLACEMENT_EVICTIONS)
+	}
+
+	replacementFee, err := replacement.Fee()
+	if err != nil {
+		return err
+	}
+	replacementRate := NewFeeRate(replacementFee, replacement.VSize())
+
+	replacedFee := Amount(0)
+	for _, tx := range replaced {
+		fee, err := tx.Fee()
+		if err != nil {
+			return err
+		}
+		if replacedFee, err = replacedFee.Add(fee); err != nil {
+			return err
+		}
+		// descendants are paid for through the absolute fee, only the conflicts have their rate beaten
+		if rate := NewFeeRate(fee, tx.VSize()); conflicts(tx, replacement) && replacementRate <= rate {
+			return fmt.Errorf("%w: fee rate %s does not exceed the original %s", ErrReplacementRejected,
+				replacementRate, rate)
+		}
+	}
+
+	switch {
+	case replacementFee < replacedFee:
+		return fmt.Errorf("%w: fee %s is less than the %s it replaces", ErrReplacementRejected, replacementFee, replacedFee)
+	case replacementFee-replacedFee < incrementalRelayFee.Fee(replacement.VSize()):
+		return fmt.Errorf("%w: additional fee %s does not pay for %d vbytes at %s", ErrReplacementRejected,
+			replacementFee-replacedFee, replacement.VSize(), incrementalRelayFee)
+	}
+	return nil
+}
+
+// conflicts checks whether the two transactions spend at least one common output
+func conflicts(a *Transaction, b *Transaction) bool {
+	for _, inA := range a.txInputs {
+		for _, inB := range b.txInputs {
+			if inA.previousTransactionIndex == inB.previousTransactionIndex &&
+				bytes.Equal(inA.previousTransactionID, inB.previousTransactionID) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// BumpFee builds an unsigned replacement paying feeRate, the extra fee is taken from the output at
+// changeIdx, which is dropped when it would become dust. The fee never drops below what BIP-125
+// requires with the default incremental relay fee. The replacement spends the same inputs, so its
+// signed size is taken from the signed original
+func (t *Transaction) BumpFee(changeIdx int, feeRate FeeRate) (*Transaction, error) {
+	if !t.SignalsRBF() {
+		return nil, ErrNotReplaceable
+	}
+	if changeIdx < 0 || changeIdx >= len(t.txOutputs) {
+		return nil, fmt.Errorf("invalid index %d for transaction output", changeIdx)
+	}
+
+	prevOutputs, err := t.spentOutputs()
+	if err != nil {
+		return nil, err
+	}
+	originalFee, err := t.Fee()
+	if err != nil {
+		return nil, err
+	}
+	originalRate := NewFeeRate(originalFee, t.VSize())
+
+	replacement := t.unsignedCopy(prevOutputs)
+	change := replacement.txOutputs[changeIdx]
+	fee := func(vsize int) Amount {
+		return max(feeRate.Fee(vsize), RequiredReplacementFee(originalFee, originalRate, vsize, DEFAULT_INCREMENTAL_RELAY_FEE))
+	}
+
+	bumped := change.amount - (fee(t.VSize()) - originalFee)
+	if bumped >= dustThreshold(change.scriptPubKey, DUST_RELAY_FEE_RATE) {
+		change.amount = bumped
+		return replacement, nil
+	}
+
+	// without the change output everything it held goes to the fee, as long as another output is left
+	if len(replacement.txOutputs) == 1 {
+		return nil, fmt.Errorf("%w: dropping the change output would leave no outputs", ErrInsufficientFunds)
+	}
+	vsize := weightToVSize(t.Weight() - OutputWeight(change))
+	if originalFee+change.amount < fee(vsize) {
+		return nil, fmt.Errorf("%w: change output cannot pay a fee of %s", ErrInsufficientFunds, fee(vsize))
+	}
+	replacement.txOutputs = append(replacement.txOutputs[:changeIdx:changeIdx], replacement.txOutputs[changeIdx+1:]...)
+
+	return replacement, nil
+}
+
+// unsignedCopy copies the transaction with every scriptSig and witness emptied, the inputs keep the outputs they spend
+func (t *Transaction) unsignedCopy(prevOutputs []*TransactionOutput) *Transaction {
+	txInputs := make([]*TransactionInput, 0, len(t.txInputs))
+	for i, txInput := range t.txInputs {
+		input := InitTransactionInput(txInput.previousTransactionID, txInput.previousTransactionIndex)
+		input.SetSequence(txInput.sequence)
+		input.SetScriptSig(InitScriptSig([]ScriptCmd{}))
+		input.SetPreviousOutput(prevOutputs[i])
+		txInputs = append(txInputs, input)
+	}
+
+	txOutputs := make([]*TransactionOutput, 0, len(t.txOutputs))
+	for _, txOutput := range t.txOutputs {
+		txOutputs = append(txOutputs, InitTransactionOutput(txOutput.amount, txOutput.scriptPubKey))
+	}
+
+	return InitTransaction(t.version, txInputs, txOutputs, t.lockTime, t.params)
+}
+
+// PackageFeeRate returns the fee rate of the transactions taken together, as miners see a parent and its children
+func PackageFeeRate(txs ...*Transaction) (FeeRate, error) {
+	totalFee, vsize := Amount(0), 0
+	for _, tx := range txs {
+		fee, err := tx.Fee()
+		if err != nil {
+			return 0, err
+		}
+		if totalFee, err = totalFee.Add(fee); err != nil {
+			return 0, err
+		}
+		vsize += tx.VSize()
+	}
+	return NewFeeRate(totalFee, vsize), nil
+}
+
+// BuildCPFP builds an unsigned child spending the output at outputIdx to destination, paying enough
+// fee that parent and child together reach packageRate. The child signals replaceability so it can
+// be bumped again, the spent output has to be of a type whose input weight can be estimated
+func (t *Transaction) BuildCPFP(outputIdx int, destination *ScriptSig, packageRate FeeRate) (*Transaction, error) {
+	if outputIdx < 0 || outputIdx >= len(t.txOutputs) {
+		return nil, fmt.Errorf("invalid index %d for transaction output", outputIdx)
+	}
+	parentFee, err := t.Fee()
+	if err != nil {
+		return nil, err
+	}
+
+	spent := t.txOutputs[outputIdx]
+	input := InitTransactionInput(t.Hash(), uint32(outputIdx))
+	input.SetSequence(SEQUENCE_RBF)
+	input.SetScriptSig(InitScriptSig([]ScriptCmd{}))
+	input.SetPreviousOutput(spent)
+	output := InitTransactionOutput(0, destination)
+	child := InitTransaction(DEFAULT_TX_VERSION, []*TransactionInput{input}, []*TransactionOutput{output}, 0, t.params)
+
+	childWeight, err := child.estimateWeight(func(int) (int, bool, error) {
+		return EstimateSpendWeight(spent.scriptPubKey, nil, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	childFee := packageRate.Fee(t.VSize()+weightToVSize(childWeight)) - parentFee
+	if childFee < 0 {
+		// the parent already pays the package rate, the child only pays for itself
+		childFee = packageRate.Fee(weightToVSize(childWeight))
+	}
+	output.amount = spent.amount - childFee
+	if output.amount < dustThreshold(destination, DUST_RELAY_FEE_RATE) {
+		return nil, fmt.Errorf("%w: output %d of %s cannot pay a child fee of %s", ErrInsufficientFunds,
+			outputIdx, spent.amount, childFee)
+	}
+
+	return child, nil
+}
@@ -0,0 +1,208 @@
+package transaction
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/sudonite/bitcoin/chaincfg"
+)
+
+// feeBumpTx spends a P2WPKH output of value from the outpoint id:0 to P2WPKH outputs of the given
+// amounts, signaling RBF when rbf is set
+func feeBumpTx(id byte, value Amount, rbf bool, amounts ...Amount) *Transaction {
+	b := NewTransactionBuilder(&chaincfg.RegTestParams)
+	if rbf {
+		b.EnableRBF()
+	}
+	b.AddInput(bytes.Repeat([]byte{id}, 32), 0, value, P2wpkhScript(make([]byte, 20)))
+	for _, amount := range amounts {
+		b.AddOutput(amount, P2wpkhScript(bytes.Repeat([]byte{0x22}, 20)))
+	}
+	return b.Build()
+}
+
+func TestCheckReplacement(t *testing.T) {
+	// pays 10000 for 82 vbytes, about 122 sat/vB
+	original := feeBumpTx(1, 100000, true, 90000)
+
+	tests := []struct {
+		name        string
+		original    *Transaction
+		replacement *Transaction
+		err         error
+		contains    string
+	}{
+		{"valid", original, feeBumpTx(1, 100000, true, 89900), nil, ""},
+		{"original does not signal", feeBumpTx(1, 100000, false, 90000), feeBumpTx(1, 100000, true, 80000),
+			ErrNotReplaceable, ""},
+		{"no common input", original, feeBumpTx(2, 100000, true, 80000), ErrReplacementRejected, "no input in common"},
+		// a smaller replacement can beat the rate of a larger original with less fee
+		{"lower absolute fee", feeBumpTx(1, 100000, true, 45000, 45000), feeBumpTx(1, 100000, true, 90500),
+			ErrReplacementRejected, "less than"},
+		// a larger replacement can pay more fee at a lower rate
+		{"fee rate not higher", original, feeBumpTx(1, 100000, true, 45000, 44900), ErrReplacementRejected,
+			"does not exceed"},
+		// 50 more satoshis beat the rate but do not pay 82 vbytes at 1 sat/vB
+		{"incremental relay fee", original, feeBumpTx(1, 100000, true, 89950), ErrReplacementRejected,
+			"does not pay for"},
+		{"exact incremental relay fee", original, feeBumpTx(1, 100000, true, 89918), nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckReplacement(tt.original, tt.replacement, DEFAULT_INCREMENTAL_RELAY_FEE)
+			if tt.err == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.err) || !strings.Contains(err.Error(), tt.contains) {
+				t.Fatalf("expected %v containing %q, got %v", tt.err, tt.contains, err)
+			}
+		})
+	}
+}
+
+func TestCheckReplacements(t *testing.T) {
+	original := feeBumpTx(1, 100000, true, 90000)
+	// descendants do not conflict with the replacement and need not signal themselves
+	descendants := func(n int) []*Transaction {
+		txs := []*Transaction{original}
+		for i := range n {
+			txs = append(txs, feeBumpTx(byte(i+2), 1000, false, 900))
+		}
+		return txs
+	}
+
+	// the fees of the descendants are paid too, 10000 + 99*100 plus 82 vbytes of relay
+	if err := CheckReplacements(descendants(MAX_REPLACEMENT_EVICTIONS-1), feeBumpTx(1, 100000, true, 80018),
+		DEFAULT_INCREMENTAL_RELAY_FEE); err != nil {
+		t.Fatal(err)
+	}
+
+	err := CheckReplacements(descendants(MAX_REPLACEMENT_EVICTIONS-1), feeBumpTx(1, 100000, true, 80019),
+		DEFAULT_INCREMENTAL_RELAY_FEE)
+	if !errors.Is(err, ErrReplacementRejected) || !strings.Contains(err.Error(), "does not pay for") {
+		t.Fatalf("expected the descendant fees to be required, got %v", err)
+	}
+
+	err = CheckReplacements(descendants(MAX_REPLACEMENT_EVICTIONS), feeBumpTx(1, 100000, true, 10000),
+		DEFAULT_INCREMENTAL_RELAY_FEE)
+	if !errors.Is(err, ErrReplacementRejected) || !strings.Contains(err.Error(), "evicts") {
+		t.Fatalf("expected more than %d evictions to be rejected, got %v", MAX_REPLACEMENT_EVICTIONS, err)
+	}
+}
+
+func TestRequiredReplacementFee(t *testing.T) {
+	tests := []struct {
+		name         string
+		originalFee  Amount
+		originalRate FeeRate
+		vsize        int
+		want         Amount
+	}{
+		// the incremental relay fee is what binds for a replacement of the same size
+		{"same size", 10000, 121951, 82, 10082},
+		// a much larger replacement has to beat the rate of the original
+		{"larger", 1000, 10000, 1000, 10001},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RequiredReplacementFee(tt.originalFee, tt.originalRate, tt.vsize, DEFAULT_INCREMENTAL_RELAY_FEE); got != tt.want {
+				t.Fatalf("fee %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBumpFee(t *testing.T) {
+	original := feeBumpTx(1, 100000, true, 50000, 40000)
+
+	replacement, err := original.BumpFee(1, 200000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckReplacement(original, replacement, DEFAULT_INCREMENTAL_RELAY_FEE); err != nil {
+		t.Fatalf("bumped transaction is not a valid replacement: %v", err)
+	}
+	if rate, err := replacement.FeeRate(); err != nil || rate < 200000 {
+		t.Fatalf("fee rate %d, want at least 200000 (%v)", rate, err)
+	}
+	if replacement.txOutputs[0].amount != 50000 {
+		t.Fatalf("payment changed to %d", replacement.txOutputs[0].amount)
+	}
+
+	// change that would become dust goes to the fee entirely
+	replacement, err = feeBumpTx(1, 52000, true, 50000, 1000).BumpFee(1, 20000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replacement.txOutputs) != 1 || replacement.txOutputs[0].amount != 50000 {
+		t.Fatalf("expected only the payment to be left, got %d outputs", len(replacement.txOutputs))
+	}
+
+	if _, err := feeBumpTx(1, 100000, false, 90000).BumpFee(0, 200000); !errors.Is(err, ErrNotReplaceable) {
+		t.Fatalf("expected %v, got %v", ErrNotReplaceable, err)
+	}
+	if _, err := feeBumpTx(1, 100000, true, 90000).BumpFee(0, 2000000); !errors.Is(err, ErrInsufficientFunds) {
+		t.Fatalf("expected %v, got %v", ErrInsufficientFunds, err)
+	}
+}
+
+func TestBuildCPFP(t *testing.T) {
+	// pays 1000 for 82 vbytes, about 12 sat/vB
+	parent := feeBumpTx(1, 100000, false, 99000)
+	destination := P2wpkhScript(bytes.Repeat([]byte{0x33}, 20))
+
+	tests := []struct {
+		name        string
+		packageRate FeeRate
+	}{
+		{"parent below the package rate", 50000},
+		{"parent above the package rate", 5000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			child, err := parent.BuildCPFP(0, destination, tt.packageRate)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			input := child.txInputs[0]
+			if !bytes.Equal(input.previousTransactionID, parent.Hash()) || input.previousTransactionIndex != 0 {
+				t.Fatal("child does not spend the chosen parent output")
+			}
+			if !input.SignalsRBF() {
+				t.Fatal("child does not signal replaceability")
+			}
+
+			// the unsigned child is smaller than its estimate, so the package beats the rate
+			rate, err := PackageFeeRate(parent, child)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rate < tt.packageRate {
+				t.Fatalf("package rate %d below %d", rate, tt.packageRate)
+			}
+			childRate, err := child.FeeRate()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if childRate < tt.packageRate {
+				t.Fatalf("child rate %d below %d", childRate, tt.packageRate)
+			}
+		})
+	}
+
+	if _, err := parent.BuildCPFP(0, destination, 1000000000); !errors.Is(err, ErrInsufficientFunds) {
+		t.Fatalf("expected %v, got %v", ErrInsufficientFunds, err)
+	}
+	if _, err := parent.BuildCPFP(1, destination, 50000); err == nil {
+		t.Fatal("expected an invalid output index to fail")
+	}
+}
@@ -1,57 +1,37 @@
 package transaction
 
 import (
-	"bufio"
-	"bytes"
+	"encoding/binary"
 	"fmt"
 	"io"
-	"math/big"
+
+	"github.com/sudonite/bitcoin/chaincfg"
 )
 
 // Represents a transaction input
 type TransactionInput struct {
 	previousTransactionID    []byte
-	previousTransactionIndex *big.Int
+	previousTransactionIndex uint32
 	scriptSig                *ScriptSig
-	sequence                 *big.Int
+	sequence                 uint32
 	fetcher                  *TransactionFetcher
 	witness                  [][]byte
+	// prevOutput is the spent output when known up front, it saves fetching the previous transaction
+	prevOutput *TransactionOutput
 }
 
 // Parses a transaction input from a binary reader
-func NewTransactionInput(reader *bufio.Reader) *TransactionInput {
-	// first 32 bytes are hash256 of previous transation
-	transactionInput := &TransactionInput{}
-	transactionInput.fetcher = NewTransactionFetcher()
-
-	previousTransaction := make([]byte, 32)
-	io.ReadFull(reader, previousTransaction)
-	// convert it from little endian to big endian
-	// reverse the byte array [0x01, 0x02, 0x03, 0x04] -> [0x04, 0x03, 0x02, 0x01]
-	transactionInput.previousTransactionID = ReverseByteSlice(previousTransaction)
-
-	// 4 bytes for previous transaction index
-	idx := make([]byte, 4)
-	io.ReadFull(reader, idx)
-	transactionInput.previousTransactionIndex = LittleEndianToBigInt(idx, LITTLE_ENDIAN_4_BYTES)
-
-	transactionInput.scriptSig = NewScriptSig(reader)
-
-	// last four bytes for sequence
-	seqBytes := make([]byte, 4)
-	io.ReadFull(reader, seqBytes)
-	transactionInput.sequence = LittleEndianToBigInt(seqBytes, LITTLE_ENDIAN_4_BYTES)
-
-	return transactionInput
+func NewTransactionInput(reader io.Reader) (*TransactionInput, error) {
+	return newTxDecoder(reader).input()
 }
 
 // InitTransactionInput creates a new transaction input referencing a previous output
-func InitTransactionInput(previousTx []byte, previousIndex *big.Int) *TransactionInput {
+func InitTransactionInput(previousTx []byte, previousIndex uint32) *TransactionInput {
 	return &TransactionInput{
 		previousTransactionID:    previousTx,
 		previousTransactionIndex: previousIndex,
 		scriptSig:                nil,
-		sequence:                 big.NewInt(0xffffffff),
+		sequence:                 SEQUENCE_FINAL,
 	}
 }
 
@@ -60,86 +40,179 @@ func (t *TransactionInput) String() string {
 	return fmt.Sprintf("previous transaction: %x\n previous tx index: %x\n", t.previousTransactionID, t.previousTransactionIndex)
 }
 
+// PreviousIndex returns the index of the spent output in the previous transaction
+func (t *TransactionInput) PreviousIndex() uint32 {
+	return t.previousTransactionIndex
+}
+
+// SetPreviousIndex sets the index of the spent output in the previous transaction
+func (t *TransactionInput) SetPreviousIndex(index uint32) {
+	t.previousTransactionIndex = index
+}
+
 // SetScriptSig sets the scriptSig for this transaction input
 func (t *TransactionInput) SetScriptSig(sig *ScriptSig) {
 	t.scriptSig = sig
 }
 
+// SetFetcher overrides the fetcher used to look up the previous transaction
+func (t *TransactionInput) SetFetcher(fetcher *TransactionFetcher) {
+	t.fetcher = fetcher
+}
+
+// SetPreviousOutput records the output spent by this input so it does not need to be fetched
+func (t *TransactionInput) SetPreviousOutput(output *TransactionOutput) {
+	t.prevOutput = output
+}
+
+// SetWitness sets the witness stack of this input
+func (t *TransactionInput) SetWitness(witness [][]byte) {
+	t.witness = witness
+}
+
+// Witness returns the witness stack of this input
+func (t *TransactionInput) Witness() [][]byte {
+	return t.witness
+}
+
 // Returns the value (amount in satoshis) of the referenced UTXO
-func (t *TransactionInput) Value(testnet bool) *big.Int {
-	tx := t.getPreviousTx(testnet)
-	return tx.txOutputs[t.previousTransactionIndex.Int64()].amount
+func (t *TransactionInput) Value(params *chaincfg.Params) (Amount, error) {
+	output, err := t.previousOutput(params)
+	if err != nil {
+		return 0, err
+	}
+	return output.amount, nil
 }
 
 // Script returns the combined script (scriptSig + scriptPubKey) for this input.
-func (t *TransactionInput) Script(testnet bool) *ScriptSig {
-	previousTxID := fmt.Sprintf("%x", t.previousTransactionID)
-	previousTX := t.fetcher.Fetch(previousTxID, testnet)
-	tx := ParseTransaction(previousTX)
-
-	scriptPubKey := tx.txOutputs[t.previousTransactionIndex.Int64()].scriptPubKey
-	return t.scriptSig.Add(scriptPubKey)
+func (t *TransactionInput) Script(params *chaincfg.Params) (*ScriptSig, error) {
+	scriptPubKey, err := t.scriptPubKey(params)
+	if err != nil {
+		return nil, err
+	}
+	return t.scriptSig.Add(scriptPubKey), nil
 }
 
 // Serialize converts the transaction input into its binary format.
 func (t *TransactionInput) Serialize() []byte {
+	return t.serializeWithScript(t.scriptSig)
+}
+
+// serializeWithScript serializes the input with the given script in place of its scriptSig
+func (t *TransactionInput) serializeWithScript(script *ScriptSig) []byte {
 	result := make([]byte, 0)
 	result = append(result, ReverseByteSlice(t.previousTransactionID)...)
-	result = append(result,
-		BigIntToLittleEndian(t.previousTransactionIndex,
-			LITTLE_ENDIAN_4_BYTES)...)
-	result = append(result, t.scriptSig.Serialize()...)
-	result = append(result,
-		BigIntToLittleEndian(t.sequence, LITTLE_ENDIAN_4_BYTES)...)
+	result = binary.LittleEndian.AppendUint32(result, t.previousTransactionIndex)
+	result = append(result, script.Serialize()...)
+	result = binary.LittleEndian.AppendUint32(result, t.sequence)
 	return result
 }
 
 // ReplaceWithScriptPubKey replaces the current scriptSig with the referenced output's scriptPubKey
-func (t *TransactionInput) ReplaceWithScriptPubKey(testnet bool) {
-	script := t.scriptPubKey(testnet)
-	isP2sh := t.isP2sh(script)
-
-	if !isP2sh {
+func (t *TransactionInput) ReplaceWithScriptPubKey(params *chaincfg.Params) error {
+	script, err := t.scriptPubKey(params)
+	if err != nil {
+		return err
+	}
+	if !script.bitcoinOpCode.isP2sh() {
 		t.scriptSig = script
-	} else {
-		redeemScriptBinary := t.scriptSig.bitcoinOpCode.cmds[len(t.scriptSig.bitcoinOpCode.cmds)-1]
-		redeemScriptReader := bytes.NewReader(redeemScriptBinary)
-		redeemScript := NewScriptSig(bufio.NewReader(redeemScriptReader))
-		t.scriptSig = redeemScript
+		return nil
+	}
+
+	redeemScript, err := parseScriptBytes(t.redeemScript())
+	if err != nil {
+		return err
+	}
+	t.scriptSig = redeemScript
+	return nil
+}
+
+// legacyScriptCode returns the script a legacy signature commits to: the redeem script for P2SH, the scriptPubKey otherwise
+func (t *TransactionInput) legacyScriptCode(params *chaincfg.Params) (*ScriptSig, error) {
+	script, err := t.scriptPubKey(params)
+	if err != nil {
+		return nil, err
+	}
+
+	if script.bitcoinOpCode.isP2sh() {
+		redeemScript := t.redeemScript()
+		if redeemScript == nil {
+			return nil, fmt.Errorf("P2SH input %x:%d has no redeem script", t.previousTransactionID, t.previousTransactionIndex)
+		}
+		return parseScriptBytes(redeemScript)
 	}
+
+	return script, nil
 }
 
-// Checks whether the given ScriptPubKey matches the standard P2SH pattern
-func (t *TransactionInput) isP2sh(script *ScriptSig) bool {
-	isP2sh := true
-	if len(script.bitcoinOpCode.cmds[0]) != 1 || script.bitcoinOpCode.cmds[0][0] != OP_HASH160 {
-		// the first element should be OP_HASH160
-		isP2sh = false
+// witnessScriptSigValid checks the scriptSig of an input spending a witness program, it must be empty
+// for native programs and nothing but the push of the redeem script for P2SH-wrapped ones
+func (t *TransactionInput) witnessScriptSigValid(params *chaincfg.Params) bool {
+	script, err := t.scriptPubKey(params)
+	if err != nil {
+		return false
 	}
 
-	if len(script.bitcoinOpCode.cmds[1]) == 1 {
-		// the second element should be hash data chunk
-		isP2sh = false
+	cmds := []ScriptCmd{}
+	if t.scriptSig != nil {
+		if t.scriptSig.unparsed != nil {
+			return false
+		}
+		cmds = t.scriptSig.bitcoinOpCode.cmds
+	}
+	if script.bitcoinOpCode.isP2sh() {
+		return len(cmds) == 1 && !cmds[0].IsOpCode()
 	}
+	return len(cmds) == 0
+}
 
-	if len(script.bitcoinOpCode.cmds[2]) != 1 || script.bitcoinOpCode.cmds[2][0] != OP_EQUAL {
-		// the third element should be OP_EQUAL
-		isP2sh = false
+// redeemScript returns the last push of the scriptSig, which is the redeem script of a P2SH spend
+func (t *TransactionInput) redeemScript() []byte {
+	if t.scriptSig == nil || len(t.scriptSig.bitcoinOpCode.cmds) == 0 {
+		return nil
 	}
 
-	return isP2sh
+	return t.scriptSig.bitcoinOpCode.cmds[len(t.scriptSig.bitcoinOpCode.cmds)-1].data
 }
 
 // scriptPubKey retrieves the locking script (scriptPubKey) from the referenced previous transaction output
-func (t *TransactionInput) scriptPubKey(testnet bool) *ScriptSig {
-	tx := t.getPreviousTx(testnet)
-	return tx.txOutputs[t.previousTransactionIndex.Int64()].scriptPubKey
+func (t *TransactionInput) scriptPubKey(params *chaincfg.Params) (*ScriptSig, error) {
+	output, err := t.previousOutput(params)
+	if err != nil {
+		return nil, err
+	}
+	return output.scriptPubKey, nil
+}
+
+// previousOutput returns the output of the previous transaction spent by this input
+func (t *TransactionInput) previousOutput(params *chaincfg.Params) (*TransactionOutput, error) {
+	if t.prevOutput != nil {
+		return t.prevOutput, nil
+	}
+
+	tx, err := t.getPreviousTx(params)
+	if err != nil {
+		return nil, err
+	}
+
+	idx := int(t.previousTransactionIndex)
+	if idx >= len(tx.txOutputs) {
+		return nil, fmt.Errorf("previous output %x:%d does not exist", t.previousTransactionID, idx)
+	}
+	return tx.txOutputs[idx], nil
 }
 
 // getPreviousTx fetches and parses the previous transaction referenced by this input
-func (t *TransactionInput) getPreviousTx(testnet bool) *Transaction {
+func (t *TransactionInput) getPreviousTx(params *chaincfg.Params) (*Transaction, error) {
+	fetcher := t.fetcher
+	if fetcher == nil {
+		fetcher = DefaultTransactionFetcher()
+	}
+
 	previousTxID := fmt.Sprintf("%x", t.previousTransactionID)
-	previousTX := t.fetcher.Fetch(previousTxID, testnet)
-	tx := ParseTransaction(previousTX)
-	return tx
+	previousTX, err := fetcher.Fetch(previousTxID, params)
+	if err != nil {
+		return nil, err
+	}
+	return ParseTransaction(previousTX)
 }
@@ -0,0 +1,292 @@
+package transaction
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/sudonite/bitcoin/chaincfg"
+)
+
+var ErrJSONMismatch = errors.New("json does not match the data it describes")
+
+// scriptJSON is a script as Core shows a scriptSig
+type scriptJSON struct {
+	Asm string `json:"asm"`
+	Hex string `json:"hex"`
+}
+
+// scriptPubKeyJSON is a locking script with its template and address
+type scriptPubKeyJSON struct {
+	Asm     string `json:"asm"`
+	Hex     string `json:"hex"`
+	Address string `json:"address,omitempty"`
+	Type    string `json:"type"`
+}
+
+// txInJSON is an entry of vin, coinbase inputs show their script as hex only
+type txInJSON struct {
+	Coinbase    string      `json:"coinbase,omitempty"`
+	TxID        string      `json:"txid,omitempty"`
+	Vout        *uint32     `json:"vout,omitempty"`
+	ScriptSig   *scriptJSON `json:"scriptSig,omitempty"`
+	TxInWitness []string    `json:"txinwitness,omitempty"`
+	Sequence    uint32      `json:"sequence"`
+}
+
+// txOutJSON is an entry of vout
+type txOutJSON struct {
+	Value        Amount           `json:"value"`
+	N            int              `json:"n"`
+	ScriptPubKey scriptPubKeyJSON `json:"scriptPubKey"`
+}
+
+// txJSON mirrors the output of decoderawtransaction
+type txJSON struct {
+	TxID     string      `json:"txid"`
+	Hash     string      `json:"hash"`
+	Version  int32       `json:"version"`
+	Size     int         `json:"size"`
+	VSize    int         `json:"vsize"`
+	Weight   int         `json:"weight"`
+	LockTime uint32      `json:"locktime"`
+	Vin      []txInJSON  `json:"vin"`
+	Vout     []txOutJSON `json:"vout"`
+}
+
+// MarshalJSON encodes the transaction the way bitcoind's decoderawtransaction does
+func (t *Transaction) MarshalJSON() ([]byte, error) {
+	result := txJSON{
+		TxID:     fmt.Sprintf("%x", t.Hash()),
+		Hash:     fmt.Sprintf("%x", t.WitnessHash()),
+		Version:  t.version,
+		Size:     t.TotalSize(),
+		VSize:    t.VSize(),
+		Weight:   t.Weight(),
+		LockTime: t.lockTime,
+		Vin:      make([]txInJSON, 0, len(t.txInputs)),
+		Vout:     make([]txOutJSON, 0, len(t.txOutputs)),
+	}
+
+	coinbase := t.IsCoinBase()
+	for _, txInput := range t.txInputs {
+		in := txInJSON{Sequence: txInput.sequence}
+		if coinbase {
+			in.Coinbase = hex.EncodeToString(txInput.scriptSig.rawSerialize())
+		} else {
+			index := txInput.previousTransactionIndex
+			in.TxID = hex.EncodeToString(txInput.previousTransactionID)
+			in.Vout = &index
+			in.ScriptSig = &scriptJSON{
+				Asm: txInput.scriptSig.AsmWithSighash(),
+				Hex: hex.EncodeToString(txInput.scriptSig.rawSerialize()),
+			}
+		}
+		for _, item := range txInput.witness {
+			in.TxInWitness = append(in.TxInWitness, hex.EncodeToString(item))
+		}
+		result.Vin = append(result.Vin, in)
+	}
+
+	for i, txOutput := range t.txOutputs {
+		out := txOutJSON{
+			Value: txOutput.amount,
+			N:     i,
+			ScriptPubKey: scriptPubKeyJSON{
+				Asm:  txOutput.scriptPubKey.Asm(),
+				Hex:  hex.EncodeToString(txOutput.scriptPubKey.rawSerialize()),
+				Type: txOutput.scriptPubKey.Classify().Class().String(),
+			},
+		}
+		if address, err := ScriptToAddress(txOutput.scriptPubKey, t.Params()); err == nil {
+			out.ScriptPubKey.Address = address
+		}
+		result.Vout = append(result.Vout, out)
+	}
+
+	return json.Marshal(result)
+}
+
+// UnmarshalJSON rebuilds the transaction from decoderawtransaction output. Scripts are taken from hex,
+// the txid and hash are checked when present and the network follows the output addresses, where
+// test networks sharing a prefix are taken as testnet3
+func (t *Transaction) UnmarshalJSON(data []byte) error {
+	var decoded txJSON
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+
+	tx := &Transaction{version: decoded.Version, lockTime: decoded.LockTime}
+	for i, in := range decoded.Vin {
+		txInput := &TransactionInput{sequence: in.Sequence}
+		scriptHex := in.Coinbase
+		if in.Coinbase != "" {
+			txInput.previousTransactionID = make([]byte, 32)
+			txInput.previousTransactionIndex = 0xffffffff
+		} else {
+			if in.Vout == nil || in.ScriptSig == nil {
+				return fmt.Errorf("vin %d: missing vout or scriptSig", i)
+			}
+			prevTx, err := hex.DecodeString(in.TxID)
+			if err != nil || len(prevTx) != 32 {
+				return fmt.Errorf("vin %d: invalid txid %q", i, in.TxID)
+			}
+			txInput.previousTransactionID = prevTx
+			txInput.previousTransactionIndex = *in.Vout
+			scriptHex = in.ScriptSig.Hex
+		}
+
+		script, err := parseScriptHex(scriptHex)
+		if err != nil {
+			return fmt.Errorf("vin %d: %w", i, err)
+		}
+		txInput.scriptSig = script
+
+		for _, itemHex := range in.TxInWitness {
+			item, err := hex.DecodeString(itemHex)
+			if err != nil {
+				return fmt.Errorf("vin %d: invalid witness item: %w", i, err)
+			}
+			txInput.witness = append(txInput.witness, item)
+		}
+		if len(txInput.witness) > 0 {
+			tx.segwit = true
+		}
+		tx.txInputs = append(tx.txInputs, txInput)
+	}
+
+	for i, out := range decoded.Vout {
+		script, err := parseScriptHex(out.ScriptPubKey.Hex)
+		if err != nil {
+			return fmt.Errorf("vout %d: %w", i, err)
+		}
+		if out.ScriptPubKey.Address != "" {
+			if params := addressParams(out.ScriptPubKey.Address, script); params != nil {
+				tx.params = params
+			}
+		}
+		tx.txOutputs = append(tx.txOutputs, InitTransactionOutput(out.Value, script))
+	}
+
+	if decoded.TxID != "" && decoded.TxID != fmt.Sprintf("%x", tx.Hash()) {
+		return fmt.Errorf("%w: txid %s", ErrJSONMismatch, decoded.TxID)
+	}
+	if decoded.Hash != "" && decoded.Hash != fmt.Sprintf("%x", tx.WitnessHash()) {
+		return fmt.Errorf("%w: hash %s", ErrJSONMismatch, decoded.Hash)
+	}
+
+	*t = *tx
+	return nil
+}
+
+// addressParams returns the first network on which the address pays to script, nil when there is none
+func addressParams(address string, script *ScriptSig) *chaincfg.Params {
+	for _, params := range []*chaincfg.Params{
+		&chaincfg.MainNetParams, &chaincfg.TestNet3Params, &chaincfg.RegTestParams,
+	} {
+		if addressScript, err := AddressToScript(address, params); err == nil &&
+			bytes.Equal(addressScript.rawSerialize(), script.rawSerialize()) {
+			return params
+		}
+	}
+	return nil
+}
+
+// parseScriptHex parses a script given as hex
+func parseScriptHex(scriptHex string) (*ScriptSig, error) {
+	raw, err := hex.DecodeString(scriptHex)
+	if err != nil {
+		return nil, fmt.Errorf("invalid script hex: %w", err)
+	}
+	return parseScriptBytes(raw)
+}
+
+// blockHeaderJSON mirrors the chain independent fields of getblockheader
+type blockHeaderJSON struct {
+	Hash              string  `json:"hash"`
+	Version           int32   `json:"version"`
+	VersionHex        string  `json:"versionHex"`
+	MerkleRoot        string  `json:"merkleroot"`
+	Time              uint32  `json:"time"`
+	Nonce             uint32  `json:"nonce"`
+	Bits              string  `json:"bits"`
+	Difficulty        float64 `json:"difficulty"`
+	PreviousBlockHash string  `json:"previousblockhash,omitempty"`
+}
+
+// MarshalJSON encodes the header like getblockheader, fields that need the chain such as height are left out
+func (b *Block) MarshalJSON() ([]byte, error) {
+	version := binary.BigEndian.Uint32(b.version)
+	result := blockHeaderJSON{
+		Hash:       fmt.Sprintf("%x", b.Hash()),
+		Version:    int32(version),
+		VersionHex: fmt.Sprintf("%08x", version),
+		MerkleRoot: fmt.Sprintf("%x", b.merkleRoot),
+		Time:       binary.BigEndian.Uint32(b.timeStamp),
+		Nonce:      binary.LittleEndian.Uint32(b.nonce),
+		Bits:       fmt.Sprintf("%08x", binary.LittleEndian.Uint32(b.bits)),
+		Difficulty: b.difficultyFloat(),
+	}
+	// the genesis block has no parent
+	if !bytes.Equal(b.previousBlockID, make([]byte, 32)) {
+		result.PreviousBlockHash = fmt.Sprintf("%x", b.previousBlockID)
+	}
+
+	return json.Marshal(result)
+}
+
+// UnmarshalJSON rebuilds the header from getblockheader output and checks it hashes to the given hash
+func (b *Block) UnmarshalJSON(data []byte) error {
+	var decoded blockHeaderJSON
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+
+	merkleRoot, err := hex.DecodeString(decoded.MerkleRoot)
+	if err != nil || len(merkleRoot) != 32 {
+		return fmt.Errorf("invalid merkleroot %q", decoded.MerkleRoot)
+	}
+	previousBlockID := make([]byte, 32)
+	if decoded.PreviousBlockHash != "" {
+		previousBlockID, err = hex.DecodeString(decoded.PreviousBlockHash)
+		if err != nil || len(previousBlockID) != 32 {
+			return fmt.Errorf("invalid previousblockhash %q", decoded.PreviousBlockHash)
+		}
+	}
+	bits, err := hex.DecodeString(decoded.Bits)
+	if err != nil || len(bits) != 4 {
+		return fmt.Errorf("invalid bits %q", decoded.Bits)
+	}
+
+	block := &Block{
+		version:         binary.BigEndian.AppendUint32(nil, uint32(decoded.Version)),
+		previousBlockID: previousBlockID,
+		merkleRoot:      merkleRoot,
+		timeStamp:       binary.BigEndian.AppendUint32(nil, decoded.Time),
+		bits:            ReverseByteSlice(bits),
+		nonce:           binary.LittleEndian.AppendUint32(nil, decoded.Nonce),
+	}
+	if decoded.Hash != "" && decoded.Hash != fmt.Sprintf("%x", block.Hash()) {
+		return fmt.Errorf("%w: block hash %s", ErrJSONMismatch, decoded.Hash)
+	}
+
+	*b = *block
+	return nil
+}
+
+// difficultyFloat computes the difficulty as a double from the compact bits, the same way Core does
+func (b *Block) difficultyFloat() float64 {
+	bits := binary.LittleEndian.Uint32(b.bits)
+	shift := int(bits >> 24 & 0xff)
+	difficulty := float64(0x0000ffff) / float64(bits&0x00ffffff)
+	for ; shift < 29; shift++ {
+		difficulty *= 256.0
+	}
+	for ; shift > 29; shift-- {
+		difficulty /= 256.0
+	}
+	return difficulty
+}
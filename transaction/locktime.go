@@ -0,0 +1,189 @@
+package transaction
+
+import (
+	"errors"
+)
+
+const (
+	// lock times below this value are block heights, above it are unix timestamps
+	LOCKTIME_THRESHOLD = 500000000
+
+	SEQUENCE_FINAL                 = 0xffffffff
+	SEQUENCE_LOCKTIME_DISABLE_FLAG = 1 << 31
+	SEQUENCE_LOCKTIME_TYPE_FLAG    = 1 << 22
+	SEQUENCE_LOCKTIME_MASK         = 0x0000ffff
+	// time based relative lock times are counted in units of 512 seconds
+	SEQUENCE_LOCKTIME_GRANULARITY = 9
+
+	// CLTV and CSV operands may be up to 5 bytes so they can express any uint32
+	LOCKTIME_MAX_NUM_SIZE = 5
+)
+
+var ErrRelativeLockTimeRange = errors.New("relative lock time out of range")
+
+// TxContext gives script evaluation access to the transaction and input being verified
+type TxContext struct {
+	tx       *Transaction
+	inputIdx int
+}
+
+// NewTxContext creates the evaluation context of the input at inputIdx
+func NewTxContext(tx *Transaction, inputIdx int) *TxContext {
+	return &TxContext{
+		tx:       tx,
+		inputIdx: inputIdx,
+	}
+}
+
+// lockTime returns the nLockTime of the spending transaction
+func (c *TxContext) lockTime() uint32 {
+	return c.tx.lockTime
+}
+
+// sequence returns the nSequence of the input being verified
+func (c *TxContext) sequence() uint32 {
+	return c.tx.txInputs[c.inputIdx].Sequence()
+}
+
+// version returns the version of the spending transaction
+func (c *TxContext) version() uint32 {
+	return uint32(c.tx.version)
+}
+
+// Sequence returns the nSequence field of the input
+func (t *TransactionInput) Sequence() uint32 {
+	return t.sequence
+}
+
+// SetSequence sets the nSequence field of the input
+func (t *TransactionInput) SetSequence(sequence uint32) {
+	t.sequence = sequence
+}
+
+// HasRelativeLockTime reports whether BIP-68 applies to the sequence, the transaction version must also be at least 2
+func (t *TransactionInput) HasRelativeLockTime() bool {
+	return t.Sequence()&SEQUENCE_LOCKTIME_DISABLE_FLAG == 0
+}
+
+// RelativeLockTimeBlocks returns the BIP-68 lock in blocks, ok is false if the lock is disabled or time based
+func (t *TransactionInput) RelativeLockTimeBlocks() (uint32, bool) {
+	sequence := t.Sequence()
+	if !t.HasRelativeLockTime() || sequence&SEQUENCE_LOCKTIME_TYPE_FLAG != 0 {
+		return 0, false
+	}
+	return sequence & SEQUENCE_LOCKTIME_MASK, true
+}
+
+// RelativeLockTimeSeconds returns the BIP-68 lock in seconds, ok is false if the lock is disabled or height based
+func (t *TransactionInput) RelativeLockTimeSeconds() (uint32, bool) {
+	sequence := t.Sequence()
+	if !t.HasRelativeLockTime() || sequence&SEQUENCE_LOCKTIME_TYPE_FLAG == 0 {
+		return 0, false
+	}
+	return (sequence & SEQUENCE_LOCKTIME_MASK) << SEQUENCE_LOCKTIME_GRANULARITY, true
+}
+
+// SetRelativeLockTimeBlocks makes the input spendable the given number of blocks after its previous output confirmed
+func (t *TransactionInput) SetRelativeLockTimeBlocks(blocks uint16) {
+	t.SetSequence(uint32(blocks))
+}
+
+// SetRelativeLockTimeSeconds makes the input spendable after the given number of seconds, rounded up to 512 seconds
+func (t *TransactionInput) SetRelativeLockTimeSeconds(seconds uint32) error {
+	units := (uint64(seconds) + (1 << SEQUENCE_LOCKTIME_GRANULARITY) - 1) >> SEQUENCE_LOCKTIME_GRANULARITY
+	if units > SEQUENCE_LOCKTIME_MASK {
+		return ErrRelativeLockTimeRange
+	}
+	t.SetSequence(SEQUENCE_LOCKTIME_TYPE_FLAG | uint32(units))
+	return nil
+}
+
+// IsRelativeLockTimeSatisfied checks the BIP-68 lock of the input given how many blocks and seconds
+// passed since its previous output confirmed, the seconds are measured with median time past
+func (t *TransactionInput) IsRelativeLockTimeSatisfied(txVersion uint32, blocks uint32, seconds uint32) bool {
+	if txVersion < 2 || !t.HasRelativeLockTime() {
+		return true
+	}
+	if lock, ok := t.RelativeLockTimeBlocks(); ok {
+		return blocks >= lock
+	}
+	lock, _ := t.RelativeLockTimeSeconds()
+	return seconds >= lock
+}
+
+// readLockTimeOperand decodes the top stack element used by OP_CHECKLOCKTIMEVERIFY and OP_CHECKSEQUENCEVERIFY
+func (b *BitcoinOpCode) readLockTimeOperand() (int64, bool) {
+	if len(b.stack) < 1 {
+		return 0, false
+	}
+
+	element := b.stack[len(b.stack)-1]
+	if len(element) > LOCKTIME_MAX_NUM_SIZE {
+		return 0, false
+	}
+
+	num := b.DecodeNum(element)
+	if num < 0 {
+		return 0, false
+	}
+	return num, true
+}
+
+// OP_CHECKLOCKTIMEVERIFY (BIP-65) fails unless the transaction lock time has reached the value on the stack
+func (b *BitcoinOpCode) opCheckLockTimeVerify() bool {
+	if b.txContext == nil {
+		return false
+	}
+
+	lockTime, ok := b.readLockTimeOperand()
+	if !ok {
+		return false
+	}
+
+	txLockTime := int64(b.txContext.lockTime())
+	// both lock times must be heights or both must be timestamps
+	if (lockTime < LOCKTIME_THRESHOLD) != (txLockTime < LOCKTIME_THRESHOLD) {
+		return false
+	}
+	if lockTime > txLockTime {
+		return false
+	}
+
+	// a final sequence would disable nLockTime and bypass the check
+	return b.txContext.sequence() != SEQUENCE_FINAL
+}
+
+// OP_CHECKSEQUENCEVERIFY (BIP-112) fails unless the input relative lock time has reached the value on the stack
+func (b *BitcoinOpCode) opCheckSequenceVerify() bool {
+	if b.txContext == nil {
+		return false
+	}
+
+	sequence, ok := b.readLockTimeOperand()
+	if !ok {
+		return false
+	}
+	if sequence&SEQUENCE_LOCKTIME_DISABLE_FLAG != 0 {
+		// the disable flag keeps the opcode a NOP
+		return true
+	}
+
+	if b.txContext.version() < 2 {
+		return false
+	}
+
+	txSequence := int64(b.txContext.sequence())
+	if txSequence&SEQUENCE_LOCKTIME_DISABLE_FLAG != 0 {
+		return false
+	}
+
+	mask := int64(SEQUENCE_LOCKTIME_TYPE_FLAG | SEQUENCE_LOCKTIME_MASK)
+	sequence &= mask
+	txSequence &= mask
+	// both locks must be block based or both must be time based
+	if (sequence < SEQUENCE_LOCKTIME_TYPE_FLAG) != (txSequence < SEQUENCE_LOCKTIME_TYPE_FLAG) {
+		return false
+	}
+
+	return sequence <= txSequence
+}
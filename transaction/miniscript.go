@@ -0,0 +1,678 @@
+package transaction
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/sudonite/bitcoin/chaincfg"
+	ecc "github.com/sudonite/bitcoin/elliptic_curve"
+)
+
+// Miniscript limits (BIP 379)
+const (
+	MAX_OPS_PER_SCRIPT      = 201
+	MAX_PUBKEYS_PER_MULTI_A = 999
+	// hash fragments only accept 32 byte preimages
+	MINISCRIPT_PREIMAGE_SIZE = 32
+	// older() and after() take values from 1 up to this one
+	MAX_MINISCRIPT_TIMELOCK = 0x7fffffff
+)
+
+// MiniscriptContext is the script a miniscript is compiled for, it decides the key format and the multisig fragment
+type MiniscriptContext int
+
+const (
+	MINISCRIPT_P2WSH MiniscriptContext = iota
+	MINISCRIPT_TAPSCRIPT
+)
+
+var ErrMiniscript = errors.New("invalid miniscript")
+
+// miniscriptType holds the BIP 379 type of an expression, one bit per letter of miniscriptTypeLetters:
+// the basic types B, V, K and W, the properties z, o, n, d and u, the malleability properties e, f, s
+// and m, x when a VERIFY costs an extra opcode and the timelock properties g, h, i, j and k
+type miniscriptType uint32
+
+const miniscriptTypeLetters = "BVKWzondufesmxghijk"
+
+// mst builds a type from its letters
+func mst(letters string) miniscriptType {
+	t := miniscriptType(0)
+	for i := 0; i < len(letters); i++ {
+		t |= 1 << strings.IndexByte(miniscriptTypeLetters, letters[i])
+	}
+	return t
+}
+
+// has checks whether the type has every property of other
+func (t miniscriptType) has(other miniscriptType) bool {
+	return t&other == other
+}
+
+// when keeps the properties only if cond holds
+func (t miniscriptType) when(cond bool) miniscriptType {
+	if cond {
+		return t
+	}
+	return 0
+}
+
+// isValid checks that the expression has a basic type, invalid subexpressions never get one
+func (t miniscriptType) isValid() bool {
+	return t&mst("BVKW") != 0
+}
+
+func (t miniscriptType) String() string {
+	letters := []byte{}
+	for i := 0; i < len(miniscriptTypeLetters); i++ {
+		if t&(1<<i) != 0 {
+			letters = append(letters, miniscriptTypeLetters[i])
+		}
+	}
+	return string(letters)
+}
+
+// Miniscript is a parsed and type checked miniscript expression
+type Miniscript struct {
+	root *miniscriptNode
+	ctx  MiniscriptContext
+}
+
+// miniscriptNode is a fragment, a wrapper is a node with one sub named by its letter
+type miniscriptNode struct {
+	fragment string
+	subs     []*miniscriptNode
+	keys     []*descriptorKey
+	// k is the threshold of thresh, multi and multi_a or the value of older and after
+	k    int
+	hash []byte
+	typ  miniscriptType
+}
+
+// ParseMiniscript parses and type checks a miniscript expression, the top level expression must be of type B.
+// Keys are hex or non ranged extended keys, 33 bytes in P2WSH and x-only in tapscript
+func ParseMiniscript(expr string, ctx MiniscriptContext, params *chaincfg.Params) (*Miniscript, error) {
+	root, err := parseMiniscriptNode(expr, ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	if !root.typ.has(mst("B")) {
+		return nil, fmt.Errorf("%w: %s is of type %s, not B", ErrMiniscript, root, root.typ)
+	}
+	for _, key := range root.allKeys() {
+		if key.ranged {
+			return nil, fmt.Errorf("%w: ranged key %q needs a descriptor", ErrMiniscript, key.text)
+		}
+	}
+	return &Miniscript{root: root, ctx: ctx}, nil
+}
+
+// String returns the expression with the pk, pkh, t, l, u and and_n shorthands
+func (m *Miniscript) String() string {
+	return m.root.String()
+}
+
+// Type returns the type letters of the expression, like Bdemsu
+func (m *Miniscript) Type() string {
+	return m.root.typ.String()
+}
+
+// Context returns the script context the expression is compiled for
+func (m *Miniscript) Context() MiniscriptContext {
+	return m.ctx
+}
+
+// Script compiles the expression, the result is the witness script of P2WSH or the leaf script of tapscript
+func (m *Miniscript) Script() (*ScriptSig, error) {
+	cmds, err := m.root.compile(0)
+	if err != nil {
+		return nil, err
+	}
+	return InitScriptSig(cmds), nil
+}
+
+// IsSane checks that the expression is nonmalleable, always needs a signature, does not mix timelock
+// types, has no repeated keys and fits the standardness limits of its context
+func (m *Miniscript) IsSane() bool {
+	return m.root.checkSane(m.ctx, 0) == nil
+}
+
+// parseMiniscriptNode parses an expression, rejecting any subexpression without a valid type
+func parseMiniscriptNode(expr string, ctx MiniscriptContext, params *chaincfg.Params) (*miniscriptNode, error) {
+	open := strings.IndexByte(expr, '(')
+	if colon := strings.IndexByte(expr, ':'); colon > 0 && (open < 0 || colon < open) {
+		node, err := parseMiniscriptNode(expr[colon+1:], ctx, params)
+		if err != nil {
+			return nil, err
+		}
+		// wrappers apply from the innermost, the letter next to the colon
+		for i := colon - 1; i >= 0; i-- {
+			if node, err = wrapMiniscript(expr[i], node, ctx); err != nil {
+				return nil, err
+			}
+		}
+		return node, nil
+	}
+
+	name, args := expr, []string{}
+	if open >= 0 {
+		if !strings.HasSuffix(expr, ")") {
+			return nil, fmt.Errorf("%w: unbalanced expression %q", ErrMiniscript, expr)
+		}
+		name, args = expr[:open], splitDescriptorArgs(expr[open+1:len(expr)-1])
+	}
+
+	arity := map[string]int{
+		"pk": 1, "pkh": 1, "pk_k": 1, "pk_h": 1, "older": 1, "after": 1,
+		"sha256": 1, "hash256": 1, "ripemd160": 1, "hash160": 1,
+		"and_v": 2, "and_b": 2, "and_n": 2, "or_b": 2, "or_c": 2, "or_d": 2, "or_i": 2, "andor": 3,
+	}
+	if want, ok := arity[name]; ok && (open < 0 || len(args) != want) {
+		return nil, fmt.Errorf("%w: %s takes %d arguments", ErrMiniscript, name, want)
+	}
+
+	node := &miniscriptNode{fragment: name}
+	switch name {
+	case "0", "1":
+		if open >= 0 {
+			return nil, fmt.Errorf("%w: %s takes no arguments", ErrMiniscript, name)
+		}
+	case "pk", "pkh", "pk_k", "pk_h":
+		key, err := parseMiniscriptKey(args[0], ctx, params)
+		if err != nil {
+			return nil, err
+		}
+		node.keys = []*descriptorKey{key}
+		switch name {
+		case "pk":
+			node.fragment = "pk_k"
+			return newMiniscriptNode(ctx, "c", node.typed(ctx))
+		case "pkh":
+			node.fragment = "pk_h"
+			return newMiniscriptNode(ctx, "c", node.typed(ctx))
+		}
+	case "older", "after":
+		k, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil || k < 1 || k > MAX_MINISCRIPT_TIMELOCK {
+			return nil, fmt.Errorf("%w: invalid %s value %q", ErrMiniscript, name, args[0])
+		}
+		node.k = int(k)
+	case "sha256", "hash256", "ripemd160", "hash160":
+		hash, err := hex.DecodeString(args[0])
+		if err != nil || len(hash) != miniscriptHashSize(name) {
+			return nil, fmt.Errorf("%w: invalid %s hash %q", ErrMiniscript, name, args[0])
+		}
+		node.hash = hash
+	case "and_v", "and_b", "and_n", "or_b", "or_c", "or_d", "or_i", "andor":
+		for _, arg := range args {
+			sub, err := parseMiniscriptNode(arg, ctx, params)
+			if err != nil {
+				return nil, err
+			}
+			node.subs = append(node.subs, sub)
+		}
+		if name == "and_n" {
+			node.fragment, node.subs = "andor", append(node.subs, (&miniscriptNode{fragment: "0"}).typed(ctx))
+		}
+	case "thresh", "multi", "multi_a":
+		if open < 0 || len(args) < 2 {
+			return nil, fmt.Errorf("%w: %s takes a threshold and at least one argument", ErrMiniscript, name)
+		}
+		if name != "thresh" && (name == "multi") != (ctx == MINISCRIPT_P2WSH) {
+			return nil, fmt.Errorf("%w: %s is not available in this context", ErrMiniscript, name)
+		}
+		k, err := strconv.Atoi(args[0])
+		if err != nil || k < 1 || k > len(args)-1 {
+			return nil, fmt.Errorf("%w: invalid %s threshold %q", ErrMiniscript, name, args[0])
+		}
+		node.k = k
+		for _, arg := range args[1:] {
+			if name == "thresh" {
+				sub, err := parseMiniscriptNode(arg, ctx, params)
+				if err != nil {
+					return nil, err
+				}
+				node.subs = append(node.subs, sub)
+				continue
+			}
+			key, err := parseMiniscriptKey(arg, ctx, params)
+			if err != nil {
+				return nil, err
+			}
+			node.keys = append(node.keys, key)
+		}
+		if name == "multi" && len(node.keys) > MAX_PUBKEYS_PER_MULTISIG || len(node.keys) > MAX_PUBKEYS_PER_MULTI_A {
+			return nil, fmt.Errorf("%w: too many keys in %s", ErrMiniscript, name)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown fragment %q", ErrMiniscript, name)
+	}
+
+	if !node.typed(ctx).typ.isValid() {
+		return nil, fmt.Errorf("%w: %s is not a valid expression", ErrMiniscript, node)
+	}
+	return node, nil
+}
+
+// wrapMiniscript applies a wrapper letter, t, l and u are shorthands for and_v(X,1), or_i(0,X) and or_i(X,0)
+func wrapMiniscript(wrapper byte, node *miniscriptNode, ctx MiniscriptContext) (*miniscriptNode, error) {
+	zero, one := (&miniscriptNode{fragment: "0"}).typed(ctx), (&miniscriptNode{fragment: "1"}).typed(ctx)
+	switch wrapper {
+	case 'a', 's', 'c', 'd', 'v', 'j', 'n':
+		return newMiniscriptNode(ctx, string(wrapper), node)
+	case 't':
+		return newMiniscriptNode(ctx, "and_v", node, one)
+	case 'l':
+		return newMiniscriptNode(ctx, "or_i", zero, node)
+	case 'u':
+		return newMiniscriptNode(ctx, "or_i", node, zero)
+	}
+	return nil, fmt.Errorf("%w: unknown wrapper %q", ErrMiniscript, wrapper)
+}
+
+// newMiniscriptNode combines subexpressions, failing when the result has no valid type
+func newMiniscriptNode(ctx MiniscriptContext, fragment string, subs ...*miniscriptNode) (*miniscriptNode, error) {
+	node := (&miniscriptNode{fragment: fragment, subs: subs}).typed(ctx)
+	if !node.typ.isValid() {
+		return nil, fmt.Errorf("%w: %s is not a valid expression", ErrMiniscript, node)
+	}
+	return node, nil
+}
+
+// parseMiniscriptKey parses a key, keys in tapscript are x-only
+func parseMiniscriptKey(text string, ctx MiniscriptContext, params *chaincfg.Params) (*descriptorKey, error) {
+	descCtx := DESCRIPTOR_WSH
+	if ctx == MINISCRIPT_TAPSCRIPT {
+		descCtx = DESCRIPTOR_TR
+	}
+	return parseDescriptorKey(text, descCtx, true, params)
+}
+
+// miniscriptHashSize returns the digest size of a hash fragment
+func miniscriptHashSize(fragment string) int {
+	if fragment == "ripemd160" || fragment == "hash160" {
+		return 20
+	}
+	return 32
+}
+
+// typed sets the type of the node from the types of its subexpressions
+func (n *miniscriptNode) typed(ctx MiniscriptContext) *miniscriptNode {
+	n.typ = n.computeType(ctx)
+	return n
+}
+
+// computeType implements the BIP 379 typing rules, following Bitcoin Core
+func (n *miniscriptNode) computeType(ctx MiniscriptContext) miniscriptType {
+	var x, y, z miniscriptType
+	if len(n.subs) > 0 {
+		x = n.subs[0].typ
+	}
+	if len(n.subs) > 1 {
+		y = n.subs[1].typ
+	}
+	if len(n.subs) > 2 {
+		z = n.subs[2].typ
+	}
+
+	switch n.fragment {
+	case "0":
+		return mst("Bzudemsxk")
+	case "1":
+		return mst("Bzufmxk")
+	case "pk_k":
+		return mst("Konudemsxk")
+	case "pk_h":
+		return mst("Knudemsxk")
+	case "older":
+		return mst("g").when(n.k&SEQUENCE_LOCKTIME_TYPE_FLAG != 0) | mst("h").when(n.k&SEQUENCE_LOCKTIME_TYPE_FLAG == 0) | mst("Bzfmxk")
+	case "after":
+		return mst("i").when(n.k >= LOCKTIME_THRESHOLD) | mst("j").when(n.k < LOCKTIME_THRESHOLD) | mst("Bzfmxk")
+	case "sha256", "hash256", "ripemd160", "hash160":
+		return mst("Bonudmk")
+	case "multi":
+		return mst("Bnudemsk")
+	case "multi_a":
+		return mst("Budemsk")
+	case "a":
+		return mst("W").when(x.has(mst("B"))) | x&mst("ghijk") | x&mst("udfems") | mst("x")
+	case "s":
+		return mst("W").when(x.has(mst("Bo"))) | x&mst("ghijk") | x&mst("udfemsx")
+	case "c":
+		return mst("B").when(x.has(mst("K"))) | x&mst("ghijk") | x&mst("ondfem") | mst("us")
+	case "d":
+		// d: is only u in tapscript where MINIMALIF is consensus
+		return mst("B").when(x.has(mst("Vz"))) | mst("o").when(x.has(mst("z"))) | mst("e").when(x.has(mst("f"))) |
+			x&mst("ghijk") | x&mst("ms") | mst("u").when(ctx == MINISCRIPT_TAPSCRIPT) | mst("ndx")
+	case "v":
+		return mst("V").when(x.has(mst("B"))) | x&mst("ghijk") | x&mst("zonms") | mst("fx")
+	case "j":
+		return mst("B").when(x.has(mst("Bn"))) | mst("e").when(x.has(mst("f"))) | x&mst("ghijk") | x&mst("oums") | mst("ndx")
+	case "n":
+		return x&mst("ghijk") | x&mst("Bzondfems") | mst("ux")
+	case "and_v":
+		return (y & mst("KVB")).when(x.has(mst("V"))) | x&mst("n") | (y & mst("n")).when(x.has(mst("z"))) |
+			((x | y) & mst("o")).when((x | y).has(mst("z"))) | x&y&mst("dmz") | (x|y)&mst("s") |
+			mst("f").when(y.has(mst("f")) || x.has(mst("s"))) | y&mst("ux") | (x|y)&mst("ghij") |
+			mst("k").when((x&y).has(mst("k")) && !mixesTimelocks(x, y))
+	case "and_b":
+		return (x & mst("B")).when(y.has(mst("W"))) | ((x | y) & mst("o")).when((x | y).has(mst("z"))) |
+			x&mst("n") | (y & mst("n")).when(x.has(mst("z"))) | (x & y & mst("e")).when((x & y).has(mst("s"))) |
+			x&y&mst("dzm") | mst("f").when((x&y).has(mst("f")) || x.has(mst("sf")) || y.has(mst("sf"))) |
+			(x|y)&mst("s") | mst("ux") | (x|y)&mst("ghij") |
+			mst("k").when((x&y).has(mst("k")) && !mixesTimelocks(x, y))
+	case "or_b":
+		return mst("B").when(x.has(mst("Bd")) && y.has(mst("Wd"))) | ((x | y) & mst("o")).when((x | y).has(mst("z"))) |
+			(x & y & mst("m")).when((x|y).has(mst("s")) && (x&y).has(mst("e"))) | x&y&mst("zse") | mst("dux") |
+			(x|y)&mst("ghij") | x&y&mst("k")
+	case "or_d":
+		return (y & mst("B")).when(x.has(mst("Bdu"))) | (x & mst("o")).when(y.has(mst("z"))) |
+			(x & y & mst("m")).when(x.has(mst("e")) && (x|y).has(mst("s"))) | x&y&mst("zes") | y&mst("ufd") |
+			mst("x") | (x|y)&mst("ghij") | x&y&mst("k")
+	case "or_c":
+		return (y & mst("V")).when(x.has(mst("Bdu"))) | (x & mst("o")).when(y.has(mst("z"))) |
+			(x & y & mst("m")).when(x.has(mst("e")) && (x|y).has(mst("s"))) | x&y&mst("zs") | mst("fx") |
+			(x|y)&mst("ghij") | x&y&mst("k")
+	case "or_i":
+		return x&y&mst("VBKufs") | mst("o").when((x & y).has(mst("z"))) | ((x | y) & mst("e")).when((x | y).has(mst("f"))) |
+			(x & y & mst("m")).when((x | y).has(mst("s"))) | (x|y)&mst("d") | mst("x") | (x|y)&mst("ghij") | x&y&mst("k")
+	case "andor":
+		return (y & z & mst("BKV")).when(x.has(mst("Bdu"))) | x&y&z&mst("z") |
+			((x | (y & z)) & mst("o")).when((x | (y & z)).has(mst("z"))) | y&z&mst("u") |
+			(z & mst("f")).when(x.has(mst("s")) || y.has(mst("f"))) | z&mst("d") |
+			(z & mst("e")).when(x.has(mst("s")) || y.has(mst("f"))) |
+			(x & y & z & mst("m")).when(x.has(mst("e")) && (x|y|z).has(mst("s"))) | z&(x|y)&mst("s") | mst("x") |
+			(x|y|z)&mst("ghij") | mst("k").when((x&y&z).has(mst("k")) && !mixesTimelocks(x, y))
+	case "thresh":
+		return n.threshType()
+	}
+	return 0
+}
+
+// threshType types thresh, the first sub must be Bdu and the others Wdu
+func (n *miniscriptNode) threshType() miniscriptType {
+	allE, allM := true, true
+	args, numS := 0, 0
+	timelocks := mst("k")
+	for i, sub := range n.subs {
+		t := sub.typ
+		if i == 0 && !t.has(mst("Bdu")) || i > 0 && !t.has(mst("Wdu")) {
+			return 0
+		}
+		allE = allE && t.has(mst("e"))
+		allM = allM && t.has(mst("m"))
+		if t.has(mst("s")) {
+			numS++
+		}
+		switch {
+		case t.has(mst("o")):
+			args++
+		case !t.has(mst("z")):
+			args += 2
+		}
+		// timelocks only mix when more than one sub has to be satisfied
+		timelocks = (timelocks|t)&mst("ghij") | mst("k").when((timelocks&t).has(mst("k")) && (n.k <= 1 || !mixesTimelocks(timelocks, t)))
+	}
+
+	subs := len(n.subs)
+	return mst("Bdu") | mst("z").when(args == 0) | mst("o").when(args == 1) |
+		mst("e").when(allE && numS == subs) | mst("m").when(allE && allM && numS >= subs-n.k) |
+		mst("s").when(numS >= subs-n.k+1) | timelocks
+}
+
+// mixesTimelocks checks whether satisfying both expressions needs a height and a time lock of the same kind
+func mixesTimelocks(x, y miniscriptType) bool {
+	return x.has(mst("g")) && y.has(mst("h")) || x.has(mst("h")) && y.has(mst("g")) ||
+		x.has(mst("i")) && y.has(mst("j")) || x.has(mst("j")) && y.has(mst("i"))
+}
+
+// verifyOps maps the opcodes a v: wrapper folds into to their VERIFY form
+var verifyOps = map[byte]byte{
+	OP_EQUAL:         OP_EQUALVERIFY,
+	OP_CHECKSIG:      OP_CHECKSIGVERIFY,
+	OP_CHECKMULTISIG: OP_CHECKMULTISIGVERIFY,
+	OP_NUMEQUAL:      OP_NUMEQUALVERIFY,
+}
+
+// miniscriptHashOps maps the hash fragments to their opcode
+var miniscriptHashOps = map[string]byte{
+	"sha256":    OP_SHA256,
+	"hash256":   OP_HASH256,
+	"ripemd160": OP_RIPEMD160,
+	"hash160":   OP_HASH160,
+}
+
+// compile returns the script commands of the expression with its keys derived at index
+func (n *miniscriptNode) compile(index uint32) ([]ScriptCmd, error) {
+	subs := make([][]ScriptCmd, len(n.subs))
+	for i, sub := range n.subs {
+		cmds, err := sub.compile(index)
+		if err != nil {
+			return nil, err
+		}
+		subs[i] = cmds
+	}
+	pubKeys := make([][]byte, len(n.keys))
+	for i, key := range n.keys {
+		pubKey, err := key.pubKeyAt(index)
+		if err != nil {
+			return nil, err
+		}
+		pubKeys[i] = pubKey
+	}
+	op := func(ops ...byte) []ScriptCmd {
+		cmds := make([]ScriptCmd, len(ops))
+		for i, code := range ops {
+			cmds[i] = OpCmd(code)
+		}
+		return cmds
+	}
+
+	switch n.fragment {
+	case "0":
+		return op(OP_0), nil
+	case "1":
+		return op(OP_1), nil
+	case "pk_k":
+		return []ScriptCmd{DataPush(pubKeys[0])}, nil
+	case "pk_h":
+		return slices.Concat(op(OP_DUP, OP_HASH160), []ScriptCmd{DataPush(ecc.Hash160(pubKeys[0]))}, op(OP_EQUALVERIFY)), nil
+	case "older":
+		return []ScriptCmd{scriptNumCmd(int64(n.k)), OpCmd(OP_CHECKSEQUENCEVERIFY)}, nil
+	case "after":
+		return []ScriptCmd{scriptNumCmd(int64(n.k)), OpCmd(OP_CHECKLOCKTIMEVERIFY)}, nil
+	case "sha256", "hash256", "ripemd160", "hash160":
+		return []ScriptCmd{OpCmd(OP_SIZE), scriptNumCmd(MINISCRIPT_PREIMAGE_SIZE), OpCmd(OP_EQUALVERIFY),
+			OpCmd(miniscriptHashOps[n.fragment]), DataPush(n.hash), OpCmd(OP_EQUAL)}, nil
+	case "a":
+		return slices.Concat(op(OP_TOALTSTACK), subs[0], op(OP_FROMALTSTACK)), nil
+	case "s":
+		return slices.Concat(op(OP_SWAP), subs[0]), nil
+	case "c":
+		return slices.Concat(subs[0], op(OP_CHECKSIG)), nil
+	case "d":
+		return slices.Concat(op(OP_DUP, OP_IF), subs[0], op(OP_ENDIF)), nil
+	case "v":
+		cmds := slices.Clone(subs[0])
+		last := cmds[len(cmds)-1]
+		if verifyOp, ok := verifyOps[last.opCode]; ok && isOpCode(last) {
+			cmds[len(cmds)-1] = OpCmd(verifyOp)
+			return cmds, nil
+		}
+		return append(cmds, OpCmd(OP_VERIFY)), nil
+	case "j":
+		return slices.Concat(op(OP_SIZE, OP_0NOTEQUAL, OP_IF), subs[0], op(OP_ENDIF)), nil
+	case "n":
+		return slices.Concat(subs[0], op(OP_0NOTEQUAL)), nil
+	case "and_v":
+		return slices.Concat(subs[0], subs[1]), nil
+	case "and_b":
+		return slices.Concat(subs[0], subs[1], op(OP_BOOLAND)), nil
+	case "or_b":
+		return slices.Concat(subs[0], subs[1], op(OP_BOOLOR)), nil
+	case "or_c":
+		return slices.Concat(subs[0], op(OP_NOTIF), subs[1], op(OP_ENDIF)), nil
+	case "or_d":
+		return slices.Concat(subs[0], op(OP_IFDUP, OP_NOTIF), subs[1], op(OP_ENDIF)), nil
+	case "or_i":
+		return slices.Concat(op(OP_IF), subs[0], op(OP_ELSE), subs[1], op(OP_ENDIF)), nil
+	case "andor":
+		return slices.Concat(subs[0], op(OP_NOTIF), subs[2], op(OP_ELSE), subs[1], op(OP_ENDIF)), nil
+	case "thresh":
+		cmds := slices.Clone(subs[0])
+		for _, sub := range subs[1:] {
+			cmds = append(append(cmds, sub...), OpCmd(OP_ADD))
+		}
+		return append(cmds, scriptNumCmd(int64(n.k)), OpCmd(OP_EQUAL)), nil
+	case "multi":
+		cmds := []ScriptCmd{scriptNumCmd(int64(n.k))}
+		for _, pubKey := range pubKeys {
+			cmds = append(cmds, DataPush(pubKey))
+		}
+		return append(cmds, scriptNumCmd(int64(len(pubKeys))), OpCmd(OP_CHECKMULTISIG)), nil
+	case "multi_a":
+		cmds := []ScriptCmd{DataPush(pubKeys[0]), OpCmd(OP_CHECKSIG)}
+		for _, pubKey := range pubKeys[1:] {
+			cmds = append(cmds, DataPush(pubKey), OpCmd(OP_CHECKSIGADD))
+		}
+		return append(cmds, scriptNumCmd(int64(n.k)), OpCmd(OP_NUMEQUAL)), nil
+	}
+	return nil, fmt.Errorf("%w: cannot compile %s", ErrMiniscript, n.fragment)
+}
+
+// checkSane returns why the expression is not sane, keys are derived at index to measure the script
+func (n *miniscriptNode) checkSane(ctx MiniscriptContext, index uint32) error {
+	switch {
+	case !n.typ.has(mst("B")):
+		return fmt.Errorf("%w: %s is not of type B", ErrMiniscript, n)
+	case !n.typ.has(mst("m")):
+		return fmt.Errorf("%w: %s is malleable", ErrMiniscript, n)
+	case !n.typ.has(mst("s")):
+		return fmt.Errorf("%w: %s can be satisfied without a signature", ErrMiniscript, n)
+	case !n.typ.has(mst("k")):
+		return fmt.Errorf("%w: %s mixes height and time locks", ErrMiniscript, n)
+	}
+
+	seen := map[string]bool{}
+	for _, key := range n.allKeys() {
+		if seen[key.text] {
+			return fmt.Errorf("%w: key %q is used more than once", ErrMiniscript, key.text)
+		}
+		seen[key.text] = true
+	}
+
+	if ctx != MINISCRIPT_P2WSH {
+		return nil
+	}
+	cmds, err := n.compile(index)
+	if err != nil {
+		return err
+	}
+	if size := len(InitScriptSig(cmds).rawSerialize()); size > MAX_STANDARD_P2WSH_SCRIPT_SIZE {
+		return fmt.Errorf("%w: script is %d bytes", ErrMiniscript, size)
+	}
+	// counted over every branch, which bounds the opcodes any execution runs
+	if ops := n.opsCount(cmds); ops > MAX_OPS_PER_SCRIPT {
+		return fmt.Errorf("%w: script has %d opcodes", ErrMiniscript, ops)
+	}
+	if _, items, ok := n.maxSatisfaction(ctx).sat.get(); ok && items > MAX_STANDARD_P2WSH_STACK_ITEMS {
+		return fmt.Errorf("%w: satisfaction needs %d stack items", ErrMiniscript, items)
+	}
+	return nil
+}
+
+// opsCount counts the non push opcodes of the script, OP_CHECKMULTISIG also counts its keys
+func (n *miniscriptNode) opsCount(cmds []ScriptCmd) int {
+	count := 0
+	for _, cmd := range cmds {
+		if isOpCode(cmd) && cmd.opCode > OP_16 {
+			count++
+		}
+	}
+	for _, node := range n.allNodes() {
+		if node.fragment == "multi" {
+			count += len(node.keys)
+		}
+	}
+	return count
+}
+
+// allNodes returns the node and every node below it
+func (n *miniscriptNode) allNodes() []*miniscriptNode {
+	nodes := []*miniscriptNode{n}
+	for _, sub := range n.subs {
+		nodes = append(nodes, sub.allNodes()...)
+	}
+	return nodes
+}
+
+// allKeys returns the keys of the expression in the order they appear
+func (n *miniscriptNode) allKeys() []*descriptorKey {
+	keys := []*descriptorKey{}
+	for _, node := range n.allNodes() {
+		keys = append(keys, node.keys...)
+	}
+	return keys
+}
+
+// String writes the expression back, using the shorthands where they apply
+func (n *miniscriptNode) String() string {
+	switch n.fragment {
+	case "0", "1":
+		return n.fragment
+	case "c":
+		switch sub := n.subs[0]; sub.fragment {
+		case "pk_k":
+			return "pk(" + sub.keys[0].text + ")"
+		case "pk_h":
+			return "pkh(" + sub.keys[0].text + ")"
+		}
+		return wrapperString("c", n.subs[0].String())
+	case "a", "s", "d", "v", "j", "n":
+		return wrapperString(n.fragment, n.subs[0].String())
+	case "and_v":
+		if n.subs[1].fragment == "1" {
+			return wrapperString("t", n.subs[0].String())
+		}
+	case "or_i":
+		if n.subs[0].fragment == "0" {
+			return wrapperString("l", n.subs[1].String())
+		}
+		if n.subs[1].fragment == "0" {
+			return wrapperString("u", n.subs[0].String())
+		}
+	case "andor":
+		if n.subs[2].fragment == "0" {
+			return "and_n(" + n.subs[0].String() + "," + n.subs[1].String() + ")"
+		}
+	case "older", "after":
+		return n.fragment + "(" + strconv.Itoa(n.k) + ")"
+	case "sha256", "hash256", "ripemd160", "hash160":
+		return n.fragment + "(" + hex.EncodeToString(n.hash) + ")"
+	}
+
+	args := []string{}
+	if n.fragment == "thresh" || n.fragment == "multi" || n.fragment == "multi_a" {
+		args = append(args, strconv.Itoa(n.k))
+	}
+	for _, key := range n.keys {
+		args = append(args, key.text)
+	}
+	for _, sub := range n.subs {
+		args = append(args, sub.String())
+	}
+	return n.fragment + "(" + strings.Join(args, ",") + ")"
+}
+
+// wrapperString prefixes a wrapper letter, joining it with the wrappers already in front of inner
+func wrapperString(wrapper string, inner string) string {
+	colon, open := strings.IndexByte(inner, ':'), strings.IndexByte(inner, '(')
+	if colon >= 0 && (open < 0 || colon < open) {
+		return wrapper + inner
+	}
+	return wrapper + ":" + inner
+}
@@ -0,0 +1,333 @@
+package transaction
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/sudonite/bitcoin/chaincfg"
+)
+
+var ErrPolicy = errors.New("invalid policy")
+
+// policyNode is an expression of the policy language: pk, after, older, the hashes, and, or and thresh
+type policyNode struct {
+	name string
+	key  *descriptorKey
+	// k is the value of after and older or the threshold of thresh
+	k    int
+	hash []byte
+	subs []*policyNode
+	// weights are the N@ odds of the branches of or
+	weights []int
+}
+
+// policyCandidate is a miniscript compiled for a policy with the witness sizes the policy is expected to use
+type policyCandidate struct {
+	node *miniscriptNode
+	size int
+	sat  float64
+	// dsat is infinite when the expression cannot be dissatisfied
+	dsat float64
+}
+
+// policyCandidates keeps the cheapest candidate for every combination of type properties
+type policyCandidates map[miniscriptType]*policyCandidate
+
+// policyCompiler searches the miniscripts a policy can compile to
+type policyCompiler struct {
+	ctx MiniscriptContext
+	// sigSize and keySize are the witness sizes of a signature and a public key
+	sigSize float64
+	keySize float64
+}
+
+// CompilePolicy compiles a spending policy to the cheapest sane miniscript the compiler finds. The
+// cost is the script size plus the expected witness size, N@ weights on the branches of or() give how
+// likely each branch is to be used and default to 1
+func CompilePolicy(policy string, ctx MiniscriptContext, params *chaincfg.Params) (*Miniscript, error) {
+	root, err := parsePolicy(policy, ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	compiler := &policyCompiler{ctx: ctx, sigSize: float64(pushSize(MAX_ECDSA_SIG_SIZE)), keySize: float64(pushSize(33))}
+	if ctx == MINISCRIPT_TAPSCRIPT {
+		compiler.sigSize, compiler.keySize = float64(pushSize(MAX_SCHNORR_SIG_SIZE)), float64(pushSize(32))
+	}
+
+	var best *policyCandidate
+	for _, candidate := range compiler.compile(root, 1, 0) {
+		if candidate.node.typ.has(mst("Bs")) && candidate.better(best, 1, 0) {
+			best = candidate
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("%w: %s has no nonmalleable compilation that needs a signature", ErrPolicy, policy)
+	}
+	if err := best.node.checkSane(ctx, 0); err != nil {
+		return nil, err
+	}
+	return &Miniscript{root: best.node, ctx: ctx}, nil
+}
+
+// parsePolicy parses a policy expression, keys are parsed like miniscript keys
+func parsePolicy(expr string, ctx MiniscriptContext, params *chaincfg.Params) (*policyNode, error) {
+	open := strings.IndexByte(expr, '(')
+	if open <= 0 || !strings.HasSuffix(expr, ")") {
+		return nil, fmt.Errorf("%w: expected a policy expression, got %q", ErrPolicy, expr)
+	}
+	node := &policyNode{name: expr[:open]}
+	args := splitDescriptorArgs(expr[open+1 : len(expr)-1])
+	if len(args) != 1 && node.name != "and" && node.name != "or" && node.name != "thresh" {
+		return nil, fmt.Errorf("%w: %s takes one argument", ErrPolicy, node.name)
+	}
+
+	switch node.name {
+	case "pk":
+		key, err := parseMiniscriptKey(args[0], ctx, params)
+		if err != nil {
+			return nil, err
+		}
+		if key.ranged {
+			return nil, fmt.Errorf("%w: ranged key %q needs a descriptor", ErrPolicy, key.text)
+		}
+		node.key = key
+	case "after", "older":
+		k, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil || k < 1 || k > MAX_MINISCRIPT_TIMELOCK {
+			return nil, fmt.Errorf("%w: invalid %s value %q", ErrPolicy, node.name, args[0])
+		}
+		node.k = int(k)
+	case "sha256", "hash256", "ripemd160", "hash160":
+		hash, err := hex.DecodeString(args[0])
+		if err != nil || len(hash) != miniscriptHashSize(node.name) {
+			return nil, fmt.Errorf("%w: invalid %s hash %q", ErrPolicy, node.name, args[0])
+		}
+		node.hash = hash
+	case "and", "or":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("%w: %s takes two policies", ErrPolicy, node.name)
+		}
+		for _, arg := range args {
+			weight := 1
+			if at := strings.IndexByte(arg, '@'); at > 0 && node.name == "or" {
+				var err error
+				if weight, err = strconv.Atoi(arg[:at]); err != nil || weight < 1 {
+					return nil, fmt.Errorf("%w: invalid weight %q", ErrPolicy, arg[:at])
+				}
+				arg = arg[at+1:]
+			}
+			sub, err := parsePolicy(arg, ctx, params)
+			if err != nil {
+				return nil, err
+			}
+			node.subs, node.weights = append(node.subs, sub), append(node.weights, weight)
+		}
+	case "thresh":
+		if len(args) < 2 {
+			return nil, fmt.Errorf("%w: thresh takes a threshold and policies", ErrPolicy)
+		}
+		k, err := strconv.Atoi(args[0])
+		if err != nil || k < 1 || k > len(args)-1 {
+			return nil, fmt.Errorf("%w: invalid thresh threshold %q", ErrPolicy, args[0])
+		}
+		node.k = k
+		for _, arg := range args[1:] {
+			sub, err := parsePolicy(arg, ctx, params)
+			if err != nil {
+				return nil, err
+			}
+			node.subs = append(node.subs, sub)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown policy %s()", ErrPolicy, node.name)
+	}
+
+	return node, nil
+}
+
+// score weighs the candidate by how likely its policy is to be satisfied and dissatisfied. A candidate
+// that cannot be dissatisfied only competes with others of its type, which are never dissatisfied either,
+// so its infinite dissatisfaction is left out instead of making every such candidate tie
+func (c *policyCandidate) score(pSat float64, pDsat float64) float64 {
+	score := float64(c.size) + pSat*c.sat
+	if pDsat > 0 && !math.IsInf(c.dsat, 1) {
+		score += pDsat * c.dsat
+	}
+	return score
+}
+
+// better checks whether the candidate beats other, ties go to the expression that sorts first so the
+// result does not depend on map order
+func (c *policyCandidate) better(other *policyCandidate, pSat float64, pDsat float64) bool {
+	if other == nil {
+		return true
+	}
+	score, otherScore := c.score(pSat, pDsat), other.score(pSat, pDsat)
+	if score != otherScore {
+		return score < otherScore
+	}
+	return c.node.String() < other.node.String()
+}
+
+// compile returns the candidates for a policy that is satisfied with probability pSat and dissatisfied with pDsat
+func (c *policyCompiler) compile(p *policyNode, pSat float64, pDsat float64) policyCandidates {
+	candidates := policyCandidates{}
+	add := func(node *miniscriptNode, sat float64, dsat float64) {
+		c.add(candidates, node, sat, dsat, pSat, pDsat)
+	}
+	leaf := func(node *miniscriptNode) *miniscriptNode {
+		return node.typed(c.ctx)
+	}
+
+	switch p.name {
+	case "pk":
+		add(leaf(&miniscriptNode{fragment: "pk_k", keys: []*descriptorKey{p.key}}), c.sigSize, 1)
+		add(leaf(&miniscriptNode{fragment: "pk_h", keys: []*descriptorKey{p.key}}), c.sigSize+c.keySize, 1+c.keySize)
+	case "after", "older":
+		add(leaf(&miniscriptNode{fragment: p.name, k: p.k}), 0, math.Inf(1))
+	case "sha256", "hash256", "ripemd160", "hash160":
+		preimage := float64(pushSize(MINISCRIPT_PREIMAGE_SIZE))
+		add(leaf(&miniscriptNode{fragment: p.name, hash: p.hash}), preimage, preimage)
+	case "and":
+		left, right := c.compile(p.subs[0], pSat, pDsat), c.compile(p.subs[1], pSat, pDsat)
+		for _, pair := range [][2]policyCandidates{{left, right}, {right, left}} {
+			for _, x := range pair[0] {
+				for _, y := range pair[1] {
+					add(c.node("and_v", x, y), x.sat+y.sat, math.Inf(1))
+					add(c.node("and_b", x, y), x.sat+y.sat, x.dsat+y.dsat)
+					add(c.node("andor", x, y, c.zero()), x.sat+y.sat, x.dsat)
+				}
+			}
+		}
+	case "or":
+		total := float64(p.weights[0] + p.weights[1])
+		pLeft, pRight := float64(p.weights[0])/total, float64(p.weights[1])/total
+		left := c.compile(p.subs[0], pSat*pLeft, pDsat+pSat*pRight)
+		right := c.compile(p.subs[1], pSat*pRight, pDsat+pSat*pLeft)
+		for _, pair := range []struct {
+			x, z   policyCandidates
+			pX, pZ float64
+		}{{left, right, pLeft, pRight}, {right, left, pRight, pLeft}} {
+			for _, x := range pair.x {
+				for _, z := range pair.z {
+					useX, useZ := pair.pX*x.sat, pair.pZ*(x.dsat+z.sat)
+					add(c.node("or_b", x, z), pair.pX*(x.sat+z.dsat)+useZ, x.dsat+z.dsat)
+					add(c.node("or_d", x, z), useX+useZ, x.dsat+z.dsat)
+					add(c.node("or_c", x, z), useX+useZ, math.Inf(1))
+					add(c.node("or_i", x, z), pair.pX*(x.sat+2)+pair.pZ*(z.sat+1), math.Min(x.dsat+2, z.dsat+1))
+				}
+			}
+		}
+	case "thresh":
+		c.compileThresh(p, pSat, pDsat, add)
+	}
+
+	c.addWrapped(candidates, pSat, pDsat)
+	return candidates
+}
+
+// compileThresh compiles thresholds of keys to multi or multi_a and other thresholds to thresh
+func (c *policyCompiler) compileThresh(p *policyNode, pSat float64, pDsat float64, add func(*miniscriptNode, float64, float64)) {
+	n, k := float64(len(p.subs)), float64(p.k)
+
+	keys := []*descriptorKey{}
+	for _, sub := range p.subs {
+		if sub.name == "pk" {
+			keys = append(keys, sub.key)
+		}
+	}
+	if len(keys) == len(p.subs) {
+		switch {
+		case c.ctx == MINISCRIPT_P2WSH && len(keys) <= MAX_PUBKEYS_PER_MULTISIG:
+			add((&miniscriptNode{fragment: "multi", k: p.k, keys: keys}).typed(c.ctx), 1+k*c.sigSize, 1+k)
+		case c.ctx == MINISCRIPT_TAPSCRIPT && len(keys) <= MAX_PUBKEYS_PER_MULTI_A:
+			add((&miniscriptNode{fragment: "multi_a", k: p.k, keys: keys}).typed(c.ctx), k*c.sigSize+n-k, n)
+		}
+	}
+
+	// every sub is satisfied k out of n times
+	pSubSat, pSubDsat := pSat*k/n, pDsat+pSat*(n-k)/n
+	node := &miniscriptNode{fragment: "thresh", k: p.k}
+	sat, dsat := 0.0, 0.0
+	for i, sub := range p.subs {
+		want := mst("Wdu")
+		if i == 0 {
+			want = mst("Bdu")
+		}
+		var best *policyCandidate
+		for _, candidate := range c.compile(sub, pSubSat, pSubDsat) {
+			if candidate.node.typ.has(want) && candidate.better(best, pSubSat, pSubDsat) {
+				best = candidate
+			}
+		}
+		if best == nil {
+			return
+		}
+		node.subs = append(node.subs, best.node)
+		sat += (k*best.sat + (n-k)*best.dsat) / n
+		dsat += best.dsat
+	}
+	add(node.typed(c.ctx), sat, dsat)
+}
+
+// addWrapped tries the wrappers on the candidates, a few rounds are enough to reach the useful chains like sln:
+func (c *policyCompiler) addWrapped(candidates policyCandidates, pSat float64, pDsat float64) {
+	for round := 0; round < 3; round++ {
+		current := make([]*policyCandidate, 0, len(candidates))
+		for _, candidate := range candidates {
+			current = append(current, candidate)
+		}
+
+		for _, x := range current {
+			for _, wrapper := range []string{"a", "s", "c", "n"} {
+				c.add(candidates, c.node(wrapper, x), x.sat, x.dsat, pSat, pDsat)
+			}
+			c.add(candidates, c.node("d", x), x.sat+2, 1, pSat, pDsat)
+			c.add(candidates, c.node("v", x), x.sat, math.Inf(1), pSat, pDsat)
+			c.add(candidates, c.node("j", x), x.sat, 1, pSat, pDsat)
+			c.add(candidates, c.node("and_v", x, c.one()), x.sat, math.Inf(1), pSat, pDsat)
+			c.add(candidates, c.node("or_i", c.zero(), x), x.sat+1, math.Min(x.dsat+1, 2), pSat, pDsat)
+			c.add(candidates, c.node("or_i", x, c.zero()), x.sat+2, math.Min(x.dsat+2, 1), pSat, pDsat)
+		}
+	}
+}
+
+// add keeps the candidate when it is valid and cheaper than the one with the same properties. Malleable
+// expressions and timelock mixes never become sane higher up, so they are dropped
+func (c *policyCompiler) add(candidates policyCandidates, node *miniscriptNode, sat float64, dsat float64, pSat float64, pDsat float64) {
+	if !node.typ.isValid() || !node.typ.has(mst("mk")) {
+		return
+	}
+	cmds, err := node.compile(0)
+	if err != nil {
+		return
+	}
+
+	candidate := &policyCandidate{node: node, size: len(InitScriptSig(cmds).rawSerialize()), sat: sat, dsat: dsat}
+	properties := node.typ & mst("BVKWzondufes")
+	if candidate.better(candidates[properties], pSat, pDsat) {
+		candidates[properties] = candidate
+	}
+}
+
+// node combines candidates into a typed expression
+func (c *policyCompiler) node(fragment string, subs ...*policyCandidate) *miniscriptNode {
+	node := &miniscriptNode{fragment: fragment}
+	for _, sub := range subs {
+		node.subs = append(node.subs, sub.node)
+	}
+	return node.typed(c.ctx)
+}
+
+func (c *policyCompiler) zero() *policyCandidate {
+	return &policyCandidate{node: (&miniscriptNode{fragment: "0"}).typed(c.ctx), dsat: 0, sat: math.Inf(1)}
+}
+
+func (c *policyCompiler) one() *policyCandidate {
+	return &policyCandidate{node: (&miniscriptNode{fragment: "1"}).typed(c.ctx), sat: 0, dsat: math.Inf(1)}
+}
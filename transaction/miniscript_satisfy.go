@@ -0,0 +1,442 @@
+package transaction
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"slices"
+
+	"golang.org/x/crypto/ripemd160"
+
+	ecc "github.com/sudonite/bitcoin/elliptic_curve"
+)
+
+// a BIP-340 signature with a non default sighash byte is 65 bytes
+const MAX_SCHNORR_SIG_SIZE = 65
+
+var (
+	ErrMiniscriptUnsatisfiable = errors.New("miniscript cannot be satisfied with the available data")
+	ErrMiniscriptMalleable     = errors.New("miniscript only has a malleable satisfaction")
+)
+
+// MiniscriptSatisfier holds the signatures, preimages and timelocks a satisfaction may use
+type MiniscriptSatisfier struct {
+	// signatures are keyed by the public key as it appears in the script
+	signatures map[string][]byte
+	// preimages are keyed by their sha256, hash256, ripemd160 and hash160 digests
+	preimages map[string][]byte
+	sequence  uint32
+	lockTime  uint32
+}
+
+// NewMiniscriptSatisfier creates a satisfier without signatures, preimages or timelocks
+func NewMiniscriptSatisfier() *MiniscriptSatisfier {
+	return &MiniscriptSatisfier{
+		signatures: map[string][]byte{},
+		preimages:  map[string][]byte{},
+	}
+}
+
+// AddSignature makes a signature for the public key available, the signature includes its sighash byte
+func (s *MiniscriptSatisfier) AddSignature(pubKey []byte, sig []byte) {
+	s.signatures[string(pubKey)] = sig
+}
+
+// AddPreimage makes a preimage available to every hash fragment that commits to it
+func (s *MiniscriptSatisfier) AddPreimage(preimage []byte) error {
+	if len(preimage) != MINISCRIPT_PREIMAGE_SIZE {
+		return fmt.Errorf("%w: preimages are %d bytes", ErrMiniscript, MINISCRIPT_PREIMAGE_SIZE)
+	}
+
+	single := sha256.Sum256(preimage)
+	hasher := ripemd160.New()
+	hasher.Write(preimage)
+	for _, hash := range [][]byte{single[:], ecc.Hash256(string(preimage)), hasher.Sum(nil), ecc.Hash160(preimage)} {
+		s.preimages[string(hash)] = preimage
+	}
+	return nil
+}
+
+// SetSequence sets the nSequence of the spending input, older() is satisfied when it reaches the lock
+func (s *MiniscriptSatisfier) SetSequence(sequence uint32) {
+	s.sequence = sequence
+}
+
+// SetLockTime sets the nLockTime of the spending transaction, after() is satisfied when it reaches the lock
+func (s *MiniscriptSatisfier) SetLockTime(lockTime uint32) {
+	s.lockTime = lockTime
+}
+
+// checkOlder mirrors OP_CHECKSEQUENCEVERIFY against the sequence of the satisfier
+func (s *MiniscriptSatisfier) checkOlder(k int) bool {
+	lock, sequence := uint32(k), s.sequence
+	if sequence&SEQUENCE_LOCKTIME_DISABLE_FLAG != 0 || lock&SEQUENCE_LOCKTIME_TYPE_FLAG != sequence&SEQUENCE_LOCKTIME_TYPE_FLAG {
+		return false
+	}
+	return lock&SEQUENCE_LOCKTIME_MASK <= sequence&SEQUENCE_LOCKTIME_MASK
+}
+
+// checkAfter mirrors OP_CHECKLOCKTIMEVERIFY against the lock time of the satisfier
+func (s *MiniscriptSatisfier) checkAfter(k int) bool {
+	lockTime := int(s.lockTime)
+	if (k < LOCKTIME_THRESHOLD) != (lockTime < LOCKTIME_THRESHOLD) {
+		return false
+	}
+	return k <= lockTime
+}
+
+// Satisfy returns the witness stack satisfying the expression, bottom element first. The witness
+// script of P2WSH, or the leaf script and control block of tapscript, still has to be added on top.
+// Malleable satisfactions are refused
+func (m *Miniscript) Satisfy(satisfier *MiniscriptSatisfier) ([][]byte, error) {
+	_, sat, err := m.root.satisfy(satisfier, m.ctx, 0)
+	if err != nil {
+		return nil, err
+	}
+	if !sat.available {
+		return nil, ErrMiniscriptUnsatisfiable
+	}
+	if sat.malleable {
+		return nil, ErrMiniscriptMalleable
+	}
+	return sat.stack, nil
+}
+
+// MaxSatisfactionSize returns the largest size and number of witness elements a satisfaction can
+// have, not counting the script itself
+func (m *Miniscript) MaxSatisfactionSize() (int, int, error) {
+	size, items, ok := m.root.maxSatisfaction(m.ctx).sat.get()
+	if !ok {
+		return 0, 0, ErrMiniscriptUnsatisfiable
+	}
+	return size, items, nil
+}
+
+// witnessStack is a candidate satisfaction or dissatisfaction of an expression
+type witnessStack struct {
+	available bool
+	hasSig    bool
+	malleable bool
+	size      int
+	stack     [][]byte
+}
+
+var (
+	unavailableStack = witnessStack{}
+	emptyStack       = witnessStack{available: true}
+)
+
+// witnessPush is a stack holding a single element
+func witnessPush(element []byte) witnessStack {
+	return witnessStack{available: true, size: pushSize(len(element)), stack: [][]byte{element}}
+}
+
+// zeroStack is the empty element dissatisfying most fragments
+func zeroStack() witnessStack {
+	return witnessPush([]byte{})
+}
+
+// oneStack selects the first branch of OP_IF
+func oneStack() witnessStack {
+	return witnessPush([]byte{1})
+}
+
+// zero32Stack dissatisfies hash fragments, anyone can replace it with other 32 bytes
+func zero32Stack() witnessStack {
+	return witnessPush(make([]byte, 32)).withMalleable(true)
+}
+
+// cat places the elements of other on top of the stack
+func (w witnessStack) cat(other witnessStack) witnessStack {
+	if !w.available || !other.available {
+		return unavailableStack
+	}
+	return witnessStack{
+		available: true,
+		hasSig:    w.hasSig || other.hasSig,
+		malleable: w.malleable || other.malleable,
+		size:      w.size + other.size,
+		stack:     slices.Concat(w.stack, other.stack),
+	}
+}
+
+func (w witnessStack) withSig() witnessStack {
+	w.hasSig = true
+	return w
+}
+
+func (w witnessStack) withMalleable(malleable bool) witnessStack {
+	w.malleable = w.malleable || malleable
+	return w
+}
+
+// chooseWitness picks between two ways of satisfying, following Bitcoin Core: an option without a
+// signature wins since a third party could use it anyway, two such options make the result malleable,
+// otherwise the nonmalleable and then the smaller option wins
+func chooseWitness(a witnessStack, b witnessStack) witnessStack {
+	switch {
+	case !a.available:
+		return b
+	case !b.available:
+		return a
+	case !a.hasSig && b.hasSig:
+		return a
+	case !b.hasSig && a.hasSig:
+		return b
+	case !a.hasSig && !b.hasSig:
+		a.malleable, b.malleable = true, true
+	case b.malleable && !a.malleable:
+		return a
+	case a.malleable && !b.malleable:
+		return b
+	}
+	if a.size <= b.size {
+		return a
+	}
+	return b
+}
+
+// satisfy returns the best dissatisfaction and satisfaction of the expression with keys derived at index
+func (n *miniscriptNode) satisfy(s *MiniscriptSatisfier, ctx MiniscriptContext, index uint32) (witnessStack, witnessStack, error) {
+	dsats := make([]witnessStack, len(n.subs))
+	sats := make([]witnessStack, len(n.subs))
+	for i, sub := range n.subs {
+		dsat, sat, err := sub.satisfy(s, ctx, index)
+		if err != nil {
+			return unavailableStack, unavailableStack, err
+		}
+		dsats[i], sats[i] = dsat, sat
+	}
+	pubKeys := make([][]byte, len(n.keys))
+	sigs := make([]witnessStack, len(n.keys))
+	for i, key := range n.keys {
+		pubKey, err := key.pubKeyAt(index)
+		if err != nil {
+			return unavailableStack, unavailableStack, err
+		}
+		pubKeys[i], sigs[i] = pubKey, unavailableStack
+		if sig, ok := s.signatures[string(pubKey)]; ok {
+			sigs[i] = witnessPush(sig).withSig()
+		}
+	}
+
+	switch n.fragment {
+	case "0":
+		return emptyStack, unavailableStack, nil
+	case "1":
+		return unavailableStack, emptyStack, nil
+	case "pk_k":
+		return zeroStack(), sigs[0], nil
+	case "pk_h":
+		return zeroStack().cat(witnessPush(pubKeys[0])), sigs[0].cat(witnessPush(pubKeys[0])), nil
+	case "older":
+		if s.checkOlder(n.k) {
+			return unavailableStack, emptyStack, nil
+		}
+		return unavailableStack, unavailableStack, nil
+	case "after":
+		if s.checkAfter(n.k) {
+			return unavailableStack, emptyStack, nil
+		}
+		return unavailableStack, unavailableStack, nil
+	case "sha256", "hash256", "ripemd160", "hash160":
+		if preimage, ok := s.preimages[string(n.hash)]; ok {
+			return zero32Stack(), witnessPush(preimage), nil
+		}
+		return zero32Stack(), unavailableStack, nil
+	case "a", "s", "c", "n":
+		return dsats[0], sats[0], nil
+	case "d":
+		return zeroStack(), sats[0].cat(oneStack()), nil
+	case "v":
+		return unavailableStack, sats[0], nil
+	case "j":
+		// a nonzero dissatisfaction of the sub could replace the empty element
+		return zeroStack().withMalleable(dsats[0].available && !dsats[0].hasSig), sats[0], nil
+	case "and_v":
+		return unavailableStack, sats[1].cat(sats[0]), nil
+	case "and_b":
+		return dsats[1].cat(dsats[0]), sats[1].cat(sats[0]), nil
+	case "or_b":
+		sat := chooseWitness(dsats[1].cat(sats[0]), sats[1].cat(dsats[0]))
+		return dsats[1].cat(dsats[0]), sat, nil
+	case "or_c":
+		return unavailableStack, chooseWitness(sats[0], sats[1].cat(dsats[0])), nil
+	case "or_d":
+		return dsats[1].cat(dsats[0]), chooseWitness(sats[0], sats[1].cat(dsats[0])), nil
+	case "or_i":
+		dsat := chooseWitness(dsats[0].cat(oneStack()), dsats[1].cat(zeroStack()))
+		return dsat, chooseWitness(sats[0].cat(oneStack()), sats[1].cat(zeroStack())), nil
+	case "andor":
+		return dsats[2].cat(dsats[0]), chooseWitness(sats[1].cat(sats[0]), sats[2].cat(dsats[0])), nil
+	case "thresh":
+		return n.satisfyThresh(dsats, sats)
+	case "multi":
+		// the extra element OP_CHECKMULTISIG consumes comes first, signatures follow the key order
+		best := []witnessStack{zeroStack()}
+		for _, sig := range sigs {
+			next := []witnessStack{best[0]}
+			for j := 1; j < len(best); j++ {
+				next = append(next, chooseWitness(best[j], best[j-1].cat(sig)))
+			}
+			best = append(next, best[len(best)-1].cat(sig))
+		}
+		dsat := zeroStack()
+		for i := 0; i < n.k; i++ {
+			dsat = dsat.cat(zeroStack())
+		}
+		return dsat, best[n.k], nil
+	case "multi_a":
+		// the first key checks the topmost element, so keys are walked from the last
+		best := []witnessStack{emptyStack}
+		for i := len(sigs) - 1; i >= 0; i-- {
+			next := []witnessStack{best[0].cat(zeroStack())}
+			for j := 1; j < len(best); j++ {
+				next = append(next, chooseWitness(best[j].cat(zeroStack()), best[j-1].cat(sigs[i])))
+			}
+			best = append(next, best[len(best)-1].cat(sigs[i]))
+		}
+		dsat := emptyStack
+		for range sigs {
+			dsat = dsat.cat(zeroStack())
+		}
+		return dsat, best[n.k], nil
+	}
+	return unavailableStack, unavailableStack, fmt.Errorf("%w: cannot satisfy %s", ErrMiniscript, n.fragment)
+}
+
+// satisfyThresh finds the best way to satisfy exactly k subs, best[j] satisfies j of the subs seen so far
+func (n *miniscriptNode) satisfyThresh(dsats []witnessStack, sats []witnessStack) (witnessStack, witnessStack, error) {
+	best := []witnessStack{emptyStack}
+	for i := len(n.subs) - 1; i >= 0; i-- {
+		next := []witnessStack{best[0].cat(dsats[i])}
+		for j := 1; j < len(best); j++ {
+			next = append(next, chooseWitness(best[j].cat(dsats[i]), best[j-1].cat(sats[i])))
+		}
+		best = append(next, best[len(best)-1].cat(sats[i]))
+	}
+
+	dsat := unavailableStack
+	for j, stack := range best {
+		if j == n.k {
+			continue
+		}
+		// satisfying the wrong number of subs dissatisfies too, but anyone can turn those into another
+		dsat = chooseWitness(dsat, stack.withMalleable(j != 0))
+	}
+	return dsat, best[n.k], nil
+}
+
+// witnessSize is the size and number of elements of the largest witness, ok is false when there is none
+type witnessSize struct {
+	size  int
+	items int
+	ok    bool
+}
+
+func (s witnessSize) get() (int, int, bool) {
+	return s.size, s.items, s.ok
+}
+
+func (s witnessSize) plus(other witnessSize) witnessSize {
+	return witnessSize{size: s.size + other.size, items: s.items + other.items, ok: s.ok && other.ok}
+}
+
+// largest returns the bigger of two witnesses
+func (s witnessSize) largest(other witnessSize) witnessSize {
+	if !s.ok || other.ok && other.size > s.size {
+		return other
+	}
+	return s
+}
+
+// maxWitnessSizes holds the largest satisfaction and dissatisfaction of an expression
+type maxWitnessSizes struct {
+	sat  witnessSize
+	dsat witnessSize
+}
+
+// maxSatisfaction bounds the witness size of the expression over its canonical satisfactions
+func (n *miniscriptNode) maxSatisfaction(ctx MiniscriptContext) maxWitnessSizes {
+	subs := make([]maxWitnessSizes, len(n.subs))
+	for i, sub := range n.subs {
+		subs[i] = sub.maxSatisfaction(ctx)
+	}
+	none := witnessSize{}
+	empty := witnessSize{ok: true}
+	push := func(length int) witnessSize {
+		return witnessSize{size: pushSize(length), items: 1, ok: true}
+	}
+	sig, keySize := push(MAX_ECDSA_SIG_SIZE), 33
+	if ctx == MINISCRIPT_TAPSCRIPT {
+		sig, keySize = push(MAX_SCHNORR_SIG_SIZE), 32
+	}
+	zero, one := push(0), push(1)
+
+	switch n.fragment {
+	case "0":
+		return maxWitnessSizes{sat: none, dsat: empty}
+	case "1", "older", "after":
+		return maxWitnessSizes{sat: empty, dsat: none}
+	case "pk_k":
+		return maxWitnessSizes{sat: sig, dsat: zero}
+	case "pk_h":
+		return maxWitnessSizes{sat: sig.plus(push(keySize)), dsat: zero.plus(push(keySize))}
+	case "sha256", "hash256", "ripemd160", "hash160":
+		return maxWitnessSizes{sat: push(MINISCRIPT_PREIMAGE_SIZE), dsat: push(32)}
+	case "a", "s", "c", "n":
+		return subs[0]
+	case "d":
+		return maxWitnessSizes{sat: subs[0].sat.plus(one), dsat: zero}
+	case "v":
+		return maxWitnessSizes{sat: subs[0].sat, dsat: none}
+	case "j":
+		return maxWitnessSizes{sat: subs[0].sat, dsat: zero}
+	case "and_v":
+		return maxWitnessSizes{sat: subs[0].sat.plus(subs[1].sat), dsat: none}
+	case "and_b":
+		return maxWitnessSizes{sat: subs[0].sat.plus(subs[1].sat), dsat: subs[0].dsat.plus(subs[1].dsat)}
+	case "or_b":
+		sat := subs[0].sat.plus(subs[1].dsat).largest(subs[0].dsat.plus(subs[1].sat))
+		return maxWitnessSizes{sat: sat, dsat: subs[0].dsat.plus(subs[1].dsat)}
+	case "or_c":
+		return maxWitnessSizes{sat: subs[0].sat.largest(subs[0].dsat.plus(subs[1].sat)), dsat: none}
+	case "or_d":
+		sat := subs[0].sat.largest(subs[0].dsat.plus(subs[1].sat))
+		return maxWitnessSizes{sat: sat, dsat: subs[0].dsat.plus(subs[1].dsat)}
+	case "or_i":
+		sat := subs[0].sat.plus(one).largest(subs[1].sat.plus(zero))
+		return maxWitnessSizes{sat: sat, dsat: subs[0].dsat.plus(one).largest(subs[1].dsat.plus(zero))}
+	case "andor":
+		sat := subs[0].sat.plus(subs[1].sat).largest(subs[0].dsat.plus(subs[2].sat))
+		return maxWitnessSizes{sat: sat, dsat: subs[0].dsat.plus(subs[2].dsat)}
+	case "thresh":
+		best := []witnessSize{empty}
+		for _, sub := range subs {
+			next := []witnessSize{best[0].plus(sub.dsat)}
+			for j := 1; j < len(best); j++ {
+				next = append(next, best[j].plus(sub.dsat).largest(best[j-1].plus(sub.sat)))
+			}
+			best = append(next, best[len(best)-1].plus(sub.sat))
+		}
+		return maxWitnessSizes{sat: best[n.k], dsat: best[0]}
+	case "multi":
+		sat, dsat := zero, zero
+		for i := 0; i < n.k; i++ {
+			sat, dsat = sat.plus(sig), dsat.plus(zero)
+		}
+		return maxWitnessSizes{sat: sat, dsat: dsat}
+	case "multi_a":
+		sat, dsat := empty, empty
+		for i := range n.keys {
+			if i < n.k {
+				sat = sat.plus(sig)
+			} else {
+				sat = sat.plus(zero)
+			}
+			dsat = dsat.plus(zero)
+		}
+		return maxWitnessSizes{sat: sat, dsat: dsat}
+	}
+	return maxWitnessSizes{sat: none, dsat: none}
+}
@@ -0,0 +1,145 @@
+package transaction
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/sudonite/bitcoin/chaincfg"
+)
+
+// P2WSH vectors from Bitcoin Core's miniscript tests, with the type and the largest satisfaction
+func TestMiniscriptCoreVectors(t *testing.T) {
+	tests := []struct {
+		expr   string
+		typ    string
+		script string
+		// maxSat and maxItems are the size and number of elements of the largest satisfaction
+		maxSat, maxItems int
+	}{
+		{"lltvln:after(1231488000)", "Bdumxik", "6300676300676300670400046749b1926869516868", 3, 3},
+		{"uuj:and_v(v:multi(2,03d01115d548e7561b15c38f004d734633687cf4419620095bc5b0f47070afe85a,025601570cb47f238d2b0286db4a990fa0f3ba28d1a319f5e7cf55c2a2444da7cc),after(1231488000))", "Bdsmxik",
+			"6363829263522103d01115d548e7561b15c38f004d734633687cf4419620095bc5b0f47070afe85a21025601570cb47f238d2b0286db4a990fa0f3ba28d1a319f5e7cf55c2a2444da7cc52af0400046749b168670068670068", 151, 5},
+		{"or_b(un:multi(2,03daed4f2be3a8bf278e70132fb0beb7522f570e144bf615c07e996d443dee8729,024ce119c96e2fa357200b559b2f7dd5a5f02d5290aff74b03f3e471b273211c97),al:older(16))", "Bduxhk",
+			"63522103daed4f2be3a8bf278e70132fb0beb7522f570e144bf615c07e996d443dee872921024ce119c96e2fa357200b559b2f7dd5a5f02d5290aff74b03f3e471b273211c9752ae926700686b63006760b2686c9b", 151, 5},
+		{"j:and_v(vdv:after(1567547623),older(2016))", "Bondemxhik", "829263766304e7e06e5db169686902e007b268", 2, 1},
+		{"t:and_v(vu:hash256(131772552c01444cd81360818376a040b7c3b2b7b0a53550ee3edde216cec61b),v:sha256(ec4916dd28fc4c10d78e287ca5d9cc51ee1ae73cbfde08c6b37324cbfaac8bc5))", "Bufmxk",
+			"6382012088aa20131772552c01444cd81360818376a040b7c3b2b7b0a53550ee3edde216cec61b876700686982012088a820ec4916dd28fc4c10d78e287ca5d9cc51ee1ae73cbfde08c6b37324cbfaac8bc58851", 68, 3},
+		{"t:andor(multi(3,02d7924d4f7d43ea965a465ae3095ff41131e5946f3c85f79e44adbcf8e27e080e,03fff97bd5755eeea420453a14355235d382f6472f8568a18b2f057a1460297556,02e493dbf1c10d80f3581e4904930b1404cc6c13900ee0758474fa94abe8c4cd13),v:older(4194305),v:sha256(9267d3dbed802941483f1afa2a6bc68de5f653128aca9bf1461c5d0a3ad36ed2))", "Bufmxgk",
+			"532102d7924d4f7d43ea965a465ae3095ff41131e5946f3c85f79e44adbcf8e27e080e2103fff97bd5755eeea420453a14355235d382f6472f8568a18b2f057a14602975562102e493dbf1c10d80f3581e4904930b1404cc6c13900ee0758474fa94abe8c4cd1353ae6482012088a8209267d3dbed802941483f1afa2a6bc68de5f653128aca9bf1461c5d0a3ad36ed2886703010040b2696851", 220, 4},
+		{"and_b(older(16),s:or_d(sha256(e38990d0c7fc009880a9c07c23842e886c6bbdc964ce6bdd5817ad357335ee6f),n:after(1567547623)))", "Bufxhik",
+			"60b27c82012088a820e38990d0c7fc009880a9c07c23842e886c6bbdc964ce6bdd5817ad357335ee6f87736404e7e06e5db192689a", 33, 1},
+		{"c:and_v(or_c(sha256(9267d3dbed802941483f1afa2a6bc68de5f653128aca9bf1461c5d0a3ad36ed2),v:multi(1,02c44d12c7065d812e8acf28d7cbb19f9011ecd9e9fdf281b0e6a3b5e87d22e7db)),pk_k(03acd484e2f0c7f65309ad178a9f559abde09796974c57e714c35f110dfc27ccbe))", "Busk",
+			"82012088a8209267d3dbed802941483f1afa2a6bc68de5f653128aca9bf1461c5d0a3ad36ed28764512102c44d12c7065d812e8acf28d7cbb19f9011ecd9e9fdf281b0e6a3b5e87d22e7db51af682103acd484e2f0c7f65309ad178a9f559abde09796974c57e714c35f110dfc27ccbeac", 180, 4},
+		{"c:and_v(or_c(multi(2,036d2b085e9e382ed10b69fc311a03f8641ccfff21574de0927513a49d9a688a00,02352bbf4a4cdd12564f93fa332ce333301d9ad40271f8107181340aef25be59d5),v:ripemd160(1b0f3c404d12075c68c938f9f60ebea4f74941a0)),pk_k(03fe72c435413d33d48ac09c9161ba8b09683215439d62b7940502bda8b202e6ce))", "Busmk",
+			"5221036d2b085e9e382ed10b69fc311a03f8641ccfff21574de0927513a49d9a688a002102352bbf4a4cdd12564f93fa332ce333301d9ad40271f8107181340aef25be59d552ae6482012088a6141b0f3c404d12075c68c938f9f60ebea4f74941a088682103fe72c435413d33d48ac09c9161ba8b09683215439d62b7940502bda8b202e6ceac", 220, 4},
+		{"and_v(andor(hash256(8a35d9ca92a48eaade6f53a64985e9e2afeb74dcf8acb4c3721e0dc7e4294b25),v:hash256(939894f70e6c3a25da75da0cc2071b4076d9b006563cf635986ada2e93c0d735),v:older(50000)),after(499999999))", "Bfxhjk",
+			"82012088aa208a35d9ca92a48eaade6f53a64985e9e2afeb74dcf8acb4c3721e0dc7e4294b2587640350c300b2696782012088aa20939894f70e6c3a25da75da0cc2071b4076d9b006563cf635986ada2e93c0d735886804ff64cd1db1", 66, 2},
+		{"andor(hash256(5f8d30e655a7ba0d7596bb3ddfb1d2d20390d23b1845000e1e118b3be1b3f040),j:and_v(v:hash160(3a2bff0da9d96868e66abc4427bea4691cf61ccd),older(4194305)),ripemd160(44d90e2d3714c8663b632fcf0f9d5f22192cc4c8))", "Bdxgk",
+			"82012088aa205f8d30e655a7ba0d7596bb3ddfb1d2d20390d23b1845000e1e118b3be1b3f040876482012088a61444d90e2d3714c8663b632fcf0f9d5f22192cc4c8876782926382012088a9143a2bff0da9d96868e66abc4427bea4691cf61ccd8803010040b26868", 66, 2},
+		{"or_i(c:and_v(v:after(500000),pk_k(02c6047f9441ed7d6d3045406e95c07cd85c778e4b8cef3ca7abac09b95c709ee5)),sha256(d9147961436944f43cd99d28b2bbddbf452ef872b30c8279e255e7daafc7f946))", "Bdumxjk",
+			"630320a107b1692102c6047f9441ed7d6d3045406e95c07cd85c778e4b8cef3ca7abac09b95c709ee5ac6782012088a820d9147961436944f43cd99d28b2bbddbf452ef872b30c8279e255e7daafc7f9468768", 75, 2},
+		{"thresh(2,c:pk_h(025cbdf0646e5db4eaa398f365f2ea7a0e3d419b7e0330e39ce92bddedcac4f9bc),s:sha256(e38990d0c7fc009880a9c07c23842e886c6bbdc964ce6bdd5817ad357335ee6f),a:hash160(dd69735817e0e3f6f826a9238dc2e291184f0131))", "Bduk",
+			"76a9145dedfbf9ea599dd4e3ca6a80b333c472fd0b3f6988ac7c82012088a820e38990d0c7fc009880a9c07c23842e886c6bbdc964ce6bdd5817ad357335ee6f87936b82012088a914dd69735817e0e3f6f826a9238dc2e291184f0131876c935287", 173, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr[:strings.IndexByte(tt.expr, '(')], func(t *testing.T) {
+			m, err := ParseMiniscript(tt.expr, MINISCRIPT_P2WSH, &chaincfg.MainNetParams)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := m.Type(); got != tt.typ {
+				t.Errorf("type %s, want %s", got, tt.typ)
+			}
+			script, err := m.Script()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := hex.EncodeToString(script.rawSerialize()); got != tt.script {
+				t.Errorf("script %s, want %s", got, tt.script)
+			}
+			size, items, err := m.MaxSatisfactionSize()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if size != tt.maxSat || items != tt.maxItems {
+				t.Errorf("max satisfaction %d bytes in %d items, want %d in %d", size, items, tt.maxSat, tt.maxItems)
+			}
+		})
+	}
+}
+
+func TestCompilePolicy(t *testing.T) {
+	// A, B and C stand for compressed keys, and for their x-only form in tapscript
+	keys := strings.NewReplacer(
+		"A", "02c6047f9441ed7d6d3045406e95c07cd85c778e4b8cef3ca7abac09b95c709ee5",
+		"B", "02f9308a019258c31049344f85f89d5229b531c845836f99b08601f113bce036f9",
+		"C", "02e493dbf1c10d80f3581e4904930b1404cc6c13900ee0758474fa94abe8c4cd13",
+	)
+	xOnly := strings.NewReplacer("(02", "(", ",02", ",")
+
+	tests := []struct {
+		name   string
+		policy string
+		ctx    MiniscriptContext
+		want   string
+		size   int
+	}{
+		{"single key", "pk(A)", MINISCRIPT_P2WSH, "pk(A)", 35},
+		{"likely key with a timelocked backup", "or(99@pk(A),1@and(pk(B),older(1000)))", MINISCRIPT_P2WSH, "or_d(pk(A),and_v(v:pkh(B),older(1000)))", 67},
+		{"likely key with a timelocked backup in tapscript", "or(99@pk(A),1@and(pk(B),older(1000)))", MINISCRIPT_TAPSCRIPT, "or_d(pk(A),and_v(v:pkh(B),older(1000)))", 66},
+		{"even key with a timelocked backup", "or(pk(A),and(pk(B),older(1000)))", MINISCRIPT_P2WSH, "or_d(pk(A),and_v(v:pk(B),older(1000)))", 77},
+		{"key or key", "or(pk(A),pk(B))", MINISCRIPT_P2WSH, "or_b(pk(A),s:pk(B))", 72},
+		{"key and key or timelock", "and(pk(A),or(pk(B),older(144)))", MINISCRIPT_P2WSH, "and_v(or_c(pk(B),v:older(144)),pk(A))", 77},
+		{"key and hash", "and(pk(A),sha256(e38990d0c7fc009880a9c07c23842e886c6bbdc964ce6bdd5817ad357335ee6f))", MINISCRIPT_P2WSH,
+			"and_v(v:pk(A),sha256(e38990d0c7fc009880a9c07c23842e886c6bbdc964ce6bdd5817ad357335ee6f))", 74},
+		{"threshold of keys", "thresh(2,pk(A),pk(B),pk(C))", MINISCRIPT_P2WSH, "multi(2,A,B,C)", 105},
+		{"threshold of keys in tapscript", "thresh(2,pk(A),pk(B),pk(C))", MINISCRIPT_TAPSCRIPT, "multi_a(2,A,B,C)", 104},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, want := keys.Replace(tt.policy), keys.Replace(tt.want)
+			if tt.ctx == MINISCRIPT_TAPSCRIPT {
+				policy, want = xOnly.Replace(policy), xOnly.Replace(want)
+			}
+			m, err := CompilePolicy(policy, tt.ctx, &chaincfg.MainNetParams)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m.String() != want {
+				t.Errorf("compiled to %s, want %s", m, want)
+			}
+			script, err := m.Script()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(script.rawSerialize()); got != tt.size {
+				t.Errorf("script of %d bytes, want %d", got, tt.size)
+			}
+			if !m.IsSane() {
+				t.Error("compiled miniscript is not sane")
+			}
+		})
+	}
+
+	invalid := []struct {
+		name   string
+		policy string
+	}{
+		{"no signature", "older(10)"},
+		{"zero weight", "or(0@pk(A),pk(B))"},
+		{"unknown policy", "foo(A)"},
+		{"and with one policy", "and(pk(A))"},
+		{"threshold above the policies", "thresh(3,pk(A),pk(B))"},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := CompilePolicy(keys.Replace(tt.policy), MINISCRIPT_P2WSH, &chaincfg.MainNetParams); !errors.Is(err, ErrPolicy) {
+				t.Errorf("error %v, want %v", err, ErrPolicy)
+			}
+		})
+	}
+}
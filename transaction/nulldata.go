@@ -0,0 +1,82 @@
+package transaction
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+var ErrNullDataSize = errors.New("null data script exceeds the relay limit")
+
+// NullDataScript builds an unspendable OP_RETURN script carrying each piece of data in its own push.
+// Scripts above MAX_OP_RETURN_RELAY bytes are refused since default nodes would not relay them
+func NullDataScript(data ...[]byte) (*ScriptSig, error) {
+	cmds := []ScriptCmd{OpCmd(OP_RETURN)}
+	for _, item := range data {
+		cmds = append(cmds, pushCmd(item))
+	}
+
+	script := InitScriptSig(cmds)
+	if size := len(script.rawSerialize()); size > MAX_OP_RETURN_RELAY {
+		return nil, fmt.Errorf("%w: %d bytes is more than %d", ErrNullDataSize, size, MAX_OP_RETURN_RELAY)
+	}
+	return script, nil
+}
+
+// TaggedNullDataScript builds a null data script whose first push is the protocol tag, so the
+// outputs of a protocol can be told apart from everyone else's
+func TaggedNullDataScript(tag []byte, data ...[]byte) (*ScriptSig, error) {
+	return NullDataScript(append([][]byte{tag}, data...)...)
+}
+
+// NullData returns the pushes of an OP_RETURN output, OP_0, OP_1NEGATE and OP_1..OP_16 give the
+// bytes they push so data built by NullDataScript comes back unchanged
+func (t *TransactionOutput) NullData() ([][]byte, bool) {
+	template := t.scriptPubKey.Classify()
+	if template.Class() != SCRIPT_NULL_DATA {
+		return nil, false
+	}
+
+	return template.Data(), true
+}
+
+// Payload returns the pushes of an OP_RETURN output joined together
+func (t *TransactionOutput) Payload() ([]byte, bool) {
+	data, ok := t.NullData()
+	if !ok {
+		return nil, false
+	}
+	return bytes.Join(data, nil), true
+}
+
+// ProtocolData returns the pushes following the tag of an output built by TaggedNullDataScript
+func (t *TransactionOutput) ProtocolData(tag []byte) ([][]byte, bool) {
+	data, ok := t.NullData()
+	if !ok || len(data) == 0 || !bytes.Equal(data[0], tag) {
+		return nil, false
+	}
+	return data[1:], true
+}
+
+// ProtocolData returns the index and pushes of the first output carrying data tagged with tag
+func (t *Transaction) ProtocolData(tag []byte) (int, [][]byte, bool) {
+	for i, txOutput := range t.txOutputs {
+		if data, ok := txOutput.ProtocolData(tag); ok {
+			return i, data, true
+		}
+	}
+	return -1, nil, false
+}
+
+// pushedBytes returns what a push only command leaves on the stack
+func pushedBytes(cmd ScriptCmd) []byte {
+	switch {
+	case isOp(cmd, OP_0):
+		return []byte{}
+	case isOp(cmd, OP_1NEGATE):
+		return []byte{0x81}
+	case smallInteger(cmd) > 0:
+		return []byte{byte(smallInteger(cmd))}
+	}
+	return cmd.data
+}
@@ -1,11 +1,12 @@
 package transaction
 
 import (
-	"bufio"
 	"bytes"
-	"fmt"
+	"crypto/sha256"
 	"math/big"
 
+	"golang.org/x/crypto/ripemd160"
+
 	ecc "github.com/sudonite/bitcoin/elliptic_curve"
 )
 
@@ -39,13 +40,17 @@ const (
 
 const (
 	OP_IF = iota + 99
-	OP_NOTIf
+	OP_NOTIF
+	OP_VERIF
+	OP_VERNOTIF
+	OP_ELSE
+	OP_ENDIF
 )
 
 const (
 	OP_VERIFY = iota + 105
 	OP_RETURN
-	OP_TOTALSTACK
+	OP_TOALTSTACK
 	OP_FROMALTSTACK
 	OP_2DROP
 	OP_2DUP
@@ -108,15 +113,16 @@ const (
 	OP_SHA256
 	OP_HASH160
 	OP_HASH256
+	OP_CODESEPARATOR
 )
 
 const (
 	OP_CHECKSIG = iota + 172
-	OP_HECKSIGVERIFY
+	OP_CHECKSIGVERIFY
 	OP_CHECKMULTISIG
 	OP_CHECKMULTISIGVERIFY
 	OP_NOP1
-	OP_CHECKLOGTIMEVERIFY
+	OP_CHECKLOCKTIMEVERIFY
 	OP_CHECKSEQUENCEVERIFY
 	OP_NOP4
 	OP_NOP5
@@ -125,6 +131,15 @@ const (
 	OP_NOP8
 	OP_NOP9
 	OP_NOP10
+	OP_CHECKSIGADD
+)
+
+// Historical misspellings kept for existing callers
+const (
+	OP_HECKSIGVERIFY      = OP_CHECKSIGVERIFY
+	OP_CHECKLOGTIMEVERIFY = OP_CHECKLOCKTIMEVERIFY
+	OP_NOTIf              = OP_NOTIF
+	OP_TOTALSTACK         = OP_TOALTSTACK
 )
 
 const (
@@ -136,8 +151,26 @@ type BitcoinOpCode struct {
 	opCodeNames map[int]string
 	stack       [][]byte
 	altStack    [][]byte
-	cmds        [][]byte
+	cmds        []ScriptCmd
 	witness     [][]byte
+	// condStack holds one entry per open OP_IF or OP_NOTIF, false while its branch is skipped
+	condStack []bool
+	// scriptExpanded is set once a redeem or witness script runs, P2SH is only matched on the scriptPubKey
+	scriptExpanded bool
+	// witnessExpanded is set once a version 0 witness program runs, which must end with a clean stack
+	witnessExpanded bool
+	// sigHash computes the BIP-143 message of a signature from its hash type and scriptCode,
+	// signatures are checked against the z given to the evaluation when it is nil
+	sigHash func(hashType byte, scriptCode []byte) ([]byte, error)
+	// scriptCode holds the commands of the witness script from its last executed OP_CODESEPARATOR on
+	scriptCode []ScriptCmd
+	// tapscript is set when executing a BIP-342 leaf script
+	tapscript *tapscriptContext
+	// txContext gives access to the spending transaction, timelock opcodes fail without it
+	txContext *TxContext
+	// tracer is told about every command executed, steps counts them
+	tracer ScriptTracer
+	steps  int
 }
 
 // Creates a new BitcoinOpCode instance with opcode names initialized.
@@ -148,6 +181,7 @@ func NewBitcoinOpCode() *BitcoinOpCode {
 		77:  "OP_PUSHDATA2",
 		78:  "OP_PUSHDATA4",
 		79:  "OP_1NEGATE",
+		80:  "OP_RESERVED",
 		81:  "OP_1",
 		82:  "OP_2",
 		83:  "OP_3",
@@ -165,8 +199,11 @@ func NewBitcoinOpCode() *BitcoinOpCode {
 		95:  "OP_15",
 		96:  "OP_16",
 		97:  "OP_NOP",
+		98:  "OP_VER",
 		99:  "OP_IF",
 		100: "OP_NOTIF",
+		101: "OP_VERIF",
+		102: "OP_VERNOTIF",
 		103: "OP_ELSE",
 		104: "OP_ENDIF",
 		105: "OP_VERIFY",
@@ -190,11 +227,23 @@ func NewBitcoinOpCode() *BitcoinOpCode {
 		123: "OP_ROT",
 		124: "OP_SWAP",
 		125: "OP_TUCK",
+		126: "OP_CAT",
+		127: "OP_SUBSTR",
+		128: "OP_LEFT",
+		129: "OP_RIGHT",
 		130: "OP_SIZE",
+		131: "OP_INVERT",
+		132: "OP_AND",
+		133: "OP_OR",
+		134: "OP_XOR",
 		135: "OP_EQUAL",
 		136: "OP_EQUALVERIFY",
+		137: "OP_RESERVED1",
+		138: "OP_RESERVED2",
 		139: "OP_1ADD",
 		140: "OP_1SUB",
+		141: "OP_2MUL",
+		142: "OP_2DIV",
 		143: "OP_NEGATE",
 		144: "OP_ABS",
 		145: "OP_NOT",
@@ -202,6 +251,10 @@ func NewBitcoinOpCode() *BitcoinOpCode {
 		147: "OP_ADD",
 		148: "OP_SUB",
 		149: "OP_MUL",
+		150: "OP_DIV",
+		151: "OP_MOD",
+		152: "OP_LSHIFT",
+		153: "OP_RSHIFT",
 		154: "OP_BOOLAND",
 		155: "OP_BOOLOR",
 		156: "OP_NUMEQUAL",
@@ -234,31 +287,86 @@ func NewBitcoinOpCode() *BitcoinOpCode {
 		183: "OP_NOP8",
 		184: "OP_NOP9",
 		185: "OP_NOP10",
+		186: "OP_CHECKSIGADD",
 		254: "OP_P2SH",
 	}
 	return &BitcoinOpCode{
 		opCodeNames: opCodeNames,
 		stack:       make([][]byte, 0),
 		altStack:    make([][]byte, 0),
-		cmds:        make([][]byte, 0),
+		cmds:        make([]ScriptCmd, 0),
 	}
 }
 
 // Executes a single Bitcoin Script operations
 func (b *BitcoinOpCode) ExecuteOperation(cmd int, z []byte) bool {
+	if b.tapscript != nil {
+		switch cmd {
+		case OP_CHECKSIG:
+			return b.opCheckSigTapscript()
+		case OP_CHECKSIGVERIFY:
+			return b.opCheckSigTapscript() && b.opVerify()
+		case OP_CHECKSIGADD:
+			return b.opCheckSigAdd()
+		case OP_CHECKMULTISIG, OP_CHECKMULTISIGVERIFY:
+			// disabled by BIP-342 in favour of OP_CHECKSIGADD
+			return false
+		case OP_CODESEPARATOR:
+			// the separator itself was removed from the commands already
+			b.tapscript.codeSepPos = uint32(b.tapscript.opCount - len(b.cmds) - 1)
+			return true
+		}
+	}
+
 	switch cmd {
 	case OP_CHECKSIG:
 		return b.opCheckSig(z)
+	case OP_CHECKSIGVERIFY:
+		return b.opCheckSig(z) && b.opVerify()
 	case OP_DUP:
 		return b.opDup()
+	case OP_DROP:
+		return b.opDrop()
+	case OP_SWAP:
+		return b.opSwap()
+	case OP_IFDUP:
+		return b.opIfDup()
+	case OP_SIZE:
+		return b.opSize()
+	case OP_TOALTSTACK:
+		return b.opToAltStack()
+	case OP_FROMALTSTACK:
+		return b.opFromAltStack()
+	case OP_ADD, OP_BOOLAND, OP_BOOLOR:
+		return b.opBinaryNum(cmd)
+	case OP_0NOTEQUAL:
+		return b.opNotEqualZero()
 	case OP_HASH160:
 		return b.opHash160()
+	case OP_SHA256:
+		return b.opSha256()
+	case OP_HASH256:
+		return b.opHash256()
+	case OP_RIPEMD160:
+		return b.opRipemd160()
+	case OP_VERIFY:
+		return b.opVerify()
 	case OP_EQUALVERIFY:
 		return b.opEqualVerify()
+	case OP_NUMEQUAL:
+		return b.opNumEqual()
+	case OP_NUMEQUALVERIFY:
+		return b.opNumEqual() && b.opVerify()
 	case OP_CHECKMULTISIG:
 		return b.opCheckMultiSig(z)
+	case OP_CHECKMULTISIGVERIFY:
+		return b.opCheckMultiSig(z) && b.opVerify()
 	case OP_P2SH:
 		return b.opP2sh()
+	case OP_CODESEPARATOR:
+		// signatures checked from here on commit only to the rest of the script
+		b.scriptCode = append([]ScriptCmd{}, b.cmds...)
+		return true
 	case OP_0:
 		fallthrough
 	case OP_1:
@@ -295,14 +403,20 @@ func (b *BitcoinOpCode) ExecuteOperation(cmd int, z []byte) bool {
 		return b.opNum(byte(cmd))
 	case OP_EQUAL:
 		return b.opEqual()
+	case OP_CHECKLOCKTIMEVERIFY:
+		return b.opCheckLockTimeVerify()
+	case OP_CHECKSEQUENCEVERIFY:
+		return b.opCheckSequenceVerify()
+	case OP_NOP, OP_NOP1, OP_NOP4, OP_NOP5, OP_NOP6, OP_NOP7, OP_NOP8, OP_NOP9, OP_NOP10:
+		return true
 	default:
-		errStr := fmt.Sprintf("operation %s not implemented\n", b.opCodeNames[cmd])
-		panic(errStr)
+		// unknown and disabled opcodes fail the script
+		return false
 	}
 }
 
 // Remove command from the stack
-func (b *BitcoinOpCode) RemoveCmd() []byte {
+func (b *BitcoinOpCode) RemoveCmd() ScriptCmd {
 	cmd := b.cmds[0]
 	b.cmds = b.cmds[1:]
 	return cmd
@@ -317,8 +431,8 @@ func (b *BitcoinOpCode) HasCmd() bool {
 func (b *BitcoinOpCode) AppendDataElement(element []byte) {
 	b.stack = append(b.stack, element)
 
-	if b.isP2sh() {
-		b.cmds = append([][]byte{{OP_P2SH}}, b.cmds...)
+	if !b.scriptExpanded && b.isP2sh() {
+		b.cmds = append([]ScriptCmd{OpCmd(OP_P2SH)}, b.cmds...)
 	}
 }
 
@@ -393,6 +507,62 @@ func (b *BitcoinOpCode) opNum(op byte) bool {
 	return true
 }
 
+// run executes the remaining commands, stopping at the first one that fails
+func (b *BitcoinOpCode) run(z []byte) error {
+	for b.HasCmd() {
+		if err := b.step(z); err != nil {
+			return err
+		}
+	}
+	return b.endOfScript()
+}
+
+// step executes the next command and reports it to the tracer
+func (b *BitcoinOpCode) step(z []byte) error {
+	cmd := b.RemoveCmd()
+	executing := b.executing()
+	err := b.execute(cmd, executing, z)
+	b.trace(&cmd, executing || isConditional(cmd), err)
+	return err
+}
+
+// execute runs a single command, commands inside a skipped branch are ignored
+func (b *BitcoinOpCode) execute(cmd ScriptCmd, executing bool, z []byte) error {
+	if isConditional(cmd) {
+		// conditionals are processed even inside a skipped branch to keep track of nesting
+		if b.opConditional(int(cmd.opCode), executing) != true {
+			return b.opFailure(cmd.opCode)
+		}
+		return nil
+	}
+	if !executing {
+		return nil
+	}
+
+	if isOpCode(cmd) {
+		//this is an op code, run it
+		if b.ExecuteOperation(int(cmd.opCode), z) != true {
+			return b.opFailure(cmd.opCode)
+		}
+	} else {
+		b.AppendDataElement(cmd.data)
+	}
+	return nil
+}
+
+// isConditional checks whether the command is one of the opcodes from OP_IF to OP_ENDIF
+func isConditional(cmd ScriptCmd) bool {
+	return isOpCode(cmd) && cmd.opCode >= OP_IF && cmd.opCode <= OP_ENDIF
+}
+
+// endOfScript checks every OP_IF got its OP_ENDIF once the commands run out
+func (b *BitcoinOpCode) endOfScript() error {
+	if len(b.condStack) != 0 {
+		return b.fail(ErrScriptUnbalancedConditional)
+	}
+	return nil
+}
+
 // Duplicate Script operation implementation
 func (b *BitcoinOpCode) opDup() bool {
 	if len(b.stack) < 1 {
@@ -403,6 +573,43 @@ func (b *BitcoinOpCode) opDup() bool {
 	return true
 }
 
+// Drop Script operation implementation
+func (b *BitcoinOpCode) opDrop() bool {
+	if len(b.stack) < 1 {
+		return false
+	}
+
+	b.popStack()
+	return true
+}
+
+// Sha256 Script operation implementation
+func (b *BitcoinOpCode) opSha256() bool {
+	if len(b.stack) < 1 {
+		return false
+	}
+
+	hash := sha256.Sum256(b.popStack())
+	b.stack = append(b.stack, hash[:])
+	return true
+}
+
+// NumEqual Script operation implementation
+func (b *BitcoinOpCode) opNumEqual() bool {
+	if len(b.stack) < 2 {
+		return false
+	}
+
+	num1 := b.DecodeNum(b.popStack())
+	num2 := b.DecodeNum(b.popStack())
+	if num1 == num2 {
+		b.stack = append(b.stack, b.EncodeNum(1))
+	} else {
+		b.stack = append(b.stack, b.EncodeNum(0))
+	}
+	return true
+}
+
 // Hash160 Script operation implementation
 func (b *BitcoinOpCode) opHash160() bool {
 	if len(b.stack) < 1 {
@@ -456,6 +663,176 @@ func (b *BitcoinOpCode) opEqualVerify() bool {
 	return resEqual && resVerify
 }
 
+// executing checks whether every open OP_IF branch is taken
+func (b *BitcoinOpCode) executing() bool {
+	for _, taken := range b.condStack {
+		if !taken {
+			return false
+		}
+	}
+	return true
+}
+
+// Implements OP_IF, OP_NOTIF, OP_ELSE and OP_ENDIF, OP_VERIF and OP_VERNOTIF fail even when skipped
+func (b *BitcoinOpCode) opConditional(op int, executing bool) bool {
+	switch op {
+	case OP_IF, OP_NOTIF:
+		taken := false
+		if executing {
+			if len(b.stack) < 1 {
+				return false
+			}
+			elem := b.popStack()
+			// tapscript requires the argument to be exactly empty or 1
+			if b.tapscript != nil && (len(elem) > 1 || len(elem) == 1 && elem[0] != 1) {
+				return false
+			}
+			taken = castToBool(elem)
+			if op == OP_NOTIF {
+				taken = !taken
+			}
+		}
+		b.condStack = append(b.condStack, taken)
+	case OP_ELSE:
+		if len(b.condStack) == 0 {
+			return false
+		}
+		b.condStack[len(b.condStack)-1] = !b.condStack[len(b.condStack)-1]
+	case OP_ENDIF:
+		if len(b.condStack) == 0 {
+			return false
+		}
+		b.condStack = b.condStack[:len(b.condStack)-1]
+	default:
+		return false
+	}
+	return true
+}
+
+// Swap Script operation implementation
+func (b *BitcoinOpCode) opSwap() bool {
+	if len(b.stack) < 2 {
+		return false
+	}
+
+	top := len(b.stack) - 1
+	b.stack[top], b.stack[top-1] = b.stack[top-1], b.stack[top]
+	return true
+}
+
+// Duplicates the top element when it is true
+func (b *BitcoinOpCode) opIfDup() bool {
+	if len(b.stack) < 1 {
+		return false
+	}
+
+	if castToBool(b.stack[len(b.stack)-1]) {
+		b.stack = append(b.stack, b.stack[len(b.stack)-1])
+	}
+	return true
+}
+
+// Pushes the length of the top element without removing it
+func (b *BitcoinOpCode) opSize() bool {
+	if len(b.stack) < 1 {
+		return false
+	}
+
+	b.stack = append(b.stack, b.EncodeNum(int64(len(b.stack[len(b.stack)-1]))))
+	return true
+}
+
+// Moves the top element to the alt stack
+func (b *BitcoinOpCode) opToAltStack() bool {
+	if len(b.stack) < 1 {
+		return false
+	}
+
+	b.altStack = append(b.altStack, b.popStack())
+	return true
+}
+
+// Moves the top element of the alt stack back to the main stack
+func (b *BitcoinOpCode) opFromAltStack() bool {
+	if len(b.altStack) < 1 {
+		return false
+	}
+
+	b.stack = append(b.stack, b.altStack[len(b.altStack)-1])
+	b.altStack = b.altStack[:len(b.altStack)-1]
+	return true
+}
+
+// popNum pops a script number, operands of arithmetic ops are at most 4 bytes
+func (b *BitcoinOpCode) popNum() (int64, bool) {
+	if len(b.stack) < 1 || len(b.stack[len(b.stack)-1]) > 4 {
+		return 0, false
+	}
+	return b.DecodeNum(b.popStack()), true
+}
+
+// Implements OP_ADD, OP_BOOLAND and OP_BOOLOR
+func (b *BitcoinOpCode) opBinaryNum(op int) bool {
+	num2, ok2 := b.popNum()
+	num1, ok1 := b.popNum()
+	if !ok1 || !ok2 {
+		return false
+	}
+
+	result := int64(0)
+	switch op {
+	case OP_ADD:
+		result = num1 + num2
+	case OP_BOOLAND:
+		if num1 != 0 && num2 != 0 {
+			result = 1
+		}
+	case OP_BOOLOR:
+		if num1 != 0 || num2 != 0 {
+			result = 1
+		}
+	}
+	b.stack = append(b.stack, b.EncodeNum(result))
+	return true
+}
+
+// Replaces the top number with 1 when it is not zero
+func (b *BitcoinOpCode) opNotEqualZero() bool {
+	num, ok := b.popNum()
+	if !ok {
+		return false
+	}
+
+	if num != 0 {
+		b.stack = append(b.stack, b.EncodeNum(1))
+	} else {
+		b.stack = append(b.stack, b.EncodeNum(0))
+	}
+	return true
+}
+
+// Hash256 Script operation implementation
+func (b *BitcoinOpCode) opHash256() bool {
+	if len(b.stack) < 1 {
+		return false
+	}
+
+	b.stack = append(b.stack, ecc.Hash256(string(b.popStack())))
+	return true
+}
+
+// Ripemd160 Script operation implementation
+func (b *BitcoinOpCode) opRipemd160() bool {
+	if len(b.stack) < 1 {
+		return false
+	}
+
+	hasher := ripemd160.New()
+	hasher.Write(b.popStack())
+	b.stack = append(b.stack, hasher.Sum(nil))
+	return true
+}
+
 // Pops and returns the top element from the stack
 func (b *BitcoinOpCode) popStack() []byte {
 	elem := b.stack[len(b.stack)-1]
@@ -465,11 +842,12 @@ func (b *BitcoinOpCode) popStack() []byte {
 
 // Implements OP_CHECKMULTISIG by verifying multiple signatures against a set of public keys
 func (b *BitcoinOpCode) opCheckMultiSig(zBin []byte) bool {
-	if len(b.stack) < 1 {
+	// read the top element to get the number of public keys
+	keyCount, ok := b.popNum()
+	if !ok || keyCount < 0 || keyCount > MAX_PUBKEYS_PER_MULTISIG {
 		return false
 	}
-	// read the top element to get the number of public keys
-	pubKeyCounts := int(b.DecodeNum(b.popStack()))
+	pubKeyCounts := int(keyCount)
 	if len(b.stack) < pubKeyCounts+1 {
 		return false
 	}
@@ -480,46 +858,76 @@ func (b *BitcoinOpCode) opCheckMultiSig(zBin []byte) bool {
 	}
 
 	// get the number of signatures
-	sigCounts := int(b.DecodeNum(b.popStack()))
+	sigCount, ok := b.popNum()
+	if !ok || sigCount < 0 || sigCount > keyCount {
+		return false
+	}
+	sigCounts := int(sigCount)
 	if len(b.stack) < sigCounts+1 {
 		return false
 	}
 
 	derSignatures := make([][]byte, 0)
+	hashTypes := make([]byte, 0)
 	for i := 0; i < sigCounts; i++ {
 		signature := b.popStack()
+		if len(signature) == 0 {
+			return false
+		}
 		// remove last byte, it is hash type
+		hashTypes = append(hashTypes, signature[len(signature)-1])
 		signature = signature[0 : len(signature)-1]
 		derSignatures = append(derSignatures, signature)
 	}
+	// OP_CHECKMULTISIG pops one extra element because of an off-by-one bug in the original client,
+	// BIP-147 requires it to be empty
+	if len(b.popStack()) != 0 {
+		return false
+	}
 
 	points := make([]*ecc.Point, 0)
 	sigs := make([]*ecc.Signature, 0)
 	for i := 0; i < pubKeyCounts; i++ {
-		points = append(points, ecc.ParseSEC(secPubKeys[i]))
+		// a key that does not parse matches no signature
+		point, err := ecc.ParseSEC(secPubKeys[i])
+		if err != nil {
+			point = nil
+		}
+		points = append(points, point)
 	}
+	zFields := make([]*ecc.FieldElement, 0)
 	for i := 0; i < sigCounts; i++ {
-		sigs = append(sigs, ecc.ParseSigBin(derSignatures[i]))
-	}
-
-	z := new(big.Int)
-	z.SetBytes(zBin)
-	n := ecc.GetBitcoinValueN()
-	zField := ecc.NewFieldElement(n, z)
-
-	for _, sig := range sigs {
-		if len(points) == 0 {
+		sig, err := ecc.ParseSigBin(derSignatures[i])
+		if err != nil {
+			return false
+		}
+		sigs = append(sigs, sig)
+		zField, ok := b.signatureHash(hashTypes[i], zBin)
+		if !ok {
 			return false
 		}
+		zFields = append(zFields, zField)
+	}
+
+	// signatures must match public keys in the same order, each key is tried at most once
+	matched := 0
+	for i, sig := range sigs {
+		zField := zFields[i]
 		for len(points) > 0 {
 			point := points[0]
 			points = points[1:]
-			if point.Verify(zField, sig) {
+			if point != nil && point.Verify(zField, sig) {
+				matched += 1
 				break
 			}
 		}
 	}
-	b.stack = append(b.stack, b.EncodeNum(1))
+
+	if matched == len(sigs) {
+		b.stack = append(b.stack, b.EncodeNum(1))
+	} else {
+		b.stack = append(b.stack, b.EncodeNum(0))
+	}
 	return true
 }
 
@@ -532,15 +940,29 @@ func (b *BitcoinOpCode) opCheckSig(zBin []byte) bool {
 	pubKey := b.stack[len(b.stack)-1]
 	b.stack = b.stack[0 : len(b.stack)-1]
 	derSig := b.stack[len(b.stack)-1]
-	derSig = derSig[0 : len(derSig)-1]
 	b.stack = b.stack[0 : len(b.stack)-1]
-	point := ecc.ParseSEC(pubKey)
-	sig := ecc.ParseSigBin(derSig)
+	if len(derSig) == 0 {
+		// an empty signature is a valid way to make OP_CHECKSIG fail
+		b.stack = append(b.stack, b.EncodeNum(0))
+		return true
+	}
+	hashType := derSig[len(derSig)-1]
+	derSig = derSig[0 : len(derSig)-1]
+	sig, err := ecc.ParseSigBin(derSig)
+	if err != nil {
+		return false
+	}
+	point, err := ecc.ParseSEC(pubKey)
+	if err != nil {
+		// a key that does not parse makes the check fail, not the script
+		b.stack = append(b.stack, b.EncodeNum(0))
+		return true
+	}
 
-	z := new(big.Int)
-	z.SetBytes(zBin)
-	n := ecc.GetBitcoinValueN()
-	zField := ecc.NewFieldElement(n, z)
+	zField, ok := b.signatureHash(hashType, zBin)
+	if !ok {
+		return false
+	}
 
 	if point.Verify(zField, sig) == true {
 		b.stack = append(b.stack, b.EncodeNum(1))
@@ -551,12 +973,27 @@ func (b *BitcoinOpCode) opCheckSig(zBin []byte) bool {
 	return true
 }
 
+// signatureHash returns the message a signature with the given hash type commits to, as a field element
+func (b *BitcoinOpCode) signatureHash(hashType byte, zBin []byte) (*ecc.FieldElement, bool) {
+	if b.sigHash != nil {
+		var err error
+		zBin, err = b.sigHash(hashType, InitScriptSig(b.scriptCode).Serialize())
+		if err != nil {
+			return nil, false
+		}
+	}
+
+	z := new(big.Int)
+	z.SetBytes(zBin)
+	return ecc.NewFieldElement(ecc.GetBitcoinValueN(), z), true
+}
+
 // Executes a P2SH script by validating the redeem script hash and then running the redeem script
 func (b *BitcoinOpCode) opP2sh() bool {
 	// the first command is OP_HASH160
 	b.RemoveCmd()
 	// the second element is a data chunk of hash
-	h160 := b.RemoveCmd()
+	h160 := b.RemoveCmd().data
 	// the third element is OP_EQUAL
 	b.RemoveCmd()
 
@@ -577,40 +1014,103 @@ func (b *BitcoinOpCode) opP2sh() bool {
 	}
 
 	// parse the redeemscript and append its command for handling
-	scriptReader := bytes.NewReader(redeemScriptBinary)
-	redeemScriptSig := NewScriptSig(bufio.NewReader(scriptReader))
-	b.cmds = append(b.cmds, redeemScriptSig.cmds...)
-	return true
+	b.scriptExpanded = true
+	redeemScriptSig, err := parseScriptBytes(redeemScriptBinary)
+	if err != nil {
+		return false
+	}
+	b.cmds = append(b.cmds, redeemScriptSig.bitcoinOpCode.cmds...)
+	// the redeem script may itself be a witness program (P2SH-P2WPKH or P2SH-P2WSH), the
+	// scriptSig of which may push nothing but the redeem script
+	if isWitnessProgram(b.cmds) && len(b.stack) != 0 {
+		return false
+	}
+	return b.handleSegwit()
 }
 
 // Checks whether the remaining commands match the standard P2SH script pattern (OP_HASH160 <hash> OP_EQUAL)
 func (b *BitcoinOpCode) isP2sh() bool {
-	if len(b.cmds[0]) != 1 && b.cmds[0][0] != OP_HASH160 {
+	return classifyCmds(b.cmds).class == SCRIPT_P2SH
+}
+
+// isWitnessProgram checks whether the commands are a version 0 witness program, OP_0 and a push
+// of 2 to 40 bytes
+func isWitnessProgram(cmds []ScriptCmd) bool {
+	if len(cmds) != 2 || !isOp(cmds[0], OP_0) || cmds[1].IsOpCode() || !isDirectPush(cmds[1]) {
 		return false
 	}
+	return len(cmds[1].data) >= 2 && len(cmds[1].data) <= 40
+}
 
-	if len(b.cmds[1]) == 1 {
-		return false
+// handleSegwit expands a version 0 witness program into the commands that verify its witness
+func (b *BitcoinOpCode) handleSegwit() bool {
+	if !isWitnessProgram(b.cmds) {
+		return true
+	}
+
+	for _, element := range b.witness {
+		if len(element) > MAX_SCRIPT_ELEMENT_SIZE {
+			return false
+		}
+	}
+
+	b.witnessExpanded = true
+	switch len(b.cmds[1].data) {
+	case 20:
+		return b.handleP2wpkh()
+	case 32:
+		return b.handleP2wsh()
 	}
 
-	if len(b.cmds[2]) != 1 && b.cmds[2][0] != OP_EQUAL {
+	// version 0 only defines 20 and 32 byte programs
+	return false
+}
+
+// handleP2wpkh detects and expands a P2WPKH script into equivalent P2PKH commands for execution
+func (b *BitcoinOpCode) handleP2wpkh() bool {
+	// remove OP_0
+	b.RemoveCmd()
+	h160 := b.RemoveCmd().data
+
+	// the witness is exactly a signature and a pubkey
+	if len(b.witness) != 2 {
 		return false
 	}
 
+	// set up signature and pubkey
+	b.stack = append(b.stack, b.witness...)
+	// set up p2pk verify command
+	p2pkh := P2pkScript(h160)
+	b.scriptCode = p2pkh.bitcoinOpCode.cmds
+	b.cmds = append(b.cmds, p2pkh.bitcoinOpCode.cmds...)
 	return true
 }
 
-// handleP2wpkh detects and expands a P2WPKH script into equivalent P2PKH commands for execution
-func (b *BitcoinOpCode) handleP2wpkh() {
-	if len(b.cmds) == 2 && b.cmds[0][0] == OP_0 && len(b.cmds[1]) == 20 {
-		b.RemoveCmd()
-		// remove OP_0
-		h160 := b.RemoveCmd()
-
-		// set up signature and pubkey
-		b.cmds = append(b.cmds, b.witness...)
-		// set up p2pk verify command
-		p2sh := P2pkScript(h160)
-		b.cmds = append(b.cmds, p2sh.bitcoinOpCode.cmds...)
+// handleP2wsh checks the witness script against the program and expands it for execution
+func (b *BitcoinOpCode) handleP2wsh() bool {
+	// remove OP_0
+	b.RemoveCmd()
+	s256 := b.RemoveCmd().data
+
+	if len(b.witness) == 0 {
+		return false
 	}
+
+	// the last witness item is the witness script, the rest are its arguments
+	witnessScript := b.witness[len(b.witness)-1]
+	witnessScriptHash := sha256.Sum256(witnessScript)
+	if bytes.Equal(witnessScriptHash[:], s256) != true {
+		return false
+	}
+
+	witnessScriptSig, err := parseScriptBytes(witnessScript)
+	if err != nil {
+		return false
+	}
+
+	b.scriptExpanded = true
+	b.stack = append(b.stack, b.witness[:len(b.witness)-1]...)
+	b.scriptCode = witnessScriptSig.bitcoinOpCode.cmds
+	b.cmds = append(b.cmds, witnessScriptSig.bitcoinOpCode.cmds...)
+	return true
 }
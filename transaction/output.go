@@ -1,38 +1,40 @@
 package transaction
 
 import (
-	"bufio"
+	"encoding/binary"
 	"fmt"
 	"io"
-	"math/big"
 )
 
 // Represents a transaction output
 type TransactionOutput struct {
-	amount       *big.Int
+	amount       Amount
 	scriptPubKey *ScriptSig
 }
 
 // Creates a new transaction output from a binary reader
-func NewTransactionOutput(reader *bufio.Reader) *TransactionOutput {
-	amountBuf := make([]byte, 8)
-	io.ReadFull(reader, amountBuf)
-	amount := LittleEndianToBigInt(amountBuf, LITTLE_ENDIAN_8_BYTES)
-	script := NewScriptSig(reader)
-	return &TransactionOutput{
-		amount:       amount,
-		scriptPubKey: script,
-	}
+func NewTransactionOutput(reader io.Reader) (*TransactionOutput, error) {
+	return newTxDecoder(reader).output()
 }
 
 // InitTransactionOutput creates a new transaction output with the given amount and locking script
-func InitTransactionOutput(amount *big.Int, script *ScriptSig) *TransactionOutput {
+func InitTransactionOutput(amount Amount, script *ScriptSig) *TransactionOutput {
 	return &TransactionOutput{
 		amount:       amount,
 		scriptPubKey: script,
 	}
 }
 
+// Amount returns the value of the output
+func (t *TransactionOutput) Amount() Amount {
+	return t.amount
+}
+
+// SetAmount sets the value of the output
+func (t *TransactionOutput) SetAmount(amount Amount) {
+	t.amount = amount
+}
+
 // String returns a human-readable representation of the transaction output
 func (t *TransactionOutput) String() string {
 	return fmt.Sprintf("amount: %v\n scriptPubKey: %x\n", t.amount,
@@ -42,8 +44,7 @@ func (t *TransactionOutput) String() string {
 // Serialize converts the TransactionOutput into raw bytes
 func (t *TransactionOutput) Serialize() []byte {
 	result := make([]byte, 0)
-	result = append(result,
-		BigIntToLittleEndian(t.amount, LITTLE_ENDIAN_8_BYTES)...)
+	result = binary.LittleEndian.AppendUint64(result, uint64(t.amount))
 	result = append(result, t.scriptPubKey.Serialize()...)
 	return result
 }
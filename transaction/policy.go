@@ -0,0 +1,338 @@
+package transaction
+
+import (
+	"fmt"
+)
+
+const (
+	TX_MIN_STANDARD_VERSION = 1
+	TX_MAX_STANDARD_VERSION = 3
+
+	MAX_STANDARD_TX_WEIGHT = 400000
+	// transactions this small could be confused with a 64 byte merkle tree node
+	MIN_STANDARD_TX_NONWITNESS_SIZE = 65
+	// enough for a 15-of-15 P2SH multisig with compressed keys
+	MAX_STANDARD_SCRIPTSIG_SIZE = 1650
+	// OP_RETURN, a push opcode and 80 bytes of data
+	MAX_OP_RETURN_RELAY = 83
+
+	// bare multisig outputs are relayed up to 3-of-3
+	MAX_BARE_MULTISIG_KEYS = 3
+
+	MAX_STANDARD_P2WSH_SCRIPT_SIZE         = 3600
+	MAX_STANDARD_P2WSH_STACK_ITEMS         = 100
+	MAX_STANDARD_P2WSH_STACK_ITEM_SIZE     = 80
+	MAX_STANDARD_TAPSCRIPT_STACK_ITEM_SIZE = 80
+
+	MAX_STANDARD_TX_SIGOPS_COST = MAX_BLOCK_SIGOPS_COST / 5
+	MAX_P2SH_SIGOPS             = 15
+)
+
+// reject reasons, spelled the way Bitcoin Core reports them
+const (
+	POLICY_VERSION             = "version"
+	POLICY_TX_SIZE             = "tx-size"
+	POLICY_TX_SIZE_SMALL       = "tx-size-small"
+	POLICY_SCRIPTSIG_SIZE      = "scriptsig-size"
+	POLICY_SCRIPTSIG_NOT_PUSH  = "scriptsig-not-pushonly"
+	POLICY_SCRIPTPUBKEY        = "scriptpubkey"
+	POLICY_BARE_MULTISIG       = "bare-multisig"
+	POLICY_DUST                = "dust"
+	POLICY_MULTI_OP_RETURN     = "multi-op-return"
+	POLICY_NONSTANDARD_INPUTS  = "bad-txns-nonstandard-inputs"
+	POLICY_NONSTANDARD_WITNESS = "bad-witness-nonstandard"
+	POLICY_TOO_MANY_SIGOPS     = "bad-txns-too-many-sigops"
+)
+
+// POLICY_NO_INDEX marks a violation that is not about a single input or output
+const POLICY_NO_INDEX = -1
+
+// PolicyViolation is one reason default nodes would refuse to relay a transaction
+type PolicyViolation struct {
+	reason string
+	detail string
+	// input and output point at the offending input or output, POLICY_NO_INDEX when it is the whole transaction
+	input  int
+	output int
+}
+
+// Reason returns the reject reason Bitcoin Core would give
+func (v *PolicyViolation) Reason() string {
+	return v.reason
+}
+
+// Detail explains what exactly broke the rule
+func (v *PolicyViolation) Detail() string {
+	return v.detail
+}
+
+// Input returns the index of the offending input, POLICY_NO_INDEX when the violation is not about an input
+func (v *PolicyViolation) Input() int {
+	return v.input
+}
+
+// Output returns the index of the offending output, POLICY_NO_INDEX when the violation is not about an output
+func (v *PolicyViolation) Output() int {
+	return v.output
+}
+
+// String returns the reason followed by where and why
+func (v *PolicyViolation) String() string {
+	switch {
+	case v.input != POLICY_NO_INDEX:
+		return fmt.Sprintf("%s: input %d: %s", v.reason, v.input, v.detail)
+	case v.output != POLICY_NO_INDEX:
+		return fmt.Sprintf("%s: output %d: %s", v.reason, v.output, v.detail)
+	}
+	return fmt.Sprintf("%s: %s", v.reason, v.detail)
+}
+
+// StandardPolicy holds the relay settings a node can change, the defaults match Bitcoin Core
+type StandardPolicy struct {
+	dustRelayFeeRate   FeeRate
+	maxDataCarrierSize int
+	permitBareMultisig bool
+}
+
+// NewStandardPolicy creates the default relay policy
+func NewStandardPolicy() *StandardPolicy {
+	return &StandardPolicy{
+		dustRelayFeeRate:   DUST_RELAY_FEE_RATE,
+		maxDataCarrierSize: MAX_OP_RETURN_RELAY,
+		permitBareMultisig: true,
+	}
+}
+
+// SetDustRelayFeeRate sets the fee rate outputs have to be worth spending at
+func (p *StandardPolicy) SetDustRelayFeeRate(feeRate FeeRate) {
+	p.dustRelayFeeRate = feeRate
+}
+
+// SetMaxDataCarrierSize sets the largest OP_RETURN script relayed, zero rejects them all
+func (p *StandardPolicy) SetMaxDataCarrierSize(size int) {
+	p.maxDataCarrierSize = size
+}
+
+// SetPermitBareMultisig sets whether bare multisig outputs are relayed
+func (p *StandardPolicy) SetPermitBareMultisig(permit bool) {
+	p.permitBareMultisig = permit
+}
+
+// DustThreshold returns the smallest value an output with the script can have without being dust,
+// OP_RETURN outputs can never be spent so any value is fine for them
+func (p *StandardPolicy) DustThreshold(script *ScriptSig) Amount {
+	if script.Classify().Class() == SCRIPT_NULL_DATA {
+		return 0
+	}
+	return dustThreshold(script, p.dustRelayFeeRate)
+}
+
+// policyChecker collects the violations found while checking one transaction
+type policyChecker struct {
+	tx         *Transaction
+	policy     *StandardPolicy
+	violations []*PolicyViolation
+}
+
+func (c *policyChecker) txViolation(reason string, format string, args ...any) {
+	c.violations = append(c.violations, &PolicyViolation{reason: reason, detail: fmt.Sprintf(format, args...),
+		input: POLICY_NO_INDEX, output: POLICY_NO_INDEX})
+}
+
+func (c *policyChecker) inputViolation(idx int, reason string, format string, args ...any) {
+	c.violations = append(c.violations, &PolicyViolation{reason: reason, detail: fmt.Sprintf(format, args...),
+		input: idx, output: POLICY_NO_INDEX})
+}
+
+func (c *policyChecker) outputViolation(idx int, reason string, format string, args ...any) {
+	c.violations = append(c.violations, &PolicyViolation{reason: reason, detail: fmt.Sprintf(format, args...),
+		input: POLICY_NO_INDEX, output: idx})
+}
+
+// CheckStandard lists every reason default nodes would refuse to relay the transaction, an empty list
+// means it is standard. A nil policy uses the defaults. Inputs are checked against the outputs they
+// spend, the error reports a previous output that could not be found
+func (t *Transaction) CheckStandard(policy *StandardPolicy) ([]*PolicyViolation, error) {
+	if policy == nil {
+		policy = NewStandardPolicy()
+	}
+	c := &policyChecker{tx: t, policy: policy}
+
+	c.checkTransaction()
+	c.checkOutputs()
+	if t.IsCoinBase() {
+		return c.violations, nil
+	}
+
+	prevOutputs, err := t.spentOutputs()
+	if err != nil {
+		return nil, err
+	}
+	for i, txInput := range t.txInputs {
+		c.checkScriptSig(i, txInput)
+		c.checkInput(i, txInput, prevOutputs[i].scriptPubKey)
+		c.checkWitness(i, txInput, prevOutputs[i].scriptPubKey)
+	}
+
+	if cost := t.sigOpCost(prevOutputs); cost > MAX_STANDARD_TX_SIGOPS_COST {
+		c.txViolation(POLICY_TOO_MANY_SIGOPS, "sigop cost %d exceeds %d", cost, MAX_STANDARD_TX_SIGOPS_COST)
+	}
+
+	return c.violations, nil
+}
+
+// IsStandard checks the transaction would be relayed by default nodes
+func (t *Transaction) IsStandard(policy *StandardPolicy) (bool, error) {
+	violations, err := t.CheckStandard(policy)
+	if err != nil {
+		return false, err
+	}
+	return len(violations) == 0, nil
+}
+
+// checkTransaction applies the rules on the transaction as a whole
+func (c *policyChecker) checkTransaction() {
+	if c.tx.version < TX_MIN_STANDARD_VERSION || c.tx.version > TX_MAX_STANDARD_VERSION {
+		c.txViolation(POLICY_VERSION, "version %d outside %d..%d", c.tx.version,
+			TX_MIN_STANDARD_VERSION, TX_MAX_STANDARD_VERSION)
+	}
+	if weight := c.tx.Weight(); weight > MAX_STANDARD_TX_WEIGHT {
+		c.txViolation(POLICY_TX_SIZE, "weight %d exceeds %d", weight, MAX_STANDARD_TX_WEIGHT)
+	}
+	if size := c.tx.StrippedSize(); size < MIN_STANDARD_TX_NONWITNESS_SIZE {
+		c.txViolation(POLICY_TX_SIZE_SMALL, "non-witness size %d below %d", size, MIN_STANDARD_TX_NONWITNESS_SIZE)
+	}
+}
+
+// checkOutputs allows only standard templates, at most one small OP_RETURN and no dust
+func (c *policyChecker) checkOutputs() {
+	dataOutputs := 0
+	for i, txOutput := range c.tx.txOutputs {
+		template := txOutput.scriptPubKey.Classify()
+		switch template.Class() {
+		case SCRIPT_NONSTANDARD:
+			c.outputViolation(i, POLICY_SCRIPTPUBKEY, "nonstandard script")
+			continue
+		case SCRIPT_NULL_DATA:
+			dataOutputs++
+			if size := len(txOutput.scriptPubKey.rawSerialize()); size > c.policy.maxDataCarrierSize {
+				c.outputViolation(i, POLICY_SCRIPTPUBKEY, "OP_RETURN script of %d bytes exceeds %d", size,
+					c.policy.maxDataCarrierSize)
+			}
+			continue
+		case SCRIPT_P2MS:
+			if n := len(template.PubKeys()); n > MAX_BARE_MULTISIG_KEYS {
+				c.outputViolation(i, POLICY_SCRIPTPUBKEY, "bare multisig with %d keys exceeds %d", n,
+					MAX_BARE_MULTISIG_KEYS)
+				continue
+			}
+			if !c.policy.permitBareMultisig {
+				c.outputViolation(i, POLICY_BARE_MULTISIG, "bare multisig is not relayed")
+				continue
+			}
+		}
+
+		if threshold := c.policy.DustThreshold(txOutput.scriptPubKey); txOutput.amount < threshold {
+			c.outputViolation(i, POLICY_DUST, "%s %s output below the dust threshold of %s",
+				txOutput.amount, template.Class(), threshold)
+		}
+	}
+
+	if dataOutputs > 1 {
+		c.txViolation(POLICY_MULTI_OP_RETURN, "%d OP_RETURN outputs, only one is relayed", dataOutputs)
+	}
+}
+
+// checkScriptSig limits the size of a scriptSig and allows nothing but pushes in it
+func (c *policyChecker) checkScriptSig(idx int, txInput *TransactionInput) {
+	if size := len(txInput.scriptSig.rawSerialize()); size > MAX_STANDARD_SCRIPTSIG_SIZE {
+		c.inputViolation(idx, POLICY_SCRIPTSIG_SIZE, "scriptSig of %d bytes exceeds %d", size, MAX_STANDARD_SCRIPTSIG_SIZE)
+	}
+	if !isPushOnly(txInput.scriptSig.bitcoinOpCode.cmds) {
+		c.inputViolation(idx, POLICY_SCRIPTSIG_NOT_PUSH, "scriptSig executes opcodes")
+	}
+}
+
+// checkInput requires the spent output to be standard and a P2SH redeem script to stay within MAX_P2SH_SIGOPS
+func (c *policyChecker) checkInput(idx int, txInput *TransactionInput, prevScript *ScriptSig) {
+	switch class := prevScript.Classify().Class(); class {
+	case SCRIPT_NONSTANDARD, SCRIPT_WITNESS_UNKNOWN:
+		c.inputViolation(idx, POLICY_NONSTANDARD_INPUTS, "spends a %s output", class)
+	case SCRIPT_P2SH:
+		redeem, ok := p2shRedeemScript(txInput.scriptSig)
+		if !ok {
+			c.inputViolation(idx, POLICY_NONSTANDARD_INPUTS, "P2SH spend without a redeem script")
+			return
+		}
+		if sigOps := redeem.SigOpCount(true); sigOps > MAX_P2SH_SIGOPS {
+			c.inputViolation(idx, POLICY_NONSTANDARD_INPUTS, "redeem script has %d sigops, at most %d allowed",
+				sigOps, MAX_P2SH_SIGOPS)
+		}
+	}
+}
+
+// checkWitness applies the P2WSH and tapscript stack limits, witnesses on anything but a witness
+// program and taproot annexes are not relayed
+func (c *policyChecker) checkWitness(idx int, txInput *TransactionInput, prevScript *ScriptSig) {
+	witness := txInput.witness
+	if len(witness) == 0 {
+		return
+	}
+
+	nested := false
+	if prevScript.Classify().Class() == SCRIPT_P2SH {
+		redeem, ok := p2shRedeemScript(txInput.scriptSig)
+		if !ok {
+			c.inputViolation(idx, POLICY_NONSTANDARD_WITNESS, "witness on a P2SH spend without a redeem script")
+			return
+		}
+		prevScript, nested = redeem, true
+	}
+
+	version, program, ok := prevScript.witnessProgram()
+	if !ok {
+		c.inputViolation(idx, POLICY_NONSTANDARD_WITNESS, "witness on a non-witness output")
+		return
+	}
+
+	switch {
+	case version == 0 && len(program) == 32:
+		script := witness[len(witness)-1]
+		if items := len(witness) - 1; items > MAX_STANDARD_P2WSH_STACK_ITEMS {
+			c.inputViolation(idx, POLICY_NONSTANDARD_WITNESS, "%d P2WSH stack items exceed %d", items,
+				MAX_STANDARD_P2WSH_STACK_ITEMS)
+		}
+		if len(script) > MAX_STANDARD_P2WSH_SCRIPT_SIZE {
+			c.inputViolation(idx, POLICY_NONSTANDARD_WITNESS, "witness script of %d bytes exceeds %d", len(script),
+				MAX_STANDARD_P2WSH_SCRIPT_SIZE)
+		}
+		for _, item := range witness[:len(witness)-1] {
+			if len(item) > MAX_STANDARD_P2WSH_STACK_ITEM_SIZE {
+				c.inputViolation(idx, POLICY_NONSTANDARD_WITNESS, "P2WSH stack item of %d bytes exceeds %d",
+					len(item), MAX_STANDARD_P2WSH_STACK_ITEM_SIZE)
+				break
+			}
+		}
+	case version == 1 && len(program) == 32 && !nested:
+		stack := witness
+		if len(stack) >= 2 && len(stack[len(stack)-1]) > 0 && stack[len(stack)-1][0] == TAPROOT_ANNEX_TAG {
+			c.inputViolation(idx, POLICY_NONSTANDARD_WITNESS, "taproot annex is not relayed")
+			return
+		}
+		if len(stack) < 2 {
+			// key path spends have no limits beyond consensus
+			return
+		}
+		control := stack[len(stack)-1]
+		if len(control) == 0 || control[0]&TAPROOT_LEAF_MASK != TAPROOT_LEAF_TAPSCRIPT {
+			return
+		}
+		for _, item := range stack[:len(stack)-2] {
+			if len(item) > MAX_STANDARD_TAPSCRIPT_STACK_ITEM_SIZE {
+				c.inputViolation(idx, POLICY_NONSTANDARD_WITNESS, "tapscript stack item of %d bytes exceeds %d",
+					len(item), MAX_STANDARD_TAPSCRIPT_STACK_ITEM_SIZE)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package transaction
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+
+	"github.com/sudonite/bitcoin/chaincfg"
+)
+
+// policyKeys returns n dummy compressed keys, the policy only looks at their size
+func policyKeys(n int) [][]byte {
+	keys := make([][]byte, n)
+	for i := range keys {
+		keys[i] = append([]byte{0x02}, bytes.Repeat([]byte{byte(i + 1)}, 32)...)
+	}
+	return keys
+}
+
+// policyBuilder starts a standard transaction spending one P2WPKH output to another
+func policyBuilder(value Amount) *TransactionBuilder {
+	b := NewTransactionBuilder(&chaincfg.RegTestParams)
+	b.AddInput(bytes.Repeat([]byte{1}, 32), 0, value, P2wpkhScript(make([]byte, 20)))
+	return b
+}
+
+func TestCheckStandard(t *testing.T) {
+	multisig := func(m, n int) *ScriptSig {
+		script, err := MultisigScript(m, policyKeys(n))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return script
+	}
+	nullData := func(size int) *ScriptSig {
+		return InitScriptSig([]ScriptCmd{OpCmd(OP_RETURN), pushCmd(bytes.Repeat([]byte{0xaa}, size))})
+	}
+	p2wpkh := P2wpkhScript(make([]byte, 20))
+	noBareMultisig := NewStandardPolicy()
+	noBareMultisig.SetPermitBareMultisig(false)
+
+	tests := []struct {
+		name   string
+		tx     func() *Transaction
+		policy *StandardPolicy
+		want   []string
+	}{
+		{"standard", func() *Transaction {
+			b := policyBuilder(100000)
+			b.AddOutput(90000, p2wpkh)
+			return b.Build()
+		}, nil, nil},
+		{"version", func() *Transaction {
+			b := policyBuilder(100000)
+			b.SetVersion(TX_MAX_STANDARD_VERSION + 1)
+			b.AddOutput(90000, p2wpkh)
+			return b.Build()
+		}, nil, []string{POLICY_VERSION}},
+		{"weight", func() *Transaction {
+			b := policyBuilder(10 * BTC)
+			for range MAX_STANDARD_TX_WEIGHT / WITNESS_SCALE_FACTOR / 31 {
+				b.AddOutput(1000, p2wpkh)
+			}
+			return b.Build()
+		}, nil, []string{POLICY_TX_SIZE}},
+		{"too small", func() *Transaction {
+			b := policyBuilder(100000)
+			b.AddOutput(0, InitScriptSig([]ScriptCmd{OpCmd(OP_RETURN)}))
+			return b.Build()
+		}, nil, []string{POLICY_TX_SIZE_SMALL}},
+		{"scriptSig size", func() *Transaction {
+			b := policyBuilder(100000)
+			b.AddOutput(90000, p2wpkh)
+			tx := b.Build()
+			big := bytes.Repeat([]byte{1}, MAX_STANDARD_SCRIPTSIG_SIZE/2)
+			tx.txInputs[0].SetScriptSig(InitScriptSig([]ScriptCmd{pushCmd(big), pushCmd(big)}))
+			return tx
+		}, nil, []string{POLICY_SCRIPTSIG_SIZE}},
+		{"scriptSig executes opcodes", func() *Transaction {
+			b := policyBuilder(100000)
+			b.AddOutput(90000, p2wpkh)
+			tx := b.Build()
+			tx.txInputs[0].SetScriptSig(InitScriptSig([]ScriptCmd{OpCmd(OP_1), OpCmd(OP_DROP)}))
+			return tx
+		}, nil, []string{POLICY_SCRIPTSIG_NOT_PUSH}},
+		{"nonstandard output", func() *Transaction {
+			b := policyBuilder(100000)
+			b.AddOutput(90000, InitScriptSig([]ScriptCmd{pushCmd(make([]byte, 20)), OpCmd(OP_DROP), OpCmd(OP_1)}))
+			return b.Build()
+		}, nil, []string{POLICY_SCRIPTPUBKEY}},
+		{"OP_RETURN too large", func() *Transaction {
+			b := policyBuilder(100000)
+			b.AddOutput(90000, p2wpkh)
+			b.AddOutput(0, nullData(MAX_OP_RETURN_RELAY-2))
+			return b.Build()
+		}, nil, []string{POLICY_SCRIPTPUBKEY}},
+		{"bare multisig with too many keys", func() *Transaction {
+			b := policyBuilder(100000)
+			b.AddOutput(90000, multisig(1, MAX_BARE_MULTISIG_KEYS+1))
+			return b.Build()
+		}, nil, []string{POLICY_SCRIPTPUBKEY}},
+		{"bare multisig not permitted", func() *Transaction {
+			b := policyBuilder(100000)
+			b.AddOutput(90000, multisig(1, 1))
+			return b.Build()
+		}, noBareMultisig, []string{POLICY_BARE_MULTISIG}},
+		{"dust", func() *Transaction {
+			b := policyBuilder(100000)
+			b.AddOutput(90000, p2wpkh)
+			b.AddOutput(100, p2wpkh)
+			return b.Build()
+		}, nil, []string{POLICY_DUST}},
+		{"several OP_RETURN outputs", func() *Transaction {
+			b := policyBuilder(100000)
+			b.AddOutput(90000, p2wpkh)
+			b.AddOutput(0, nullData(4))
+			b.AddOutput(0, nullData(4))
+			return b.Build()
+		}, nil, []string{POLICY_MULTI_OP_RETURN}},
+		{"spends a nonstandard output", func() *Transaction {
+			b := NewTransactionBuilder(&chaincfg.RegTestParams)
+			b.AddInput(bytes.Repeat([]byte{1}, 32), 0, 100000, InitScriptSig([]ScriptCmd{OpCmd(OP_1)}))
+			b.AddOutput(90000, p2wpkh)
+			return b.Build()
+		}, nil, []string{POLICY_NONSTANDARD_INPUTS}},
+		{"P2SH without a redeem script", func() *Transaction {
+			b := NewTransactionBuilder(&chaincfg.RegTestParams)
+			b.AddInput(bytes.Repeat([]byte{1}, 32), 0, 100000, P2shScript(make([]byte, 20)))
+			b.AddOutput(90000, p2wpkh)
+			return b.Build()
+		}, nil, []string{POLICY_NONSTANDARD_INPUTS}},
+		{"P2SH redeem script sigops", func() *Transaction {
+			redeem := make([]ScriptCmd, MAX_P2SH_SIGOPS+1)
+			for i := range redeem {
+				redeem[i] = OpCmd(OP_CHECKSIG)
+			}
+			b := NewTransactionBuilder(&chaincfg.RegTestParams)
+			b.AddInput(bytes.Repeat([]byte{1}, 32), 0, 100000, P2shScript(make([]byte, 20)))
+			b.AddOutput(90000, p2wpkh)
+			tx := b.Build()
+			tx.txInputs[0].SetScriptSig(InitScriptSig([]ScriptCmd{pushCmd(InitScriptSig(redeem).rawSerialize())}))
+			return tx
+		}, nil, []string{POLICY_NONSTANDARD_INPUTS}},
+		{"witness on a non-witness output", func() *Transaction {
+			b := NewTransactionBuilder(&chaincfg.RegTestParams)
+			b.AddInput(bytes.Repeat([]byte{1}, 32), 0, 100000, P2pkhScript(make([]byte, 20)))
+			b.AddOutput(90000, p2wpkh)
+			tx := b.Build()
+			tx.txInputs[0].SetWitness([][]byte{{1}})
+			return tx
+		}, nil, []string{POLICY_NONSTANDARD_WITNESS}},
+		{"P2WSH stack items", func() *Transaction {
+			b := NewTransactionBuilder(&chaincfg.RegTestParams)
+			b.AddInput(bytes.Repeat([]byte{1}, 32), 0, 100000, P2wshScript(make([]byte, 32)))
+			b.AddOutput(90000, p2wpkh)
+			tx := b.Build()
+			tx.txInputs[0].SetWitness(make([][]byte, MAX_STANDARD_P2WSH_STACK_ITEMS+2))
+			return tx
+		}, nil, []string{POLICY_NONSTANDARD_WITNESS}},
+		{"P2WSH script size", func() *Transaction {
+			b := NewTransactionBuilder(&chaincfg.RegTestParams)
+			b.AddInput(bytes.Repeat([]byte{1}, 32), 0, 100000, P2wshScript(make([]byte, 32)))
+			b.AddOutput(90000, p2wpkh)
+			tx := b.Build()
+			tx.txInputs[0].SetWitness([][]byte{make([]byte, MAX_STANDARD_P2WSH_SCRIPT_SIZE+1)})
+			return tx
+		}, nil, []string{POLICY_NONSTANDARD_WITNESS}},
+		{"P2WSH stack item size", func() *Transaction {
+			b := NewTransactionBuilder(&chaincfg.RegTestParams)
+			b.AddInput(bytes.Repeat([]byte{1}, 32), 0, 100000, P2wshScript(make([]byte, 32)))
+			b.AddOutput(90000, p2wpkh)
+			tx := b.Build()
+			tx.txInputs[0].SetWitness([][]byte{make([]byte, MAX_STANDARD_P2WSH_STACK_ITEM_SIZE+1), {OP_1}})
+			return tx
+		}, nil, []string{POLICY_NONSTANDARD_WITNESS}},
+		{"taproot annex", func() *Transaction {
+			b := NewTransactionBuilder(&chaincfg.RegTestParams)
+			b.AddInput(bytes.Repeat([]byte{1}, 32), 0, 100000, P2trScript(make([]byte, 32)))
+			b.AddOutput(90000, p2wpkh)
+			tx := b.Build()
+			tx.txInputs[0].SetWitness([][]byte{make([]byte, 64), {TAPROOT_ANNEX_TAG}})
+			return tx
+		}, nil, []string{POLICY_NONSTANDARD_WITNESS}},
+		{"tapscript stack item size", func() *Transaction {
+			b := NewTransactionBuilder(&chaincfg.RegTestParams)
+			b.AddInput(bytes.Repeat([]byte{1}, 32), 0, 100000, P2trScript(make([]byte, 32)))
+			b.AddOutput(90000, p2wpkh)
+			tx := b.Build()
+			control := append([]byte{TAPROOT_LEAF_TAPSCRIPT}, make([]byte, 32)...)
+			tx.txInputs[0].SetWitness([][]byte{make([]byte, MAX_STANDARD_TAPSCRIPT_STACK_ITEM_SIZE+1), {OP_1}, control})
+			return tx
+		}, nil, []string{POLICY_NONSTANDARD_WITNESS}},
+		{"too many sigops", func() *Transaction {
+			// a bare CHECKMULTISIG counts as 20 legacy sigops, each costing WITNESS_SCALE_FACTOR
+			b := policyBuilder(10 * BTC)
+			for range MAX_STANDARD_TX_SIGOPS_COST/(20*WITNESS_SCALE_FACTOR) + 1 {
+				b.AddOutput(1000, multisig(1, MAX_BARE_MULTISIG_KEYS))
+			}
+			return b.Build()
+		}, nil, []string{POLICY_TOO_MANY_SIGOPS}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violations, err := tt.tx().CheckStandard(tt.policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var reasons []string
+			for _, v := range violations {
+				reasons = append(reasons, v.Reason())
+			}
+			if !slices.Equal(reasons, tt.want) {
+				t.Fatalf("reasons %q, want %q (%v)", reasons, tt.want, violations)
+			}
+		})
+	}
+}
+
+func TestPolicyReasons(t *testing.T) {
+	// the reasons have to match what Bitcoin Core puts in its reject messages
+	reasons := map[string]string{
+		POLICY_VERSION:             "version",
+		POLICY_TX_SIZE:             "tx-size",
+		POLICY_TX_SIZE_SMALL:       "tx-size-small",
+		POLICY_SCRIPTSIG_SIZE:      "scriptsig-size",
+		POLICY_SCRIPTSIG_NOT_PUSH:  "scriptsig-not-pushonly",
+		POLICY_SCRIPTPUBKEY:        "scriptpubkey",
+		POLICY_BARE_MULTISIG:       "bare-multisig",
+		POLICY_DUST:                "dust",
+		POLICY_MULTI_OP_RETURN:     "multi-op-return",
+		POLICY_NONSTANDARD_INPUTS:  "bad-txns-nonstandard-inputs",
+		POLICY_NONSTANDARD_WITNESS: "bad-witness-nonstandard",
+		POLICY_TOO_MANY_SIGOPS:     "bad-txns-too-many-sigops",
+	}
+	for got, want := range reasons {
+		if got != want {
+			t.Errorf("reason %q, want %q", got, want)
+		}
+	}
+}
@@ -0,0 +1,1058 @@
+package transaction
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+)
+
+const (
+	PSBT_MAGIC = "psbt\xff"
+
+	PSBT_GLOBAL_UNSIGNED_TX       = 0x00
+	PSBT_GLOBAL_XPUB              = 0x01
+	PSBT_GLOBAL_TX_VERSION        = 0x02
+	PSBT_GLOBAL_FALLBACK_LOCKTIME = 0x03
+	PSBT_GLOBAL_INPUT_COUNT       = 0x04
+	PSBT_GLOBAL_OUTPUT_COUNT      = 0x05
+	PSBT_GLOBAL_TX_MODIFIABLE     = 0x06
+	PSBT_GLOBAL_VERSION           = 0xfb
+
+	PSBT_IN_NON_WITNESS_UTXO         = 0x00
+	PSBT_IN_WITNESS_UTXO             = 0x01
+	PSBT_IN_PARTIAL_SIG              = 0x02
+	PSBT_IN_SIGHASH_TYPE             = 0x03
+	PSBT_IN_REDEEM_SCRIPT            = 0x04
+	PSBT_IN_WITNESS_SCRIPT           = 0x05
+	PSBT_IN_BIP32_DERIVATION         = 0x06
+	PSBT_IN_FINAL_SCRIPTSIG          = 0x07
+	PSBT_IN_FINAL_SCRIPTWITNESS      = 0x08
+	PSBT_IN_PREVIOUS_TXID            = 0x0e
+	PSBT_IN_OUTPUT_INDEX             = 0x0f
+	PSBT_IN_SEQUENCE                 = 0x10
+	PSBT_IN_REQUIRED_TIME_LOCKTIME   = 0x11
+	PSBT_IN_REQUIRED_HEIGHT_LOCKTIME = 0x12
+
+	PSBT_OUT_REDEEM_SCRIPT    = 0x00
+	PSBT_OUT_WITNESS_SCRIPT   = 0x01
+	PSBT_OUT_BIP32_DERIVATION = 0x02
+	PSBT_OUT_AMOUNT           = 0x03
+	PSBT_OUT_SCRIPT           = 0x04
+
+	// bits of PSBT_GLOBAL_TX_MODIFIABLE
+	PSBT_INPUTS_MODIFIABLE  = 0x01
+	PSBT_OUTPUTS_MODIFIABLE = 0x02
+	PSBT_SIGHASH_SINGLE     = 0x04
+
+	// length of a serialized BIP-32 extended public key
+	BIP32_XPUB_LENGTH = 78
+)
+
+var (
+	ErrPsbtFormat     = errors.New("invalid psbt")
+	ErrPsbtVersion    = errors.New("unsupported psbt version")
+	ErrPsbtMismatch   = errors.New("psbts describe different transactions")
+	ErrPsbtNotFinal   = errors.New("psbt input is not finalized")
+	ErrPsbtModifiable = errors.New("psbt does not allow adding inputs or outputs")
+	ErrPsbtLockTime   = errors.New("psbt inputs have incompatible lock time requirements")
+)
+
+// Bip32Derivation is the master key fingerprint and derivation path of a public key
+type Bip32Derivation struct {
+	fingerprint []byte
+	path        []uint32
+}
+
+// NewBip32Derivation creates a derivation record from a 4 byte fingerprint and a path, hardened indexes have bit 31 set
+func NewBip32Derivation(fingerprint []byte, path []uint32) (*Bip32Derivation, error) {
+	if len(fingerprint) != 4 {
+		return nil, fmt.Errorf("%w: fingerprint length %d", ErrPsbtFormat, len(fingerprint))
+	}
+	return &Bip32Derivation{
+		fingerprint: fingerprint,
+		path:        path,
+	}, nil
+}
+
+// Fingerprint returns the fingerprint of the master key
+func (d *Bip32Derivation) Fingerprint() []byte {
+	return d.fingerprint
+}
+
+// Path returns the derivation path from the master key
+func (d *Bip32Derivation) Path() []uint32 {
+	return d.path
+}
+
+// serialize encodes the fingerprint followed by each path index as 4 little endian bytes
+func (d *Bip32Derivation) serialize() []byte {
+	result := append([]byte{}, d.fingerprint...)
+	for _, index := range d.path {
+		result = binary.LittleEndian.AppendUint32(result, index)
+	}
+	return result
+}
+
+// parseBip32Derivation decodes the value of a BIP-32 derivation field
+func parseBip32Derivation(value []byte) (*Bip32Derivation, error) {
+	if len(value) < 4 || len(value)%4 != 0 {
+		return nil, fmt.Errorf("%w: derivation length %d", ErrPsbtFormat, len(value))
+	}
+
+	path := make([]uint32, 0, len(value)/4-1)
+	for i := 4; i < len(value); i += 4 {
+		path = append(path, binary.LittleEndian.Uint32(value[i:]))
+	}
+	return NewBip32Derivation(value[:4], path)
+}
+
+// psbtKeyValue is a raw map entry, used to keep unknown fields
+type psbtKeyValue struct {
+	key   []byte
+	value []byte
+}
+
+// PsbtInput holds everything known about one input of a partially signed transaction
+type PsbtInput struct {
+	previousTxID           []byte
+	outputIndex            uint32
+	sequence               uint32
+	requiredTimeLockTime   uint32
+	requiredHeightLockTime uint32
+
+	nonWitnessUtxo     *Transaction
+	witnessUtxo        *TransactionOutput
+	partialSigs        map[string][]byte
+	sighashType        uint32
+	hasSighashType     bool
+	redeemScript       []byte
+	witnessScript      []byte
+	bip32Derivation    map[string]*Bip32Derivation
+	finalScriptSig     []byte
+	finalScriptWitness [][]byte
+	unknown            []psbtKeyValue
+}
+
+// newPsbtInput creates an input spending prevIndex of prevTxID
+func newPsbtInput(prevTxID []byte, prevIndex uint32, sequence uint32) *PsbtInput {
+	return &PsbtInput{
+		previousTxID:    prevTxID,
+		outputIndex:     prevIndex,
+		sequence:        sequence,
+		partialSigs:     make(map[string][]byte),
+		bip32Derivation: make(map[string]*Bip32Derivation),
+	}
+}
+
+// isFinalized reports whether the finalizer already produced the scriptSig or witness
+func (in *PsbtInput) isFinalized() bool {
+	return in.finalScriptSig != nil || in.finalScriptWitness != nil
+}
+
+// PsbtOutput holds everything known about one output of a partially signed transaction
+type PsbtOutput struct {
+	amount          Amount
+	script          []byte
+	redeemScript    []byte
+	witnessScript   []byte
+	bip32Derivation map[string]*Bip32Derivation
+	unknown         []psbtKeyValue
+}
+
+// newPsbtOutput creates an output paying amount to the raw script
+func newPsbtOutput(amount Amount, script []byte) *PsbtOutput {
+	return &PsbtOutput{
+		amount:          amount,
+		script:          script,
+		bip32Derivation: make(map[string]*Bip32Derivation),
+	}
+}
+
+// Psbt is a Partially Signed Bitcoin Transaction as defined by BIP-174 (version 0) and BIP-370 (version 2)
+type Psbt struct {
+	version   uint32
+	txVersion uint32
+	// nLockTime for version 0, the fallback lock time for version 2
+	lockTime            uint32
+	hasFallbackLockTime bool
+	txModifiable        byte
+	xpubs               map[string]*Bip32Derivation
+	inputs              []*PsbtInput
+	outputs             []*PsbtOutput
+	unknown             []psbtKeyValue
+}
+
+// NewPsbt creates a version 0 PSBT from an unsigned transaction
+func NewPsbt(tx *Transaction) (*Psbt, error) {
+	p := &Psbt{
+		txVersion: uint32(tx.version),
+		lockTime:  tx.lockTime,
+		xpubs:     make(map[string]*Bip32Derivation),
+	}
+
+	for _, txInput := range tx.txInputs {
+		if (txInput.scriptSig != nil && len(txInput.scriptSig.rawSerialize()) > 0) || len(txInput.witness) > 0 {
+			return nil, fmt.Errorf("%w: unsigned transaction has a scriptSig or witness", ErrPsbtFormat)
+		}
+		p.inputs = append(p.inputs, newPsbtInput(txInput.previousTransactionID,
+			txInput.previousTransactionIndex, txInput.Sequence()))
+	}
+	for _, txOutput := range tx.txOutputs {
+		p.outputs = append(p.outputs, newPsbtOutput(txOutput.amount, txOutput.scriptPubKey.rawSerialize()))
+	}
+
+	return p, nil
+}
+
+// NewPsbtV2 creates an empty version 2 PSBT whose inputs and outputs can be added later
+func NewPsbtV2(txVersion uint32, fallbackLockTime uint32) *Psbt {
+	return &Psbt{
+		version:             2,
+		txVersion:           txVersion,
+		lockTime:            fallbackLockTime,
+		hasFallbackLockTime: true,
+		txModifiable:        PSBT_INPUTS_MODIFIABLE | PSBT_OUTPUTS_MODIFIABLE,
+		xpubs:               make(map[string]*Bip32Derivation),
+	}
+}
+
+// Version returns the PSBT version, 0 or 2
+func (p *Psbt) Version() uint32 {
+	return p.version
+}
+
+// InputCount returns the number of inputs
+func (p *Psbt) InputCount() int {
+	return len(p.inputs)
+}
+
+// OutputCount returns the number of outputs
+func (p *Psbt) OutputCount() int {
+	return len(p.outputs)
+}
+
+// AddInput appends an input to a version 2 PSBT, returns the index of the new input
+func (p *Psbt) AddInput(prevTxID []byte, prevIndex uint32, sequence uint32) (int, error) {
+	if p.version != 2 || p.txModifiable&PSBT_INPUTS_MODIFIABLE == 0 {
+		return 0, ErrPsbtModifiable
+	}
+	if len(prevTxID) != 32 {
+		return 0, fmt.Errorf("%w: previous txid length %d", ErrPsbtFormat, len(prevTxID))
+	}
+
+	p.inputs = append(p.inputs, newPsbtInput(prevTxID, prevIndex, sequence))
+	return len(p.inputs) - 1, nil
+}
+
+// AddOutput appends an output to a version 2 PSBT, returns the index of the new output
+func (p *Psbt) AddOutput(amount Amount, script *ScriptSig) (int, error) {
+	if p.version != 2 || p.txModifiable&PSBT_OUTPUTS_MODIFIABLE == 0 {
+		return 0, ErrPsbtModifiable
+	}
+
+	p.outputs = append(p.outputs, newPsbtOutput(amount, script.rawSerialize()))
+	return len(p.outputs) - 1, nil
+}
+
+// SetTxModifiable sets the PSBT_GLOBAL_TX_MODIFIABLE flags of a version 2 PSBT
+func (p *Psbt) SetTxModifiable(flags byte) error {
+	if p.version != 2 {
+		return ErrPsbtVersion
+	}
+	p.txModifiable = flags
+	return nil
+}
+
+// input returns the input at inputIdx or an error
+func (p *Psbt) input(inputIdx int) (*PsbtInput, error) {
+	if inputIdx < 0 || inputIdx >= len(p.inputs) {
+		return nil, fmt.Errorf("invalid index %d for psbt input", inputIdx)
+	}
+	return p.inputs[inputIdx], nil
+}
+
+// output returns the output at outputIdx or an error
+func (p *Psbt) output(outputIdx int) (*PsbtOutput, error) {
+	if outputIdx < 0 || outputIdx >= len(p.outputs) {
+		return nil, fmt.Errorf("invalid index %d for psbt output", outputIdx)
+	}
+	return p.outputs[outputIdx], nil
+}
+
+// SetRequiredLockTime records the lock time an input of a version 2 PSBT needs,
+// values below LOCKTIME_THRESHOLD are heights and the others timestamps
+func (p *Psbt) SetRequiredLockTime(inputIdx int, lockTime uint32) error {
+	if p.version != 2 {
+		return ErrPsbtVersion
+	}
+	in, err := p.input(inputIdx)
+	if err != nil {
+		return err
+	}
+
+	if lockTime < LOCKTIME_THRESHOLD {
+		in.requiredHeightLockTime = lockTime
+	} else {
+		in.requiredTimeLockTime = lockTime
+	}
+	return nil
+}
+
+// SetNonWitnessUtxo attaches the full previous transaction of an input
+func (p *Psbt) SetNonWitnessUtxo(inputIdx int, prevTx *Transaction) error {
+	in, err := p.input(inputIdx)
+	if err != nil {
+		return err
+	}
+	if err := in.checkNonWitnessUtxo(prevTx); err != nil {
+		return err
+	}
+
+	in.nonWitnessUtxo = prevTx
+	return nil
+}
+
+// checkNonWitnessUtxo makes sure the previous transaction is the one the input spends
+func (in *PsbtInput) checkNonWitnessUtxo(prevTx *Transaction) error {
+	if !bytes.Equal(prevTx.Hash(), in.previousTxID) {
+		return fmt.Errorf("%w: previous transaction %x does not match input", ErrTxIDMismatch, prevTx.Hash())
+	}
+	if int(in.outputIndex) >= len(prevTx.txOutputs) {
+		return fmt.Errorf("%w: previous transaction has no output %d", ErrPsbtFormat, in.outputIndex)
+	}
+	return nil
+}
+
+// SetWitnessUtxo attaches the output spent by a segwit input
+func (p *Psbt) SetWitnessUtxo(inputIdx int, output *TransactionOutput) error {
+	in, err := p.input(inputIdx)
+	if err != nil {
+		return err
+	}
+
+	in.witnessUtxo = output
+	return nil
+}
+
+// SetSighashType sets the sighash type signers must use for an input
+func (p *Psbt) SetSighashType(inputIdx int, sighashType uint32) error {
+	in, err := p.input(inputIdx)
+	if err != nil {
+		return err
+	}
+
+	in.sighashType = sighashType
+	in.hasSighashType = true
+	return nil
+}
+
+// SetInputRedeemScript sets the P2SH redeem script of an input
+func (p *Psbt) SetInputRedeemScript(inputIdx int, redeemScript *ScriptSig) error {
+	in, err := p.input(inputIdx)
+	if err != nil {
+		return err
+	}
+
+	in.redeemScript = redeemScript.rawSerialize()
+	return nil
+}
+
+// SetInputWitnessScript sets the P2WSH witness script of an input
+func (p *Psbt) SetInputWitnessScript(inputIdx int, witnessScript *ScriptSig) error {
+	in, err := p.input(inputIdx)
+	if err != nil {
+		return err
+	}
+
+	in.witnessScript = witnessScript.rawSerialize()
+	return nil
+}
+
+// AddInputBip32Derivation records how the public key of an input was derived
+func (p *Psbt) AddInputBip32Derivation(inputIdx int, pubKey []byte, derivation *Bip32Derivation) error {
+	in, err := p.input(inputIdx)
+	if err != nil {
+		return err
+	}
+	if err := checkPsbtPubKey(pubKey); err != nil {
+		return err
+	}
+
+	in.bip32Derivation[string(pubKey)] = derivation
+	return nil
+}
+
+// SetOutputRedeemScript sets the redeem script of a P2SH output, used by signers to recognize change
+func (p *Psbt) SetOutputRedeemScript(outputIdx int, redeemScript *ScriptSig) error {
+	out, err := p.output(outputIdx)
+	if err != nil {
+		return err
+	}
+
+	out.redeemScript = redeemScript.rawSerialize()
+	return nil
+}
+
+// SetOutputWitnessScript sets the witness script of a P2WSH output
+func (p *Psbt) SetOutputWitnessScript(outputIdx int, witnessScript *ScriptSig) error {
+	out, err := p.output(outputIdx)
+	if err != nil {
+		return err
+	}
+
+	out.witnessScript = witnessScript.rawSerialize()
+	return nil
+}
+
+// AddOutputBip32Derivation records how a public key of an output was derived
+func (p *Psbt) AddOutputBip32Derivation(outputIdx int, pubKey []byte, derivation *Bip32Derivation) error {
+	out, err := p.output(outputIdx)
+	if err != nil {
+		return err
+	}
+	if err := checkPsbtPubKey(pubKey); err != nil {
+		return err
+	}
+
+	out.bip32Derivation[string(pubKey)] = derivation
+	return nil
+}
+
+// AddXpub records an extended public key and the derivation of it from the master key
+func (p *Psbt) AddXpub(xpub []byte, derivation *Bip32Derivation) error {
+	if len(xpub) != BIP32_XPUB_LENGTH {
+		return fmt.Errorf("%w: xpub length %d", ErrPsbtFormat, len(xpub))
+	}
+
+	p.xpubs[string(xpub)] = derivation
+	return nil
+}
+
+// checkPsbtPubKey accepts compressed and uncompressed SEC public keys
+func checkPsbtPubKey(pubKey []byte) error {
+	if (len(pubKey) == 33 && (pubKey[0] == 0x02 || pubKey[0] == 0x03)) || (len(pubKey) == 65 && pubKey[0] == 0x04) {
+		return nil
+	}
+	return fmt.Errorf("%w: public key %x", ErrPsbtFormat, pubKey)
+}
+
+// txLockTime computes the nLockTime of the transaction, version 2 PSBTs derive it from the input requirements
+func (p *Psbt) txLockTime() (uint32, error) {
+	if p.version == 0 {
+		return p.lockTime, nil
+	}
+
+	heightOK, timeOK, constrained := true, true, false
+	height, time := uint32(0), uint32(0)
+	for _, in := range p.inputs {
+		if in.requiredHeightLockTime == 0 && in.requiredTimeLockTime == 0 {
+			continue
+		}
+		constrained = true
+		if in.requiredHeightLockTime == 0 {
+			heightOK = false
+		}
+		if in.requiredTimeLockTime == 0 {
+			timeOK = false
+		}
+		height = max(height, in.requiredHeightLockTime)
+		time = max(time, in.requiredTimeLockTime)
+	}
+
+	switch {
+	case !constrained:
+		return p.lockTime, nil
+	case heightOK:
+		// heights are preferred when every input accepts both
+		return height, nil
+	case timeOK:
+		return time, nil
+	}
+	return 0, ErrPsbtLockTime
+}
+
+// UnsignedTx returns the transaction described by the PSBT without any signatures
+func (p *Psbt) UnsignedTx() (*Transaction, error) {
+	lockTime, err := p.txLockTime()
+	if err != nil {
+		return nil, err
+	}
+
+	txInputs := make([]*TransactionInput, 0, len(p.inputs))
+	for _, in := range p.inputs {
+		txInput := InitTransactionInput(in.previousTxID, in.outputIndex)
+		txInput.SetScriptSig(InitScriptSig([]ScriptCmd{}))
+		txInput.SetSequence(in.sequence)
+		txInputs = append(txInputs, txInput)
+	}
+
+	txOutputs := make([]*TransactionOutput, 0, len(p.outputs))
+	for _, out := range p.outputs {
+		script, err := parseScriptBytes(out.script)
+		if err != nil {
+			return nil, err
+		}
+		txOutputs = append(txOutputs, InitTransactionOutput(out.amount, script))
+	}
+
+	return InitTransaction(int32(p.txVersion), txInputs, txOutputs, lockTime, nil), nil
+}
+
+// ParsePsbtBase64 decodes a base64 encoded PSBT
+func ParsePsbtBase64(s string) (*Psbt, error) {
+	raw, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPsbtFormat, err)
+	}
+	return ParsePsbt(raw)
+}
+
+// ParsePsbt decodes a binary PSBT of version 0 or 2
+func ParsePsbt(raw []byte) (*Psbt, error) {
+	if !bytes.HasPrefix(raw, []byte(PSBT_MAGIC)) {
+		return nil, fmt.Errorf("%w: missing magic bytes", ErrPsbtFormat)
+	}
+	reader := bytes.NewReader(raw[len(PSBT_MAGIC):])
+
+	globals, err := readPsbtMap(reader)
+	if err != nil {
+		return nil, err
+	}
+	p, inputCount, outputCount, err := parsePsbtGlobals(globals, reader.Len())
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < inputCount; i++ {
+		entries, err := readPsbtMap(reader)
+		if err != nil {
+			return nil, err
+		}
+		in := p.inputs[i]
+		if p.version == 2 {
+			in = newPsbtInput(nil, 0, SEQUENCE_FINAL)
+			p.inputs[i] = in
+		}
+		if err := p.parseInput(in, entries); err != nil {
+			return nil, fmt.Errorf("input %d: %w", i, err)
+		}
+	}
+
+	for i := 0; i < outputCount; i++ {
+		entries, err := readPsbtMap(reader)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.parseOutput(p.outputs[i], entries); err != nil {
+			return nil, fmt.Errorf("output %d: %w", i, err)
+		}
+	}
+
+	if reader.Len() != 0 {
+		return nil, fmt.Errorf("%w: %d trailing bytes", ErrPsbtFormat, reader.Len())
+	}
+	return p, nil
+}
+
+// parsePsbtGlobals decodes the global map and returns the PSBT with its input and output counts,
+// remaining is the number of bytes left for the input and output maps
+func parsePsbtGlobals(entries []psbtKeyValue, remaining int) (*Psbt, int, int, error) {
+	p := &Psbt{xpubs: make(map[string]*Bip32Derivation)}
+	var unsignedTx *Transaction
+	var hasTxVersion bool
+	inputCount, outputCount := -1, -1
+
+	for _, entry := range entries {
+		keyType, keyData := entry.key[0], entry.key[1:]
+		hasKeyData := keyType == PSBT_GLOBAL_XPUB || (keyType > PSBT_GLOBAL_TX_MODIFIABLE && keyType != PSBT_GLOBAL_VERSION)
+		if !hasKeyData && len(keyData) != 0 {
+			return nil, 0, 0, fmt.Errorf("%w: global key %x", ErrPsbtFormat, entry.key)
+		}
+
+		var err error
+		switch keyType {
+		case PSBT_GLOBAL_UNSIGNED_TX:
+			unsignedTx, err = ParseTransaction(entry.value)
+		case PSBT_GLOBAL_XPUB:
+			if len(keyData) != BIP32_XPUB_LENGTH {
+				return nil, 0, 0, fmt.Errorf("%w: xpub length %d", ErrPsbtFormat, len(keyData))
+			}
+			p.xpubs[string(keyData)], err = parseBip32Derivation(entry.value)
+		case PSBT_GLOBAL_TX_VERSION:
+			p.txVersion, err = psbtUint32(entry.value)
+			hasTxVersion = true
+		case PSBT_GLOBAL_FALLBACK_LOCKTIME:
+			p.lockTime, err = psbtUint32(entry.value)
+			p.hasFallbackLockTime = true
+		case PSBT_GLOBAL_INPUT_COUNT:
+			inputCount, err = psbtCount(entry.value, remaining)
+		case PSBT_GLOBAL_OUTPUT_COUNT:
+			outputCount, err = psbtCount(entry.value, remaining)
+		case PSBT_GLOBAL_TX_MODIFIABLE:
+			if len(entry.value) != 1 {
+				err = fmt.Errorf("%w: tx modifiable length %d", ErrPsbtFormat, len(entry.value))
+			} else {
+				p.txModifiable = entry.value[0]
+			}
+		case PSBT_GLOBAL_VERSION:
+			p.version, err = psbtUint32(entry.value)
+		default:
+			p.unknown = append(p.unknown, entry)
+		}
+		if err != nil {
+			return nil, 0, 0, err
+		}
+	}
+
+	switch p.version {
+	case 0:
+		if unsignedTx == nil {
+			return nil, 0, 0, fmt.Errorf("%w: missing unsigned transaction", ErrPsbtFormat)
+		}
+		if hasTxVersion || p.hasFallbackLockTime || inputCount != -1 || outputCount != -1 || p.txModifiable != 0 {
+			return nil, 0, 0, fmt.Errorf("%w: version 2 field in a version 0 psbt", ErrPsbtFormat)
+		}
+		if unsignedTx.segwit {
+			return nil, 0, 0, fmt.Errorf("%w: unsigned transaction uses the witness serialization", ErrPsbtFormat)
+		}
+		fromTx, err := NewPsbt(unsignedTx)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		p.txVersion, p.lockTime, p.inputs, p.outputs = fromTx.txVersion, fromTx.lockTime, fromTx.inputs, fromTx.outputs
+	case 2:
+		if unsignedTx != nil {
+			return nil, 0, 0, fmt.Errorf("%w: unsigned transaction in a version 2 psbt", ErrPsbtFormat)
+		}
+		if !hasTxVersion || inputCount == -1 || outputCount == -1 {
+			return nil, 0, 0, fmt.Errorf("%w: missing version 2 global field", ErrPsbtFormat)
+		}
+		// every map takes at least its separator byte
+		if inputCount+outputCount > remaining {
+			return nil, 0, 0, fmt.Errorf("%w: %d inputs and %d outputs in %d bytes", ErrPsbtFormat, inputCount, outputCount, remaining)
+		}
+		p.inputs = make([]*PsbtInput, inputCount)
+		p.outputs = make([]*PsbtOutput, outputCount)
+		for i := range p.outputs {
+			p.outputs[i] = newPsbtOutput(0, nil)
+		}
+	default:
+		return nil, 0, 0, fmt.Errorf("%w: %d", ErrPsbtVersion, p.version)
+	}
+
+	return p, len(p.inputs), len(p.outputs), nil
+}
+
+// parseInput decodes an input map into in
+func (p *Psbt) parseInput(in *PsbtInput, entries []psbtKeyValue) error {
+	var hasTxID, hasIndex bool
+
+	for _, entry := range entries {
+		keyType, keyData := entry.key[0], entry.key[1:]
+		if keyType != PSBT_IN_PARTIAL_SIG && keyType != PSBT_IN_BIP32_DERIVATION &&
+			keyType <= PSBT_IN_REQUIRED_HEIGHT_LOCKTIME && len(keyData) != 0 {
+			return fmt.Errorf("%w: input key %x", ErrPsbtFormat, entry.key)
+		}
+		if p.version == 0 && keyType >= PSBT_IN_PREVIOUS_TXID && keyType <= PSBT_IN_REQUIRED_HEIGHT_LOCKTIME {
+			return fmt.Errorf("%w: version 2 input field in a version 0 psbt", ErrPsbtFormat)
+		}
+
+		var err error
+		switch keyType {
+		case PSBT_IN_NON_WITNESS_UTXO:
+			in.nonWitnessUtxo, err = ParseTransaction(entry.value)
+		case PSBT_IN_WITNESS_UTXO:
+			in.witnessUtxo, err = parsePsbtOutput(entry.value)
+		case PSBT_IN_PARTIAL_SIG:
+			if err = checkPsbtPubKey(keyData); err == nil {
+				in.partialSigs[string(keyData)] = entry.value
+			}
+		case PSBT_IN_SIGHASH_TYPE:
+			in.sighashType, err = psbtUint32(entry.value)
+			in.hasSighashType = true
+		case PSBT_IN_REDEEM_SCRIPT:
+			in.redeemScript = entry.value
+		case PSBT_IN_WITNESS_SCRIPT:
+			in.witnessScript = entry.value
+		case PSBT_IN_BIP32_DERIVATION:
+			if err = checkPsbtPubKey(keyData); err == nil {
+				in.bip32Derivation[string(keyData)], err = parseBip32Derivation(entry.value)
+			}
+		case PSBT_IN_FINAL_SCRIPTSIG:
+			in.finalScriptSig = entry.value
+		case PSBT_IN_FINAL_SCRIPTWITNESS:
+			in.finalScriptWitness, err = parsePsbtWitness(entry.value)
+		case PSBT_IN_PREVIOUS_TXID:
+			if len(entry.value) != 32 {
+				err = fmt.Errorf("%w: previous txid length %d", ErrPsbtFormat, len(entry.value))
+			}
+			in.previousTxID = ReverseByteSlice(entry.value)
+			hasTxID = true
+		case PSBT_IN_OUTPUT_INDEX:
+			in.outputIndex, err = psbtUint32(entry.value)
+			hasIndex = true
+		case PSBT_IN_SEQUENCE:
+			in.sequence, err = psbtUint32(entry.value)
+		case PSBT_IN_REQUIRED_TIME_LOCKTIME:
+			in.requiredTimeLockTime, err = psbtUint32(entry.value)
+			if err == nil && in.requiredTimeLockTime < LOCKTIME_THRESHOLD {
+				err = fmt.Errorf("%w: required time lock time %d", ErrPsbtFormat, in.requiredTimeLockTime)
+			}
+		case PSBT_IN_REQUIRED_HEIGHT_LOCKTIME:
+			in.requiredHeightLockTime, err = psbtUint32(entry.value)
+			if err == nil && (in.requiredHeightLockTime == 0 || in.requiredHeightLockTime >= LOCKTIME_THRESHOLD) {
+				err = fmt.Errorf("%w: required height lock time %d", ErrPsbtFormat, in.requiredHeightLockTime)
+			}
+		default:
+			in.unknown = append(in.unknown, entry)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if p.version == 2 && (!hasTxID || !hasIndex) {
+		return fmt.Errorf("%w: missing previous outpoint", ErrPsbtFormat)
+	}
+	if in.nonWitnessUtxo != nil {
+		return in.checkNonWitnessUtxo(in.nonWitnessUtxo)
+	}
+	return nil
+}
+
+// parseOutput decodes an output map into out
+func (p *Psbt) parseOutput(out *PsbtOutput, entries []psbtKeyValue) error {
+	var hasAmount, hasScript bool
+
+	for _, entry := range entries {
+		keyType, keyData := entry.key[0], entry.key[1:]
+		if keyType != PSBT_OUT_BIP32_DERIVATION && keyType <= PSBT_OUT_SCRIPT && len(keyData) != 0 {
+			return fmt.Errorf("%w: output key %x", ErrPsbtFormat, entry.key)
+		}
+		if p.version == 0 && (keyType == PSBT_OUT_AMOUNT || keyType == PSBT_OUT_SCRIPT) {
+			return fmt.Errorf("%w: version 2 output field in a version 0 psbt", ErrPsbtFormat)
+		}
+
+		var err error
+		switch keyType {
+		case PSBT_OUT_REDEEM_SCRIPT:
+			out.redeemScript = entry.value
+		case PSBT_OUT_WITNESS_SCRIPT:
+			out.witnessScript = entry.value
+		case PSBT_OUT_BIP32_DERIVATION:
+			if err = checkPsbtPubKey(keyData); err == nil {
+				out.bip32Derivation[string(keyData)], err = parseBip32Derivation(entry.value)
+			}
+		case PSBT_OUT_AMOUNT:
+			if len(entry.value) != 8 {
+				err = fmt.Errorf("%w: amount length %d", ErrPsbtFormat, len(entry.value))
+			} else {
+				out.amount = Amount(binary.LittleEndian.Uint64(entry.value))
+			}
+			hasAmount = true
+		case PSBT_OUT_SCRIPT:
+			out.script = entry.value
+			hasScript = true
+		default:
+			out.unknown = append(out.unknown, entry)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if p.version == 2 && (!hasAmount || !hasScript) {
+		return fmt.Errorf("%w: missing output amount or script", ErrPsbtFormat)
+	}
+	return nil
+}
+
+// parsePsbtOutput decodes a serialized transaction output, the value of PSBT_IN_WITNESS_UTXO
+func parsePsbtOutput(value []byte) (*TransactionOutput, error) {
+	reader := bytes.NewReader(value)
+	if reader.Len() < 8 {
+		return nil, fmt.Errorf("%w: witness utxo too short", ErrPsbtFormat)
+	}
+	amount := make([]byte, 8)
+	io.ReadFull(reader, amount)
+
+	scriptLen, err := readCompactSize(reader)
+	if err != nil {
+		return nil, err
+	}
+	if scriptLen != uint64(reader.Len()) {
+		return nil, fmt.Errorf("%w: witness utxo script length", ErrPsbtFormat)
+	}
+	script, err := parseScriptBytes(value[len(value)-int(scriptLen):])
+	if err != nil {
+		return nil, err
+	}
+
+	return InitTransactionOutput(Amount(binary.LittleEndian.Uint64(amount)), script), nil
+}
+
+// parsePsbtWitness decodes a serialized witness stack, the value of PSBT_IN_FINAL_SCRIPTWITNESS
+func parsePsbtWitness(value []byte) ([][]byte, error) {
+	reader := bytes.NewReader(value)
+	count, err := readCompactSize(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	witness := make([][]byte, 0)
+	for i := uint64(0); i < count; i++ {
+		item, err := readPsbtBytes(reader)
+		if err != nil {
+			return nil, err
+		}
+		witness = append(witness, item)
+	}
+	if reader.Len() != 0 {
+		return nil, fmt.Errorf("%w: trailing witness bytes", ErrPsbtFormat)
+	}
+
+	return witness, nil
+}
+
+// psbtUint32 decodes a 4 byte little endian field
+func psbtUint32(value []byte) (uint32, error) {
+	if len(value) != 4 {
+		return 0, fmt.Errorf("%w: expected 4 bytes, got %d", ErrPsbtFormat, len(value))
+	}
+	return binary.LittleEndian.Uint32(value), nil
+}
+
+// psbtCount decodes a compact size input or output count, which cannot exceed the bytes remaining
+func psbtCount(value []byte, remaining int) (int, error) {
+	reader := bytes.NewReader(value)
+	count, err := readCompactSize(reader)
+	if err != nil {
+		return 0, err
+	}
+	if reader.Len() != 0 {
+		return 0, fmt.Errorf("%w: count", ErrPsbtFormat)
+	}
+	// every map takes at least its separator byte, checked before the count can overflow an int
+	if count > uint64(remaining) {
+		return 0, fmt.Errorf("%w: count %d in %d bytes", ErrPsbtFormat, count, remaining)
+	}
+	return int(count), nil
+}
+
+// readCompactSize reads a Bitcoin variable length integer, failing on truncated input and
+// non-canonical encodings
+func readCompactSize(reader *bytes.Reader) (uint64, error) {
+	prefix, err := reader.ReadByte()
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrPsbtFormat, err)
+	}
+
+	size := 0
+	var min uint64
+	switch prefix {
+	case 0xfd:
+		size, min = 2, 0xfd
+	case 0xfe:
+		size, min = 4, 0x10000
+	case 0xff:
+		size, min = 8, 0x100000000
+	default:
+		return uint64(prefix), nil
+	}
+
+	buf := make([]byte, 8)
+	if _, err := io.ReadFull(reader, buf[:size]); err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrPsbtFormat, err)
+	}
+	v := binary.LittleEndian.Uint64(buf)
+	if v < min {
+		return 0, fmt.Errorf("%w: non-canonical compact size", ErrPsbtFormat)
+	}
+	return v, nil
+}
+
+// readPsbtBytes reads a length prefixed byte string
+func readPsbtBytes(reader *bytes.Reader) ([]byte, error) {
+	length, err := readCompactSize(reader)
+	if err != nil {
+		return nil, err
+	}
+	if length > uint64(reader.Len()) {
+		return nil, fmt.Errorf("%w: length %d exceeds remaining %d bytes", ErrPsbtFormat, length, reader.Len())
+	}
+
+	data := make([]byte, length)
+	io.ReadFull(reader, data)
+	return data, nil
+}
+
+// readPsbtMap reads key value pairs up to the 0x00 separator, duplicated keys are rejected
+func readPsbtMap(reader *bytes.Reader) ([]psbtKeyValue, error) {
+	entries := make([]psbtKeyValue, 0)
+	seen := make(map[string]bool)
+
+	for {
+		key, err := readPsbtBytes(reader)
+		if err != nil {
+			return nil, err
+		}
+		if len(key) == 0 {
+			return entries, nil
+		}
+		if seen[string(key)] {
+			return nil, fmt.Errorf("%w: duplicate key %x", ErrPsbtFormat, key)
+		}
+		seen[string(key)] = true
+
+		value, err := readPsbtBytes(reader)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, psbtKeyValue{key: key, value: value})
+	}
+}
+
+// Base64 encodes the PSBT in the base64 form used to pass it between wallets
+func (p *Psbt) Base64() (string, error) {
+	raw, err := p.Serialize()
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(raw), nil
+}
+
+// Serialize encodes the PSBT in its binary format
+func (p *Psbt) Serialize() ([]byte, error) {
+	result := []byte(PSBT_MAGIC)
+
+	if p.version == 0 {
+		tx, err := p.UnsignedTx()
+		if err != nil {
+			return nil, err
+		}
+		result = appendPsbtField(result, PSBT_GLOBAL_UNSIGNED_TX, nil, tx.serializeLegacy())
+	}
+	for _, xpub := range sortedKeys(p.xpubs) {
+		result = appendPsbtField(result, PSBT_GLOBAL_XPUB, []byte(xpub), p.xpubs[xpub].serialize())
+	}
+	if p.version == 2 {
+		result = appendPsbtField(result, PSBT_GLOBAL_TX_VERSION, nil, binary.LittleEndian.AppendUint32(nil, p.txVersion))
+		if p.hasFallbackLockTime {
+			result = appendPsbtField(result, PSBT_GLOBAL_FALLBACK_LOCKTIME, nil, binary.LittleEndian.AppendUint32(nil, p.lockTime))
+		}
+		result = appendPsbtField(result, PSBT_GLOBAL_INPUT_COUNT, nil, EncodeVarint(big.NewInt(int64(len(p.inputs)))))
+		result = appendPsbtField(result, PSBT_GLOBAL_OUTPUT_COUNT, nil, EncodeVarint(big.NewInt(int64(len(p.outputs)))))
+		result = appendPsbtField(result, PSBT_GLOBAL_TX_MODIFIABLE, nil, []byte{p.txModifiable})
+		result = appendPsbtField(result, PSBT_GLOBAL_VERSION, nil, binary.LittleEndian.AppendUint32(nil, p.version))
+	}
+	result = appendPsbtUnknown(result, p.unknown)
+	result = append(result, 0x00)
+
+	for _, in := range p.inputs {
+		result = p.serializeInput(result, in)
+	}
+	for _, out := range p.outputs {
+		result = p.serializeOutput(result, out)
+	}
+
+	return result, nil
+}
+
+// serializeInput appends the map of an input
+func (p *Psbt) serializeInput(result []byte, in *PsbtInput) []byte {
+	if in.nonWitnessUtxo != nil {
+		result = appendPsbtField(result, PSBT_IN_NON_WITNESS_UTXO, nil, in.nonWitnessUtxo.Serialize())
+	}
+	if in.witnessUtxo != nil {
+		result = appendPsbtField(result, PSBT_IN_WITNESS_UTXO, nil, in.witnessUtxo.Serialize())
+	}
+	for _, pubKey := range sortedKeys(in.partialSigs) {
+		result = appendPsbtField(result, PSBT_IN_PARTIAL_SIG, []byte(pubKey), in.partialSigs[pubKey])
+	}
+	if in.hasSighashType {
+		result = appendPsbtField(result, PSBT_IN_SIGHASH_TYPE, nil, binary.LittleEndian.AppendUint32(nil, in.sighashType))
+	}
+	if in.redeemScript != nil {
+		result = appendPsbtField(result, PSBT_IN_REDEEM_SCRIPT, nil, in.redeemScript)
+	}
+	if in.witnessScript != nil {
+		result = appendPsbtField(result, PSBT_IN_WITNESS_SCRIPT, nil, in.witnessScript)
+	}
+	for _, pubKey := range sortedKeys(in.bip32Derivation) {
+		result = appendPsbtField(result, PSBT_IN_BIP32_DERIVATION, []byte(pubKey), in.bip32Derivation[pubKey].serialize())
+	}
+	if in.finalScriptSig != nil {
+		result = appendPsbtField(result, PSBT_IN_FINAL_SCRIPTSIG, nil, in.finalScriptSig)
+	}
+	if in.finalScriptWitness != nil {
+		result = appendPsbtField(result, PSBT_IN_FINAL_SCRIPTWITNESS, nil, serializeWitness(in.finalScriptWitness))
+	}
+	if p.version == 2 {
+		result = appendPsbtField(result, PSBT_IN_PREVIOUS_TXID, nil, ReverseByteSlice(in.previousTxID))
+		result = appendPsbtField(result, PSBT_IN_OUTPUT_INDEX, nil, binary.LittleEndian.AppendUint32(nil, in.outputIndex))
+		if in.sequence != SEQUENCE_FINAL {
+			result = appendPsbtField(result, PSBT_IN_SEQUENCE, nil, binary.LittleEndian.AppendUint32(nil, in.sequence))
+		}
+		if in.requiredTimeLockTime != 0 {
+			result = appendPsbtField(result, PSBT_IN_REQUIRED_TIME_LOCKTIME, nil,
+				binary.LittleEndian.AppendUint32(nil, in.requiredTimeLockTime))
+		}
+		if in.requiredHeightLockTime != 0 {
+			result = appendPsbtField(result, PSBT_IN_REQUIRED_HEIGHT_LOCKTIME, nil,
+				binary.LittleEndian.AppendUint32(nil, in.requiredHeightLockTime))
+		}
+	}
+	result = appendPsbtUnknown(result, in.unknown)
+	return append(result, 0x00)
+}
+
+// serializeOutput appends the map of an output
+func (p *Psbt) serializeOutput(result []byte, out *PsbtOutput) []byte {
+	if out.redeemScript != nil {
+		result = appendPsbtField(result, PSBT_OUT_REDEEM_SCRIPT, nil, out.redeemScript)
+	}
+	if out.witnessScript != nil {
+		result = appendPsbtField(result, PSBT_OUT_WITNESS_SCRIPT, nil, out.witnessScript)
+	}
+	for _, pubKey := range sortedKeys(out.bip32Derivation) {
+		result = appendPsbtField(result, PSBT_OUT_BIP32_DERIVATION, []byte(pubKey), out.bip32Derivation[pubKey].serialize())
+	}
+	if p.version == 2 {
+		result = appendPsbtField(result, PSBT_OUT_AMOUNT, nil, binary.LittleEndian.AppendUint64(nil, uint64(out.amount)))
+		result = appendPsbtField(result, PSBT_OUT_SCRIPT, nil, out.script)
+	}
+	result = appendPsbtUnknown(result, out.unknown)
+	return append(result, 0x00)
+}
+
+// appendPsbtField appends one key value pair
+func appendPsbtField(result []byte, keyType byte, keyData []byte, value []byte) []byte {
+	key := append([]byte{keyType}, keyData...)
+	result = append(result, EncodeVarint(big.NewInt(int64(len(key))))...)
+	result = append(result, key...)
+	result = append(result, EncodeVarint(big.NewInt(int64(len(value))))...)
+	return append(result, value...)
+}
+
+// appendPsbtUnknown appends the unknown fields in the order they were read
+func appendPsbtUnknown(result []byte, entries []psbtKeyValue) []byte {
+	for _, entry := range entries {
+		result = appendPsbtField(result, entry.key[0], entry.key[1:], entry.value)
+	}
+	return result
+}
+
+// sortedKeys returns the keys of a map keyed by raw bytes in a stable order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
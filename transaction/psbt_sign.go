@@ -0,0 +1,430 @@
+package transaction
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	ecc "github.com/sudonite/bitcoin/elliptic_curve"
+)
+
+// psbtSpend describes how an input is spent once the P2SH and witness layers are resolved
+type psbtSpend struct {
+	// scriptSigCmds are the pushes the scriptSig needs besides signatures, the redeem script of P2SH inputs
+	scriptSigCmds [][]byte
+	// script holds the public keys, it is the redeem or witness script, or nil for P2PKH and P2WPKH
+	script *ScriptSig
+	// pubKeyHash is set for P2PKH and P2WPKH spends
+	pubKeyHash []byte
+	witness    bool
+}
+
+// utxo returns the output an input spends
+func (in *PsbtInput) utxo() (*TransactionOutput, error) {
+	if in.nonWitnessUtxo != nil {
+		return in.nonWitnessUtxo.txOutputs[in.outputIndex], nil
+	}
+	if in.witnessUtxo != nil {
+		return in.witnessUtxo, nil
+	}
+	return nil, fmt.Errorf("%w: missing utxo", ErrPsbtFormat)
+}
+
+// resolveSpend walks through the scriptPubKey, redeem script and witness script of an input
+func (in *PsbtInput) resolveSpend() (*psbtSpend, error) {
+	utxo, err := in.utxo()
+	if err != nil {
+		return nil, err
+	}
+
+	spend := &psbtSpend{}
+	script := utxo.scriptPubKey
+	if template := script.Classify(); template.Class() == SCRIPT_P2PKH {
+		spend.pubKeyHash = template.Hash()
+		return spend, nil
+	}
+
+	if script.bitcoinOpCode.isP2sh() {
+		if in.redeemScript == nil {
+			return nil, ErrMissingScript
+		}
+		if !bytes.Equal(ecc.Hash160(in.redeemScript), script.bitcoinOpCode.cmds[1].data) {
+			return nil, fmt.Errorf("redeem script does not match P2SH hash")
+		}
+		if script, err = parseScriptBytes(in.redeemScript); err != nil {
+			return nil, err
+		}
+		spend.scriptSigCmds = [][]byte{in.redeemScript}
+	}
+
+	version, program, isWitness := script.witnessProgram()
+	if !isWitness {
+		if spend.scriptSigCmds == nil {
+			return nil, ErrUnsupported
+		}
+		spend.script = script
+		return spend, nil
+	}
+	if version != 0 {
+		return nil, ErrUnsupported
+	}
+
+	spend.witness = true
+	if len(program) == 20 {
+		spend.pubKeyHash = program
+		return spend, nil
+	}
+
+	if in.witnessScript == nil {
+		return nil, ErrMissingScript
+	}
+	if !bytes.Equal(sha256Bytes(in.witnessScript), program) {
+		return nil, fmt.Errorf("witness script does not match P2WSH hash")
+	}
+	if spend.script, err = parseScriptBytes(in.witnessScript); err != nil {
+		return nil, err
+	}
+	return spend, nil
+}
+
+// scriptPubKeys returns the public keys of a <pubkey> OP_CHECKSIG or multisig script
+func scriptPubKeys(script *ScriptSig) ([][]byte, error) {
+	switch template := script.Classify(); template.Class() {
+	case SCRIPT_P2PK, SCRIPT_P2MS:
+		return template.PubKeys(), nil
+	}
+	return nil, ErrUnsupported
+}
+
+// Sign adds partial signatures for every input the keys can sign, inputs without a matching key are skipped
+func (p *Psbt) Sign(keys []*ecc.PrivateKey) error {
+	for i := range p.inputs {
+		for _, key := range keys {
+			err := p.SignInput(i, key)
+			if err != nil && !errors.Is(err, ErrMissingKey) {
+				return fmt.Errorf("sign input %d: %w", i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// SignInput adds the partial signature of key to an input, only SIGHASH_ALL is supported
+func (p *Psbt) SignInput(inputIdx int, key *ecc.PrivateKey) error {
+	in, err := p.input(inputIdx)
+	if err != nil {
+		return err
+	}
+	if in.isFinalized() {
+		return nil
+	}
+	if in.hasSighashType && in.sighashType != SIGHASH_ALL {
+		return fmt.Errorf("%w: sighash type %d", ErrUnsupported, in.sighashType)
+	}
+
+	spend, err := in.resolveSpend()
+	if err != nil {
+		return err
+	}
+	if !spend.witness && in.nonWitnessUtxo == nil {
+		// a witness utxo alone lets a malicious updater lie about the amount of legacy inputs
+		return fmt.Errorf("%w: legacy input needs the previous transaction", ErrPsbtFormat)
+	}
+
+	keyring := newKeyring([]*ecc.PrivateKey{key})
+	var pubKeys [][]byte
+	if spend.pubKeyHash != nil {
+		_, sec, err := keyring.byHash160(spend.pubKeyHash)
+		if err != nil {
+			return err
+		}
+		if spend.witness && len(sec) != 33 {
+			return fmt.Errorf("P2WPKH requires a compressed public key")
+		}
+		pubKeys = [][]byte{sec}
+	} else {
+		if pubKeys, err = scriptPubKeys(spend.script); err != nil {
+			return err
+		}
+	}
+
+	tx, err := p.UnsignedTx()
+	if err != nil {
+		return err
+	}
+	utxo, _ := in.utxo()
+	tx.txInputs[inputIdx].SetPreviousOutput(utxo)
+
+	var z []byte
+	switch {
+	case !spend.witness && spend.script == nil:
+		z = ecc.Hash256(string(tx.serializeForLegacySig(inputIdx, utxo.scriptPubKey)))
+	case !spend.witness:
+		z = ecc.Hash256(string(tx.serializeForLegacySig(inputIdx, spend.script)))
+	case spend.script == nil:
+		z, err = tx.bip143SigHash(NewSigHashCache(tx), inputIdx, P2pkhScript(spend.pubKeyHash).Serialize(), SIGHASH_ALL)
+	default:
+		z, err = tx.bip143SigHash(NewSigHashCache(tx), inputIdx, spend.script.Serialize(), SIGHASH_ALL)
+	}
+	if err != nil {
+		return err
+	}
+
+	signed := false
+	for _, pubKey := range pubKeys {
+		if _, err := keyring.bySec(pubKey); err != nil {
+			continue
+		}
+		in.partialSigs[string(pubKey)] = signEcdsa(key, z)
+		signed = true
+	}
+	if !signed {
+		return ErrMissingKey
+	}
+	return nil
+}
+
+// Combine merges the fields of other PSBTs for the same transaction into p
+func (p *Psbt) Combine(others ...*Psbt) error {
+	txID, err := p.uniqueID()
+	if err != nil {
+		return err
+	}
+
+	for _, other := range others {
+		otherID, err := other.uniqueID()
+		if err != nil {
+			return err
+		}
+		if other.version != p.version || !bytes.Equal(otherID, txID) {
+			return ErrPsbtMismatch
+		}
+
+		for xpub, derivation := range other.xpubs {
+			p.xpubs[xpub] = derivation
+		}
+		p.unknown = mergeUnknown(p.unknown, other.unknown)
+		for i, in := range p.inputs {
+			in.combine(other.inputs[i])
+		}
+		for i, out := range p.outputs {
+			out.combine(other.outputs[i])
+		}
+	}
+	return nil
+}
+
+// uniqueID identifies the transaction regardless of signatures, version 2 ignores sequences as BIP-370 requires
+func (p *Psbt) uniqueID() ([]byte, error) {
+	tx, err := p.UnsignedTx()
+	if err != nil {
+		return nil, err
+	}
+	if p.version == 2 {
+		for _, txInput := range tx.txInputs {
+			txInput.SetSequence(0)
+		}
+	}
+	return tx.Hash(), nil
+}
+
+// combine copies the fields of other that in does not have yet
+func (in *PsbtInput) combine(other *PsbtInput) {
+	if in.nonWitnessUtxo == nil {
+		in.nonWitnessUtxo = other.nonWitnessUtxo
+	}
+	if in.witnessUtxo == nil {
+		in.witnessUtxo = other.witnessUtxo
+	}
+	if !in.hasSighashType {
+		in.sighashType, in.hasSighashType = other.sighashType, other.hasSighashType
+	}
+	if in.redeemScript == nil {
+		in.redeemScript = other.redeemScript
+	}
+	if in.witnessScript == nil {
+		in.witnessScript = other.witnessScript
+	}
+	if in.finalScriptSig == nil {
+		in.finalScriptSig = other.finalScriptSig
+	}
+	if in.finalScriptWitness == nil {
+		in.finalScriptWitness = other.finalScriptWitness
+	}
+	if in.requiredTimeLockTime == 0 {
+		in.requiredTimeLockTime = other.requiredTimeLockTime
+	}
+	if in.requiredHeightLockTime == 0 {
+		in.requiredHeightLockTime = other.requiredHeightLockTime
+	}
+	for pubKey, sig := range other.partialSigs {
+		in.partialSigs[pubKey] = sig
+	}
+	for pubKey, derivation := range other.bip32Derivation {
+		in.bip32Derivation[pubKey] = derivation
+	}
+	in.unknown = mergeUnknown(in.unknown, other.unknown)
+}
+
+// combine copies the fields of other that out does not have yet
+func (out *PsbtOutput) combine(other *PsbtOutput) {
+	if out.redeemScript == nil {
+		out.redeemScript = other.redeemScript
+	}
+	if out.witnessScript == nil {
+		out.witnessScript = other.witnessScript
+	}
+	for pubKey, derivation := range other.bip32Derivation {
+		out.bip32Derivation[pubKey] = derivation
+	}
+	out.unknown = mergeUnknown(out.unknown, other.unknown)
+}
+
+// mergeUnknown appends the unknown fields of other whose keys are not in entries
+func mergeUnknown(entries []psbtKeyValue, other []psbtKeyValue) []psbtKeyValue {
+	for _, entry := range other {
+		found := false
+		for _, existing := range entries {
+			if bytes.Equal(existing.key, entry.key) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// Finalize builds the final scriptSig and witness of every input from its partial signatures
+func (p *Psbt) Finalize() error {
+	for i := range p.inputs {
+		if err := p.FinalizeInput(i); err != nil {
+			return fmt.Errorf("finalize input %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// FinalizeInput builds the final scriptSig and witness of an input and drops the data only signers need
+func (p *Psbt) FinalizeInput(inputIdx int) error {
+	in, err := p.input(inputIdx)
+	if err != nil {
+		return err
+	}
+	if in.isFinalized() {
+		return nil
+	}
+
+	spend, err := in.resolveSpend()
+	if err != nil {
+		return err
+	}
+
+	var args [][]byte
+	if spend.pubKeyHash != nil {
+		args, err = in.satisfyPubKeyHash(spend.pubKeyHash)
+	} else {
+		args, err = in.satisfyScript(spend.script)
+	}
+	if err != nil {
+		return err
+	}
+
+	scriptSigCmds := spend.scriptSigCmds
+	if spend.witness {
+		if spend.script != nil {
+			args = append(args, in.witnessScript)
+		}
+		in.finalScriptWitness = args
+	} else {
+		scriptSigCmds = append(args, scriptSigCmds...)
+	}
+	if len(scriptSigCmds) > 0 {
+		in.finalScriptSig = InitScriptSig(pushCmds(scriptSigCmds)).rawSerialize()
+	}
+
+	in.partialSigs = make(map[string][]byte)
+	in.bip32Derivation = make(map[string]*Bip32Derivation)
+	in.hasSighashType = false
+	in.redeemScript = nil
+	in.witnessScript = nil
+	return nil
+}
+
+// satisfyPubKeyHash returns <sig> <pubkey> for a P2PKH or P2WPKH spend
+func (in *PsbtInput) satisfyPubKeyHash(h160 []byte) ([][]byte, error) {
+	for pubKey, sig := range in.partialSigs {
+		if bytes.Equal(ecc.Hash160([]byte(pubKey)), h160) {
+			return [][]byte{sig, []byte(pubKey)}, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: no signature for public key hash %x", ErrMissingKey, h160)
+}
+
+// satisfyScript returns the signatures a <pubkey> OP_CHECKSIG or multisig script consumes
+func (in *PsbtInput) satisfyScript(script *ScriptSig) ([][]byte, error) {
+	template := script.Classify()
+	if template.Class() == SCRIPT_P2PK {
+		pubKey := template.PubKeys()[0]
+		sig, ok := in.partialSigs[string(pubKey)]
+		if !ok {
+			return nil, fmt.Errorf("%w: no signature for public key %x", ErrMissingKey, pubKey)
+		}
+		return [][]byte{sig}, nil
+	}
+
+	if template.Class() != SCRIPT_P2MS {
+		return nil, ErrUnsupported
+	}
+	m, pubKeys := template.Required(), template.PubKeys()
+
+	// the dummy element consumed by OP_CHECKMULTISIG, signatures must follow the public key order
+	args := [][]byte{{}}
+	for _, pubKey := range pubKeys {
+		if len(args)-1 == m {
+			break
+		}
+		if sig, ok := in.partialSigs[string(pubKey)]; ok {
+			args = append(args, sig)
+		}
+	}
+	if len(args)-1 < m {
+		return nil, fmt.Errorf("%w: have %d of %d signatures", ErrMissingKey, len(args)-1, m)
+	}
+	return args, nil
+}
+
+// Extract returns the signed transaction once every input is finalized
+func (p *Psbt) Extract() (*Transaction, error) {
+	tx, err := p.UnsignedTx()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, in := range p.inputs {
+		if !in.isFinalized() {
+			return nil, fmt.Errorf("input %d: %w", i, ErrPsbtNotFinal)
+		}
+
+		txInput := tx.txInputs[i]
+		if in.finalScriptSig != nil {
+			scriptSig, err := parseScriptBytes(in.finalScriptSig)
+			if err != nil {
+				return nil, err
+			}
+			txInput.SetScriptSig(scriptSig)
+		}
+		if len(in.finalScriptWitness) > 0 {
+			txInput.SetWitness(in.finalScriptWitness)
+			tx.segwit = true
+		}
+		// keep the spent outputs so the transaction can be verified without fetching them
+		if utxo, err := in.utxo(); err == nil {
+			txInput.SetPreviousOutput(utxo)
+		}
+	}
+
+	return tx, nil
+}
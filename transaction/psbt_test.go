@@ -0,0 +1,304 @@
+package transaction
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/sudonite/bitcoin/chaincfg"
+	ecc "github.com/sudonite/bitcoin/elliptic_curve"
+)
+
+// BIP-174 example with one P2PKH input carrying its non-witness UTXO and two outputs
+const bip174P2pkhPsbt = "cHNidP8BAHUCAAAAASaBcTce3/KF6Tet7qSze3gADAVmy7OtZGQXE8pCFxv2AAAAAAD+////AtPf9QUAAAAAGXapFNDFmQPFusKGh2DpD9UhpGZap2UgiKwA4fUFAAAAABepFDVF5uM7gyxHBQ8k0+65PJwDlIvHh7MuEwAAAQD9pQEBAAAAAAECiaPHHqtNIOA3G7ukzGmPopXJRjr6Ljl/hTPMti+VZ+UBAAAAFxYAFL4Y0VKpsBIDna89p95PUzSe7LmF/////4b4qkOnHf8USIk6UwpyN+9rRgi7st0tAXHmOuxqSJC0AQAAABcWABT+Pp7xp0XpdNkCxDVZQ6vLNL1TU/////8CAMLrCwAAAAAZdqkUhc/xCX/Z4Ai7NK9wnGIZeziXikiIrHL++E4sAAAAF6kUM5cluiHv1irHU6m80GfWx6ajnQWHAkcwRAIgJxK+IuAnDzlPVoMR3HyppolwuAJf3TskAinwf4pfOiQCIAGLONfc0xTnNMkna9b7QPZzMlvEuqFEyADS8vAtsnZcASED0uFWdJQbrUqZY3LLh+GFbTZSYG2YVi/jnF6efkE/IQUCSDBFAiEA0SuFLYXc2WHS9fSrZgZU327tzHlMDDPOXMMJ/7X85Y0CIGczio4OFyXBl/saiK9Z9R5E5CVbIBZ8hoQDHAXR8lkqASECI7cr7vCWXRC+B3jv7NYfysb3mk6haTkzgHNEZPhPKrMAAAAAAAAA"
+
+func TestParsePsbtBIP174(t *testing.T) {
+	p, err := ParsePsbtBase64(bip174P2pkhPsbt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Version() != 0 || p.InputCount() != 1 || p.OutputCount() != 2 {
+		t.Errorf("version %d with %d inputs and %d outputs", p.Version(), p.InputCount(), p.OutputCount())
+	}
+	if p.inputs[0].nonWitnessUtxo == nil {
+		t.Error("non-witness UTXO not parsed")
+	}
+
+	encoded, err := p.Base64()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encoded != bip174P2pkhPsbt {
+		t.Errorf("round trip gave %s", encoded)
+	}
+}
+
+// unsignedTestTx is a version 2 transaction with one input and one P2WPKH output, ready to go into a PSBT
+func unsignedTestTx() *Transaction {
+	in := InitTransactionInput(bytes.Repeat([]byte{1}, 32), 0)
+	in.SetScriptSig(InitScriptSig([]ScriptCmd{}))
+	out := InitTransactionOutput(90000, P2wpkhScript(make([]byte, 20)))
+	return InitTransaction(2, []*TransactionInput{in}, []*TransactionOutput{out}, 0, &chaincfg.RegTestParams)
+}
+
+func TestPsbtRoundTrip(t *testing.T) {
+	key := ecc.NewPrivateKey(big.NewInt(4242))
+	_, sec := key.GetPublicKey().Sec(true)
+	derivation, err := NewBip32Derivation([]byte{0xde, 0xad, 0xbe, 0xef}, []uint32{0x80000054, 0x80000000, 0x80000000, 0, 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	multisig, err := MultisigScript(1, [][]byte{sec})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		psbt func(t *testing.T) *Psbt
+	}{
+		{"version 0 without metadata", func(t *testing.T) *Psbt {
+			p, err := NewPsbt(unsignedTestTx())
+			if err != nil {
+				t.Fatal(err)
+			}
+			return p
+		}},
+		{"version 0 with input and output fields", func(t *testing.T) *Psbt {
+			p, err := NewPsbt(unsignedTestTx())
+			if err != nil {
+				t.Fatal(err)
+			}
+			wsh := P2wshScript(sha256Bytes(multisig.rawSerialize()))
+			p.SetWitnessUtxo(0, InitTransactionOutput(100000, P2shScript(ecc.Hash160(wsh.rawSerialize()))))
+			p.SetSighashType(0, SIGHASH_ALL|SIGHASH_ANYONECANPAY)
+			p.SetInputRedeemScript(0, wsh)
+			p.SetInputWitnessScript(0, multisig)
+			p.AddInputBip32Derivation(0, sec, derivation)
+			p.SetOutputWitnessScript(0, multisig)
+			p.AddOutputBip32Derivation(0, sec, derivation)
+			p.AddXpub(bytes.Repeat([]byte{4}, BIP32_XPUB_LENGTH), derivation)
+			return p
+		}},
+		{"version 0 with unknown fields", func(t *testing.T) *Psbt {
+			p, err := NewPsbt(unsignedTestTx())
+			if err != nil {
+				t.Fatal(err)
+			}
+			p.unknown = append(p.unknown, psbtKeyValue{key: []byte{0x0f, 1, 2}, value: []byte{3}})
+			p.inputs[0].unknown = append(p.inputs[0].unknown, psbtKeyValue{key: []byte{0xfc, 'x'}, value: []byte{}})
+			p.outputs[0].unknown = append(p.outputs[0].unknown, psbtKeyValue{key: []byte{0x0a}, value: []byte{1, 2, 3}})
+			return p
+		}},
+		{"version 2", func(t *testing.T) *Psbt {
+			p := NewPsbtV2(2, 100)
+			if _, err := p.AddInput(bytes.Repeat([]byte{1}, 32), 3, 0xfffffffd); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := p.AddInput(bytes.Repeat([]byte{2}, 32), 0, SEQUENCE_FINAL); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := p.AddOutput(90000, P2wpkhScript(make([]byte, 20))); err != nil {
+				t.Fatal(err)
+			}
+			p.SetRequiredLockTime(0, 800000)
+			p.SetRequiredLockTime(1, 810000)
+			p.SetTxModifiable(PSBT_OUTPUTS_MODIFIABLE)
+			return p
+		}},
+		{"version 2 with a required time lock", func(t *testing.T) *Psbt {
+			p := NewPsbtV2(2, 0)
+			if _, err := p.AddInput(bytes.Repeat([]byte{1}, 32), 0, 0xfffffffe); err != nil {
+				t.Fatal(err)
+			}
+			p.SetRequiredLockTime(0, 1700000000)
+			return p
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.psbt(t)
+			raw, err := p.Serialize()
+			if err != nil {
+				t.Fatal(err)
+			}
+			parsed, err := ParsePsbt(raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			again, err := parsed.Serialize()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(again, raw) {
+				t.Errorf("round trip gave %x, want %x", again, raw)
+			}
+
+			encoded, err := p.Base64()
+			if err != nil {
+				t.Fatal(err)
+			}
+			fromBase64, err := ParsePsbtBase64(encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fromBase64.InputCount() != p.InputCount() || fromBase64.OutputCount() != p.OutputCount() {
+				t.Errorf("base64 round trip has %d inputs and %d outputs", fromBase64.InputCount(), fromBase64.OutputCount())
+			}
+		})
+	}
+}
+
+// psbtField builds a map entry for the invalid PSBT cases
+func psbtField(keyType byte, keyData []byte, value []byte) psbtKeyValue {
+	return psbtKeyValue{key: append([]byte{keyType}, keyData...), value: value}
+}
+
+// encodePsbtMaps serializes the magic and the maps as given, without checking the fields
+func encodePsbtMaps(maps ...[]psbtKeyValue) []byte {
+	result := []byte(PSBT_MAGIC)
+	for _, entries := range maps {
+		result = appendPsbtUnknown(result, entries)
+		result = append(result, 0x00)
+	}
+	return result
+}
+
+// uint32LE encodes a PSBT integer field
+func uint32LE(v uint32) []byte {
+	return binary.LittleEndian.AppendUint32(nil, v)
+}
+
+// psbtV0Maps returns the global, input and output maps of a minimal version 0 PSBT
+func psbtV0Maps() [][]psbtKeyValue {
+	return [][]psbtKeyValue{
+		{psbtField(PSBT_GLOBAL_UNSIGNED_TX, nil, unsignedTestTx().Serialize())},
+		{},
+		{},
+	}
+}
+
+// psbtV2Maps returns the global, input and output maps of a minimal version 2 PSBT
+func psbtV2Maps() [][]psbtKeyValue {
+	return [][]psbtKeyValue{
+		{
+			psbtField(PSBT_GLOBAL_TX_VERSION, nil, uint32LE(2)),
+			psbtField(PSBT_GLOBAL_INPUT_COUNT, nil, []byte{1}),
+			psbtField(PSBT_GLOBAL_OUTPUT_COUNT, nil, []byte{1}),
+			psbtField(PSBT_GLOBAL_VERSION, nil, uint32LE(2)),
+		},
+		{
+			psbtField(PSBT_IN_PREVIOUS_TXID, nil, bytes.Repeat([]byte{1}, 32)),
+			psbtField(PSBT_IN_OUTPUT_INDEX, nil, uint32LE(0)),
+		},
+		{
+			psbtField(PSBT_OUT_AMOUNT, nil, binary.LittleEndian.AppendUint64(nil, 90000)),
+			psbtField(PSBT_OUT_SCRIPT, nil, P2wpkhScript(make([]byte, 20)).rawSerialize()),
+		},
+	}
+}
+
+// withField adds an entry to one of the maps
+func withField(maps [][]psbtKeyValue, mapIdx int, entry psbtKeyValue) [][]psbtKeyValue {
+	maps[mapIdx] = append(maps[mapIdx], entry)
+	return maps
+}
+
+// withoutField drops the entries of a key type from one of the maps
+func withoutField(maps [][]psbtKeyValue, mapIdx int, keyType byte) [][]psbtKeyValue {
+	entries := make([]psbtKeyValue, 0)
+	for _, entry := range maps[mapIdx] {
+		if entry.key[0] != keyType {
+			entries = append(entries, entry)
+		}
+	}
+	maps[mapIdx] = entries
+	return maps
+}
+
+func TestParsePsbtInvalid(t *testing.T) {
+	withScriptSig := unsignedTestTx()
+	withScriptSig.txInputs[0].SetScriptSig(InitScriptSig([]ScriptCmd{DataPush([]byte{1, 2, 3})}))
+	withUnparsed := unsignedTestTx()
+	withUnparsed.txInputs[0].SetScriptSig(&ScriptSig{bitcoinOpCode: NewBitcoinOpCode(), unparsed: []byte{OP_PUSHDATA1}})
+	withWitness := unsignedTestTx()
+	withWitness.txInputs[0].SetWitness([][]byte{{1}})
+	withWitness.segwit = true
+
+	// the valid maps encoded with the key of the first global field written as a non-canonical 0xfd compact size
+	nonCanonical := encodePsbtMaps(psbtV0Maps()...)
+	nonCanonical = append(append(append([]byte{}, nonCanonical[:len(PSBT_MAGIC)]...), 0xfd, 0x01, 0x00), nonCanonical[len(PSBT_MAGIC)+1:]...)
+
+	tests := []struct {
+		name string
+		raw  []byte
+		err  error
+	}{
+		{"network transaction", unsignedTestTx().Serialize(), ErrPsbtFormat},
+		{"empty after the magic", []byte(PSBT_MAGIC), ErrPsbtFormat},
+		{"missing output map", encodePsbtMaps(psbtV0Maps()[:2]...), ErrPsbtFormat},
+		{"trailing bytes", append(encodePsbtMaps(psbtV0Maps()...), 0x00), ErrPsbtFormat},
+		{"non-canonical compact size", nonCanonical, ErrPsbtFormat},
+		{"value past the end", append([]byte(PSBT_MAGIC), 0x01, 0x00, 0xfd, 0xff, 0xff), ErrPsbtFormat},
+		{"missing unsigned transaction", encodePsbtMaps(withoutField(psbtV0Maps(), 0, PSBT_GLOBAL_UNSIGNED_TX)...), ErrPsbtFormat},
+		{"unsigned transaction with a scriptSig", encodePsbtMaps(
+			[]psbtKeyValue{psbtField(PSBT_GLOBAL_UNSIGNED_TX, nil, withScriptSig.Serialize())}, nil, nil), ErrPsbtFormat},
+		{"unsigned transaction with an unparsable scriptSig", encodePsbtMaps(
+			[]psbtKeyValue{psbtField(PSBT_GLOBAL_UNSIGNED_TX, nil, withUnparsed.Serialize())}, nil, nil), ErrPsbtFormat},
+		{"unsigned transaction with a witness", encodePsbtMaps(
+			[]psbtKeyValue{psbtField(PSBT_GLOBAL_UNSIGNED_TX, nil, withWitness.Serialize())}, nil, nil), ErrPsbtFormat},
+		{"unsigned transaction key with key data", encodePsbtMaps(
+			[]psbtKeyValue{psbtField(PSBT_GLOBAL_UNSIGNED_TX, []byte{0}, unsignedTestTx().Serialize())}, nil, nil), ErrPsbtFormat},
+		{"duplicate input key", encodePsbtMaps(withField(withField(psbtV0Maps(),
+			1, psbtField(PSBT_IN_SIGHASH_TYPE, nil, uint32LE(1))), 1, psbtField(PSBT_IN_SIGHASH_TYPE, nil, uint32LE(1)))...), ErrPsbtFormat},
+		{"witness utxo key with key data", encodePsbtMaps(withField(psbtV0Maps(),
+			1, psbtField(PSBT_IN_WITNESS_UTXO, []byte{0}, make([]byte, 9)))...), ErrPsbtFormat},
+		{"sighash type of 3 bytes", encodePsbtMaps(withField(psbtV0Maps(),
+			1, psbtField(PSBT_IN_SIGHASH_TYPE, nil, []byte{1, 0, 0}))...), ErrPsbtFormat},
+		{"partial signature with a bad public key", encodePsbtMaps(withField(psbtV0Maps(),
+			1, psbtField(PSBT_IN_PARTIAL_SIG, []byte{0x05, 1}, []byte{1}))...), ErrPsbtFormat},
+		{"output derivation with a short fingerprint", encodePsbtMaps(withField(psbtV0Maps(),
+			2, psbtField(PSBT_OUT_BIP32_DERIVATION, append([]byte{0x02}, make([]byte, 32)...), []byte{1, 2}))...), ErrPsbtFormat},
+		{"version 0 with a transaction version", encodePsbtMaps(withField(psbtV0Maps(),
+			0, psbtField(PSBT_GLOBAL_TX_VERSION, nil, uint32LE(2)))...), ErrPsbtFormat},
+		{"version 0 with a previous txid", encodePsbtMaps(withField(psbtV0Maps(),
+			1, psbtField(PSBT_IN_PREVIOUS_TXID, nil, make([]byte, 32)))...), ErrPsbtFormat},
+		{"version 0 with an output amount", encodePsbtMaps(withField(psbtV0Maps(),
+			2, psbtField(PSBT_OUT_AMOUNT, nil, make([]byte, 8)))...), ErrPsbtFormat},
+		{"version 1", encodePsbtMaps(withField(psbtV0Maps(),
+			0, psbtField(PSBT_GLOBAL_VERSION, nil, uint32LE(1)))...), ErrPsbtVersion},
+		{"version 2 with an unsigned transaction", encodePsbtMaps(withField(psbtV2Maps(),
+			0, psbtField(PSBT_GLOBAL_UNSIGNED_TX, nil, unsignedTestTx().Serialize()))...), ErrPsbtFormat},
+		{"version 2 without an input count", encodePsbtMaps(withoutField(psbtV2Maps(), 0, PSBT_GLOBAL_INPUT_COUNT)...), ErrPsbtFormat},
+		{"version 2 without a previous txid", encodePsbtMaps(withoutField(psbtV2Maps(), 1, PSBT_IN_PREVIOUS_TXID)...), ErrPsbtFormat},
+		{"version 2 without an output index", encodePsbtMaps(withoutField(psbtV2Maps(), 1, PSBT_IN_OUTPUT_INDEX)...), ErrPsbtFormat},
+		{"version 2 without an output amount", encodePsbtMaps(withoutField(psbtV2Maps(), 2, PSBT_OUT_AMOUNT)...), ErrPsbtFormat},
+		{"version 2 without an output script", encodePsbtMaps(withoutField(psbtV2Maps(), 2, PSBT_OUT_SCRIPT)...), ErrPsbtFormat},
+		{"version 2 required time lock below the threshold", encodePsbtMaps(withField(psbtV2Maps(),
+			1, psbtField(PSBT_IN_REQUIRED_TIME_LOCKTIME, nil, uint32LE(LOCKTIME_THRESHOLD-1)))...), ErrPsbtFormat},
+		{"version 2 required height lock of zero", encodePsbtMaps(withField(psbtV2Maps(),
+			1, psbtField(PSBT_IN_REQUIRED_HEIGHT_LOCKTIME, nil, uint32LE(0)))...), ErrPsbtFormat},
+		{"version 2 input count over the remaining bytes", encodePsbtMaps(withField(withoutField(psbtV2Maps(), 0, PSBT_GLOBAL_INPUT_COUNT),
+			0, psbtField(PSBT_GLOBAL_INPUT_COUNT, nil, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}))...), ErrPsbtFormat},
+		{"version 2 non-canonical input count", encodePsbtMaps(withField(withoutField(psbtV2Maps(), 0, PSBT_GLOBAL_INPUT_COUNT),
+			0, psbtField(PSBT_GLOBAL_INPUT_COUNT, nil, []byte{0xfd, 0x01, 0x00}))...), ErrPsbtFormat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParsePsbt(tt.raw); !errors.Is(err, tt.err) {
+				t.Errorf("got %v, want %v", err, tt.err)
+			}
+		})
+	}
+
+	// the untouched maps are valid, so each case fails only on what it changes
+	for _, maps := range [][][]psbtKeyValue{psbtV0Maps(), psbtV2Maps()} {
+		if _, err := ParsePsbt(encodePsbtMaps(maps...)); err != nil {
+			t.Errorf("valid maps: %v", err)
+		}
+	}
+}
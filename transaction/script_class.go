@@ -0,0 +1,211 @@
+package transaction
+
+// ScriptClass is the standard template a scriptPubKey matches
+type ScriptClass int
+
+const (
+	SCRIPT_NONSTANDARD ScriptClass = iota
+	SCRIPT_P2PK
+	SCRIPT_P2PKH
+	SCRIPT_P2SH
+	SCRIPT_P2MS
+	SCRIPT_P2WPKH
+	SCRIPT_P2WSH
+	SCRIPT_P2TR
+	SCRIPT_NULL_DATA
+	SCRIPT_WITNESS_UNKNOWN
+)
+
+// String returns the class name Bitcoin Core uses for the template
+func (c ScriptClass) String() string {
+	switch c {
+	case SCRIPT_P2PK:
+		return "pubkey"
+	case SCRIPT_P2PKH:
+		return "pubkeyhash"
+	case SCRIPT_P2SH:
+		return "scripthash"
+	case SCRIPT_P2MS:
+		return "multisig"
+	case SCRIPT_P2WPKH:
+		return "witness_v0_keyhash"
+	case SCRIPT_P2WSH:
+		return "witness_v0_scripthash"
+	case SCRIPT_P2TR:
+		return "witness_v1_taproot"
+	case SCRIPT_NULL_DATA:
+		return "nulldata"
+	case SCRIPT_WITNESS_UNKNOWN:
+		return "witness_unknown"
+	}
+	return "nonstandard"
+}
+
+// ScriptTemplate is the result of classifying a script together with the keys and hashes it commits to
+type ScriptTemplate struct {
+	class ScriptClass
+	// required is the number of signatures a multisig needs
+	required int
+	// pubKeys holds the key of P2PK, the keys of a multisig and the output key of P2TR
+	pubKeys [][]byte
+	// hash is the hash160 of P2PKH, P2SH and P2WPKH or the sha256 of P2WSH
+	hash           []byte
+	witnessVersion int
+	program        []byte
+	// data holds the pushes following OP_RETURN
+	data [][]byte
+}
+
+// Classify matches the script against the standard templates
+func (s *ScriptSig) Classify() *ScriptTemplate {
+	return classifyCmds(s.bitcoinOpCode.cmds)
+}
+
+// Class returns the template the script matches
+func (t *ScriptTemplate) Class() ScriptClass {
+	return t.class
+}
+
+// Required returns the number of signatures a multisig needs
+func (t *ScriptTemplate) Required() int {
+	return t.required
+}
+
+// PubKeys returns the public keys of P2PK and multisig scripts and the output key of P2TR
+func (t *ScriptTemplate) PubKeys() [][]byte {
+	return t.pubKeys
+}
+
+// Hash returns the key or script hash of P2PKH, P2SH, P2WPKH and P2WSH scripts
+func (t *ScriptTemplate) Hash() []byte {
+	return t.hash
+}
+
+// WitnessProgram returns the witness version and program when the script is a witness program
+func (t *ScriptTemplate) WitnessProgram() (int, []byte, bool) {
+	if t.program == nil {
+		return 0, nil, false
+	}
+	return t.witnessVersion, t.program, true
+}
+
+// Data returns the pushes of a null data script
+func (t *ScriptTemplate) Data() [][]byte {
+	return t.data
+}
+
+func classifyCmds(cmds []ScriptCmd) *ScriptTemplate {
+	if version, program, ok := matchWitnessProgram(cmds); ok {
+		template := &ScriptTemplate{class: SCRIPT_WITNESS_UNKNOWN, witnessVersion: version, program: program}
+		switch {
+		case version == 0 && len(program) == 20:
+			template.class, template.hash = SCRIPT_P2WPKH, program
+		case version == 0 && len(program) == 32:
+			template.class, template.hash = SCRIPT_P2WSH, program
+		case version == 0:
+			// version 0 only defines P2WPKH and P2WSH programs
+			return &ScriptTemplate{class: SCRIPT_NONSTANDARD}
+		case version == 1 && len(program) == 32:
+			template.class, template.pubKeys = SCRIPT_P2TR, [][]byte{program}
+		}
+		return template
+	}
+
+	switch {
+	case len(cmds) == 2 && isValidPubKeySize(cmds[0]) && isOp(cmds[1], OP_CHECKSIG):
+		return &ScriptTemplate{class: SCRIPT_P2PK, pubKeys: [][]byte{cmds[0].data}}
+	case len(cmds) == 5 && isOp(cmds[0], OP_DUP) && isOp(cmds[1], OP_HASH160) && !isOpCode(cmds[2]) &&
+		len(cmds[2].data) == 20 && isDirectPush(cmds[2]) && isOp(cmds[3], OP_EQUALVERIFY) && isOp(cmds[4], OP_CHECKSIG):
+		return &ScriptTemplate{class: SCRIPT_P2PKH, hash: cmds[2].data}
+	case len(cmds) == 3 && isOp(cmds[0], OP_HASH160) && !isOpCode(cmds[1]) && len(cmds[1].data) == 20 && isDirectPush(cmds[1]) &&
+		isOp(cmds[2], OP_EQUAL):
+		return &ScriptTemplate{class: SCRIPT_P2SH, hash: cmds[1].data}
+	case len(cmds) > 0 && isOp(cmds[0], OP_RETURN) && isPushOnly(cmds[1:]):
+		data := make([][]byte, 0, len(cmds)-1)
+		for _, cmd := range cmds[1:] {
+			data = append(data, pushedBytes(cmd))
+		}
+		return &ScriptTemplate{class: SCRIPT_NULL_DATA, data: data}
+	}
+
+	if m, pubKeys, ok := matchMultisig(cmds); ok {
+		return &ScriptTemplate{class: SCRIPT_P2MS, required: m, pubKeys: pubKeys}
+	}
+
+	return &ScriptTemplate{class: SCRIPT_NONSTANDARD}
+}
+
+// isDirectPush checks the push uses its length as the opcode, templates are matched on the exact bytes
+func isDirectPush(cmd ScriptCmd) bool {
+	return cmd.opCode == 0 || int(cmd.opCode) == len(cmd.data)
+}
+
+// matchWitnessProgram matches a version opcode followed by a 2 to 40 byte program, as BIP 141 defines
+func matchWitnessProgram(cmds []ScriptCmd) (int, []byte, bool) {
+	if len(cmds) != 2 || isOpCode(cmds[1]) || !isDirectPush(cmds[1]) || len(cmds[1].data) < 2 || len(cmds[1].data) > 40 {
+		return 0, nil, false
+	}
+
+	switch version := smallInteger(cmds[0]); {
+	case isOp(cmds[0], OP_0):
+		return 0, cmds[1].data, true
+	case version > 0:
+		return version, cmds[1].data, true
+	}
+	return 0, nil, false
+}
+
+// matchMultisig matches OP_m <pubkeys...> OP_n OP_CHECKMULTISIG
+func matchMultisig(cmds []ScriptCmd) (int, [][]byte, bool) {
+	if len(cmds) < 4 || !isOp(cmds[len(cmds)-1], OP_CHECKMULTISIG) {
+		return 0, nil, false
+	}
+
+	m := smallInteger(cmds[0])
+	n := smallInteger(cmds[len(cmds)-2])
+	if m < 1 || n < 1 || m > n || n != len(cmds)-3 {
+		return 0, nil, false
+	}
+	pubKeys := make([][]byte, 0, n)
+	for _, cmd := range cmds[1 : len(cmds)-2] {
+		if !isValidPubKeySize(cmd) {
+			return 0, nil, false
+		}
+		pubKeys = append(pubKeys, cmd.data)
+	}
+
+	return m, pubKeys, true
+}
+
+// smallInteger returns the value of an OP_1..OP_16 command, or 0 for anything else
+func smallInteger(cmd ScriptCmd) int {
+	if !isOpCode(cmd) || cmd.opCode < OP_1 || cmd.opCode > OP_16 {
+		return 0
+	}
+	return int(cmd.opCode) - OP_1 + 1
+}
+
+// isValidPubKeySize checks the length of a SEC public key against its prefix byte
+func isValidPubKeySize(cmd ScriptCmd) bool {
+	pubKey := cmd.data
+	if isOpCode(cmd) || len(pubKey) == 0 {
+		return false
+	}
+	switch pubKey[0] {
+	case 0x02, 0x03:
+		return len(pubKey) == 33
+	case 0x04, 0x06, 0x07:
+		return len(pubKey) == 65
+	}
+	return false
+}
+
+// isPushOnly checks that the commands only push data, counting OP_1NEGATE and OP_1..OP_16 as pushes
+func isPushOnly(cmds []ScriptCmd) bool {
+	for _, cmd := range cmds {
+		if isOpCode(cmd) && cmd.opCode > OP_16 {
+			return false
+		}
+	}
+	return true
+}
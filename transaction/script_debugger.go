@@ -0,0 +1,139 @@
+package transaction
+
+// ScriptDebugger evaluates a script one command at a time, the way EvaluateWithContext would, so a
+// front end can step through it, run to a breakpoint and look at the stacks in between
+type ScriptDebugger struct {
+	opCode *BitcoinOpCode
+	z      []byte
+	// breakpoints holds the opcodes execution stops in front of
+	breakpoints map[byte]bool
+	// last is the most recent step, the tracer hook keeps it up to date
+	last     *TraceStep
+	finished bool
+	err      error
+}
+
+// NewScriptDebugger prepares the evaluation of script against the message hash z, ctx may be nil
+// when the script has no timelocks. The script itself is left untouched
+func NewScriptDebugger(script *ScriptSig, z []byte, ctx *TxContext) *ScriptDebugger {
+	opCode := NewBitcoinOpCode()
+	opCode.cmds = append(opCode.cmds, script.bitcoinOpCode.cmds...)
+	opCode.witness = script.bitcoinOpCode.witness
+	opCode.txContext = ctx
+
+	d := &ScriptDebugger{
+		opCode:      opCode,
+		z:           z,
+		breakpoints: make(map[byte]bool),
+	}
+	opCode.SetTracer(func(step *TraceStep) {
+		d.last = step
+	})
+
+	// witness programs are expanded up front, just like EvaluateWithContext does
+	if script.unparsed != nil {
+		d.stop(opCode.fail(ErrScriptMalformed))
+	} else if opCode.handleSegwit() != true {
+		d.stop(opCode.fail(ErrScriptWitnessMismatch))
+	} else if !opCode.HasCmd() {
+		d.stop(d.end())
+	}
+	return d
+}
+
+// SetBreakpoint makes Continue stop in front of every occurrence of the opcode
+func (d *ScriptDebugger) SetBreakpoint(op byte) {
+	d.breakpoints[op] = true
+}
+
+// ClearBreakpoint removes the breakpoint on the opcode
+func (d *ScriptDebugger) ClearBreakpoint(op byte) {
+	delete(d.breakpoints, op)
+}
+
+// Step executes the next command and returns the state after it, nil once the script finished.
+// Running the last command also runs the end of script checks, see Result for the outcome
+func (d *ScriptDebugger) Step() *TraceStep {
+	if d.finished {
+		return nil
+	}
+
+	if err := d.opCode.step(d.z); err != nil {
+		d.stop(err)
+		return d.last
+	}
+	step := d.last
+	if !d.opCode.HasCmd() {
+		d.stop(d.end())
+	}
+	return step
+}
+
+// Continue executes commands until the next one is an opcode with a breakpoint or the script
+// finishes, it returns the last step executed
+func (d *ScriptDebugger) Continue() *TraceStep {
+	step := d.Step()
+	for !d.finished && !d.atBreakpoint() {
+		step = d.Step()
+	}
+	return step
+}
+
+// atBreakpoint checks whether the next command is an opcode with a breakpoint
+func (d *ScriptDebugger) atBreakpoint() bool {
+	next := d.NextCmd()
+	return next != nil && next.IsOpCode() && d.breakpoints[next.opCode]
+}
+
+// NextCmd returns the command Step would execute next, nil once the script finished
+func (d *ScriptDebugger) NextCmd() *ScriptCmd {
+	if d.finished || !d.opCode.HasCmd() {
+		return nil
+	}
+	next := d.opCode.cmds[0]
+	return &next
+}
+
+// Remaining returns the commands left to execute, redeem and witness scripts show up once expanded
+func (d *ScriptDebugger) Remaining() *ScriptSig {
+	return InitScriptSig(append([]ScriptCmd{}, d.opCode.cmds...))
+}
+
+// Stack returns the main stack, the top is the last item
+func (d *ScriptDebugger) Stack() [][]byte {
+	return append([][]byte{}, d.opCode.stack...)
+}
+
+// AltStack returns the alt stack
+func (d *ScriptDebugger) AltStack() [][]byte {
+	return append([][]byte{}, d.opCode.altStack...)
+}
+
+// CondStack returns one entry per open OP_IF or OP_NOTIF, false while its branch is skipped
+func (d *ScriptDebugger) CondStack() []bool {
+	return append([]bool{}, d.opCode.condStack...)
+}
+
+// Finished tells whether the script has run to its end or failed
+func (d *ScriptDebugger) Finished() bool {
+	return d.finished
+}
+
+// Result returns whether the script succeeded and why it failed, it is only final once Finished
+func (d *ScriptDebugger) Result() (bool, error) {
+	return d.finished && d.err == nil, d.err
+}
+
+// end runs the checks EvaluateWithContext does once the commands ran out
+func (d *ScriptDebugger) end() error {
+	if err := d.opCode.endOfScript(); err != nil {
+		return err
+	}
+	return d.opCode.checkResult()
+}
+
+// stop marks the evaluation finished with its outcome
+func (d *ScriptDebugger) stop(err error) {
+	d.finished = true
+	d.err = err
+}
@@ -1,7 +1,6 @@
 package transaction
 
 import (
-	"bufio"
 	"fmt"
 	"io"
 	"math/big"
@@ -9,8 +8,18 @@ import (
 
 // Represents a Bitcoin script
 type ScriptSig struct {
-	cmds          [][]byte
 	bitcoinOpCode *BitcoinOpCode
+	// unparsed holds the raw bytes of a script with a push running past its end
+	unparsed []byte
+}
+
+// ScriptCmd is a single command of a script, either an opcode or a push of data
+type ScriptCmd struct {
+	// opCode is the push opcode for parsed pushes, zero when the shortest one should be used
+	opCode byte
+	// data holds the pushed bytes, push tells a push of one byte apart from an opcode
+	data []byte
+	push bool
 }
 
 // Script push-data opcode constants
@@ -19,57 +28,67 @@ const (
 	SCRIPT_DATA_LENGTH_END   = 75
 	OP_PUSHDATA1             = 76
 	OP_PUSHDATA2             = 77
+	OP_PUSHDATA4             = 78
+	MAX_SCRIPT_ELEMENT_SIZE  = 520
+	MAX_PUBKEYS_PER_MULTISIG = 20
 )
 
-// Parses a script from a binary reader
-func NewScriptSig(reader *bufio.Reader) *ScriptSig {
-	cmds := [][]byte{}
-
-	scriptLen := ReadVarint(reader).Int64()
-	count := int64(0)
-	current := make([]byte, 1)
-	var current_byte byte
-
-	for count < scriptLen {
-		io.ReadFull(reader, current)
-		count += 1
-		current_byte = current[0]
-
-		if current_byte >= SCRIPT_DATA_LENGTH_BEGIN && current_byte <= SCRIPT_DATA_LENGTH_END {
-			// push the following bytes as data on the stack
-			data := make([]byte, current_byte)
-			io.ReadFull(reader, data)
-			cmds = append(cmds, data)
-			count += int64(current_byte)
-		} else if current_byte == OP_PUSHDATA1 {
-			length := make([]byte, 1)
-			io.ReadFull(reader, length)
-			data := make([]byte, length[0])
-			reader.Read(data)
-			cmds = append(cmds, data)
-			count += int64(length[0] + 1)
-		} else if current_byte == OP_PUSHDATA2 {
-			lenBuf := make([]byte, 2)
-			io.ReadFull(reader, lenBuf)
-			length := LittleEndianToBigInt(lenBuf, LITTLE_ENDIAN_2_BYTES)
-			data := make([]byte, length.Int64())
-			cmds = append(cmds, data)
-			count += length.Int64() + 2
-		} else {
-			// current byte is an instruction
-			cmds = append(cmds, []byte{current_byte})
-		}
-	}
+// OpCmd returns the command executing the opcode
+func OpCmd(op byte) ScriptCmd {
+	return ScriptCmd{opCode: op}
+}
+
+// DataPush returns a command that always serializes as a push of data, even when it is one byte
+func DataPush(data []byte) ScriptCmd {
+	return ScriptCmd{data: data, push: true}
+}
+
+// IsOpCode checks whether the command is an opcode rather than pushed data
+func (c ScriptCmd) IsOpCode() bool {
+	return !c.push
+}
 
-	if count != scriptLen {
-		panic("parsing script field failed")
+// OpCode returns the opcode of the command, only meaningful when IsOpCode
+func (c ScriptCmd) OpCode() byte {
+	return c.opCode
+}
+
+// Data returns the bytes the command pushes, nil for opcodes
+func (c ScriptCmd) Data() []byte {
+	return c.data
+}
+
+// isOpCode checks whether the command is an opcode rather than pushed data
+func isOpCode(cmd ScriptCmd) bool {
+	return cmd.IsOpCode()
+}
+
+// pushCmds turns stack items into the data pushes of a scriptSig
+func pushCmds(items [][]byte) []ScriptCmd {
+	cmds := make([]ScriptCmd, 0, len(items))
+	for _, item := range items {
+		cmds = append(cmds, DataPush(item))
 	}
+	return cmds
+}
 
-	return InitScriptSig(cmds)
+// Parses a length prefixed script from a binary reader
+func NewScriptSig(reader io.Reader) (*ScriptSig, error) {
+	return newTxDecoder(reader).script()
+}
+
+// parseScriptBytes parses script bytes without a length prefix, such as redeem and witness scripts,
+// returning an error for pushes that run past the end
+func parseScriptBytes(raw []byte) (*ScriptSig, error) {
+	cmds, err := parseScriptCmds(raw)
+	if err != nil {
+		return nil, fmt.Errorf("parse script: %w", err)
+	}
+	return InitScriptSig(cmds), nil
 }
 
 // Creates a new ScriptSig from a list of commands
-func InitScriptSig(cmds [][]byte) *ScriptSig {
+func InitScriptSig(cmds []ScriptCmd) *ScriptSig {
 	bitcoinOpCode := NewBitcoinOpCode()
 	bitcoinOpCode.cmds = cmds
 	return &ScriptSig{
@@ -84,30 +103,17 @@ func (s *ScriptSig) SetWitness(witness [][]byte) {
 
 // Executes all commands in the ScriptSig against the given message hash `z`
 func (s *ScriptSig) Evaluate(z []byte) bool {
-	s.bitcoinOpCode.handleP2wpkh()
-
-	for s.bitcoinOpCode.HasCmd() {
-		cmd := s.bitcoinOpCode.RemoveCmd()
-		if len(cmd) == 1 {
-			//this is an op code, run it
-			opRes := s.bitcoinOpCode.ExecuteOperation(int(cmd[0]), z)
-			if opRes != true {
-				return false
-			}
-		} else {
-			s.bitcoinOpCode.AppendDataElement(cmd)
-		}
-	}
-
-	if len(s.bitcoinOpCode.stack) == 0 {
-		return false
-	}
+	return s.EvaluateWithContext(z, nil)
+}
 
-	if len(s.bitcoinOpCode.stack[len(s.bitcoinOpCode.stack)-1]) == 0 {
+// EvaluateWithContext executes the script with access to the spending transaction, which
+// OP_CHECKLOCKTIMEVERIFY and OP_CHECKSEQUENCEVERIFY require
+func (s *ScriptSig) EvaluateWithContext(z []byte, ctx *TxContext) bool {
+	if s.unparsed != nil {
 		return false
 	}
-
-	return true
+	s.bitcoinOpCode.txContext = ctx
+	return s.bitcoinOpCode.evaluate(z) == nil
 }
 
 // Serializes the script with length prefix (varint)
@@ -117,7 +123,6 @@ func (s *ScriptSig) Serialize() []byte {
 	result := []byte{}
 
 	// encode the total length of the script at the head
-	fmt.Printf("total: %+v\n", total)
 	result = append(result, EncodeVarint(big.NewInt(int64(total)))...)
 	result = append(result, rawResult...)
 	return result
@@ -125,31 +130,52 @@ func (s *ScriptSig) Serialize() []byte {
 
 // Combines two ScriptSig scripts into a single ScriptSig
 func (s *ScriptSig) Add(script *ScriptSig) *ScriptSig {
-	cmds := make([][]byte, 0)
+	if s.unparsed != nil || script.unparsed != nil {
+		raw := append(s.rawSerialize(), script.rawSerialize()...)
+		return &ScriptSig{bitcoinOpCode: NewBitcoinOpCode(), unparsed: raw}
+	}
+	cmds := make([]ScriptCmd, 0)
 	cmds = append(cmds, s.bitcoinOpCode.cmds...)
 	cmds = append(cmds, script.bitcoinOpCode.cmds...)
 	return InitScriptSig(cmds)
 }
 
-// Prints the script command at the given index to standard output
-func (s *ScriptSig) PrintCmd(idx int) {
+// witnessProgram returns the witness version and program when the script is a BIP-141 witness program
+func (s *ScriptSig) witnessProgram() (int, []byte, bool) {
+	return s.Classify().WitnessProgram()
+}
+
+// CmdAsm returns the command at the given index in ASM form
+func (s *ScriptSig) CmdAsm(idx int) (string, error) {
 	if idx < 0 || idx >= len(s.bitcoinOpCode.cmds) {
-		fmt.Printf("idx out of range")
+		return "", fmt.Errorf("invalid index %d for script command", idx)
 	}
 
-	fmt.Printf("%s\n", string(s.bitcoinOpCode.cmds[idx]))
+	return s.cmdAsm(s.bitcoinOpCode.cmds[idx], false), nil
 }
 
 // Serializes script commands without length prefix
 func (s *ScriptSig) rawSerialize() []byte {
+	if s.unparsed != nil {
+		return append([]byte{}, s.unparsed...)
+	}
+
 	result := []byte{}
 	for _, cmd := range s.bitcoinOpCode.cmds {
-		if len(cmd) == 1 {
-			// only one byte means its an instruction
-			result = append(result, cmd...)
+		if isOpCode(cmd) {
+			result = append(result, cmd.opCode)
 		} else {
-			length := len(cmd)
-			if length <= SCRIPT_DATA_LENGTH_END {
+			length := len(cmd.data)
+			// parsed pushes keep their opcode, so pushes that weren't minimal round-trip
+			if cmd.opCode == OP_PUSHDATA1 {
+				result = append(result, OP_PUSHDATA1, byte(length))
+			} else if cmd.opCode == OP_PUSHDATA2 {
+				result = append(result, OP_PUSHDATA2)
+				result = append(result, BigIntToLittleEndian(big.NewInt(int64(length)), LITTLE_ENDIAN_2_BYTES)...)
+			} else if cmd.opCode == OP_PUSHDATA4 {
+				result = append(result, OP_PUSHDATA4)
+				result = append(result, BigIntToLittleEndian(big.NewInt(int64(length)), LITTLE_ENDIAN_4_BYTES)...)
+			} else if length <= SCRIPT_DATA_LENGTH_END {
 				// length in [0x01, 0x4b]
 				result = append(result, byte(length))
 			} else if length > SCRIPT_DATA_LENGTH_END && length < 0x100 {
@@ -157,16 +183,18 @@ func (s *ScriptSig) rawSerialize() []byte {
 				// push the command and then the next byte is the length of the data
 				result = append(result, OP_PUSHDATA1)
 				result = append(result, byte(length))
-			} else if length >= 0x100 && length <= 520 {
+			} else if length >= 0x100 && length < 0x10000 {
 				result = append(result, OP_PUSHDATA2)
 				lenBuf := BigIntToLittleEndian(big.NewInt(int64(length)), LITTLE_ENDIAN_2_BYTES)
 				result = append(result, lenBuf...)
 			} else {
-				panic("too long an cmd")
+				result = append(result, OP_PUSHDATA4)
+				lenBuf := BigIntToLittleEndian(big.NewInt(int64(length)), LITTLE_ENDIAN_4_BYTES)
+				result = append(result, lenBuf...)
 			}
 
 			// append the chunk of data with given length
-			result = append(result, cmd...)
+			result = append(result, cmd.data...)
 		}
 	}
 
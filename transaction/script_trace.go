@@ -0,0 +1,165 @@
+package transaction
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrScriptOpFailed              = errors.New("script operation failed")
+	ErrScriptUnbalancedConditional = errors.New("unbalanced conditional")
+	ErrScriptWitnessMismatch       = errors.New("witness does not match the witness program")
+	ErrScriptEvalFalse             = errors.New("script evaluated without true on the stack")
+	ErrScriptCleanStack            = errors.New("witness script left more than one item on the stack")
+	ErrScriptMalformed             = errors.New("script has a push past its end")
+)
+
+// ScriptTracer is called after every command the interpreter goes through, including the ones
+// skipped inside an untaken branch. Failures found once the commands ran out, such as an
+// unbalanced OP_IF or a false result, are reported as a step without a command
+type ScriptTracer func(step *TraceStep)
+
+// TraceStep is the state of the interpreter right after a command
+type TraceStep struct {
+	index     int
+	cmd       *ScriptCmd
+	op        string
+	executed  bool
+	stack     [][]byte
+	altStack  [][]byte
+	condStack []bool
+	err       error
+}
+
+// Index returns the position of the step, starting at 0
+func (t *TraceStep) Index() int {
+	return t.index
+}
+
+// Cmd returns the command of the step, nil for a failure at the end of the script
+func (t *TraceStep) Cmd() *ScriptCmd {
+	return t.cmd
+}
+
+// Op returns the command in ASM form
+func (t *TraceStep) Op() string {
+	return t.op
+}
+
+// Executed tells whether the command ran, commands inside an untaken branch are skipped
+func (t *TraceStep) Executed() bool {
+	return t.executed
+}
+
+// Stack returns the main stack after the command, the top is the last item
+func (t *TraceStep) Stack() [][]byte {
+	return t.stack
+}
+
+// AltStack returns the alt stack after the command
+func (t *TraceStep) AltStack() [][]byte {
+	return t.altStack
+}
+
+// CondStack returns one entry per open OP_IF or OP_NOTIF, false while its branch is skipped
+func (t *TraceStep) CondStack() []bool {
+	return t.condStack
+}
+
+// Err returns why the script failed at this step, nil when it did not
+func (t *TraceStep) Err() error {
+	return t.err
+}
+
+// String renders the step on a single line
+func (t *TraceStep) String() string {
+	op := t.op
+	if t.cmd == nil {
+		op = "<end>"
+	} else if !t.executed {
+		op += " (skipped)"
+	}
+
+	result := fmt.Sprintf("%d %s stack: %s altstack: %s condstack: %v", t.index, op, stackHex(t.stack),
+		stackHex(t.altStack), t.condStack)
+	if t.err != nil {
+		result += " error: " + t.err.Error()
+	}
+	return result
+}
+
+// stackHex renders stack items as hex, bottom first
+func stackHex(stack [][]byte) string {
+	items := make([]string, 0, len(stack))
+	for _, item := range stack {
+		items = append(items, hex.EncodeToString(item))
+	}
+	return "[" + strings.Join(items, " ") + "]"
+}
+
+// SetTracer sets the hook told about every step of the execution, nil turns tracing off
+func (b *BitcoinOpCode) SetTracer(tracer ScriptTracer) {
+	b.tracer = tracer
+}
+
+// SetTracer sets the hook told about every step when the script is evaluated
+func (s *ScriptSig) SetTracer(tracer ScriptTracer) {
+	s.bitcoinOpCode.SetTracer(tracer)
+}
+
+// trace hands a snapshot of the interpreter to the tracer
+func (b *BitcoinOpCode) trace(cmd *ScriptCmd, executed bool, err error) {
+	if b.tracer == nil {
+		return
+	}
+
+	step := &TraceStep{
+		index:     b.steps,
+		cmd:       cmd,
+		executed:  executed,
+		stack:     append([][]byte{}, b.stack...),
+		altStack:  append([][]byte{}, b.altStack...),
+		condStack: append([]bool{}, b.condStack...),
+		err:       err,
+	}
+	if cmd != nil {
+		step.op = (&ScriptSig{bitcoinOpCode: b}).cmdAsm(*cmd, false)
+	}
+	b.steps += 1
+	b.tracer(step)
+}
+
+// fail reports a failure not caused by a single command to the tracer
+func (b *BitcoinOpCode) fail(err error) error {
+	b.trace(nil, true, err)
+	return err
+}
+
+// opFailure builds the reason an opcode failed
+func (b *BitcoinOpCode) opFailure(op byte) error {
+	return fmt.Errorf("%w: %s", ErrScriptOpFailed, (&ScriptSig{bitcoinOpCode: b}).opCodeName(op))
+}
+
+// evaluate runs the whole script the way EvaluateWithContext does, the error says why it failed
+func (b *BitcoinOpCode) evaluate(z []byte) error {
+	if b.handleSegwit() != true {
+		return b.fail(ErrScriptWitnessMismatch)
+	}
+	if err := b.run(z); err != nil {
+		return err
+	}
+	return b.checkResult()
+}
+
+// checkResult checks the script left true on top of the stack, witness scripts must leave nothing else
+func (b *BitcoinOpCode) checkResult() error {
+	if len(b.stack) == 0 || !castToBool(b.stack[len(b.stack)-1]) {
+		return b.fail(ErrScriptEvalFalse)
+	}
+	if b.witnessExpanded && len(b.stack) != 1 {
+		return b.fail(ErrScriptCleanStack)
+	}
+	return nil
+}
@@ -0,0 +1,488 @@
+package transaction
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/sudonite/bitcoin/chaincfg"
+	ecc "github.com/sudonite/bitcoin/elliptic_curve"
+)
+
+// decodeHex decodes a hex test vector
+func decodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	raw, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+// parseTestTx parses a transaction and attaches the outputs it spends, given as amount and scriptPubKey hex pairs
+func parseTestTx(t *testing.T, txHex string, prevOuts ...prevOut) *Transaction {
+	t.Helper()
+	tx, err := ParseTransaction(decodeHex(t, txHex))
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputs := make([]*TransactionOutput, 0, len(prevOuts))
+	for _, prev := range prevOuts {
+		script, err := parseScriptBytes(decodeHex(t, prev.scriptPubKey))
+		if err != nil {
+			t.Fatal(err)
+		}
+		outputs = append(outputs, InitTransactionOutput(prev.amount, script))
+	}
+	if err := tx.SetPreviousOutputs(outputs); err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+// prevOut is an output spent by a test transaction
+type prevOut struct {
+	amount       Amount
+	scriptPubKey string
+}
+
+// BIP-143 examples, the second input of the native P2WPKH one spends a P2WPKH output
+const (
+	bip143NativeP2wpkhTx = "01000000000102fff7f7881a8099afa6940d42d1e7f6362bec38171ea3edf433541db4e4ad969f00000000494830450221008b9d1dc26ba6a9cb62127b02742fa9d754cd3bebf337f7a55d114c8e5cdd30be022040529b194ba3f9281a99f2b1c0a19c0489bc22ede944ccf4ecbab4cc618ef3ed01eeffffffef51e1b804cc89d182d279655c3aa89e815b1b309fe287d9b2b55d57b90ec68a0100000000ffffffff02202cb206000000001976a9148280b37df378db99f66f85c95a783a76ac7a6d5988ac9093510d000000001976a9143bde42dbee7e4dbe6a21b2d50ce2f0167faa815988ac000247304402203609e17b84f6a7d30c80bfa610b5b4542f32a8a0d5447a12fb1366d7f01cc44a0220573a954c4518331561406f90300e8f3358f51928d43c212a8caed02de67eebee0121025476c2e83188368da1ff3e292e7acafcdb3566bb0ad253f62fc70f07aeee635711000000"
+	bip143NestedP2wpkhTx = "01000000000101db6b1b20aa0fd7b23880be2ecbd4a98130974cf4748fb66092ac4d3ceb1a5477010000001716001479091972186c449eb1ded22b78e40d009bdf0089feffffff02b8b4eb0b000000001976a914a457b684d7f0d539a46a45bbc043f35b59d0d96388ac0008af2f000000001976a914fd270b1ee6abcaea97fea7ad0402e8bd8ad6d77c88ac02473044022047ac8e878352d3ebbde1c94ce3a10d057c24175747116f8288e5d794d12d482f0220217f36a485cae903c713331d877c1f64677e3622ad4010726870540656fe9dcb012103ad1d8e89212f0b92c74d23bb710c00662ad1470198ac48c43f7d6f93a2a2687392040000"
+	// P2SH-P2WSH 6-of-6 multisig signed with each of the six hash types
+	bip143NestedP2wshTx = "0100000000010136641869ca081e70f394c6948e8af409e18b619df2ed74aa106c1ca29787b96e0100000023220020a16b5755f7f6f96dbd65f5f0d6ab9418b89af4b1f14a1bb8a09062c35f0dcb54ffffffff0200e9a435000000001976a914389ffce9cd9ae88dcc0631e88a821ffdbe9bfe2688acc0832f05000000001976a9147480a33f950689af511e6e84c138dbbd3c3ee41588ac080047304402206ac44d672dac41f9b00e28f4df20c52eeb087207e8d758d76d92c6fab3b73e2b0220367750dbbe19290069cba53d096f44530e4f98acaa594810388cf7409a1870ce01473044022068c7946a43232757cbdf9176f009a928e1cd9a1a8c212f15c1e11ac9f2925d9002205b75f937ff2f9f3c1246e547e54f62e027f64eefa2695578cc6432cdabce271502473044022059ebf56d98010a932cf8ecfec54c48e6139ed6adb0728c09cbe1e4fa0915302e022007cd986c8fa870ff5d2b3a89139c9fe7e499259875357e20fcbb15571c76795403483045022100fbefd94bd0a488d50b79102b5dad4ab6ced30c4069f1eaa69a4b5a763414067e02203156c6a5c9cf88f91265f5a942e96213afae16d83321c8b31bb342142a14d16381483045022100a5263ea0553ba89221984bd7f0b13613db16e7a70c549a86de0cc0444141a407022005c360ef0ae5a5d4f9f2f87a56c1546cc8268cab08c73501d6b3be2e1e1a8a08824730440220525406a1482936d5a21888260dc165497a90a15669636d8edca6b9fe490d309c022032af0c646a34a44d1f4576bf6a4a74b67940f8faa84c7df9abe12a01a11e2b4783cf56210307b8ae49ac90a048e9b53357a2354b3334e9c8bee813ecb98e99a7e07e8c3ba32103b28f0c28bfab54554ae8c658ac5c3e0ce6e79ad336331f78c428dd43eea8449b21034b8113d703413d57761b8b9781957b8c0ac1dfe69f492580ca4195f50376ba4a21033400f6afecb833092a9a21cfdf1ed1376e58c5d1f47de74683123987e967a8f42103a6d48b1131e94ba04d9737d61acdaa1322008af9602b3b14862c07a1789aac162102d8b661b0b3302ee2f162b09e07a55ad5dfbe673a9f01d9f0c19617681024306b56ae00000000"
+)
+
+var (
+	bip143NativeP2wpkhPrevOuts = []prevOut{
+		{625000000, "2103c9f4836b9a4f77fc0d81f7bcb01b7f1b35916864b9476c241ce9fc198bd25432ac"},
+		{600000000, "00141d0f172a0ecb48aee1be1f2687d2963ae33f71a1"},
+	}
+	bip143NestedP2wpkhPrevOuts = []prevOut{
+		{1000000000, "a9144733f37cf4db86fbc2efed2500b4f4e49f31202387"},
+	}
+	bip143NestedP2wshPrevOuts = []prevOut{
+		{987654321, "a9149993a429037b5d912407a71c252019287b8d27a587"},
+	}
+)
+
+func TestBIP143SigHash(t *testing.T) {
+	tests := []struct {
+		name     string
+		tx       string
+		prevOuts []prevOut
+		inputIdx int
+		want     string
+	}{
+		{
+			name:     "native P2WPKH",
+			tx:       bip143NativeP2wpkhTx,
+			prevOuts: bip143NativeP2wpkhPrevOuts,
+			inputIdx: 1,
+			want:     "c37af31116d1b27caf68aae9e3ac82f1477929014d5b917657d0eb49478cb670",
+		},
+		{
+			name:     "P2SH-P2WPKH",
+			tx:       bip143NestedP2wpkhTx,
+			prevOuts: bip143NestedP2wpkhPrevOuts,
+			inputIdx: 0,
+			want:     "64f3b0f4dd2bb3aa1ce8566d220cc74dda9df97d8490cc81d89d735c92e59fb6",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := parseTestTx(t, tt.tx, tt.prevOuts...)
+			z, err := tx.BIP143SigHash(tt.inputIdx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := hex.EncodeToString(z); got != tt.want {
+				t.Errorf("sighash %s, want %s", got, tt.want)
+			}
+			if !tx.VerifyInput(tt.inputIdx) {
+				t.Errorf("input %d does not verify", tt.inputIdx)
+			}
+		})
+	}
+}
+
+func TestSigHashCache(t *testing.T) {
+	tx := parseTestTx(t, bip143NativeP2wpkhTx, bip143NativeP2wpkhPrevOuts...)
+	sigHashes := NewSigHashCache(tx)
+
+	tests := []struct {
+		name string
+		got  []byte
+		want string
+	}{
+		{"hashPrevouts", sigHashes.HashPrevouts(), "96b827c8483d4e9b96712b6713a7b68d6e8003a781feba36c31143470b4efd37"},
+		{"hashSequence", sigHashes.HashSequence(), "52b0a642eea2fb7ae638c36f6252b6750293dbe574a806984b8e4d8548339a3b"},
+		{"hashOutputs", sigHashes.HashOutputs(), "863ef3e1a92afbfdb97f31ad0fc7683ee943e9abcf2501590ff8f6551f47e5e5"},
+	}
+	for _, tt := range tests {
+		if got := hex.EncodeToString(tt.got); got != tt.want {
+			t.Errorf("%s %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	// changing the transaction is seen by the next verification, the cache lives for one pass only
+	tx.txInputs[1].SetSequence(0)
+	if tx.VerifyInput(1) {
+		t.Error("input verifies after its sequence changed")
+	}
+}
+
+func TestBIP143SigHashTypes(t *testing.T) {
+	tx := parseTestTx(t, bip143NestedP2wshTx, bip143NestedP2wshPrevOuts...)
+	witness := tx.txInputs[0].witness
+	witnessScript, err := parseScriptBytes(witness[len(witness)-1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		hashType byte
+		want     string
+	}{
+		{"ALL", SIGHASH_ALL, "185c0be5263dce5b4bb50a047973c1b6272bfbd0103a89444597dc40b248ee7c"},
+		{"NONE", SIGHASH_NONE, "e9733bc60ea13c95c6527066bb975a2ff29a925e80aa14c213f686cbae5d2f36"},
+		{"SINGLE", SIGHASH_SINGLE, "1e1f1c303dc025bd664acb72e583e933fae4cff9148bf78c157d1e8f78530aea"},
+		{"ALL|ANYONECANPAY", SIGHASH_ALL | SIGHASH_ANYONECANPAY, "2a67f03e63a6a422125878b40b82da593be8d4efaafe88ee528af6e5a9955c6e"},
+		{"NONE|ANYONECANPAY", SIGHASH_NONE | SIGHASH_ANYONECANPAY, "781ba15f3779d5542ce8ecb5c18716733a5ee42a6f51488ec96154934e2c890a"},
+		{"SINGLE|ANYONECANPAY", SIGHASH_SINGLE | SIGHASH_ANYONECANPAY, "511e8e52ed574121fc1b654970395502128263f62662e076dc6baf05c2e6a99b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			z, err := tx.bip143SigHash(NewSigHashCache(tx), 0, witnessScript.Serialize(), tt.hashType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := hex.EncodeToString(z); got != tt.want {
+				t.Errorf("sighash %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	// the 6-of-6 multisig carries one signature of each hash type
+	if !tx.VerifyInput(0) {
+		t.Error("input does not verify")
+	}
+}
+
+// witnessSig says which scriptCode and hash type a witness signature commits to,
+// the scriptCode starts at the given command of the witness script
+type witnessSig struct {
+	codeFrom int
+	hashType byte
+}
+
+func TestVerifyCodeSeparator(t *testing.T) {
+	key := ecc.NewPrivateKey(big.NewInt(8675309))
+	_, sec := key.GetPublicKey().Sec(true)
+
+	executed := []ScriptCmd{DataPush(sec), OpCmd(OP_CHECKSIGVERIFY), OpCmd(OP_CODESEPARATOR), DataPush(sec), OpCmd(OP_CHECKSIG)}
+	unexecuted := []ScriptCmd{OpCmd(OP_0), OpCmd(OP_IF), OpCmd(OP_CODESEPARATOR), OpCmd(OP_ENDIF), DataPush(sec), OpCmd(OP_CHECKSIG)}
+
+	tests := []struct {
+		name   string
+		script []ScriptCmd
+		// sigs are listed bottom of the stack first
+		sigs []witnessSig
+		want bool
+	}{
+		{"executed separator", executed, []witnessSig{{3, SIGHASH_SINGLE}, {0, SIGHASH_ALL}}, true},
+		{"executed separator with other hash types", executed, []witnessSig{{3, SIGHASH_NONE | SIGHASH_ANYONECANPAY}, {0, SIGHASH_SINGLE | SIGHASH_ANYONECANPAY}}, true},
+		{"executed separator ignored", executed, []witnessSig{{0, SIGHASH_ALL}, {0, SIGHASH_ALL}}, false},
+		{"unexecuted separator", unexecuted, []witnessSig{{0, SIGHASH_SINGLE | SIGHASH_ANYONECANPAY}}, true},
+		{"unexecuted separator honoured", unexecuted, []witnessSig{{4, SIGHASH_SINGLE | SIGHASH_ANYONECANPAY}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			witnessScript := InitScriptSig(tt.script)
+			builder := NewTransactionBuilder(&chaincfg.RegTestParams)
+			builder.AddInput(bytes.Repeat([]byte{1}, 32), 0, 100000, P2wshScript(sha256Bytes(witnessScript.rawSerialize())))
+			builder.AddOutput(90000, P2pkhScript(make([]byte, 20)))
+			tx := builder.Build()
+
+			witness := make([][]byte, 0)
+			for _, s := range tt.sigs {
+				z, err := tx.bip143SigHash(NewSigHashCache(tx), 0, InitScriptSig(tt.script[s.codeFrom:]).Serialize(), s.hashType)
+				if err != nil {
+					t.Fatal(err)
+				}
+				witness = append(witness, append(key.Sign(new(big.Int).SetBytes(z)).Der(), s.hashType))
+			}
+			tx.txInputs[0].SetWitness(append(witness, witnessScript.rawSerialize()))
+
+			if got := tx.VerifyInput(0); got != tt.want {
+				t.Errorf("verify %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// evaluateP2wsh evaluates a P2WSH output against the witness arguments and script
+func evaluateP2wsh(witnessScript []byte, args ...[]byte) bool {
+	program := sha256.Sum256(witnessScript)
+	script := P2wshScript(program[:])
+	script.SetWitness(append(args, witnessScript))
+	return script.Evaluate(nil)
+}
+
+func TestEvaluateWitness(t *testing.T) {
+	tests := []struct {
+		name          string
+		witnessScript []byte
+		args          [][]byte
+		want          bool
+	}{
+		{"true", []byte{OP_1}, nil, true},
+		{"false", []byte{OP_0}, nil, false},
+		{"argument checked by the script", []byte{OP_EQUAL}, [][]byte{{1, 2}, {1, 2}}, true},
+		{"wrong argument", []byte{OP_EQUAL}, [][]byte{{1, 2}, {1, 3}}, false},
+		{"negative zero is false", []byte{OP_DROP, 0x01, 0x80}, [][]byte{{1}}, false},
+		{"unclean stack", []byte{OP_1, OP_1}, nil, false},
+		{"arguments left on the stack", []byte{OP_1}, [][]byte{{1}}, false},
+		{"element of 520 bytes", []byte{OP_DROP, OP_1}, [][]byte{bytes.Repeat([]byte{1}, 520)}, true},
+		{"element over 520 bytes", []byte{OP_DROP, OP_1}, [][]byte{bytes.Repeat([]byte{1}, 521)}, false},
+		{"malformed witness script", []byte{OP_PUSHDATA1}, nil, false},
+		{"disabled opcode", []byte{OP_1, OP_1, 126}, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := evaluateP2wsh(tt.witnessScript, tt.args...); got != tt.want {
+				t.Errorf("evaluate %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateNestedWitness(t *testing.T) {
+	for _, want := range []bool{true, false} {
+		witnessScript := []byte{OP_0}
+		if want {
+			witnessScript = []byte{OP_1}
+		}
+		program := sha256.Sum256(witnessScript)
+		redeemScript := append([]byte{OP_0, 32}, program[:]...)
+		scriptPubKey := P2shScript(ecc.Hash160(redeemScript))
+		script := InitScriptSig([]ScriptCmd{DataPush(redeemScript)}).Add(scriptPubKey)
+		script.SetWitness([][]byte{witnessScript})
+		if got := script.Evaluate(nil); got != want {
+			t.Errorf("witness script %x: evaluate %v, want %v", witnessScript, got, want)
+		}
+	}
+}
+
+func TestEvaluateMultisigCounts(t *testing.T) {
+	// keys pushes OP_1 as each of count public keys, a key that doesn't parse is fine with no signatures
+	keys := func(count int) []byte {
+		return bytes.Repeat([]byte{OP_1}, count)
+	}
+	multisig := func(parts ...[]byte) []byte {
+		return append(bytes.Join(parts, nil), OP_CHECKMULTISIG)
+	}
+
+	tests := []struct {
+		name   string
+		script []byte
+		want   bool
+	}{
+		{"0-of-0", multisig([]byte{OP_0, OP_0, OP_0}), true},
+		{"0-of-20", multisig([]byte{OP_0, OP_0}, keys(20), []byte{0x01, 20}), true},
+		{"0-of-21", multisig([]byte{OP_0, OP_0}, keys(21), []byte{0x01, 21}), false},
+		{"negative key count", multisig([]byte{0x01, 0x81}), false},
+		{"negative signature count", multisig([]byte{OP_0, 0x01, 0x81, OP_0}), false},
+		{"more signatures than keys", multisig([]byte{OP_0, OP_0, OP_0, OP_2}, keys(1), []byte{OP_1}), false},
+		{"key count of 5 bytes", multisig([]byte{OP_0, OP_0, 0x05, 0x00, 0x00, 0x00, 0x00, 0x00}), false},
+		{"missing dummy element", multisig([]byte{OP_0, OP_0}), false},
+		{"dummy element not empty", multisig([]byte{OP_1, OP_0, OP_0}), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script, err := parseScriptBytes(tt.script)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := script.Evaluate(nil); got != tt.want {
+				t.Errorf("evaluate %v, want %v", got, tt.want)
+			}
+			if got := evaluateP2wsh(tt.script); got != tt.want {
+				t.Errorf("evaluate as witness script %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateWitnessMismatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  *ScriptSig
+		witness [][]byte
+	}{
+		{"P2WSH without witness", P2wshScript(make([]byte, 32)), nil},
+		{"P2WSH with another script", P2wshScript(make([]byte, 32)), [][]byte{{OP_1}}},
+		{"P2WPKH with one item", P2wpkhScript(make([]byte, 20)), [][]byte{{1}}},
+		{"P2WPKH with three items", P2wpkhScript(make([]byte, 20)), [][]byte{{1}, {2}, {3}}},
+		{"version 0 program of 25 bytes", InitScriptSig([]ScriptCmd{OpCmd(OP_0), DataPush(make([]byte, 25))}), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.script.SetWitness(tt.witness)
+			if tt.script.Evaluate(nil) {
+				t.Error("witness program evaluated")
+			}
+		})
+	}
+}
+
+func TestEvaluateMalformedRedeemScript(t *testing.T) {
+	redeemScript := []byte{OP_PUSHDATA1}
+	script := InitScriptSig([]ScriptCmd{DataPush(redeemScript)}).Add(P2shScript(ecc.Hash160(redeemScript)))
+	if script.Evaluate(nil) {
+		t.Error("malformed redeem script evaluated")
+	}
+}
+
+// signedSegwitTx spends a P2WPKH output, wrapped in P2SH when nested, with a transaction built by TransactionBuilder
+func signedSegwitTx(t *testing.T, nested bool) *Transaction {
+	t.Helper()
+	key := ecc.NewPrivateKey(big.NewInt(8675309))
+	_, sec := key.GetPublicKey().Sec(true)
+	program := P2wpkhScript(ecc.Hash160(sec))
+
+	builder := NewTransactionBuilder(&chaincfg.RegTestParams)
+	if nested {
+		idx := builder.AddInput(bytes.Repeat([]byte{1}, 32), 0, 100000, P2shScript(ecc.Hash160(program.rawSerialize())))
+		if err := builder.SetRedeemScript(idx, program); err != nil {
+			t.Fatal(err)
+		}
+	} else {
+		builder.AddInput(bytes.Repeat([]byte{1}, 32), 0, 100000, program)
+	}
+	builder.AddOutput(90000, P2pkhScript(make([]byte, 20)))
+
+	tx, err := builder.Sign([]*ecc.PrivateKey{key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func TestVerifySegwitScriptSig(t *testing.T) {
+	tests := []struct {
+		name      string
+		nested    bool
+		scriptSig func(redeemScript []byte) *ScriptSig
+		want      bool
+	}{
+		{"native with empty scriptSig", false, func([]byte) *ScriptSig { return InitScriptSig([]ScriptCmd{}) }, true},
+		{"native with a push", false, func([]byte) *ScriptSig { return InitScriptSig([]ScriptCmd{DataPush([]byte{1})}) }, false},
+		{"native with an unparsable scriptSig", false, func([]byte) *ScriptSig {
+			return &ScriptSig{bitcoinOpCode: NewBitcoinOpCode(), unparsed: []byte{OP_PUSHDATA1}}
+		}, false},
+		{"nested with the redeem script", true, func(redeemScript []byte) *ScriptSig {
+			return InitScriptSig([]ScriptCmd{DataPush(redeemScript)})
+		}, true},
+		{"nested with an extra push", true, func(redeemScript []byte) *ScriptSig {
+			return InitScriptSig([]ScriptCmd{DataPush([]byte{1}), DataPush(redeemScript)})
+		}, false},
+		{"nested with an extra opcode", true, func(redeemScript []byte) *ScriptSig {
+			return InitScriptSig([]ScriptCmd{OpCmd(OP_1), OpCmd(OP_DROP), DataPush(redeemScript)})
+		}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := signedSegwitTx(t, tt.nested)
+			tx.txInputs[0].SetScriptSig(tt.scriptSig(tx.txInputs[0].redeemScript()))
+			if got := tx.VerifyInput(0); got != tt.want {
+				t.Errorf("verify %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyBadSignature(t *testing.T) {
+	tests := []struct {
+		name    string
+		witness func(witness [][]byte) [][]byte
+	}{
+		{"truncated signature", func(w [][]byte) [][]byte { return [][]byte{w[0][:10], w[1]} }},
+		{"signature with only the hash type", func(w [][]byte) [][]byte { return [][]byte{{SIGHASH_ALL}, w[1]} }},
+		{"public key not on the curve", func(w [][]byte) [][]byte { return [][]byte{w[0], append([]byte{0x02}, make([]byte, 32)...)} }},
+		{"empty public key", func(w [][]byte) [][]byte { return [][]byte{w[0], {}} }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := signedSegwitTx(t, false)
+			tx.txInputs[0].SetWitness(tt.witness(tx.txInputs[0].witness))
+			if tx.VerifyInput(0) {
+				t.Error("input verified")
+			}
+			if err := tx.VerifyContext(t.Context(), 2); err == nil {
+				t.Error("transaction verified")
+			}
+		})
+	}
+}
+
+func TestVerifyHostileScripts(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+	}{
+		{"negative key count", "0181ae"},
+		{"negative signature count", "00018100ae"},
+		{"key count too large", "0115ae"},
+		{"five byte key count", "050000000001ae"},
+		{"checksig on an empty stack", "ac"},
+		{"checkmultisig on an empty stack", "ae"},
+	}
+
+	for _, tt := range tests {
+		for _, witness := range []bool{false, true} {
+			name := tt.name
+			if witness {
+				name += " in P2WSH"
+			}
+			t.Run(name, func(t *testing.T) {
+				script := decodeHex(t, tt.script)
+				scriptPubKey, err := parseScriptBytes(script)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if witness {
+					scriptPubKey = P2wshScript(sha256Bytes(script))
+				}
+
+				builder := NewTransactionBuilder(&chaincfg.RegTestParams)
+				builder.AddInput(bytes.Repeat([]byte{1}, 32), 0, 100000, scriptPubKey)
+				builder.AddOutput(90000, P2pkhScript(make([]byte, 20)))
+				tx := builder.Build()
+				if witness {
+					tx.txInputs[0].SetWitness([][]byte{script})
+				}
+
+				// evaluated outside the worker pool, a panic fails the test
+				if tx.VerifyInput(0) {
+					t.Error("input verified")
+				}
+				if err := tx.VerifyContext(t.Context(), 1); !errors.Is(err, ErrInputInvalid) {
+					t.Errorf("verify error %v, want %v", err, ErrInputInvalid)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package transaction
+
+import (
+	"encoding/binary"
+	"sync"
+)
+
+// SigHashCache holds the hashes the BIP-143 and BIP-341 signature hashes of every input share, so
+// signing or verifying a transaction stays linear in its size. Each group is worked out on first
+// use and the cache is safe to share between goroutines. It is meant for a single signing or
+// verification pass, changing the outpoints, sequences, outputs or spent outputs needs a new one
+type SigHashCache struct {
+	tx *Transaction
+
+	// single SHA256 of the outpoints, sequences and outputs, BIP-143 hashes them once more
+	hashesOnce   sync.Once
+	shaPrevouts  []byte
+	shaSequences []byte
+	shaOutputs   []byte
+	hashPrevouts []byte
+	hashSequence []byte
+	hashOutputs  []byte
+
+	// BIP-341 also commits to the amounts and scripts of every spent output, which may need fetching
+	spentOnce        sync.Once
+	spentErr         error
+	spent            []*TransactionOutput
+	shaAmounts       []byte
+	shaScriptPubKeys []byte
+}
+
+// NewSigHashCache creates an empty cache for the transaction
+func NewSigHashCache(tx *Transaction) *SigHashCache {
+	return &SigHashCache{tx: tx}
+}
+
+// BIP143SigHash computes the BIP-143 signature hash of an input like Transaction.BIP143SigHash
+func (c *SigHashCache) BIP143SigHash(inputIdx int) ([]byte, error) {
+	scriptCode, err := c.tx.bip143ScriptCode(inputIdx)
+	if err != nil {
+		return nil, err
+	}
+	return c.tx.bip143SigHash(c, inputIdx, scriptCode, SIGHASH_ALL)
+}
+
+// BIP341SigHash computes the taproot signature hash of an input like Transaction.BIP341SigHash
+func (c *SigHashCache) BIP341SigHash(inputIdx int, hashType byte, annex []byte, leafHash []byte) ([]byte, error) {
+	return c.tx.bip341SigHash(c, inputIdx, hashType, annex, leafHash, TAPSCRIPT_NO_CODESEPARATOR)
+}
+
+// computeHashes serializes the outpoints, sequences and outputs once for both signature hash versions
+func (c *SigHashCache) computeHashes() {
+	c.hashesOnce.Do(func() {
+		prevouts := make([]byte, 0)
+		sequences := make([]byte, 0)
+		for _, txIn := range c.tx.txInputs {
+			prevouts = append(prevouts, ReverseByteSlice(txIn.previousTransactionID)...)
+			prevouts = binary.LittleEndian.AppendUint32(prevouts, txIn.previousTransactionIndex)
+			sequences = binary.LittleEndian.AppendUint32(sequences, txIn.sequence)
+		}
+		outputs := make([]byte, 0)
+		for _, txOut := range c.tx.txOutputs {
+			outputs = append(outputs, txOut.Serialize()...)
+		}
+
+		c.shaPrevouts = sha256Bytes(prevouts)
+		c.shaSequences = sha256Bytes(sequences)
+		c.shaOutputs = sha256Bytes(outputs)
+		// hash256 is the SHA256 of the single SHA256
+		c.hashPrevouts = sha256Bytes(c.shaPrevouts)
+		c.hashSequence = sha256Bytes(c.shaSequences)
+		c.hashOutputs = sha256Bytes(c.shaOutputs)
+	})
+}
+
+// computeSpent looks up the spent outputs once and hashes their amounts and scripts
+func (c *SigHashCache) computeSpent() error {
+	c.spentOnce.Do(func() {
+		c.spent, c.spentErr = c.tx.spentOutputs()
+		if c.spentErr != nil {
+			return
+		}
+
+		amounts := make([]byte, 0)
+		scriptPubKeys := make([]byte, 0)
+		for _, output := range c.spent {
+			amounts = binary.LittleEndian.AppendUint64(amounts, uint64(output.amount))
+			scriptPubKeys = append(scriptPubKeys, output.scriptPubKey.Serialize()...)
+		}
+		c.shaAmounts = sha256Bytes(amounts)
+		c.shaScriptPubKeys = sha256Bytes(scriptPubKeys)
+	})
+	return c.spentErr
+}
+
+// HashPrevouts returns the BIP-143 hashPrevouts, the hash256 of every outpoint
+func (c *SigHashCache) HashPrevouts() []byte {
+	c.computeHashes()
+	return c.hashPrevouts
+}
+
+// HashSequence returns the BIP-143 hashSequence, the hash256 of every input sequence
+func (c *SigHashCache) HashSequence() []byte {
+	c.computeHashes()
+	return c.hashSequence
+}
+
+// HashOutputs returns the BIP-143 hashOutputs, the hash256 of every output
+func (c *SigHashCache) HashOutputs() []byte {
+	c.computeHashes()
+	return c.hashOutputs
+}
+
+// ShaPrevouts returns the BIP-341 sha_prevouts, the SHA256 of every outpoint
+func (c *SigHashCache) ShaPrevouts() []byte {
+	c.computeHashes()
+	return c.shaPrevouts
+}
+
+// ShaSequences returns the BIP-341 sha_sequences, the SHA256 of every input sequence
+func (c *SigHashCache) ShaSequences() []byte {
+	c.computeHashes()
+	return c.shaSequences
+}
+
+// ShaOutputs returns the BIP-341 sha_outputs, the SHA256 of every output
+func (c *SigHashCache) ShaOutputs() []byte {
+	c.computeHashes()
+	return c.shaOutputs
+}
+
+// ShaAmounts returns the BIP-341 sha_amounts, the SHA256 of the amounts of every spent output
+func (c *SigHashCache) ShaAmounts() ([]byte, error) {
+	if err := c.computeSpent(); err != nil {
+		return nil, err
+	}
+	return c.shaAmounts, nil
+}
+
+// ShaScriptPubKeys returns the BIP-341 sha_scriptpubkeys, the SHA256 of the scripts of every spent output
+func (c *SigHashCache) ShaScriptPubKeys() ([]byte, error) {
+	if err := c.computeSpent(); err != nil {
+		return nil, err
+	}
+	return c.shaScriptPubKeys, nil
+}
+
+// spentOutput returns the output spent by the input at inputIdx
+func (c *SigHashCache) spentOutput(inputIdx int) (*TransactionOutput, error) {
+	if err := c.computeSpent(); err != nil {
+		return nil, err
+	}
+	return c.spent[inputIdx], nil
+}
@@ -0,0 +1,169 @@
+package transaction
+
+import "fmt"
+
+// a block may use this much sigop cost, a legacy or P2SH sigop costs WITNESS_SCALE_FACTOR and a witness sigop one
+const MAX_BLOCK_SIGOPS_COST = 80000
+
+// SigOpCount counts the signature checks in the script. An inaccurate count, the one legacy block
+// limits use, takes every CHECKMULTISIG as MAX_PUBKEYS_PER_MULTISIG checks while an accurate one
+// reads the key count from the OP_1..OP_16 before it
+func (s *ScriptSig) SigOpCount(accurate bool) int {
+	return countSigOps(s.bitcoinOpCode.cmds, accurate)
+}
+
+// P2SHSigOpCount counts the sigops of the scriptPubKey when spent by scriptSig, for P2SH that is
+// the accurate count of the redeem script the scriptSig pushes last
+func (s *ScriptSig) P2SHSigOpCount(scriptSig *ScriptSig) int {
+	if s.Classify().Class() != SCRIPT_P2SH {
+		return s.SigOpCount(true)
+	}
+
+	redeem, ok := p2shRedeemScript(scriptSig)
+	if !ok {
+		return 0
+	}
+	return redeem.SigOpCount(true)
+}
+
+// WitnessSigOpCount counts the sigops of a witness spend of scriptPubKey, directly or nested in P2SH.
+// P2WPKH is a single check, P2WSH counts its witness script accurately and other versions have none
+func WitnessSigOpCount(scriptSig *ScriptSig, scriptPubKey *ScriptSig, witness [][]byte) int {
+	if scriptPubKey.Classify().Class() == SCRIPT_P2SH {
+		redeem, ok := p2shRedeemScript(scriptSig)
+		if !ok {
+			return 0
+		}
+		scriptPubKey = redeem
+	}
+
+	version, program, ok := scriptPubKey.witnessProgram()
+	switch {
+	case !ok || version != 0:
+		return 0
+	case len(program) == 20:
+		return 1
+	case len(program) == 32 && len(witness) > 0:
+		witnessScript, err := parseScriptBytes(witness[len(witness)-1])
+		if err != nil {
+			return 0
+		}
+		return witnessScript.SigOpCount(true)
+	}
+	return 0
+}
+
+// LegacySigOpCount counts the sigops of every scriptSig and scriptPubKey inaccurately, as the original block limit does
+func (t *Transaction) LegacySigOpCount() int {
+	count := 0
+	for _, txInput := range t.txInputs {
+		count += txInput.scriptSig.SigOpCount(false)
+	}
+	for _, txOutput := range t.txOutputs {
+		count += txOutput.scriptPubKey.SigOpCount(false)
+	}
+	return count
+}
+
+// P2SHSigOpCount counts the sigops of the redeem scripts of inputs spending P2SH outputs, which
+// needs the outputs being spent
+func (t *Transaction) P2SHSigOpCount() (int, error) {
+	if t.IsCoinBase() {
+		return 0, nil
+	}
+
+	prevOutputs, err := t.spentOutputs()
+	if err != nil {
+		return 0, err
+	}
+	return t.p2shSigOpCount(prevOutputs), nil
+}
+
+// SigOpCost returns the sigop cost of the transaction counted against MAX_BLOCK_SIGOPS_COST
+func (t *Transaction) SigOpCost() (int, error) {
+	if t.IsCoinBase() {
+		return t.LegacySigOpCount() * WITNESS_SCALE_FACTOR, nil
+	}
+
+	prevOutputs, err := t.spentOutputs()
+	if err != nil {
+		return 0, err
+	}
+	return t.sigOpCost(prevOutputs), nil
+}
+
+// BlockSigOpCost adds up the sigop cost of the transactions of a block
+func BlockSigOpCost(txs []*Transaction) (int, error) {
+	total := 0
+	for i, tx := range txs {
+		cost, err := tx.SigOpCost()
+		if err != nil {
+			return 0, fmt.Errorf("transaction %d: %w", i, err)
+		}
+		total += cost
+	}
+	return total, nil
+}
+
+// sigOpCost computes the sigop cost of a non-coinbase transaction given the outputs its inputs spend
+func (t *Transaction) sigOpCost(prevOutputs []*TransactionOutput) int {
+	cost := (t.LegacySigOpCount() + t.p2shSigOpCount(prevOutputs)) * WITNESS_SCALE_FACTOR
+	for i, txInput := range t.txInputs {
+		cost += WitnessSigOpCount(txInput.scriptSig, prevOutputs[i].scriptPubKey, txInput.witness)
+	}
+	return cost
+}
+
+// p2shSigOpCount counts the redeem script sigops of the inputs spending P2SH outputs
+func (t *Transaction) p2shSigOpCount(prevOutputs []*TransactionOutput) int {
+	count := 0
+	for i, txInput := range t.txInputs {
+		if prevOutputs[i].scriptPubKey.Classify().Class() == SCRIPT_P2SH {
+			count += prevOutputs[i].scriptPubKey.P2SHSigOpCount(txInput.scriptSig)
+		}
+	}
+	return count
+}
+
+// p2shRedeemScript returns the script a push only scriptSig pushes last, the redeem script of a P2SH spend
+func p2shRedeemScript(scriptSig *ScriptSig) (*ScriptSig, bool) {
+	cmds := scriptSig.bitcoinOpCode.cmds
+	if len(cmds) == 0 || !isPushOnly(cmds) {
+		return nil, false
+	}
+
+	last := cmds[len(cmds)-1]
+	if isOpCode(last) {
+		// OP_0 and OP_1..OP_16 push numbers, as scripts they are empty
+		return InitScriptSig([]ScriptCmd{}), true
+	}
+	redeem, err := parseScriptBytes(last.data)
+	if err != nil {
+		return nil, false
+	}
+	return redeem, true
+}
+
+// countSigOps counts the signature checks of parsed script commands
+func countSigOps(cmds []ScriptCmd, accurate bool) int {
+	count := 0
+	for i, cmd := range cmds {
+		if !isOpCode(cmd) {
+			continue
+		}
+		switch cmd.opCode {
+		case OP_CHECKSIG, OP_CHECKSIGVERIFY:
+			count++
+		case OP_CHECKMULTISIG, OP_CHECKMULTISIGVERIFY:
+			keys := 0
+			if accurate && i > 0 {
+				keys = smallInteger(cmds[i-1])
+			}
+			if keys == 0 {
+				keys = MAX_PUBKEYS_PER_MULTISIG
+			}
+			count += keys
+		}
+	}
+	return count
+}
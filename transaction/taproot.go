@@ -0,0 +1,326 @@
+package transaction
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+
+	ecc "github.com/sudonite/bitcoin/elliptic_curve"
+)
+
+const (
+	TAPROOT_LEAF_TAPSCRIPT      = 0xc0
+	TAPROOT_LEAF_MASK           = 0xfe
+	TAPROOT_ANNEX_TAG           = 0x50
+	TAPROOT_CONTROL_BASE_SIZE   = 33
+	TAPROOT_CONTROL_NODE_SIZE   = 32
+	TAPROOT_CONTROL_MAX_NODES   = 128
+	VALIDATION_WEIGHT_OFFSET    = 50
+	VALIDATION_WEIGHT_PER_SIGOP = 50
+	// codesep_pos committed to when no OP_CODESEPARATOR was executed
+	TAPSCRIPT_NO_CODESEPARATOR = 0xffffffff
+)
+
+var (
+	ErrInvalidControlBlock = errors.New("invalid taproot control block")
+	ErrInvalidSigHashType  = errors.New("invalid taproot sighash type")
+)
+
+// ControlBlock is the last witness item of a taproot script path spend
+type ControlBlock struct {
+	leafVersion  byte
+	outputParity byte
+	internalKey  []byte
+	path         [][]byte
+}
+
+// ParseControlBlock parses a BIP-341 control block: leaf version and parity byte, internal key and merkle path
+func ParseControlBlock(raw []byte) (*ControlBlock, error) {
+	if len(raw) < TAPROOT_CONTROL_BASE_SIZE ||
+		(len(raw)-TAPROOT_CONTROL_BASE_SIZE)%TAPROOT_CONTROL_NODE_SIZE != 0 ||
+		(len(raw)-TAPROOT_CONTROL_BASE_SIZE)/TAPROOT_CONTROL_NODE_SIZE > TAPROOT_CONTROL_MAX_NODES {
+		return nil, fmt.Errorf("%w: length %d", ErrInvalidControlBlock, len(raw))
+	}
+
+	path := make([][]byte, 0)
+	for i := TAPROOT_CONTROL_BASE_SIZE; i < len(raw); i += TAPROOT_CONTROL_NODE_SIZE {
+		path = append(path, raw[i:i+TAPROOT_CONTROL_NODE_SIZE])
+	}
+
+	return &ControlBlock{
+		leafVersion:  raw[0] & TAPROOT_LEAF_MASK,
+		outputParity: raw[0] & 0x01,
+		internalKey:  raw[1:TAPROOT_CONTROL_BASE_SIZE],
+		path:         path,
+	}, nil
+}
+
+// LeafVersion returns the leaf version of the script being spent
+func (c *ControlBlock) LeafVersion() byte {
+	return c.leafVersion
+}
+
+// InternalKey returns the x-only internal key of the output
+func (c *ControlBlock) InternalKey() []byte {
+	return c.internalKey
+}
+
+// MerkleRoot walks the merkle path from the given leaf hash up to the script tree root
+func (c *ControlBlock) MerkleRoot(leafHash []byte) []byte {
+	node := leafHash
+	for _, sibling := range c.path {
+		node = TapBranchHash(node, sibling)
+	}
+	return node
+}
+
+// VerifyCommitment checks that the output key commits to the script through the internal key and merkle path
+func (c *ControlBlock) VerifyCommitment(outputKey []byte, script []byte) bool {
+	internalKey, err := ecc.LiftX(c.internalKey)
+	if err != nil {
+		return false
+	}
+
+	merkleRoot := c.MerkleRoot(TapLeafHash(c.leafVersion, script))
+	tweaked, err := TaprootOutputKey(internalKey, merkleRoot)
+	if err != nil {
+		return false
+	}
+
+	parity := byte(0)
+	if tweaked.HasEvenY() != true {
+		parity = 1
+	}
+	return bytes.Equal(tweaked.XOnly(), outputKey) && parity == c.outputParity
+}
+
+// TapLeafHash computes the tagged hash of a leaf script
+func TapLeafHash(leafVersion byte, script []byte) []byte {
+	return ecc.TaggedHash("TapLeaf", []byte{leafVersion}, EncodeVarint(big.NewInt(int64(len(script)))), script)
+}
+
+// TapBranchHash computes the tagged hash of two tree nodes, ordered lexicographically
+func TapBranchHash(a []byte, b []byte) []byte {
+	if bytes.Compare(a, b) > 0 {
+		a, b = b, a
+	}
+	return ecc.TaggedHash("TapBranch", a, b)
+}
+
+// TapTweakHash computes the tweak applied to the internal key, merkleRoot is nil for key path only outputs
+func TapTweakHash(internalKey []byte, merkleRoot []byte) []byte {
+	return ecc.TaggedHash("TapTweak", internalKey, merkleRoot)
+}
+
+// TaprootOutputKey tweaks the internal key with the script tree root into the key found in the scriptPubKey
+func TaprootOutputKey(internalKey *ecc.Point, merkleRoot []byte) (*ecc.Point, error) {
+	return internalKey.XOnlyTweakAdd(TapTweakHash(internalKey.XOnly(), merkleRoot))
+}
+
+// P2trScript builds a Pay-to-Taproot (P2TR) locking script from a 32 bytes output key
+func P2trScript(outputKey []byte) *ScriptSig {
+	return InitScriptSig([]ScriptCmd{OpCmd(OP_1), DataPush(outputKey)})
+}
+
+// splitSchnorrSig separates a taproot signature into its 64 bytes signature and hash type
+func splitSchnorrSig(sig []byte) ([]byte, byte, bool) {
+	switch len(sig) {
+	case 64:
+		return sig, SIGHASH_DEFAULT, true
+	case 65:
+		// an explicit SIGHASH_DEFAULT byte is not allowed
+		if sig[64] == SIGHASH_DEFAULT {
+			return nil, 0, false
+		}
+		return sig[0:64], sig[64], true
+	}
+
+	return nil, 0, false
+}
+
+// isValidTaprootHashType checks the hash type against the values accepted by BIP-341
+func isValidTaprootHashType(hashType byte) bool {
+	base := hashType &^ SIGHASH_ANYONECANPAY
+	return hashType == SIGHASH_DEFAULT || (base >= SIGHASH_ALL && base <= SIGHASH_SINGLE)
+}
+
+// spentOutputs returns the outputs spent by every input, BIP-341 signatures commit to all of them
+func (t *Transaction) spentOutputs() ([]*TransactionOutput, error) {
+	outputs := make([]*TransactionOutput, 0, len(t.txInputs))
+	for _, txInput := range t.txInputs {
+		output, err := txInput.previousOutput(t.Params())
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, output)
+	}
+	return outputs, nil
+}
+
+// BIP341SigHash computes the taproot signature hash of an input.
+// annex is nil when the witness has none, leafHash is nil for key path spends.
+func (t *Transaction) BIP341SigHash(inputIdx int, hashType byte, annex []byte, leafHash []byte) ([]byte, error) {
+	return NewSigHashCache(t).BIP341SigHash(inputIdx, hashType, annex, leafHash)
+}
+
+// bip341SigHash computes the taproot signature hash of an input, the hashes shared by every input come from sigHashes.
+// codeSepPos is the opcode position of the last OP_CODESEPARATOR executed in the leaf, only used for script path spends
+func (t *Transaction) bip341SigHash(sigHashes *SigHashCache, inputIdx int, hashType byte, annex []byte, leafHash []byte, codeSepPos uint32) ([]byte, error) {
+	if inputIdx < 0 || inputIdx >= len(t.txInputs) {
+		return nil, fmt.Errorf("invalid index %d for transaction input", inputIdx)
+	}
+	if !isValidTaprootHashType(hashType) {
+		return nil, fmt.Errorf("%w: %#x", ErrInvalidSigHashType, hashType)
+	}
+
+	spent, err := sigHashes.spentOutput(inputIdx)
+	if err != nil {
+		return nil, err
+	}
+
+	outputType := hashType & 0x03
+	if hashType == SIGHASH_DEFAULT {
+		outputType = SIGHASH_ALL
+	}
+	anyoneCanPay := hashType&SIGHASH_ANYONECANPAY != 0
+
+	msg := make([]byte, 0)
+	// sighash epoch
+	msg = append(msg, 0x00)
+	msg = append(msg, hashType)
+	msg = binary.LittleEndian.AppendUint32(msg, uint32(t.version))
+	msg = binary.LittleEndian.AppendUint32(msg, t.lockTime)
+
+	if !anyoneCanPay {
+		shaAmounts, err := sigHashes.ShaAmounts()
+		if err != nil {
+			return nil, err
+		}
+		shaScriptPubKeys, err := sigHashes.ShaScriptPubKeys()
+		if err != nil {
+			return nil, err
+		}
+		msg = append(msg, sigHashes.ShaPrevouts()...)
+		msg = append(msg, shaAmounts...)
+		msg = append(msg, shaScriptPubKeys...)
+		msg = append(msg, sigHashes.ShaSequences()...)
+	}
+
+	if outputType == SIGHASH_ALL {
+		msg = append(msg, sigHashes.ShaOutputs()...)
+	}
+
+	spendType := byte(0)
+	if leafHash != nil {
+		spendType |= 0x02
+	}
+	if annex != nil {
+		spendType |= 0x01
+	}
+	msg = append(msg, spendType)
+
+	txInput := t.txInputs[inputIdx]
+	if anyoneCanPay {
+		msg = append(msg, ReverseByteSlice(txInput.previousTransactionID)...)
+		msg = binary.LittleEndian.AppendUint32(msg, txInput.previousTransactionIndex)
+		msg = binary.LittleEndian.AppendUint64(msg, uint64(spent.amount))
+		msg = append(msg, spent.scriptPubKey.Serialize()...)
+		msg = binary.LittleEndian.AppendUint32(msg, txInput.sequence)
+	} else {
+		msg = binary.LittleEndian.AppendUint32(msg, uint32(inputIdx))
+	}
+
+	if annex != nil {
+		annexBinary := EncodeVarint(big.NewInt(int64(len(annex))))
+		annexBinary = append(annexBinary, annex...)
+		msg = append(msg, sha256Bytes(annexBinary)...)
+	}
+
+	if outputType == SIGHASH_SINGLE {
+		if inputIdx >= len(t.txOutputs) {
+			return nil, fmt.Errorf("SIGHASH_SINGLE input %d has no matching output", inputIdx)
+		}
+		msg = append(msg, sha256Bytes(t.txOutputs[inputIdx].Serialize())...)
+	}
+
+	if leafHash != nil {
+		msg = append(msg, leafHash...)
+		// key_version 0 is the only version defined by BIP-342
+		msg = append(msg, 0x00)
+		msg = binary.LittleEndian.AppendUint32(msg, codeSepPos)
+	}
+
+	return ecc.TaggedHash("TapSighash", msg), nil
+}
+
+// verifyTaproot verifies a segwit v1 input through its key path or script path
+func (t *Transaction) verifyTaproot(sigHashes *SigHashCache, inputIdx int, outputKey []byte) bool {
+	witness := t.txInputs[inputIdx].witness
+	if len(witness) == 0 {
+		return false
+	}
+
+	// an annex is the last item of a stack with at least two items starting with 0x50
+	var annex []byte
+	stack := witness
+	if len(stack) >= 2 && len(stack[len(stack)-1]) > 0 && stack[len(stack)-1][0] == TAPROOT_ANNEX_TAG {
+		annex = stack[len(stack)-1]
+		stack = stack[0 : len(stack)-1]
+	}
+
+	if len(stack) == 1 {
+		// key path spend
+		sig, hashType, ok := splitSchnorrSig(stack[0])
+		if !ok {
+			return false
+		}
+		msg, err := sigHashes.BIP341SigHash(inputIdx, hashType, annex, nil)
+		if err != nil {
+			return false
+		}
+		return ecc.SchnorrVerify(outputKey, msg, sig)
+	}
+
+	// script path spend
+	control, err := ParseControlBlock(stack[len(stack)-1])
+	if err != nil {
+		return false
+	}
+	script := stack[len(stack)-2]
+	if control.VerifyCommitment(outputKey, script) != true {
+		return false
+	}
+
+	if control.leafVersion != TAPROOT_LEAF_TAPSCRIPT {
+		// unknown leaf versions are left unencumbered for future soft forks
+		return true
+	}
+
+	leafHash := TapLeafHash(control.leafVersion, script)
+	ctx := &tapscriptContext{
+		sigHash: func(hashType byte, codeSepPos uint32) ([]byte, error) {
+			return t.bip341SigHash(sigHashes, inputIdx, hashType, annex, leafHash, codeSepPos)
+		},
+		validationWeight: VALIDATION_WEIGHT_OFFSET + len(serializeWitness(witness)),
+	}
+	return executeTapscript(script, stack[0:len(stack)-2], ctx, NewTxContext(t, inputIdx))
+}
+
+// serializeWitness encodes a witness stack the way it appears in a segwit transaction
+func serializeWitness(witness [][]byte) []byte {
+	result := EncodeVarint(big.NewInt(int64(len(witness))))
+	for _, item := range witness {
+		result = append(result, EncodeVarint(big.NewInt(int64(len(item))))...)
+		result = append(result, item...)
+	}
+	return result
+}
+
+// sha256Bytes computes a single SHA256, BIP-341 uses it instead of hash256 for its midstates
+func sha256Bytes(data []byte) []byte {
+	hash := sha256.Sum256(data)
+	return hash[:]
+}
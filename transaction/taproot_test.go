@@ -0,0 +1,459 @@
+package transaction
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/sudonite/bitcoin/chaincfg"
+	ecc "github.com/sudonite/bitcoin/elliptic_curve"
+)
+
+func TestTaprootOutputKey(t *testing.T) {
+	// BIP-341 scriptPubKey test vectors
+	tests := []struct {
+		name         string
+		internalKey  string
+		leafScript   string
+		leafHash     string
+		outputKey    string
+		controlBlock string
+	}{
+		{
+			name:        "key path only",
+			internalKey: "d6889cb081036e0faefa3a35157ad71086b123b2b144b649798b494c300a961d",
+			outputKey:   "53a1f6e454df1aa2776a2814a721372d6258050de330b3c6d10ee8f4e0dda343",
+		},
+		{
+			name:         "single leaf",
+			internalKey:  "187791b6f712a8ea41c8ecdd0ee77fab3e85263b37e1ec18a3651926b3a6cf27",
+			leafScript:   "20d85a959b0290bf19bb89ed43c916be835475d013da4b362117393e25a48229b8ac",
+			leafHash:     "5b75adecf53548f3ec6ad7d78383bf84cc57b55a3127c72b9a2481752dd88b21",
+			outputKey:    "147c9c57132f6e7ecddba9800bb0c4449251c92a1e60371ee77557b6620f3ea3",
+			controlBlock: "c1187791b6f712a8ea41c8ecdd0ee77fab3e85263b37e1ec18a3651926b3a6cf27",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			internalKey, err := ecc.LiftX(decodeHex(t, tt.internalKey))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var merkleRoot []byte
+			if tt.leafScript != "" {
+				merkleRoot = TapLeafHash(TAPROOT_LEAF_TAPSCRIPT, decodeHex(t, tt.leafScript))
+				if got := hex.EncodeToString(merkleRoot); got != tt.leafHash {
+					t.Errorf("leaf hash %s, want %s", got, tt.leafHash)
+				}
+			}
+
+			outputKey, err := TaprootOutputKey(internalKey, merkleRoot)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := hex.EncodeToString(outputKey.XOnly()); got != tt.outputKey {
+				t.Errorf("output key %s, want %s", got, tt.outputKey)
+			}
+			if got := hex.EncodeToString(P2trScript(outputKey.XOnly()).rawSerialize()); got != "5120"+tt.outputKey {
+				t.Errorf("scriptPubKey %s", got)
+			}
+
+			if tt.controlBlock == "" {
+				return
+			}
+			control, err := ParseControlBlock(decodeHex(t, tt.controlBlock))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !control.VerifyCommitment(outputKey.XOnly(), decodeHex(t, tt.leafScript)) {
+				t.Error("control block does not commit to the leaf")
+			}
+			if control.VerifyCommitment(outputKey.XOnly(), []byte{OP_1}) {
+				t.Error("control block commits to another leaf")
+			}
+		})
+	}
+}
+
+func TestParseControlBlock(t *testing.T) {
+	tests := []struct {
+		name  string
+		size  int
+		nodes int
+		err   bool
+	}{
+		{"no path", 33, 0, false},
+		{"two nodes", 33 + 64, 2, false},
+		{"128 nodes", 33 + 128*32, 128, false},
+		{"too short", 32, 0, true},
+		{"partial node", 33 + 31, 0, true},
+		{"129 nodes", 33 + 129*32, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			control, err := ParseControlBlock(append([]byte{0xc1}, make([]byte, tt.size-1)...))
+			if tt.err {
+				if !errors.Is(err, ErrInvalidControlBlock) {
+					t.Errorf("got %v, want %v", err, ErrInvalidControlBlock)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(control.path) != tt.nodes || control.LeafVersion() != TAPROOT_LEAF_TAPSCRIPT || control.outputParity != 1 {
+				t.Errorf("parsed %d nodes, leaf version %#x, parity %d", len(control.path), control.LeafVersion(), control.outputParity)
+			}
+		})
+	}
+}
+
+// BIP-341 key path spending example, its second output script has a push running past the end
+const bip341KeyPathTx = "02000000097de20cbff686da83a54981d2b9bab3586f4ca7e48f57f5b55963115f3b334e9c010000000000000000d7b7cab57b1393ace2d064f4d4a2cb8af6def61273e127517d44759b6dafdd990000000000fffffffff8e1f583384333689228c5d28eac13366be082dc57441760d957275419a418420000000000fffffffff0689180aa63b30cb162a73c6d2a38b7eeda2a83ece74310fda0843ad604853b0100000000feffffffaa5202bdf6d8ccd2ee0f0202afbbb7461d9264a25e5bfd3c5a52ee1239e0ba6c0000000000feffffff956149bdc66faa968eb2be2d2faa29718acbfe3941215893a2a3446d32acd050000000000000000000e664b9773b88c09c32cb70a2a3e4da0ced63b7ba3b22f848531bbb1d5d5f4c94010000000000000000e9aa6b8e6c9de67619e6a3924ae25696bb7b694bb677a632a74ef7eadfd4eabf0000000000ffffffffa778eb6a263dc090464cd125c466b5a99667720b1c110468831d058aa1b82af10100000000ffffffff0200ca9a3b000000001976a91406afd46bcdfd22ef94ac122aa11f241244a37ecc88ac807840cb0000000020ac9a87f5594be208f8532db38cff670c450ed2fea8fcdefcc9a663f78bab962b0065cd1d"
+
+var bip341KeyPathPrevOuts = []prevOut{
+	{420000000, "512053a1f6e454df1aa2776a2814a721372d6258050de330b3c6d10ee8f4e0dda343"},
+	{462000000, "5120147c9c57132f6e7ecddba9800bb0c4449251c92a1e60371ee77557b6620f3ea3"},
+	{294000000, "76a914751e76e8199196d454941c45d1b3a323f1433bd688ac"},
+	{504000000, "5120e4d810fd50586274face62b8a807eb9719cef49c04177cc6b76a9a4251d5450e"},
+	{630000000, "512091b64d5324723a985170e4dc5a0f84c041804f2cd12660fa5dec09fc21783605"},
+	{378000000, "00147dd65592d0ab2fe0d0257d571abf032cd9db93dc"},
+	{672000000, "512075169f4001aa68f15bbed28b218df1d0a62cbbcf1188c6665110c293c907b831"},
+	{546000000, "5120712447206d7a5238acc7ff53fbe94a3b64539ad291c7cdbc490b7577e4b17df5"},
+	{588000000, "512077e30a5522dd9f894c3f8b8bd4c4b2cf82ca7da8a3ea6a239655c39c050ab220"},
+}
+
+func TestBIP341SigHashCache(t *testing.T) {
+	tx := parseTestTx(t, bip341KeyPathTx, bip341KeyPathPrevOuts...)
+	sigHashes := NewSigHashCache(tx)
+	shaAmounts, err := sigHashes.ShaAmounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	shaScriptPubKeys, err := sigHashes.ShaScriptPubKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		got  []byte
+		want string
+	}{
+		{"hashAmounts", shaAmounts, "58a6964a4f5f8f0b642ded0a8a553be7622a719da71d1f5befcefcdee8e0fde6"},
+		{"hashOutputs", sigHashes.ShaOutputs(), "a2e6dab7c1f0dcd297c8d61647fd17d821541ea69c3cc37dcbad7f90d4eb4bc5"},
+		{"hashPrevouts", sigHashes.ShaPrevouts(), "e3b33bb4ef3a52ad1fffb555c0d82828eb22737036eaeb02a235d82b909c4c3f"},
+		{"hashScriptPubkeys", shaScriptPubKeys, "23ad0f61ad2bca5ba6a7693f50fce988e17c3780bf2b1e720cfbb38fbdd52e21"},
+		{"hashSequences", sigHashes.ShaSequences(), "18959c7221ab5ce9e26c3cd67b22c24f8baa54bac281d8e6b05e400e6c3a957e"},
+	}
+	for _, tt := range tests {
+		if got := hex.EncodeToString(tt.got); got != tt.want {
+			t.Errorf("%s %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBIP341SigHash(t *testing.T) {
+	tests := []struct {
+		inputIdx int
+		hashType byte
+		want     string
+	}{
+		{0, SIGHASH_SINGLE, "2514a6272f85cfa0f45eb907fcb0d121b808ed37c6ea160a5a9046ed5526d555"},
+		{1, SIGHASH_SINGLE | SIGHASH_ANYONECANPAY, "325a644af47e8a5a2591cda0ab0723978537318f10e6a63d4eed783b96a71a4d"},
+		{3, SIGHASH_ALL, "bf013ea93474aa67815b1b6cc441d23b64fa310911d991e713cd34c7f5d46669"},
+		{4, SIGHASH_DEFAULT, "4f900a0bae3f1446fd48490c2958b5a023228f01661cda3496a11da502a7f7ef"},
+		{6, SIGHASH_NONE, "15f25c298eb5cdc7eb1d638dd2d45c97c4c59dcaec6679cfc16ad84f30876b85"},
+		{7, SIGHASH_NONE | SIGHASH_ANYONECANPAY, "cd292de50313804dabe4685e83f923d2969577191a3e1d2882220dca88cbeb10"},
+		{8, SIGHASH_ALL | SIGHASH_ANYONECANPAY, "cccb739eca6c13a8a89e6e5cd317ffe55669bbda23f2fd37b0f18755e008edd2"},
+	}
+
+	tx := parseTestTx(t, bip341KeyPathTx, bip341KeyPathPrevOuts...)
+	for _, tt := range tests {
+		z, err := tx.BIP341SigHash(tt.inputIdx, tt.hashType, nil, nil)
+		if err != nil {
+			t.Errorf("input %d: %v", tt.inputIdx, err)
+			continue
+		}
+		if got := hex.EncodeToString(z); got != tt.want {
+			t.Errorf("input %d hash type %#x: sighash %s, want %s", tt.inputIdx, tt.hashType, got, tt.want)
+		}
+	}
+}
+
+func TestBIP341SigHashInvalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		inputIdx int
+		hashType byte
+		err      error
+	}{
+		{"undefined hash type", 0, 0x04, ErrInvalidSigHashType},
+		{"default with anyone can pay", 0, SIGHASH_ANYONECANPAY, ErrInvalidSigHashType},
+		{"single without a matching output", 3, SIGHASH_SINGLE, nil},
+		{"input out of range", 9, SIGHASH_DEFAULT, nil},
+	}
+
+	tx := parseTestTx(t, bip341KeyPathTx, bip341KeyPathPrevOuts...)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tx.BIP341SigHash(tt.inputIdx, tt.hashType, nil, nil)
+			if err == nil {
+				t.Fatal("sighash computed")
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Errorf("got %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+// taprootFixture is an output committing to three leaves: a single key, a 2-of-2 OP_CHECKSIGADD and OP_SUCCESS80,
+// spent by the first input of tx
+type taprootFixture struct {
+	tx                  *Transaction
+	internal, k1, k2    *ecc.PrivateKey
+	tweaked             *ecc.PrivateKey
+	leafA, leafB, leafC []byte
+	hashA, hashB, hashC []byte
+	hashAB              []byte
+	parity              byte
+}
+
+func newTaprootFixture(t *testing.T) *taprootFixture {
+	t.Helper()
+	f := &taprootFixture{
+		internal: ecc.NewPrivateKey(big.NewInt(777)),
+		k1:       ecc.NewPrivateKey(big.NewInt(1001)),
+		k2:       ecc.NewPrivateKey(big.NewInt(1002)),
+	}
+	f.leafA = InitScriptSig([]ScriptCmd{DataPush(f.k1.GetPublicKey().XOnly()), OpCmd(OP_CHECKSIG)}).rawSerialize()
+	f.leafB = InitScriptSig([]ScriptCmd{
+		DataPush(f.k1.GetPublicKey().XOnly()), OpCmd(OP_CHECKSIG),
+		DataPush(f.k2.GetPublicKey().XOnly()), OpCmd(OP_CHECKSIGADD),
+		OpCmd(OP_2), OpCmd(OP_NUMEQUAL),
+	}).rawSerialize()
+	f.leafC = []byte{0x50}
+	f.hashA = TapLeafHash(TAPROOT_LEAF_TAPSCRIPT, f.leafA)
+	f.hashB = TapLeafHash(TAPROOT_LEAF_TAPSCRIPT, f.leafB)
+	f.hashC = TapLeafHash(TAPROOT_LEAF_TAPSCRIPT, f.leafC)
+	f.hashAB = TapBranchHash(f.hashA, f.hashB)
+	root := TapBranchHash(f.hashAB, f.hashC)
+
+	outputKey, err := TaprootOutputKey(f.internal.GetPublicKey(), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !outputKey.HasEvenY() {
+		f.parity = 1
+	}
+	if f.tweaked, err = f.internal.XOnlyTweakAdd(TapTweakHash(f.internal.GetPublicKey().XOnly(), root)); err != nil {
+		t.Fatal(err)
+	}
+
+	spk := P2trScript(outputKey.XOnly())
+	in := InitTransactionInput(bytes.Repeat([]byte{1}, 32), 1)
+	in.SetScriptSig(InitScriptSig([]ScriptCmd{}))
+	in2 := InitTransactionInput(bytes.Repeat([]byte{2}, 32), 0)
+	in2.SetScriptSig(InitScriptSig([]ScriptCmd{}))
+	out := InitTransactionOutput(80000, P2trScript(outputKey.XOnly()))
+	f.tx = InitTransaction(2, []*TransactionInput{in, in2}, []*TransactionOutput{out}, 0, &chaincfg.MainNetParams)
+	f.tx.segwit = true
+	if err := f.tx.SetPreviousOutputs([]*TransactionOutput{InitTransactionOutput(90000, spk), InitTransactionOutput(5000, spk)}); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// control builds the control block of a leaf from its merkle path
+func (f *taprootFixture) control(path ...[]byte) []byte {
+	control := append([]byte{TAPROOT_LEAF_TAPSCRIPT | f.parity}, f.internal.GetPublicKey().XOnly()...)
+	for _, node := range path {
+		control = append(control, node...)
+	}
+	return control
+}
+
+// sign makes a schnorr signature of the first input, appending the hash type unless it is SIGHASH_DEFAULT
+func (f *taprootFixture) sign(t *testing.T, key *ecc.PrivateKey, hashType byte, annex []byte, leafHash []byte) []byte {
+	t.Helper()
+	msg, err := f.tx.BIP341SigHash(0, hashType, annex, leafHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := key.SignSchnorr(msg, nil)
+	if hashType != SIGHASH_DEFAULT {
+		sig = append(sig, hashType)
+	}
+	return sig
+}
+
+func TestVerifyTaprootKeyPath(t *testing.T) {
+	hashTypes := []byte{
+		SIGHASH_DEFAULT, SIGHASH_ALL, SIGHASH_NONE, SIGHASH_SINGLE,
+		SIGHASH_ALL | SIGHASH_ANYONECANPAY, SIGHASH_NONE | SIGHASH_ANYONECANPAY, SIGHASH_SINGLE | SIGHASH_ANYONECANPAY,
+	}
+
+	f := newTaprootFixture(t)
+	in := f.tx.txInputs[0]
+	for _, hashType := range hashTypes {
+		sig := f.sign(t, f.tweaked, hashType, nil, nil)
+		in.witness = [][]byte{sig}
+		if !f.tx.VerifyInput(0) {
+			t.Errorf("hash type %#x: signature does not verify", hashType)
+		}
+		// the annex is committed to, so adding one breaks the signature
+		in.witness = [][]byte{sig, {TAPROOT_ANNEX_TAG, 1}}
+		if f.tx.VerifyInput(0) {
+			t.Errorf("hash type %#x: signature verifies with an annex it does not commit to", hashType)
+		}
+	}
+
+	annex := []byte{TAPROOT_ANNEX_TAG, 0xaa}
+	in.witness = [][]byte{f.sign(t, f.tweaked, SIGHASH_DEFAULT, annex, nil), annex}
+	if !f.tx.VerifyInput(0) {
+		t.Error("signature committing to the annex does not verify")
+	}
+
+	tests := []struct {
+		name string
+		sig  func(sig []byte) []byte
+	}{
+		{"untweaked key", func([]byte) []byte { return f.sign(t, f.internal, SIGHASH_DEFAULT, nil, nil) }},
+		{"explicit SIGHASH_DEFAULT byte", func(sig []byte) []byte { return append(sig, SIGHASH_DEFAULT) }},
+		{"undefined hash type", func(sig []byte) []byte { return append(sig, 0x04) }},
+		{"truncated signature", func(sig []byte) []byte { return sig[:63] }},
+		{"empty signature", func([]byte) []byte { return []byte{} }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in.witness = [][]byte{tt.sig(f.sign(t, f.tweaked, SIGHASH_DEFAULT, nil, nil))}
+			if f.tx.VerifyInput(0) {
+				t.Error("input verified")
+			}
+		})
+	}
+}
+
+func TestVerifyTapscript(t *testing.T) {
+	tests := []struct {
+		name    string
+		witness func(t *testing.T, f *taprootFixture) [][]byte
+		want    bool
+	}{
+		{"single key leaf", func(t *testing.T, f *taprootFixture) [][]byte {
+			return [][]byte{f.sign(t, f.k1, SIGHASH_DEFAULT, nil, f.hashA), f.leafA, f.control(f.hashB, f.hashC)}
+		}, true},
+		{"single key leaf signed by another key", func(t *testing.T, f *taprootFixture) [][]byte {
+			return [][]byte{f.sign(t, f.k2, SIGHASH_DEFAULT, nil, f.hashA), f.leafA, f.control(f.hashB, f.hashC)}
+		}, false},
+		{"single key leaf with an empty signature", func(t *testing.T, f *taprootFixture) [][]byte {
+			return [][]byte{{}, f.leafA, f.control(f.hashB, f.hashC)}
+		}, false},
+		{"signature for the key path", func(t *testing.T, f *taprootFixture) [][]byte {
+			return [][]byte{f.sign(t, f.k1, SIGHASH_DEFAULT, nil, nil), f.leafA, f.control(f.hashB, f.hashC)}
+		}, false},
+		{"2-of-2 OP_CHECKSIGADD", func(t *testing.T, f *taprootFixture) [][]byte {
+			hashType := byte(SIGHASH_ALL | SIGHASH_ANYONECANPAY)
+			return [][]byte{
+				f.sign(t, f.k2, hashType, nil, f.hashB), f.sign(t, f.k1, hashType, nil, f.hashB),
+				f.leafB, f.control(f.hashA, f.hashC),
+			}
+		}, true},
+		{"2-of-2 OP_CHECKSIGADD with one signature", func(t *testing.T, f *taprootFixture) [][]byte {
+			return [][]byte{{}, f.sign(t, f.k1, SIGHASH_DEFAULT, nil, f.hashB), f.leafB, f.control(f.hashA, f.hashC)}
+		}, false},
+		{"merkle path in the wrong order", func(t *testing.T, f *taprootFixture) [][]byte {
+			return [][]byte{f.sign(t, f.k1, SIGHASH_DEFAULT, nil, f.hashA), f.leafA, f.control(f.hashC, f.hashB)}
+		}, false},
+		{"annex committed by the leaf signature", func(t *testing.T, f *taprootFixture) [][]byte {
+			annex := []byte{TAPROOT_ANNEX_TAG}
+			return [][]byte{f.sign(t, f.k1, SIGHASH_DEFAULT, annex, f.hashA), f.leafA, f.control(f.hashB, f.hashC), annex}
+		}, true},
+		{"OP_SUCCESS leaf", func(t *testing.T, f *taprootFixture) [][]byte {
+			return [][]byte{f.leafC, f.control(f.hashAB)}
+		}, true},
+		{"OP_SUCCESS leaf with a bad path", func(t *testing.T, f *taprootFixture) [][]byte {
+			return [][]byte{f.leafC, f.control(f.hashA)}
+		}, false},
+		{"control block of the wrong parity", func(t *testing.T, f *taprootFixture) [][]byte {
+			control := f.control(f.hashAB)
+			control[0] ^= 1
+			return [][]byte{f.leafC, control}
+		}, false},
+		{"malformed control block", func(t *testing.T, f *taprootFixture) [][]byte {
+			return [][]byte{f.leafC, f.control(f.hashAB)[:40]}
+		}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newTaprootFixture(t)
+			f.tx.txInputs[0].witness = tt.witness(t, f)
+			if got := f.tx.VerifyInput(0); got != tt.want {
+				t.Errorf("verify %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyTapscriptCodeSeparator(t *testing.T) {
+	internal := ecc.NewPrivateKey(big.NewInt(777))
+	key := ecc.NewPrivateKey(big.NewInt(1001))
+	checkSig := []ScriptCmd{DataPush(key.GetPublicKey().XOnly()), OpCmd(OP_CHECKSIG)}
+	executed := append([]ScriptCmd{OpCmd(OP_1), OpCmd(OP_IF), OpCmd(OP_CODESEPARATOR), OpCmd(OP_ENDIF)}, checkSig...)
+	skipped := append([]ScriptCmd{OpCmd(OP_0), OpCmd(OP_IF), OpCmd(OP_CODESEPARATOR), OpCmd(OP_ENDIF)}, checkSig...)
+	twice := append([]ScriptCmd{OpCmd(OP_CODESEPARATOR), OpCmd(OP_CODESEPARATOR)}, checkSig...)
+
+	tests := []struct {
+		name       string
+		leaf       []ScriptCmd
+		codeSepPos uint32
+		want       bool
+	}{
+		{"executed separator", executed, 2, true},
+		{"executed separator not committed to", executed, TAPSCRIPT_NO_CODESEPARATOR, false},
+		{"skipped separator", skipped, TAPSCRIPT_NO_CODESEPARATOR, true},
+		{"skipped separator committed to", skipped, 2, false},
+		{"last of two separators", twice, 1, true},
+		{"first of two separators", twice, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			leaf := InitScriptSig(tt.leaf).rawSerialize()
+			leafHash := TapLeafHash(TAPROOT_LEAF_TAPSCRIPT, leaf)
+			outputKey, err := TaprootOutputKey(internal.GetPublicKey(), leafHash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			control := append([]byte{TAPROOT_LEAF_TAPSCRIPT}, internal.GetPublicKey().XOnly()...)
+			if !outputKey.HasEvenY() {
+				control[0] |= 1
+			}
+
+			spk := P2trScript(outputKey.XOnly())
+			in := InitTransactionInput(bytes.Repeat([]byte{1}, 32), 0)
+			in.SetScriptSig(InitScriptSig([]ScriptCmd{}))
+			tx := InitTransaction(2, []*TransactionInput{in}, []*TransactionOutput{InitTransactionOutput(80000, spk)}, 0, &chaincfg.MainNetParams)
+			tx.segwit = true
+			if err := tx.SetPreviousOutputs([]*TransactionOutput{InitTransactionOutput(90000, spk)}); err != nil {
+				t.Fatal(err)
+			}
+
+			msg, err := tx.bip341SigHash(NewSigHashCache(tx), 0, SIGHASH_DEFAULT, nil, leafHash, tt.codeSepPos)
+			if err != nil {
+				t.Fatal(err)
+			}
+			in.witness = [][]byte{key.SignSchnorr(msg, nil), leaf, control}
+			if got := tx.VerifyInput(0); got != tt.want {
+				t.Errorf("verify %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
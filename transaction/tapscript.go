@@ -0,0 +1,198 @@
+package transaction
+
+import (
+	ecc "github.com/sudonite/bitcoin/elliptic_curve"
+)
+
+// tapscriptContext carries the state BIP-342 adds to script execution
+type tapscriptContext struct {
+	// sigHash returns the BIP-341 message for the given hash type of the leaf being executed,
+	// committing to the position of the last executed OP_CODESEPARATOR
+	sigHash func(hashType byte, codeSepPos uint32) ([]byte, error)
+	// opCount is the number of opcodes in the leaf, pushes included, to find the position of the one running
+	opCount int
+	// codeSepPos is the position of the last executed OP_CODESEPARATOR, TAPSCRIPT_NO_CODESEPARATOR before any
+	codeSepPos uint32
+	// validationWeight is the remaining signature budget, each signature check costs 50
+	validationWeight int
+}
+
+// executeTapscript runs a leaf script with version 0xc0 against its witness arguments
+func executeTapscript(script []byte, stack [][]byte, ctx *tapscriptContext, txContext *TxContext) bool {
+	success, ok := scanOpSuccess(script)
+	if !ok {
+		return false
+	}
+	if success {
+		// OP_SUCCESSx makes the whole script succeed, reserved for future soft forks
+		return true
+	}
+
+	for _, element := range stack {
+		if len(element) > MAX_SCRIPT_ELEMENT_SIZE {
+			return false
+		}
+	}
+
+	leafScript, err := parseScriptBytes(script)
+	if err != nil {
+		return false
+	}
+
+	bitcoinOpCode := leafScript.bitcoinOpCode
+	ctx.opCount = len(bitcoinOpCode.cmds)
+	ctx.codeSepPos = TAPSCRIPT_NO_CODESEPARATOR
+	bitcoinOpCode.stack = append(bitcoinOpCode.stack, stack...)
+	bitcoinOpCode.scriptExpanded = true
+	bitcoinOpCode.tapscript = ctx
+	bitcoinOpCode.txContext = txContext
+	if bitcoinOpCode.run(nil) != nil {
+		return false
+	}
+
+	// the clean stack rule is part of consensus for tapscript
+	return len(bitcoinOpCode.stack) == 1 && castToBool(bitcoinOpCode.stack[0])
+}
+
+// scanOpSuccess walks the raw script and reports whether it contains an OP_SUCCESSx opcode.
+// ok is false when the script fails to decode before one is found.
+func scanOpSuccess(script []byte) (success bool, ok bool) {
+	i := 0
+	for i < len(script) {
+		op := int(script[i])
+		i += 1
+
+		length := 0
+		switch {
+		case op >= SCRIPT_DATA_LENGTH_BEGIN && op <= SCRIPT_DATA_LENGTH_END:
+			length = op
+		case op == OP_PUSHDATA1:
+			if i+1 > len(script) {
+				return false, false
+			}
+			length = int(script[i])
+			i += 1
+		case op == OP_PUSHDATA2:
+			if i+2 > len(script) {
+				return false, false
+			}
+			length = int(script[i]) | int(script[i+1])<<8
+			i += 2
+		case op == OP_PUSHDATA4:
+			if i+4 > len(script) {
+				return false, false
+			}
+			length = int(script[i]) | int(script[i+1])<<8 | int(script[i+2])<<16 | int(script[i+3])<<24
+			i += 4
+		case isOpSuccess(op):
+			return true, true
+		}
+
+		if length < 0 || i+length > len(script) {
+			return false, false
+		}
+		i += length
+	}
+
+	return false, true
+}
+
+// isOpSuccess checks whether the opcode is one of the OP_SUCCESSx opcodes defined by BIP-342
+func isOpSuccess(op int) bool {
+	return op == 80 || op == 98 || (op >= 126 && op <= 129) ||
+		(op >= 131 && op <= 134) || (op >= 137 && op <= 138) ||
+		(op >= 141 && op <= 142) || (op >= 149 && op <= 153) ||
+		(op >= 187 && op <= 254)
+}
+
+// castToBool interprets a stack element as a boolean, negative zero is false
+func castToBool(element []byte) bool {
+	for i, b := range element {
+		if b != 0 {
+			return !(i == len(element)-1 && b == 0x80)
+		}
+	}
+	return false
+}
+
+// checkSchnorrSig applies the BIP-342 signature rules, ok is false when the script must fail
+func (b *BitcoinOpCode) checkSchnorrSig(sig []byte, pubKey []byte) (valid bool, ok bool) {
+	if len(pubKey) == 0 {
+		return false, false
+	}
+	if len(sig) == 0 {
+		return false, true
+	}
+
+	b.tapscript.validationWeight -= VALIDATION_WEIGHT_PER_SIGOP
+	if b.tapscript.validationWeight < 0 {
+		return false, false
+	}
+
+	if len(pubKey) != 32 {
+		// unknown public key types are reserved for future soft forks
+		return true, true
+	}
+
+	sig64, hashType, valid := splitSchnorrSig(sig)
+	if !valid {
+		return false, false
+	}
+	msg, err := b.tapscript.sigHash(hashType, b.tapscript.codeSepPos)
+	if err != nil {
+		return false, false
+	}
+
+	// a non-empty signature that does not verify fails the script
+	if ecc.SchnorrVerify(pubKey, msg, sig64) != true {
+		return false, false
+	}
+	return true, true
+}
+
+// OP_CHECKSIG under tapscript rules, verifies a BIP-340 signature
+func (b *BitcoinOpCode) opCheckSigTapscript() bool {
+	if len(b.stack) < 2 {
+		return false
+	}
+
+	pubKey := b.popStack()
+	sig := b.popStack()
+	valid, ok := b.checkSchnorrSig(sig, pubKey)
+	if !ok {
+		return false
+	}
+
+	if valid {
+		b.stack = append(b.stack, b.EncodeNum(1))
+	} else {
+		b.stack = append(b.stack, b.EncodeNum(0))
+	}
+	return true
+}
+
+// OP_CHECKSIGADD pops a public key, a counter and a signature and pushes the counter plus one if the signature is valid
+func (b *BitcoinOpCode) opCheckSigAdd() bool {
+	if len(b.stack) < 3 {
+		return false
+	}
+
+	pubKey := b.popStack()
+	numElement := b.popStack()
+	if len(numElement) > 4 {
+		return false
+	}
+	num := b.DecodeNum(numElement)
+	sig := b.popStack()
+
+	valid, ok := b.checkSchnorrSig(sig, pubKey)
+	if !ok {
+		return false
+	}
+
+	if valid {
+		num += 1
+	}
+	b.stack = append(b.stack, b.EncodeNum(num))
+	return true
+}
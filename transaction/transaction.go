@@ -1,38 +1,43 @@
 package transaction
 
 import (
-	"bufio"
 	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
 	"fmt"
-	"io"
 	"math/big"
 
+	"github.com/sudonite/bitcoin/chaincfg"
 	ecc "github.com/sudonite/bitcoin/elliptic_curve"
 )
 
 const (
-	SIGHASH_ALL = 1
+	SIGHASH_DEFAULT      = 0x00
+	SIGHASH_ALL          = 0x01
+	SIGHASH_NONE         = 0x02
+	SIGHASH_SINGLE       = 0x03
+	SIGHASH_ANYONECANPAY = 0x80
 )
 
 // Represents a Bitcoin transaction
 type Transaction struct {
-	version   *big.Int
+	version   int32
 	txInputs  []*TransactionInput
 	txOutputs []*TransactionOutput
-	lockTime  *big.Int
-	testnet   bool
+	lockTime  uint32
+	params    *chaincfg.Params
 	segwit    bool
 }
 
 // InitTransaction creates a new Bitcoin transaction with the given parameters
-func InitTransaction(version *big.Int, txInputs []*TransactionInput,
-	txOutputs []*TransactionOutput, lockTime *big.Int, testnet bool) *Transaction {
+func InitTransaction(version int32, txInputs []*TransactionInput,
+	txOutputs []*TransactionOutput, lockTime uint32, params *chaincfg.Params) *Transaction {
 	return &Transaction{
 		version:   version,
 		txInputs:  txInputs,
 		txOutputs: txOutputs,
 		lockTime:  lockTime,
-		testnet:   testnet,
+		params:    params,
 	}
 }
 
@@ -54,41 +59,60 @@ func (t *Transaction) String() string {
 		t.version, txIns, txOuts, t.lockTime)
 }
 
-// Parses a raw transaction from binary data
-func ParseTransaction(binary []byte) *Transaction {
+// Parses a raw transaction from binary data, the data has to hold exactly one transaction
+func ParseTransaction(binary []byte) (*Transaction, error) {
 	reader := bytes.NewReader(binary)
-	bufReader := bufio.NewReader(reader)
+	tx, err := DecodeTransaction(reader)
+	if err != nil {
+		return nil, err
+	}
+	if reader.Len() != 0 {
+		return nil, fmt.Errorf("%w: %d trailing bytes", ErrTxMalformed, reader.Len())
+	}
+	return tx, nil
+}
 
-	verBuf := make([]byte, 4)
-	io.ReadFull(bufReader, verBuf)
+// Version returns the transaction version
+func (t *Transaction) Version() int32 {
+	return t.version
+}
 
-	segWitMarker := make([]byte, 1)
-	io.ReadFull(bufReader, segWitMarker)
+// SetVersion sets the transaction version
+func (t *Transaction) SetVersion(version int32) {
+	t.version = version
+}
 
-	reader = bytes.NewReader(binary)
-	bufReader = bufio.NewReader(reader)
-	if segWitMarker[0] == 0x00 {
-		return parseSegwit(bufReader)
-	}
+// LockTime returns the nLockTime field of the transaction
+func (t *Transaction) LockTime() uint32 {
+	return t.lockTime
+}
 
-	return parseLegacy(bufReader)
+// SetLockTime sets the nLockTime field of the transaction
+func (t *Transaction) SetLockTime(lockTime uint32) {
+	t.lockTime = lockTime
 }
 
-// SetTestnet marks the transaction as using Bitcoin testnet parameters
-func (t *Transaction) SetTestnet() {
-	t.testnet = true
+// Params returns the network the transaction is on, mainnet unless set otherwise
+func (t *Transaction) Params() *chaincfg.Params {
+	if t.params == nil {
+		return &chaincfg.MainNetParams
+	}
+	return t.params
+}
+
+// SetParams sets the network used to fetch previous outputs and show addresses
+func (t *Transaction) SetParams(params *chaincfg.Params) {
+	t.params = params
 }
 
 // IsP2wpkh checks whether the given script matches a Pay-to-Witness-Public-Key-Hash (P2WPKH) pattern
 func (t *Transaction) IsP2wpkh(script *ScriptSig) bool {
-	if len(script.bitcoinOpCode.cmds) != 2 {
-		return false
-	}
-	if script.bitcoinOpCode.cmds[0][0] != byte(OP_0) && len(script.bitcoinOpCode.cmds[1]) != 20 {
-		return false
-	}
+	return script.Classify().Class() == SCRIPT_P2WPKH
+}
 
-	return true
+// IsP2wsh checks whether the given script matches a Pay-to-Witness-Script-Hash (P2WSH) pattern
+func (t *Transaction) IsP2wsh(script *ScriptSig) bool {
+	return script.Classify().Class() == SCRIPT_P2WSH
 }
 
 // Serialize encodes the transaction into bytes, using SegWit or legacy format depending on the flag
@@ -106,50 +130,76 @@ func (t *Transaction) Hash() []byte {
 	return ReverseByteSlice(hash)
 }
 
+// SetFetcher makes every input of the transaction look up previous transactions through the given fetcher
+func (t *Transaction) SetFetcher(fetcher *TransactionFetcher) {
+	for _, txInput := range t.txInputs {
+		txInput.SetFetcher(fetcher)
+	}
+}
+
 // GetScript returns the combined script (scriptSig + scriptPubKey) for the input at index `idx`
-func (t *Transaction) GetScript(idx int, testnet bool) *ScriptSig {
+func (t *Transaction) GetScript(idx int, params *chaincfg.Params) (*ScriptSig, error) {
 	if idx < 0 || idx >= len(t.txInputs) {
-		panic("invalid index for transaction input")
+		return nil, fmt.Errorf("invalid index %d for transaction input", idx)
 	}
 
 	txInput := t.txInputs[idx]
-	return txInput.Script(testnet)
+	return txInput.Script(params)
 }
 
 // Fee calculates the transaction fee as (sum of inputs - sum of outputs)
-func (t *Transaction) Fee() *big.Int {
-	inputSum := big.NewInt(0)
-	outputSum := big.NewInt(0)
+func (t *Transaction) Fee() (Amount, error) {
+	inputSum := Amount(0)
+	outputSum := Amount(0)
 
 	for i := 0; i < len(t.txInputs); i++ {
-		addOp := new(big.Int)
-		value := t.txInputs[i].Value(t.testnet)
-		inputSum = addOp.Add(inputSum, value)
+		value, err := t.txInputs[i].Value(t.Params())
+		if err != nil {
+			return 0, err
+		}
+		if inputSum, err = inputSum.Add(value); err != nil {
+			return 0, err
+		}
 	}
 
 	for i := 0; i < len(t.txOutputs); i++ {
-		addOp := new(big.Int)
-		outputSum = addOp.Add(outputSum, t.txOutputs[i].amount)
+		var err error
+		if outputSum, err = outputSum.Add(t.txOutputs[i].amount); err != nil {
+			return 0, err
+		}
 	}
 
-	opSub := new(big.Int)
-	return opSub.Sub(inputSum, outputSum)
+	return inputSum.Sub(outputSum)
 }
 
 // SerializeWithSign serializes the transaction for signing a specific input
-func (t *Transaction) SerializeWithSign(inputIdx int) []byte {
+func (t *Transaction) SerializeWithSign(inputIdx int) ([]byte, error) {
+	if inputIdx < 0 || inputIdx >= len(t.txInputs) {
+		return nil, fmt.Errorf("invalid index %d for transaction input", inputIdx)
+	}
+
+	scriptCode, err := t.txInputs[inputIdx].legacyScriptCode(t.Params())
+	if err != nil {
+		return nil, err
+	}
+
+	return t.serializeForLegacySig(inputIdx, scriptCode), nil
+}
+
+// serializeForLegacySig serializes the transaction with the scriptCode in place of the signed input's
+// scriptSig and empty scripts for every other input, followed by the SIGHASH_ALL hash type
+func (t *Transaction) serializeForLegacySig(inputIdx int, scriptCode *ScriptSig) []byte {
 	signBinary := make([]byte, 0)
-	signBinary = append(signBinary, BigIntToLittleEndian(t.version, LITTLE_ENDIAN_4_BYTES)...)
+	signBinary = binary.LittleEndian.AppendUint32(signBinary, uint32(t.version))
 
 	inputCount := big.NewInt(int64(len(t.txInputs)))
 	signBinary = append(signBinary, EncodeVarint(inputCount)...)
 
 	for i := 0; i < len(t.txInputs); i++ {
 		if i == inputIdx {
-			t.txInputs[i].ReplaceWithScriptPubKey(t.testnet)
-			signBinary = append(signBinary, t.txInputs[i].Serialize()...)
+			signBinary = append(signBinary, t.txInputs[i].serializeWithScript(scriptCode)...)
 		} else {
-			signBinary = append(signBinary, t.txInputs[i].Serialize()...)
+			signBinary = append(signBinary, t.txInputs[i].serializeWithScript(InitScriptSig([]ScriptCmd{}))...)
 		}
 	}
 
@@ -159,49 +209,64 @@ func (t *Transaction) SerializeWithSign(inputIdx int) []byte {
 		signBinary = append(signBinary, t.txOutputs[i].Serialize()...)
 	}
 
-	signBinary = append(signBinary, BigIntToLittleEndian(t.lockTime, LITTLE_ENDIAN_4_BYTES)...)
-	signBinary = append(signBinary,
-		BigIntToLittleEndian(big.NewInt(int64(SIGHASH_ALL)), LITTLE_ENDIAN_4_BYTES)...)
+	signBinary = binary.LittleEndian.AppendUint32(signBinary, t.lockTime)
+	signBinary = binary.LittleEndian.AppendUint32(signBinary, SIGHASH_ALL)
 
 	return signBinary
 }
 
 // SignHash computes the double-SHA256 hash of the serialized transaction for signing
-func (t *Transaction) SignHash(inputIdx int) []byte {
-	signBinary := t.SerializeWithSign(inputIdx)
+func (t *Transaction) SignHash(inputIdx int) ([]byte, error) {
+	signBinary, err := t.SerializeWithSign(inputIdx)
+	if err != nil {
+		return nil, err
+	}
 	// compute hash256 for the modified transaction binary
 	h256 := ecc.Hash256(string(signBinary))
-	return h256
+	return h256, nil
 }
 
 // VerifyInput verifies a single input by executing its combined script
 func (t *Transaction) VerifyInput(inputIndex int) bool {
-	verifyScript := t.GetScript(inputIndex, t.testnet)
-	if t.IsP2wpkh(verifyScript) != true {
-		z := t.SignHash(inputIndex)
-		return verifyScript.Evaluate(z)
-	}
-
-	// verify segwit transaction
-	z := t.BIP143SigHash(inputIndex)
-	witness := t.txInputs[inputIndex].witness
-	verifyScript.SetWitness(witness)
-	return verifyScript.Evaluate(z)
+	return t.verifyInput(inputIndex, NewSigHashCache(t))
 }
 
-// Verify checks the entire transaction
-func (t *Transaction) Verify() bool {
-	if t.Fee().Cmp(big.NewInt(int64(0))) < 0 {
+// verifyInput verifies a single input, taking the signature hashes from a cache shared with the other inputs
+func (t *Transaction) verifyInput(inputIndex int, sigHashes *SigHashCache) bool {
+	verifyScript, err := t.GetScript(inputIndex, t.Params())
+	if err != nil {
 		return false
 	}
-
-	for i := 0; i < len(t.txInputs); i++ {
-		if t.VerifyInput(i) != true {
+	version, program, err := t.witnessProgram(inputIndex)
+	if err != nil {
+		return false
+	}
+	if program != nil && !t.txInputs[inputIndex].witnessScriptSigValid(t.Params()) {
+		return false
+	}
+	if template := verifyScript.Classify(); template.Class() == SCRIPT_P2TR {
+		// native segwit v1 output, P2SH-wrapped v1 programs are not taproot
+		return t.verifyTaproot(sigHashes, inputIndex, template.PubKeys()[0])
+	}
+	if program != nil && version != 0 {
+		// unknown witness versions are left unencumbered for future soft forks
+		return true
+	}
+	if program == nil {
+		z, err := t.SignHash(inputIndex)
+		if err != nil {
 			return false
 		}
+		return verifyScript.EvaluateWithContext(z, NewTxContext(t, inputIndex))
 	}
 
-	return true
+	// verify segwit transaction, native or nested in P2SH, each signature commits to its own hash type
+	witness := t.txInputs[inputIndex].witness
+	verifyScript.SetWitness(witness)
+	verifyScript.bitcoinOpCode.sigHash = func(hashType byte, scriptCode []byte) ([]byte, error) {
+		return t.bip143SigHash(sigHashes, inputIndex, scriptCode, hashType)
+	}
+	return verifyScript.EvaluateWithContext(nil, NewTxContext(t, inputIndex))
 }
 
 // Checks the transaction is a CoinBase transacion
@@ -215,72 +280,127 @@ func (t *Transaction) IsCoinBase() bool {
 		}
 	}
 
-	coinBaseIdx := big.NewInt(int64(0xffffffff))
-	if t.txInputs[0].previousTransactionIndex.Cmp(coinBaseIdx) != 0 {
+	if t.txInputs[0].previousTransactionIndex != 0xffffffff {
 		return false
 	}
 
 	return true
 }
 
-// BIP143SigHash computes the signature hash for a SegWit (BIP-143) input, following the BIP-143 serialization rules for signing P2WPKH transactions.
-func (t *Transaction) BIP143SigHash(inputIdx int) []byte {
+// witnessProgram returns the witness version and program spent by the input, looking inside the redeem script of P2SH-wrapped spends.
+// The program is nil when the input does not spend a witness output.
+func (t *Transaction) witnessProgram(inputIdx int) (int, []byte, error) {
+	if inputIdx < 0 || inputIdx >= len(t.txInputs) {
+		return 0, nil, fmt.Errorf("invalid index %d for transaction input", inputIdx)
+	}
+
 	txInput := t.txInputs[inputIdx]
-	// construct hash
-	result := make([]byte, 0)
-	result = append(result, BigIntToLittleEndian(t.version, LITTLE_ENDIAN_4_BYTES)...)
-	result = append(result, t.previousTxInBIP134Hash()...)
-	result = append(result, t.previousHashSequence()...)
-	result = append(result, ReverseByteSlice(txInput.previousTransactionID)...)
-	result = append(result, BigIntToLittleEndian(txInput.previousTransactionIndex, LITTLE_ENDIAN_4_BYTES)...)
-	script := t.GetScript(inputIdx, true)
-	p2pkScript := P2pkhScript(script.bitcoinOpCode.cmds[1])
-	result = append(result, p2pkScript.Serialize()...)
-	result = append(result, BigIntToLittleEndian(txInput.Value(t.testnet), LITTLE_ENDIAN_8_BYTES)...)
-	result = append(result, BigIntToLittleEndian(txInput.sequence, LITTLE_ENDIAN_4_BYTES)...)
-	result = append(result, t.txOutBIP134Hash()...)
-	result = append(result, BigIntToLittleEndian(t.lockTime, LITTLE_ENDIAN_4_BYTES)...)
-	sigHashAll := big.NewInt(int64(SIGHASH_ALL))
-	result = append(result, BigIntToLittleEndian(sigHashAll, LITTLE_ENDIAN_4_BYTES)...)
-	hashResult := ecc.Hash256(string(result))
-	return hashResult
-}
+	scriptPubKey, err := txInput.scriptPubKey(t.Params())
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if scriptPubKey.bitcoinOpCode.isP2sh() {
+		redeemScript := txInput.redeemScript()
+		if redeemScript == nil {
+			return 0, nil, nil
+		}
+		scriptPubKey, err = parseScriptBytes(redeemScript)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
 
-// previousTxInBIP134Hash computes the double-SHA256 hash of all input outpoints (previous transaction IDs and output indices), as required by BIP-143.
-func (t *Transaction) previousTxInBIP134Hash() []byte {
-	allPreviousOut := make([]byte, 0)
-	for _, txIn := range t.txInputs {
-		allPreviousOut = append(allPreviousOut, ReverseByteSlice(txIn.previousTransactionID)...)
-		allPreviousOut = append(allPreviousOut, BigIntToLittleEndian(txIn.previousTransactionIndex, LITTLE_ENDIAN_4_BYTES)...)
+	version, program, ok := scriptPubKey.witnessProgram()
+	if !ok {
+		return 0, nil, nil
 	}
-	hash := ecc.Hash256(string(allPreviousOut))
-	return hash
+	return version, program, nil
 }
 
-// txOutBIP134Hash computes the double-SHA256 hash of all transaction outputs, used in the BIP-143 signature hash calculation.
-func (t *Transaction) txOutBIP134Hash() []byte {
-	hashOut := make([]byte, 0)
-	for _, txOut := range t.txOutputs {
-		hashOut = append(hashOut, txOut.Serialize()...)
+// bip143ScriptCode returns the serialized scriptCode a BIP-143 signature commits to:
+// the implied P2PKH script for P2WPKH and the witness script for P2WSH.
+func (t *Transaction) bip143ScriptCode(inputIdx int) ([]byte, error) {
+	version, program, err := t.witnessProgram(inputIdx)
+	if err != nil {
+		return nil, err
+	}
+	if program == nil || version != 0 {
+		return nil, fmt.Errorf("input %d does not spend a segwit v0 output", inputIdx)
+	}
+
+	if len(program) == 20 {
+		return P2pkhScript(program).Serialize(), nil
+	}
+
+	witness := t.txInputs[inputIdx].witness
+	if len(witness) == 0 {
+		return nil, fmt.Errorf("input %d has no witness script", inputIdx)
 	}
+	witnessScript := witness[len(witness)-1]
+	witnessScriptHash := sha256.Sum256(witnessScript)
+	if bytes.Equal(witnessScriptHash[:], program) != true {
+		return nil, fmt.Errorf("witness script of input %d does not match its program", inputIdx)
+	}
+
+	scriptCode := EncodeVarint(big.NewInt(int64(len(witnessScript))))
+	return append(scriptCode, witnessScript...), nil
+}
 
-	return ecc.Hash256(string(hashOut))
+// BIP143SigHash computes the signature hash for a SegWit (BIP-143) input, following the BIP-143 serialization rules for P2WPKH, P2WSH and their P2SH-wrapped forms.
+func (t *Transaction) BIP143SigHash(inputIdx int) ([]byte, error) {
+	return NewSigHashCache(t).BIP143SigHash(inputIdx)
 }
 
-// previousHashSequence computes the double-SHA256 hash of all input sequence numbers, as required by the BIP-143 signature hashing algorithm.
-func (t *Transaction) previousHashSequence() []byte {
-	allSequence := make([]byte, 0)
-	for _, txIn := range t.txInputs {
-		allSequence = append(allSequence, BigIntToLittleEndian(txIn.sequence, LITTLE_ENDIAN_4_BYTES)...)
+// bip143SigHash computes the BIP-143 signature hash of an input committing to the given serialized scriptCode
+// and hash type, the hashes shared by every input come from sigHashes
+func (t *Transaction) bip143SigHash(sigHashes *SigHashCache, inputIdx int, scriptCode []byte, hashType byte) ([]byte, error) {
+	txInput := t.txInputs[inputIdx]
+	value, err := txInput.Value(t.Params())
+	if err != nil {
+		return nil, err
+	}
+
+	// ANYONECANPAY leaves out the other inputs, NONE and SINGLE their sequences and the outputs
+	// not signed, the hashes left out are all zeros
+	anyoneCanPay := hashType&SIGHASH_ANYONECANPAY != 0
+	outputType := hashType & 0x1f
+	hashPrevouts := make([]byte, 32)
+	hashSequence := make([]byte, 32)
+	hashOutputs := make([]byte, 32)
+	if !anyoneCanPay {
+		hashPrevouts = sigHashes.HashPrevouts()
 	}
-	hash := ecc.Hash256(string(allSequence))
-	return hash
+	if !anyoneCanPay && outputType != SIGHASH_NONE && outputType != SIGHASH_SINGLE {
+		hashSequence = sigHashes.HashSequence()
+	}
+	if outputType != SIGHASH_NONE && outputType != SIGHASH_SINGLE {
+		hashOutputs = sigHashes.HashOutputs()
+	} else if outputType == SIGHASH_SINGLE && inputIdx < len(t.txOutputs) {
+		hashOutputs = ecc.Hash256(string(t.txOutputs[inputIdx].Serialize()))
+	}
+
+	// construct hash
+	result := make([]byte, 0)
+	result = binary.LittleEndian.AppendUint32(result, uint32(t.version))
+	result = append(result, hashPrevouts...)
+	result = append(result, hashSequence...)
+	result = append(result, ReverseByteSlice(txInput.previousTransactionID)...)
+	result = binary.LittleEndian.AppendUint32(result, txInput.previousTransactionIndex)
+	result = append(result, scriptCode...)
+	result = binary.LittleEndian.AppendUint64(result, uint64(value))
+	result = binary.LittleEndian.AppendUint32(result, txInput.sequence)
+	result = append(result, hashOutputs...)
+	result = binary.LittleEndian.AppendUint32(result, t.lockTime)
+	result = binary.LittleEndian.AppendUint32(result, uint32(hashType))
+	hashResult := ecc.Hash256(string(result))
+	return hashResult, nil
 }
 
 // serializeSegwit serializes the transaction using the SegWit format, including marker, flag, and witness data.
 func (t *Transaction) serializeSegwit() []byte {
 	result := make([]byte, 0)
-	result = append(result, BigIntToLittleEndian(t.version, LITTLE_ENDIAN_4_BYTES)...)
+	result = binary.LittleEndian.AppendUint32(result, uint32(t.version))
 	result = append(result, []byte{0x00, 0x01}...)
 	inputCount := big.NewInt(int64(len(t.txInputs)))
 	result = append(result, EncodeVarint(inputCount)...)
@@ -304,14 +424,14 @@ func (t *Transaction) serializeSegwit() []byte {
 		}
 	}
 
-	result = append(result, BigIntToLittleEndian(t.lockTime, LITTLE_ENDIAN_4_BYTES)...)
+	result = binary.LittleEndian.AppendUint32(result, t.lockTime)
 	return result
 }
 
 // serializeLegacy serializes the transaction in the pre-SegWit (legacy) format without witness data.
 func (t *Transaction) serializeLegacy() []byte {
 	result := make([]byte, 0)
-	result = append(result, BigIntToLittleEndian(t.version, LITTLE_ENDIAN_4_BYTES)...)
+	result = binary.LittleEndian.AppendUint32(result, uint32(t.version))
 
 	inputCount := big.NewInt(int64(len(t.txInputs)))
 	result = append(result, EncodeVarint(inputCount)...)
@@ -326,119 +446,45 @@ func (t *Transaction) serializeLegacy() []byte {
 		result = append(result, t.txOutputs[i].Serialize()...)
 	}
 
-	result = append(result, BigIntToLittleEndian(t.lockTime, LITTLE_ENDIAN_4_BYTES)...)
+	result = binary.LittleEndian.AppendUint32(result, t.lockTime)
 
 	return result
 }
 
 // P2pkhScript builds a standard Pay-to-Public-Key-Hash (P2PKH) locking script from a hash160.
 func P2pkhScript(h160 []byte) *ScriptSig {
-	cmd := make([][]byte, 0)
-	cmd = append(cmd, []byte{OP_DUP})
-	cmd = append(cmd, []byte{OP_HASH160})
-	cmd = append(cmd, h160)
-	cmd = append(cmd, []byte{OP_EQUALVERIFY})
-	cmd = append(cmd, []byte{OP_CHECKSIG})
+	cmd := make([]ScriptCmd, 0)
+	cmd = append(cmd, OpCmd(OP_DUP))
+	cmd = append(cmd, OpCmd(OP_HASH160))
+	cmd = append(cmd, DataPush(h160))
+	cmd = append(cmd, OpCmd(OP_EQUALVERIFY))
+	cmd = append(cmd, OpCmd(OP_CHECKSIG))
 	return InitScriptSig(cmd)
 }
 
-// Reads the transaction input count, handling possible SegWit marker
-func getInputCount(bufReader *bufio.Reader) *big.Int {
-	count := ReadVarint(bufReader)
-	fmt.Printf("input count is: %x\n", count)
-	return count
-}
-
-// parseLegacy parses a legacy (non-SegWit) Bitcoin transaction from the reader.
-func parseLegacy(bufReader *bufio.Reader) *Transaction {
-	transaction := &Transaction{}
-	verBuf := make([]byte, 4)
-	io.ReadFull(bufReader, verBuf)
-	version := LittleEndianToBigInt(verBuf, LITTLE_ENDIAN_4_BYTES)
-	fmt.Printf("transaction version:%x\n", version)
-	transaction.version = version
-
-	inputs := getInputCount(bufReader)
-	transactionInputs := []*TransactionInput{}
-	for i := 0; i < int(inputs.Int64()); i++ {
-		input := NewTransactionInput(bufReader)
-		transactionInputs = append(transactionInputs, input)
-	}
-	transaction.txInputs = transactionInputs
-
-	// read output counts
-	outputs := ReadVarint(bufReader)
-	transactionOutputs := []*TransactionOutput{}
-	for i := 0; i < int(outputs.Int64()); i++ {
-		output := NewTransactionOutput(bufReader)
-		transactionOutputs = append(transactionOutputs, output)
-	}
-	transaction.txOutputs = transactionOutputs
-
-	// get last four bytes for lock time
-	lockTimeBytes := make([]byte, 4)
-	io.ReadFull(bufReader, lockTimeBytes)
-	transaction.lockTime = LittleEndianToBigInt(lockTimeBytes, LITTLE_ENDIAN_4_BYTES)
-
-	return transaction
-}
-
-// parseSegwit parses a SegWit (BIP141) Bitcoin transaction from the reader.
-func parseSegwit(bufReader *bufio.Reader) *Transaction {
-	transaction := &Transaction{}
-	transaction.segwit = true
-
-	verBuf := make([]byte, 4)
-	io.ReadFull(bufReader, verBuf)
-	version := LittleEndianToBigInt(verBuf, LITTLE_ENDIAN_4_BYTES)
-	fmt.Printf("transaction version:%x\n", version)
-	transaction.version = version
-
-	// check the following 2 bytes
-	marker := make([]byte, 2)
-	io.ReadFull(bufReader, marker)
-	if marker[0] != 0x00 && marker[1] != 0x01 {
-		panic("Not segwit transaction")
-	}
-
-	inputs := getInputCount(bufReader)
-	transactionInputs := []*TransactionInput{}
-	for i := 0; i < int(inputs.Int64()); i++ {
-		input := NewTransactionInput(bufReader)
-		transactionInputs = append(transactionInputs, input)
-	}
-	transaction.txInputs = transactionInputs
-
-	// read output counts
-	outputs := ReadVarint(bufReader)
-	transactionOutputs := []*TransactionOutput{}
-	for i := 0; i < int(outputs.Int64()); i++ {
-		output := NewTransactionOutput(bufReader)
-		transactionOutputs = append(transactionOutputs, output)
-	}
-	transaction.txOutputs = transactionOutputs
-
-	// parsing witness data,
-	for _, input := range transactionInputs {
-		numItems := ReadVarint(bufReader)
-		items := make([][]byte, 0)
-		for i := 0; i < int(numItems.Int64()); i++ {
-			itemLen := ReadVarint(bufReader)
-			if itemLen.Int64() == 0 {
-				items = append(items, []byte{})
-			} else {
-				item := make([]byte, itemLen.Int64())
-				io.ReadFull(bufReader, item)
-				items = append(items, item)
-			}
-		}
-		input.witness = items
-	}
+// P2shScript builds a Pay-to-Script-Hash (P2SH) locking script from the hash160 of a redeem script.
+func P2shScript(h160 []byte) *ScriptSig {
+	return InitScriptSig([]ScriptCmd{OpCmd(OP_HASH160), DataPush(h160), OpCmd(OP_EQUAL)})
+}
 
-	// get last four bytes for lock time
-	lockTimeBytes := make([]byte, 4)
-	io.ReadFull(bufReader, lockTimeBytes)
-	transaction.lockTime = LittleEndianToBigInt(lockTimeBytes, LITTLE_ENDIAN_4_BYTES)
+// P2wpkhScript builds a Pay-to-Witness-Public-Key-Hash (P2WPKH) locking script from a hash160.
+func P2wpkhScript(h160 []byte) *ScriptSig {
+	return InitScriptSig([]ScriptCmd{OpCmd(OP_0), DataPush(h160)})
+}
+
+// P2wshScript builds a Pay-to-Witness-Script-Hash (P2WSH) locking script from the sha256 of a witness script.
+func P2wshScript(s256 []byte) *ScriptSig {
+	return InitScriptSig([]ScriptCmd{OpCmd(OP_0), DataPush(s256)})
+}
+
+// MultisigScript builds an m-of-n OP_CHECKMULTISIG script from SEC encoded public keys.
+func MultisigScript(m int, secPubKeys [][]byte) (*ScriptSig, error) {
+	if m < 1 || m > len(secPubKeys) || len(secPubKeys) > MAX_PUBKEYS_PER_MULTISIG {
+		return nil, fmt.Errorf("invalid %d-of-%d multisig", m, len(secPubKeys))
+	}
 
-	return transaction
+	cmds := []ScriptCmd{scriptNumCmd(int64(m))}
+	cmds = append(cmds, pushCmds(secPubKeys)...)
+	cmds = append(cmds, scriptNumCmd(int64(len(secPubKeys))), OpCmd(OP_CHECKMULTISIG))
+	return InitScriptSig(cmds), nil
 }
@@ -1,49 +1,370 @@
 package transaction
 
 import (
+	"bytes"
+	"container/list"
+	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sudonite/bitcoin/chaincfg"
+)
+
+const (
+	DEFAULT_MAINNET_API_URL   = "https://blockstream.info/api"
+	DEFAULT_TESTNET_API_URL   = "https://blockstream.info/testnet/api"
+	DEFAULT_TESTNET4_API_URL  = "https://mempool.space/testnet4/api"
+	DEFAULT_SIGNET_API_URL    = "https://mempool.space/signet/api"
+	DEFAULT_FETCH_TIMEOUT     = 15 * time.Second
+	DEFAULT_FETCH_RETRIES     = 3
+	DEFAULT_FETCH_BACKOFF     = 500 * time.Millisecond
+	DEFAULT_FETCH_CACHE_SIZE  = 1024
+	MAX_FETCH_RESPONSE_LENGTH = 8 * 1024 * 1024
 )
 
-// Fetches raw Bitcoin transactions from a public API
-type TransactionFetcher struct{}
+var (
+	ErrTxNotFound      = errors.New("transaction not found")
+	ErrTxIDMismatch    = errors.New("fetched transaction does not hash to the requested txid")
+	ErrInvalidTxID     = errors.New("invalid transaction id")
+	ErrFetchStatusCode = errors.New("unexpected status code from transaction api")
+	ErrNoAPIURL        = errors.New("no transaction api configured for network")
+)
+
+// FetcherConfig holds the settings used by a TransactionFetcher
+type FetcherConfig struct {
+	// URLs maps a network name to an Esplora-compatible API root, requests go to {url}/tx/{txid}/hex
+	URLs map[string]string
+	// Timeout bounds a single HTTP attempt
+	Timeout time.Duration
+	// MaxRetries is the number of extra attempts after the first one fails with a transient error,
+	// negative values are treated as zero
+	MaxRetries int
+	// Backoff is the delay before the first retry, it doubles on each following retry
+	Backoff time.Duration
+	// CacheSize is the number of raw transactions kept in memory, zero disables the memory cache
+	CacheSize int
+	// CacheDir enables the on-disk cache when not empty
+	CacheDir string
+	// Client overrides the HTTP client, a client with Timeout is created when nil
+	Client *http.Client
+}
+
+// DefaultFetcherConfig returns the configuration used by NewTransactionFetcher
+func DefaultFetcherConfig() FetcherConfig {
+	return FetcherConfig{
+		URLs: map[string]string{
+			chaincfg.MainNetParams.Name:  DEFAULT_MAINNET_API_URL,
+			chaincfg.TestNet3Params.Name: DEFAULT_TESTNET_API_URL,
+			chaincfg.TestNet4Params.Name: DEFAULT_TESTNET4_API_URL,
+			chaincfg.SigNetParams.Name:   DEFAULT_SIGNET_API_URL,
+		},
+		Timeout:    DEFAULT_FETCH_TIMEOUT,
+		MaxRetries: DEFAULT_FETCH_RETRIES,
+		Backoff:    DEFAULT_FETCH_BACKOFF,
+		CacheSize:  DEFAULT_FETCH_CACHE_SIZE,
+	}
+}
+
+// Fetches raw Bitcoin transactions from an Esplora-compatible API
+type TransactionFetcher struct {
+	config FetcherConfig
+	client *http.Client
+	cache  *txCache
+}
+
+// defaultFetcher is shared by every parsed input so the cache is reused across transactions, it is
+// swapped atomically since inputs may be verified on several goroutines
+var defaultFetcher atomic.Pointer[TransactionFetcher]
+
+func init() {
+	defaultFetcher.Store(NewTransactionFetcher())
+}
+
+// DefaultTransactionFetcher returns the fetcher used by inputs that have no fetcher of their own
+func DefaultTransactionFetcher() *TransactionFetcher {
+	return defaultFetcher.Load()
+}
 
-// Creates a new transaction fetcher
+// SetDefaultTransactionFetcher replaces the fetcher used by inputs that have no fetcher of their own
+func SetDefaultTransactionFetcher(fetcher *TransactionFetcher) {
+	defaultFetcher.Store(fetcher)
+}
+
+// Creates a new transaction fetcher with the default configuration
 func NewTransactionFetcher() *TransactionFetcher {
-	return &TransactionFetcher{}
+	return NewTransactionFetcherWithConfig(DefaultFetcherConfig())
+}
+
+// NewTransactionFetcherWithConfig creates a transaction fetcher with the given configuration
+func NewTransactionFetcherWithConfig(config FetcherConfig) *TransactionFetcher {
+	client := config.Client
+	if client == nil {
+		client = &http.Client{Timeout: config.Timeout}
+	}
+
+	return &TransactionFetcher{
+		config: config,
+		client: client,
+		cache:  newTxCache(config.CacheSize),
+	}
 }
 
 // Returns the base API URL depending on network
-func (t *TransactionFetcher) getURL(testnet bool) string {
-	if testnet {
-		return "https://blockstream.info/testnet/api/tx"
+func (t *TransactionFetcher) getURL(params *chaincfg.Params) (string, error) {
+	url, ok := t.config.URLs[params.Name]
+	if !ok || url == "" {
+		return "", fmt.Errorf("%w: %s", ErrNoAPIURL, params.Name)
 	}
 
-	return "https://blockstream.info/api/tx"
+	return strings.TrimSuffix(url, "/"), nil
 }
 
 // Fetches a raw transaction by txID and returns its binary form
-func (t *TransactionFetcher) Fetch(txID string, testnet bool) []byte {
-	url := fmt.Sprintf("%s/%s/hex", t.getURL(testnet), txID)
-	fmt.Printf("fetching url: %s\n", url)
-	resp, err := http.Get(url)
+func (t *TransactionFetcher) Fetch(txID string, params *chaincfg.Params) ([]byte, error) {
+	return t.FetchContext(context.Background(), txID, params)
+}
+
+// FetchContext fetches a raw transaction by txID, checking the memory cache, the disk cache and then the API
+func (t *TransactionFetcher) FetchContext(ctx context.Context, txID string, params *chaincfg.Params) ([]byte, error) {
+	txID = strings.ToLower(txID)
+	expected, err := hex.DecodeString(txID)
+	if err != nil || len(expected) != 32 {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidTxID, txID)
+	}
+
+	key := cacheKey(txID, params)
+	if raw, ok := t.cache.get(key); ok {
+		return raw, nil
+	}
+
+	if raw, ok := t.readDisk(txID, params, expected); ok {
+		t.cache.put(key, raw)
+		return raw, nil
+	}
+
+	raw, err := t.fetchWithRetry(ctx, txID, params)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkTxID(raw, expected); err != nil {
+		return nil, fmt.Errorf("fetch %s: %w", txID, err)
+	}
+
+	t.cache.put(key, raw)
+	// the disk cache only saves a later request, failing to write it does not fail this one
+	_ = t.writeDisk(txID, params, raw)
+
+	return raw, nil
+}
+
+// fetchWithRetry requests the transaction hex and retries transient failures with exponential backoff
+func (t *TransactionFetcher) fetchWithRetry(ctx context.Context, txID string, params *chaincfg.Params) ([]byte, error) {
+	baseURL, err := t.getURL(params)
 	if err != nil {
-		panic(fmt.Sprintf("fetch transaction err: %v\n", err))
+		return nil, err
+	}
+	url := fmt.Sprintf("%s/tx/%s/hex", baseURL, txID)
+	backoff := t.config.Backoff
+
+	// the first attempt is always made, so the loop always leaves an error behind
+	var lastErr error
+	for attempt := 0; attempt <= max(t.config.MaxRetries, 0); attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+
+		raw, retry, err := t.fetchOnce(ctx, url)
+		if err == nil {
+			return raw, nil
+		}
+		lastErr = err
+		if !retry || ctx.Err() != nil {
+			break
+		}
+	}
+
+	return nil, fmt.Errorf("fetch %s: %w", txID, lastErr)
+}
+
+// fetchOnce performs a single HTTP request, reporting whether a failure is worth retrying
+func (t *TransactionFetcher) fetchOnce(ctx context.Context, url string) ([]byte, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, false, err
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, true, err
 	}
 	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	body, err := io.ReadAll(io.LimitReader(resp.Body, MAX_FETCH_RESPONSE_LENGTH))
 	if err != nil {
-		panic(fmt.Sprintf("read body err: %v\n", err))
+		return nil, true, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, false, ErrTxNotFound
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return nil, true, fmt.Errorf("%w: %d", ErrFetchStatusCode, resp.StatusCode)
+	case resp.StatusCode != http.StatusOK:
+		return nil, false, fmt.Errorf("%w: %d", ErrFetchStatusCode, resp.StatusCode)
 	}
 
-	fmt.Printf("response:  %s\n", string(body))
-	buf, err := hex.DecodeString(string(body))
+	buf, err := hex.DecodeString(strings.TrimSpace(string(body)))
 	if err != nil {
-		panic(err)
+		return nil, false, fmt.Errorf("decode response: %w", err)
 	}
 
-	return buf
+	return buf, false, nil
+}
+
+// diskPath returns the cache file of a transaction, split by network
+func (t *TransactionFetcher) diskPath(txID string, params *chaincfg.Params) string {
+	return filepath.Join(t.config.CacheDir, params.Name, txID)
+}
+
+// readDisk loads a transaction from the disk cache, ignoring files that fail the txid check
+func (t *TransactionFetcher) readDisk(txID string, params *chaincfg.Params, expected []byte) ([]byte, bool) {
+	if t.config.CacheDir == "" {
+		return nil, false
+	}
+
+	raw, err := os.ReadFile(t.diskPath(txID, params))
+	if err != nil {
+		return nil, false
+	}
+	if checkTxID(raw, expected) != nil {
+		return nil, false
+	}
+
+	return raw, true
+}
+
+// writeDisk stores a verified transaction in the disk cache
+func (t *TransactionFetcher) writeDisk(txID string, params *chaincfg.Params, raw []byte) error {
+	if t.config.CacheDir == "" {
+		return nil
+	}
+
+	path := t.diskPath(txID, params)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	// write to a temporary file of its own first so a crash never leaves a partial entry and concurrent
+	// writers of the same transaction do not share one
+	tmp, err := os.CreateTemp(filepath.Dir(path), txID+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(raw)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0o644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// checkTxID verifies that the raw transaction hashes to the expected txid
+func checkTxID(raw []byte, expected []byte) error {
+	tx, err := ParseTransaction(raw)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(tx.Hash(), expected) {
+		return ErrTxIDMismatch
+	}
+
+	return nil
+}
+
+// cacheKey builds the memory cache key for a transaction on the given network
+func cacheKey(txID string, params *chaincfg.Params) string {
+	return params.Name + ":" + txID
+}
+
+// txCacheEntry is a single element of the LRU list
+type txCacheEntry struct {
+	key string
+	raw []byte
+}
+
+// txCache is a fixed size, concurrency safe LRU cache of raw transactions
+type txCache struct {
+	lock     sync.Mutex
+	capacity int
+	order    *list.List
+	items    map[string]*list.Element
+}
+
+// newTxCache creates an LRU cache holding up to capacity transactions
+func newTxCache(capacity int) *txCache {
+	return &txCache{
+		capacity: capacity,
+		order:    list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// get returns a cached transaction and marks it as recently used
+func (c *txCache) get(key string) ([]byte, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*txCacheEntry).raw, true
+}
+
+// put stores a transaction, evicting the least recently used one when full
+func (c *txCache) put(key string, raw []byte) {
+	if c.capacity <= 0 {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		elem.Value.(*txCacheEntry).raw = raw
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&txCacheEntry{key: key, raw: raw})
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*txCacheEntry).key)
+	}
 }
@@ -0,0 +1,191 @@
+package transaction
+
+import (
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sudonite/bitcoin/chaincfg"
+)
+
+// fetchServer is an Esplora stand-in answering each request with the next status in statuses, the
+// last one repeats, and body for a 200
+type fetchServer struct {
+	*httptest.Server
+	lock     sync.Mutex
+	statuses []int
+	body     string
+	requests []time.Time
+}
+
+func newFetchServer(t *testing.T, body string, statuses ...int) *fetchServer {
+	t.Helper()
+
+	s := &fetchServer{statuses: statuses, body: body}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.lock.Lock()
+		status := s.statuses[min(len(s.requests), len(s.statuses)-1)]
+		s.requests = append(s.requests, time.Now())
+		s.lock.Unlock()
+
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			w.Write([]byte(s.body + "\n"))
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *fetchServer) hits() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.requests)
+}
+
+// testFetcher points a fetcher at the server for mainnet with a short backoff
+func testFetcher(s *fetchServer, maxRetries int, cacheDir string) *TransactionFetcher {
+	return NewTransactionFetcherWithConfig(FetcherConfig{
+		URLs:       map[string]string{chaincfg.MainNetParams.Name: s.URL},
+		Timeout:    time.Second,
+		MaxRetries: maxRetries,
+		Backoff:    5 * time.Millisecond,
+		CacheSize:  DEFAULT_FETCH_CACHE_SIZE,
+		CacheDir:   cacheDir,
+	})
+}
+
+// testTxID returns the txid the raw transaction in hex hashes to
+func testTxID(t *testing.T, rawHex string) string {
+	t.Helper()
+
+	tx, err := ParseTransaction(decodeHex(t, rawHex))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hex.EncodeToString(tx.Hash())
+}
+
+func TestFetchRetry(t *testing.T) {
+	txID := testTxID(t, bip143NativeP2wpkhTx)
+
+	tests := []struct {
+		name       string
+		statuses   []int
+		maxRetries int
+		err        error
+		hits       int
+	}{
+		{"ok", []int{http.StatusOK}, 3, nil, 1},
+		{"retried until ok", []int{http.StatusInternalServerError, http.StatusTooManyRequests, http.StatusOK}, 3, nil, 3},
+		{"retries exhausted", []int{http.StatusServiceUnavailable}, 2, ErrFetchStatusCode, 3},
+		{"not found is not retried", []int{http.StatusNotFound}, 3, ErrTxNotFound, 1},
+		{"client error is not retried", []int{http.StatusBadRequest}, 3, ErrFetchStatusCode, 1},
+		{"negative retries still try once", []int{http.StatusServiceUnavailable}, -1, ErrFetchStatusCode, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFetchServer(t, bip143NativeP2wpkhTx, tt.statuses...)
+			raw, err := testFetcher(server, tt.maxRetries, "").Fetch(txID, &chaincfg.MainNetParams)
+
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("expected %v, got %v", tt.err, err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			} else if hex.EncodeToString(raw) != bip143NativeP2wpkhTx {
+				t.Fatalf("unexpected transaction %x", raw)
+			}
+
+			if server.hits() != tt.hits {
+				t.Fatalf("expected %d requests, got %d", tt.hits, server.hits())
+			}
+		})
+	}
+}
+
+func TestFetchBackoff(t *testing.T) {
+	server := newFetchServer(t, bip143NativeP2wpkhTx, http.StatusServiceUnavailable)
+	fetcher := testFetcher(server, 3, "")
+
+	if _, err := fetcher.Fetch(testTxID(t, bip143NativeP2wpkhTx), &chaincfg.MainNetParams); !errors.Is(err, ErrFetchStatusCode) {
+		t.Fatalf("expected %v, got %v", ErrFetchStatusCode, err)
+	}
+
+	// each retry waits at least twice as long as the one before it
+	wait := fetcher.config.Backoff
+	for i := 1; i < len(server.requests); i++ {
+		if gap := server.requests[i].Sub(server.requests[i-1]); gap < wait {
+			t.Fatalf("retry %d came after %v, expected at least %v", i, gap, wait)
+		}
+		wait *= 2
+	}
+}
+
+func TestFetchTxIDMismatch(t *testing.T) {
+	// the server answers with a different transaction than the one asked for
+	server := newFetchServer(t, bip143NestedP2wpkhTx, http.StatusOK)
+	fetcher := testFetcher(server, 3, t.TempDir())
+	txID := testTxID(t, bip143NativeP2wpkhTx)
+
+	for range 2 {
+		if _, err := fetcher.Fetch(txID, &chaincfg.MainNetParams); !errors.Is(err, ErrTxIDMismatch) {
+			t.Fatalf("expected %v, got %v", ErrTxIDMismatch, err)
+		}
+	}
+
+	// a mismatch is neither retried nor cached
+	if server.hits() != 2 {
+		t.Fatalf("expected 2 requests, got %d", server.hits())
+	}
+}
+
+func TestFetchCache(t *testing.T) {
+	txID := testTxID(t, bip143NativeP2wpkhTx)
+	cacheDir := t.TempDir()
+	server := newFetchServer(t, bip143NativeP2wpkhTx, http.StatusOK)
+
+	fetcher := testFetcher(server, 3, cacheDir)
+	for range 2 {
+		if _, err := fetcher.Fetch(txID, &chaincfg.MainNetParams); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if server.hits() != 1 {
+		t.Fatalf("memory cache: expected 1 request, got %d", server.hits())
+	}
+
+	entries, err := os.ReadDir(filepath.Join(cacheDir, chaincfg.MainNetParams.Name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != txID {
+		t.Fatalf("expected only the cache entry for %s, got %v", txID, entries)
+	}
+
+	// a fresh fetcher on the same directory is served from disk while the api is down
+	server.Close()
+	raw, err := testFetcher(server, 0, cacheDir).Fetch(txID, &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(raw) != bip143NativeP2wpkhTx {
+		t.Fatalf("unexpected transaction %x", raw)
+	}
+
+	// a corrupt disk entry is ignored rather than returned
+	if err := os.WriteFile(filepath.Join(cacheDir, chaincfg.MainNetParams.Name, txID), []byte{0x01}, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testFetcher(server, 0, cacheDir).Fetch(txID, &chaincfg.MainNetParams); err == nil {
+		t.Fatal("expected the corrupt entry to be refetched from the closed server")
+	}
+}
@@ -133,6 +133,6 @@ func ReverseByteSlice(bytes []byte) []byte {
 
 // P2pkScript creates a Pay-to-Public-Key-Hash (P2PKH) locking script
 func P2pkScript(h160 []byte) *ScriptSig {
-	scriptContent := [][]byte{[]byte{OP_DUP}, []byte{OP_HASH160}, h160, []byte{OP_EQUALVERIFY}, []byte{OP_CHECKSIG}}
+	scriptContent := []ScriptCmd{OpCmd(OP_DUP), OpCmd(OP_HASH160), DataPush(h160), OpCmd(OP_EQUALVERIFY), OpCmd(OP_CHECKSIG)}
 	return InitScriptSig(scriptContent)
 }
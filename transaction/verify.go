@@ -0,0 +1,86 @@
+package transaction
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+var ErrInputInvalid = errors.New("input failed verification")
+
+// Verify checks the entire transaction, spreading the inputs over one worker per CPU
+func (t *Transaction) Verify() bool {
+	return t.VerifyContext(context.Background(), 0) == nil
+}
+
+// VerifyContext checks the fee and every input across a pool of workers, zero or less uses one
+// worker per CPU. The first failing input cancels the others and is named by the returned error
+func (t *Transaction) VerifyContext(ctx context.Context, workers int) error {
+	fee, err := t.Fee()
+	if err != nil {
+		return err
+	}
+	if fee < 0 {
+		return fmt.Errorf("outputs spend %s more than the inputs", -fee)
+	}
+
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	workers = min(workers, len(t.txInputs))
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// the workers share the hashes common to every input
+	sigHashes := NewSigHashCache(t)
+	indexes := make(chan int)
+	var (
+		wg       sync.WaitGroup
+		failOnce sync.Once
+		failure  error
+	)
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if !t.verifyInputRecovered(i, sigHashes) {
+					failOnce.Do(func() {
+						failure = fmt.Errorf("%w: input %d", ErrInputInvalid, i)
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+feed:
+	for i := range t.txInputs {
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	if failure != nil {
+		return failure
+	}
+	return ctx.Err()
+}
+
+// verifyInputRecovered verifies an input, a panic while evaluating the input only fails that input
+// instead of taking the whole process down with the worker
+func (t *Transaction) verifyInputRecovered(inputIndex int, sigHashes *SigHashCache) (valid bool) {
+	defer func() {
+		if recover() != nil {
+			valid = false
+		}
+	}()
+	return t.verifyInput(inputIndex, sigHashes)
+}
@@ -0,0 +1,220 @@
+package transaction
+
+import (
+	"fmt"
+	"math/big"
+)
+
+const (
+	WITNESS_SCALE_FACTOR = 4
+
+	// weight of version, locktime and single byte input and output counts
+	TX_BASE_WEIGHT = 40
+	// weight of the segwit marker and flag, which are witness data
+	SEGWIT_MARKER_WEIGHT = 2
+
+	// input weights assume 72 byte DER signatures and compressed public keys
+	P2PKH_INPUT_WEIGHT       = 592
+	P2PK_INPUT_WEIGHT        = 456
+	P2WPKH_INPUT_WEIGHT      = 272
+	P2SH_P2WPKH_INPUT_WEIGHT = 364
+	P2TR_INPUT_WEIGHT        = 230
+
+	// outpoint and sequence of an input
+	INPUT_BASE_SIZE = 40
+	// a DER signature with its sighash byte is at most 72 bytes
+	MAX_ECDSA_SIG_SIZE = 72
+)
+
+// StrippedSize returns the size of the transaction without witness data
+func (t *Transaction) StrippedSize() int {
+	return len(t.serializeLegacy())
+}
+
+// TotalSize returns the size of the serialized transaction, witness data included
+func (t *Transaction) TotalSize() int {
+	return len(t.Serialize())
+}
+
+// Weight returns the BIP-141 weight, non-witness bytes count four times and witness bytes once
+func (t *Transaction) Weight() int {
+	return t.StrippedSize()*(WITNESS_SCALE_FACTOR-1) + t.TotalSize()
+}
+
+// VSize returns the virtual size, the weight divided by four and rounded up
+func (t *Transaction) VSize() int {
+	return weightToVSize(t.Weight())
+}
+
+// FeeRate returns the rate the transaction pays for its virtual size
+func (t *Transaction) FeeRate() (FeeRate, error) {
+	fee, err := t.Fee()
+	if err != nil {
+		return 0, err
+	}
+
+	return NewFeeRate(fee, t.VSize()), nil
+}
+
+// SetPreviousOutputs records the outputs spent by every input, so fees and weights are computed without fetching
+func (t *Transaction) SetPreviousOutputs(outputs []*TransactionOutput) error {
+	if len(outputs) != len(t.txInputs) {
+		return fmt.Errorf("got %d previous outputs for %d inputs", len(outputs), len(t.txInputs))
+	}
+
+	for i, output := range outputs {
+		t.txInputs[i].SetPreviousOutput(output)
+	}
+	return nil
+}
+
+// EstimateWeight returns the weight of the transaction once signed, inputs are estimated from the type of
+// the script they spend, see EstimateSpendWeight
+func (t *Transaction) EstimateWeight() (int, error) {
+	return t.estimateWeight(func(inputIdx int) (int, bool, error) {
+		script, err := t.txInputs[inputIdx].scriptPubKey(t.Params())
+		if err != nil {
+			return 0, false, err
+		}
+		return EstimateSpendWeight(script, nil, nil)
+	})
+}
+
+// EstimateVSize returns the virtual size of the transaction once signed
+func (t *Transaction) EstimateVSize() (int, error) {
+	weight, err := t.EstimateWeight()
+	if err != nil {
+		return 0, err
+	}
+	return weightToVSize(weight), nil
+}
+
+// estimateWeight adds the weight of the outputs and transaction fields to the input weights given by spendWeight
+func (t *Transaction) estimateWeight(spendWeight func(inputIdx int) (int, bool, error)) (int, error) {
+	weight := 8 * WITNESS_SCALE_FACTOR
+	weight += len(EncodeVarint(big.NewInt(int64(len(t.txInputs))))) * WITNESS_SCALE_FACTOR
+	weight += len(EncodeVarint(big.NewInt(int64(len(t.txOutputs))))) * WITNESS_SCALE_FACTOR
+	for _, output := range t.txOutputs {
+		weight += OutputWeight(output)
+	}
+
+	legacyInputs, hasWitness := 0, false
+	for i := range t.txInputs {
+		inputWeight, witness, err := spendWeight(i)
+		if err != nil {
+			return 0, fmt.Errorf("input %d: %w", i, err)
+		}
+
+		weight += inputWeight
+		if witness {
+			hasWitness = true
+		} else {
+			legacyInputs++
+		}
+	}
+
+	if hasWitness {
+		// the marker and flag, plus an empty witness stack for every input without witness
+		weight += SEGWIT_MARKER_WEIGHT + legacyInputs
+	}
+	return weight, nil
+}
+
+// weightToVSize converts a weight into virtual bytes, rounding up
+func weightToVSize(weight int) int {
+	return (weight + WITNESS_SCALE_FACTOR - 1) / WITNESS_SCALE_FACTOR
+}
+
+// OutputWeight returns the weight of a serialized output
+func OutputWeight(output *TransactionOutput) int {
+	return len(output.Serialize()) * WITNESS_SCALE_FACTOR
+}
+
+// EstimateInputWeight returns the weight of an input spending the script, ok is false when the
+// satisfaction depends on a redeem or witness script the scriptPubKey does not reveal
+func EstimateInputWeight(scriptPubKey *ScriptSig) (int, bool) {
+	weight, _, err := EstimateSpendWeight(scriptPubKey, nil, nil)
+	return weight, err == nil
+}
+
+// EstimateSpendWeight returns the weight of a signed input spending scriptPubKey and whether it has a witness.
+// P2SH inputs need their redeem script and P2WSH inputs their witness script, which must be a
+// <pubkey> OP_CHECKSIG or multisig script.
+func EstimateSpendWeight(scriptPubKey *ScriptSig, redeemScript *ScriptSig, witnessScript *ScriptSig) (int, bool, error) {
+	switch scriptPubKey.Classify().Class() {
+	case SCRIPT_P2PKH:
+		return P2PKH_INPUT_WEIGHT, false, nil
+	case SCRIPT_P2PK:
+		return P2PK_INPUT_WEIGHT, false, nil
+	}
+
+	script := scriptPubKey
+	scriptSigSize := 0
+	if scriptPubKey.bitcoinOpCode.isP2sh() {
+		if redeemScript == nil {
+			return 0, false, fmt.Errorf("%w: P2SH needs the redeem script", ErrUnknownInputWeight)
+		}
+		script = redeemScript
+		scriptSigSize = pushSize(len(redeemScript.rawSerialize()))
+	}
+
+	version, program, isWitness := script.witnessProgram()
+	switch {
+	case !isWitness && script != scriptPubKey:
+		// bare P2SH, the signatures go in the scriptSig next to the redeem script
+		argsSize, _, err := satisfactionSize(redeemScript)
+		if err != nil {
+			return 0, false, err
+		}
+		return legacyInputWeight(scriptSigSize + argsSize), false, nil
+	case isWitness && version == 0 && len(program) == 20:
+		// <sig> <pubkey>
+		witnessSize := 1 + pushSize(MAX_ECDSA_SIG_SIZE) + pushSize(33)
+		return legacyInputWeight(scriptSigSize) + witnessSize, true, nil
+	case isWitness && version == 0 && len(program) == 32:
+		if witnessScript == nil {
+			return 0, false, fmt.Errorf("%w: P2WSH needs the witness script", ErrUnknownInputWeight)
+		}
+		argsSize, items, err := satisfactionSize(witnessScript)
+		if err != nil {
+			return 0, false, err
+		}
+		witnessSize := len(EncodeVarint(big.NewInt(int64(items+1)))) + argsSize + pushSize(len(witnessScript.rawSerialize()))
+		return legacyInputWeight(scriptSigSize) + witnessSize, true, nil
+	case isWitness && version == 1 && len(program) == 32 && script == scriptPubKey:
+		return P2TR_INPUT_WEIGHT, true, nil
+	}
+
+	return 0, false, ErrUnknownInputWeight
+}
+
+// satisfactionSize returns the size and number of the elements signing a <pubkey> OP_CHECKSIG or multisig script.
+// Witness elements and scriptSig pushes both take a length byte, so the size is the same in either place.
+func satisfactionSize(script *ScriptSig) (int, int, error) {
+	switch template := script.Classify(); template.Class() {
+	case SCRIPT_P2PK:
+		return pushSize(MAX_ECDSA_SIG_SIZE), 1, nil
+	case SCRIPT_P2MS:
+		// the extra element consumed by OP_CHECKMULTISIG is an empty push
+		m := template.Required()
+		return 1 + m*pushSize(MAX_ECDSA_SIG_SIZE), m + 1, nil
+	}
+	return 0, 0, fmt.Errorf("%w: unsupported script", ErrUnknownInputWeight)
+}
+
+// legacyInputWeight returns the weight of an input without its witness given the size of its scriptSig
+func legacyInputWeight(scriptSigSize int) int {
+	size := INPUT_BASE_SIZE + len(EncodeVarint(big.NewInt(int64(scriptSigSize)))) + scriptSigSize
+	return size * WITNESS_SCALE_FACTOR
+}
+
+// pushSize returns the size of pushing length bytes of data
+func pushSize(length int) int {
+	switch {
+	case length <= SCRIPT_DATA_LENGTH_END:
+		return 1 + length
+	case length <= 0xff:
+		return 2 + length
+	}
+	return 3 + length
+}
@@ -0,0 +1,78 @@
+package transaction
+
+import (
+	"bytes"
+
+	ecc "github.com/sudonite/bitcoin/elliptic_curve"
+)
+
+const (
+	// OP_RETURN, a 36 byte push and the commitment header 0xaa21a9ed
+	WITNESS_COMMITMENT_HEADER   = "\x6a\x24\xaa\x21\xa9\xed"
+	WITNESS_COMMITMENT_LENGTH   = 38
+	WITNESS_RESERVED_VALUE_SIZE = 32
+)
+
+// WitnessHash returns the wtxid, the hash of the serialization with witness data.
+// It equals the txid for transactions without witness.
+func (t *Transaction) WitnessHash() []byte {
+	hash := ecc.Hash256(string(t.Serialize()))
+	return ReverseByteSlice(hash)
+}
+
+// ComputeWitnessCommitment returns the BIP-141 commitment, the double SHA256 of the witness merkle root
+// followed by the witness reserved value
+func ComputeWitnessCommitment(witnessRoot []byte, reservedValue []byte) []byte {
+	data := append(append([]byte{}, witnessRoot...), reservedValue...)
+	return ecc.Hash256(string(data))
+}
+
+// WitnessCommitmentScript builds the OP_RETURN scriptPubKey of the coinbase output carrying the commitment
+func WitnessCommitmentScript(commitment []byte) *ScriptSig {
+	data := append([]byte(WITNESS_COMMITMENT_HEADER[2:]), commitment...)
+	return InitScriptSig([]ScriptCmd{OpCmd(OP_RETURN), DataPush(data)})
+}
+
+// WitnessCommitment returns the commitment of a coinbase transaction, found in the last output matching
+// the BIP-141 pattern, ok is false when there is none
+func (t *Transaction) WitnessCommitment() ([]byte, bool) {
+	for i := len(t.txOutputs) - 1; i >= 0; i-- {
+		raw := t.txOutputs[i].scriptPubKey.rawSerialize()
+		if len(raw) >= WITNESS_COMMITMENT_LENGTH && bytes.HasPrefix(raw, []byte(WITNESS_COMMITMENT_HEADER)) {
+			return raw[len(WITNESS_COMMITMENT_HEADER):WITNESS_COMMITMENT_LENGTH], true
+		}
+	}
+	return nil, false
+}
+
+// WitnessReservedValue returns the witness reserved value of a coinbase, the single 32 byte item of its
+// input witness, ok is false when the witness does not have that shape
+func (t *Transaction) WitnessReservedValue() ([]byte, bool) {
+	if len(t.txInputs) != 1 {
+		return nil, false
+	}
+
+	witness := t.txInputs[0].witness
+	if len(witness) != 1 || len(witness[0]) != WITNESS_RESERVED_VALUE_SIZE {
+		return nil, false
+	}
+	return witness[0], true
+}
+
+// SetWitnessCommitment adds the commitment output to a coinbase and sets its witness reserved value
+func (t *Transaction) SetWitnessCommitment(witnessRoot []byte, reservedValue []byte) {
+	commitment := ComputeWitnessCommitment(witnessRoot, reservedValue)
+	t.txOutputs = append(t.txOutputs, InitTransactionOutput(0, WitnessCommitmentScript(commitment)))
+	t.txInputs[0].SetWitness([][]byte{reservedValue})
+	t.segwit = true
+}
+
+// HasWitness reports whether the transaction carries witness data
+func (t *Transaction) HasWitness() bool {
+	for _, txInput := range t.txInputs {
+		if len(txInput.witness) > 0 {
+			return true
+		}
+	}
+	return false
+}